@@ -0,0 +1,82 @@
+// Package audit records every command gen generates, who asked for it and
+// what became of it.
+package audit
+
+import (
+	"errors"
+	"time"
+
+	"github.com/zombor/gen/jsonl"
+)
+
+// ErrSyslogUnsupported is returned by NewSyslog on platforms without a
+// system logger.
+var ErrSyslogUnsupported = errors.New("syslog is not supported on this platform")
+
+// Decision is what the user did with a generated command.
+type Decision string
+
+const (
+	// Accepted commands were run.
+	Accepted Decision = "accepted"
+	// Aborted commands were turned down by the user.
+	Aborted Decision = "aborted"
+	// Denied commands were blocked by the policy.
+	Denied Decision = "denied"
+	// Failed invocations ended before a command was generated.
+	Failed Decision = "failed"
+)
+
+// Entry describes one command offered to the user.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	Host     string    `json:"host"`
+	Cwd      string    `json:"cwd"`
+	Prompt   string    `json:"prompt"`
+	Provider string    `json:"provider"`
+	Model    string    `json:"model"`
+	// Generated is the command as the provider returned it.
+	Generated string `json:"generated"`
+	// Command is the command the user was left with, after editing it.
+	Command  string   `json:"command"`
+	Decision Decision `json:"decision"`
+	// ExitCode and Duration are set for accepted commands.
+	ExitCode *int          `json:"exit_code,omitempty"`
+	Duration time.Duration `json:"duration_ns,omitempty"`
+	// Error is set when the invocation failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Recorder stores audit entries.
+type Recorder interface {
+	Record(entry Entry) error
+}
+
+// Recorders is a Recorder that passes each entry to all of its recorders.
+type Recorders []Recorder
+
+// Record implements Recorder.
+func (r Recorders) Record(entry Entry) error {
+	var errs []error
+	for _, recorder := range r {
+		errs = append(errs, recorder.Record(entry))
+	}
+	return errors.Join(errs...)
+}
+
+// Log is a Recorder that appends entries to a JSON lines file. Entries are
+// only ever appended, never rewritten.
+type Log struct {
+	Path string
+}
+
+// NewLog returns a Log that writes to path.
+func NewLog(path string) *Log {
+	return &Log{Path: path}
+}
+
+// Record implements Recorder.
+func (l *Log) Record(entry Entry) error {
+	return jsonl.Append(l.Path, entry)
+}
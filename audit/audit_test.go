@@ -0,0 +1,162 @@
+package audit_test
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/audit"
+)
+
+// entry returns an audit entry of a command that ran.
+func entry() audit.Entry {
+	exitCode := 1
+	return audit.Entry{
+		Time:      time.Date(2025, 3, 10, 9, 30, 0, 0, time.UTC),
+		User:      "alice",
+		Host:      "laptop",
+		Cwd:       "/home/alice/project",
+		Prompt:    "list go files",
+		Provider:  "openai",
+		Model:     "gpt-4o",
+		Generated: "```\nfind . -name '*.go'\n```",
+		Command:   "find . -name '*.go' -type f",
+		Decision:  audit.Accepted,
+		ExitCode:  &exitCode,
+		Duration:  250 * time.Millisecond,
+	}
+}
+
+// readEntries decodes the entries in the log at path.
+func readEntries(path string) ([]audit.Entry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []audit.Entry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var e audit.Entry
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+var _ = Describe("Log", func() {
+	var (
+		log *audit.Log
+		err error
+	)
+
+	BeforeEach(func() {
+		log = audit.NewLog(filepath.Join(GinkgoT().TempDir(), "gen", "audit.jsonl"))
+	})
+
+	JustBeforeEach(func() {
+		err = log.Record(entry())
+	})
+
+	It("records the entry", func() {
+		Expect(readEntries(log.Path)).To(Equal([]audit.Entry{entry()}))
+	})
+
+	It("succeeds", func() {
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("writes one JSON object per line", func() {
+		Expect(os.ReadFile(log.Path)).To(HaveSuffix("\"exit_code\":1,\"duration_ns\":250000000}\n"))
+	})
+
+	It("keeps the log private", func() {
+		info, err := os.Stat(log.Path)
+		Expect(info.Mode().Perm(), err).To(Equal(os.FileMode(0o600)))
+	})
+
+	When("the log has entries", func() {
+		BeforeEach(func() {
+			Expect(log.Record(entry())).To(Succeed())
+		})
+
+		It("appends the entry", func() {
+			Expect(readEntries(log.Path)).To(HaveLen(2))
+		})
+	})
+
+	When("the entry is of a command that did not run", func() {
+		JustBeforeEach(func() {
+			Expect(os.Remove(log.Path)).To(Succeed())
+			err = log.Record(audit.Entry{Prompt: "wipe the disk", Generated: "dd if=/dev/zero of=/dev/sda", Command: "dd if=/dev/zero of=/dev/sda", Decision: audit.Denied})
+		})
+
+		It("leaves out the exit code and duration", func() {
+			Expect(os.ReadFile(log.Path)).ToNot(ContainSubstring("exit_code"))
+		})
+	})
+
+	When("the directory cannot be created", func() {
+		BeforeEach(func() {
+			parent := filepath.Join(GinkgoT().TempDir(), "file")
+			Expect(os.WriteFile(parent, nil, 0o600)).To(Succeed())
+			log = audit.NewLog(filepath.Join(parent, "audit.jsonl"))
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	When("the log cannot be opened", func() {
+		BeforeEach(func() {
+			log = audit.NewLog(GinkgoT().TempDir())
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
+
+type recorderFunc func(entry audit.Entry) error
+
+func (f recorderFunc) Record(entry audit.Entry) error {
+	return f(entry)
+}
+
+var _ = Describe("Recorders", func() {
+	var (
+		recorded []audit.Entry
+		failure  error
+		err      error
+	)
+
+	BeforeEach(func() {
+		recorded = nil
+		failure = errors.New("disk full")
+	})
+
+	JustBeforeEach(func() {
+		record := func(entry audit.Entry) error {
+			recorded = append(recorded, entry)
+			return nil
+		}
+		fail := func(audit.Entry) error { return failure }
+		err = audit.Recorders{recorderFunc(record), recorderFunc(fail), recorderFunc(record)}.Record(entry())
+	})
+
+	It("records the entry with every recorder", func() {
+		Expect(recorded).To(Equal([]audit.Entry{entry(), entry()}))
+	})
+
+	It("returns the errors of the recorders", func() {
+		Expect(err).To(MatchError(failure))
+	})
+})
@@ -0,0 +1,38 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"log/syslog"
+)
+
+// Syslog is a Recorder that sends entries to the system logger as JSON. On
+// Linux the local logger is usually journald.
+type Syslog struct {
+	writer *syslog.Writer
+}
+
+// NewSyslog connects to the syslog server at raddr over network, or to the
+// local system logger when network is empty.
+func NewSyslog(network, raddr string) (*Syslog, error) {
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_USER, "gen")
+	if err != nil {
+		return nil, err
+	}
+	return &Syslog{writer: writer}, nil
+}
+
+// Record implements Recorder.
+func (s *Syslog) Record(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return s.writer.Info(string(line))
+}
+
+// Close closes the connection to the system logger.
+func (s *Syslog) Close() error {
+	return s.writer.Close()
+}
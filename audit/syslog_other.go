@@ -0,0 +1,21 @@
+//go:build windows || plan9
+
+package audit
+
+// Syslog is not supported on this platform.
+type Syslog struct{}
+
+// NewSyslog returns ErrSyslogUnsupported.
+func NewSyslog(network, raddr string) (*Syslog, error) {
+	return nil, ErrSyslogUnsupported
+}
+
+// Record implements Recorder.
+func (s *Syslog) Record(entry Entry) error {
+	return ErrSyslogUnsupported
+}
+
+// Close does nothing.
+func (s *Syslog) Close() error {
+	return nil
+}
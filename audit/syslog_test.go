@@ -0,0 +1,64 @@
+//go:build !windows && !plan9
+
+package audit_test
+
+import (
+	"net"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/audit"
+)
+
+var _ = Describe("Syslog", func() {
+	var (
+		server  *net.UnixConn
+		address string
+		sink    *audit.Syslog
+		err     error
+	)
+
+	BeforeEach(func() {
+		address = filepath.Join(GinkgoT().TempDir(), "log")
+		server, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: address, Net: "unixgram"})
+		Expect(err).ToNot(HaveOccurred())
+		DeferCleanup(server.Close)
+	})
+
+	JustBeforeEach(func() {
+		sink, err = audit.NewSyslog("unixgram", address)
+	})
+
+	It("connects to the logger", func() {
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("Record", func() {
+		var message string
+
+		JustBeforeEach(func() {
+			Expect(sink.Record(entry())).To(Succeed())
+			buf := make([]byte, 4096)
+			n, err := server.Read(buf)
+			Expect(err).ToNot(HaveOccurred())
+			message = string(buf[:n])
+			Expect(sink.Close()).To(Succeed())
+		})
+
+		It("sends the entry as JSON, tagged gen", func() {
+			Expect(message).To(MatchRegexp(`^<14>.* gen\[\d+\]: \{"time":"2025-03-10T09:30:00Z","user":"alice",.*"decision":"accepted",.*\}\n?$`))
+		})
+	})
+
+	When("there is no logger", func() {
+		BeforeEach(func() {
+			address = filepath.Join(GinkgoT().TempDir(), "missing")
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
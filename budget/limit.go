@@ -0,0 +1,83 @@
+// Package budget enforces daily and monthly spending limits per provider.
+package budget
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Period is how often a budget resets.
+type Period string
+
+const (
+	Daily   Period = "daily"
+	Monthly Period = "monthly"
+)
+
+// Unit is what a budget is measured in.
+type Unit string
+
+const (
+	Tokens   Unit = "tokens"
+	Requests Unit = "requests"
+	USD      Unit = "usd"
+)
+
+// Limit is the most a provider may use in a period.
+type Limit struct {
+	Provider string
+	Period   Period
+	Unit     Unit
+	Amount   float64
+}
+
+// String formats the limit as it is parsed by ParseLimit.
+func (l Limit) String() string {
+	return fmt.Sprintf("%s:%s:%s", l.Provider, l.Period, formatAmount(l.Unit, l.Amount))
+}
+
+// formatAmount formats an amount of unit for people, e.g. "$5.00" or
+// "200 requests".
+func formatAmount(unit Unit, amount float64) string {
+	if unit == USD {
+		return fmt.Sprintf("$%.2f", amount)
+	}
+	return fmt.Sprintf("%s %s", strconv.FormatFloat(amount, 'f', -1, 64), unit)
+}
+
+// ParseLimit parses a limit written as provider:period:amount, where period
+// is daily or monthly and amount is a dollar amount ("$5" or "5usd"), a
+// number of tokens ("100000tokens") or a number of requests ("500requests").
+func ParseLimit(s string) (Limit, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 || parts[0] == "" {
+		return Limit{}, fmt.Errorf("invalid budget %q, expected provider:period:amount, e.g. bedrock:daily:$5", s)
+	}
+
+	limit := Limit{Provider: parts[0], Period: Period(parts[1])}
+	if limit.Period != Daily && limit.Period != Monthly {
+		return Limit{}, fmt.Errorf("invalid budget %q, the period must be daily or monthly", s)
+	}
+
+	amount := strings.ToLower(strings.ReplaceAll(parts[2], " ", ""))
+	switch {
+	case strings.HasPrefix(amount, "$"):
+		limit.Unit, amount = USD, strings.TrimPrefix(amount, "$")
+	case strings.HasSuffix(amount, string(USD)):
+		limit.Unit, amount = USD, strings.TrimSuffix(amount, string(USD))
+	case strings.HasSuffix(amount, string(Tokens)):
+		limit.Unit, amount = Tokens, strings.TrimSuffix(amount, string(Tokens))
+	case strings.HasSuffix(amount, string(Requests)):
+		limit.Unit, amount = Requests, strings.TrimSuffix(amount, string(Requests))
+	default:
+		return Limit{}, fmt.Errorf("invalid budget %q, the amount must be in dollars ($5), tokens (100000tokens) or requests (500requests)", s)
+	}
+
+	value, err := strconv.ParseFloat(amount, 64)
+	if err != nil || value < 0 {
+		return Limit{}, fmt.Errorf("invalid budget %q, %q is not a valid amount", s, amount)
+	}
+	limit.Amount = value
+	return limit, nil
+}
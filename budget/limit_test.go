@@ -0,0 +1,112 @@
+package budget_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/budget"
+)
+
+var _ = Describe("ParseLimit", func() {
+	var (
+		s     string
+		limit budget.Limit
+		err   error
+	)
+
+	JustBeforeEach(func() {
+		limit, err = budget.ParseLimit(s)
+	})
+
+	When("the amount is in dollars", func() {
+		BeforeEach(func() {
+			s = "bedrock:daily:$5"
+		})
+
+		It("parses the limit", func() {
+			Expect(limit, err).To(Equal(budget.Limit{Provider: "bedrock", Period: budget.Daily, Unit: budget.USD, Amount: 5}))
+		})
+	})
+
+	When("the amount is suffixed with usd", func() {
+		BeforeEach(func() {
+			s = "openai:monthly:12.50usd"
+		})
+
+		It("parses the limit", func() {
+			Expect(limit, err).To(Equal(budget.Limit{Provider: "openai", Period: budget.Monthly, Unit: budget.USD, Amount: 12.5}))
+		})
+	})
+
+	When("the amount is in tokens", func() {
+		BeforeEach(func() {
+			s = "openai:monthly:2000000tokens"
+		})
+
+		It("parses the limit", func() {
+			Expect(limit, err).To(Equal(budget.Limit{Provider: "openai", Period: budget.Monthly, Unit: budget.Tokens, Amount: 2000000}))
+		})
+	})
+
+	When("the amount is in requests", func() {
+		BeforeEach(func() {
+			s = "anthropic:daily:500requests"
+		})
+
+		It("parses the limit", func() {
+			Expect(limit, err).To(Equal(budget.Limit{Provider: "anthropic", Period: budget.Daily, Unit: budget.Requests, Amount: 500}))
+		})
+	})
+
+	When("a part is missing", func() {
+		BeforeEach(func() {
+			s = "bedrock:$5"
+		})
+
+		It("returns an error", func() {
+			Expect(limit, err).Error().To(MatchError(ContainSubstring("expected provider:period:amount")))
+		})
+	})
+
+	When("the period is unknown", func() {
+		BeforeEach(func() {
+			s = "bedrock:weekly:$5"
+		})
+
+		It("returns an error", func() {
+			Expect(limit, err).Error().To(MatchError(ContainSubstring("daily or monthly")))
+		})
+	})
+
+	When("the amount has no unit", func() {
+		BeforeEach(func() {
+			s = "bedrock:daily:5"
+		})
+
+		It("returns an error", func() {
+			Expect(limit, err).Error().To(MatchError(ContainSubstring("dollars ($5), tokens")))
+		})
+	})
+
+	When("the amount is not a number", func() {
+		BeforeEach(func() {
+			s = "bedrock:daily:$five"
+		})
+
+		It("returns an error", func() {
+			Expect(limit, err).Error().To(MatchError(ContainSubstring(`"five" is not a valid amount`)))
+		})
+	})
+})
+
+var _ = Describe("Limit", func() {
+	Describe("String", func() {
+		It("formats dollar amounts with cents", func() {
+			Expect(budget.Limit{Provider: "bedrock", Period: budget.Daily, Unit: budget.USD, Amount: 5}.String()).To(Equal("bedrock:daily:$5.00"))
+		})
+
+		It("formats counts with their unit", func() {
+			Expect(budget.Limit{Provider: "openai", Period: budget.Monthly, Unit: budget.Tokens, Amount: 2000000}.String()).To(Equal("openai:monthly:2000000 tokens"))
+		})
+	})
+})
@@ -0,0 +1,216 @@
+package budget
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zombor/gen/llm"
+	"github.com/zombor/gen/usage"
+)
+
+// Spent is what a provider has used in one period.
+type Spent struct {
+	// Period identifies the day ("2025-03-10") or month ("2025-03") the
+	// amounts were spent in.
+	Period   string  `json:"period"`
+	Requests int     `json:"requests"`
+	Tokens   int     `json:"tokens"`
+	Cost     float64 `json:"cost"`
+}
+
+func (s Spent) amount(unit Unit) float64 {
+	switch unit {
+	case Tokens:
+		return float64(s.Tokens)
+	case Requests:
+		return float64(s.Requests)
+	}
+	return s.Cost
+}
+
+// state is the content of the state file: what each provider has spent in
+// the current day and month.
+type state map[string]map[Period]Spent
+
+// Tracker records what each provider spends in a state file shared by every
+// gen process, and refuses providers that are over one of their Limits. It
+// implements llm.BudgetChecker and llm.UsageRecorder.
+type Tracker struct {
+	Path   string
+	Limits []Limit
+	// Prices estimates the cost of requests for dollar budgets.
+	Prices usage.Prices
+	Now    func() time.Time
+}
+
+// NewTracker creates a Tracker that keeps its state in path.
+func NewTracker(path string, limits []Limit, prices usage.Prices) *Tracker {
+	return &Tracker{
+		Path:   path,
+		Limits: limits,
+		Prices: prices,
+		Now:    time.Now,
+	}
+}
+
+// CheckPrice returns an error when provider has a dollar budget but the
+// price table has no price for model, since its requests would cost nothing
+// and never reach the budget.
+func (t *Tracker) CheckPrice(provider, model string) error {
+	for _, limit := range t.limits(provider) {
+		if limit.Unit != USD {
+			continue
+		}
+		if _, ok := t.Prices.Lookup(provider, model); !ok {
+			return fmt.Errorf("the budget %s needs the price of %s model %q; add \"%s %s <input> <output>\" in USD per million tokens to the prices file", limit, provider, model, provider, model)
+		}
+		return nil
+	}
+	return nil
+}
+
+// periodKey returns the key of the current period.
+func periodKey(period Period, now time.Time) string {
+	if period == Monthly {
+		return now.Format("2006-01")
+	}
+	return now.Format("2006-01-02")
+}
+
+func (t *Tracker) limits(provider string) []Limit {
+	var limits []Limit
+	for _, limit := range t.Limits {
+		if limit.Provider == provider {
+			limits = append(limits, limit)
+		}
+	}
+	return limits
+}
+
+// CheckBudget implements llm.BudgetChecker. A provider is over budget once it
+// has spent at least the limit, so the request that crosses a limit is still
+// made. The state is read under the lock RecordUsage takes, but the lock is
+// not held during the request, so gen processes that check at the same time
+// may each make one more request before the limit stops them.
+func (t *Tracker) CheckBudget(provider string) error {
+	limits := t.limits(provider)
+	if len(limits) == 0 {
+		return nil
+	}
+
+	unlock, err := lock(t.Path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	s, err := t.read()
+	if err != nil {
+		return err
+	}
+
+	now := t.Now()
+	for _, limit := range limits {
+		spent := s[provider][limit.Period]
+		if spent.Period != periodKey(limit.Period, now) {
+			continue
+		}
+		if used := spent.amount(limit.Unit); used >= limit.Amount {
+			return &llm.ProviderError{
+				Kind:     llm.ErrBudgetExceeded,
+				Provider: provider,
+				Err: fmt.Errorf("%s has used its %s budget of %s (%s spent, resets %s)",
+					provider, limit.Period, formatAmount(limit.Unit, limit.Amount), formatAmount(limit.Unit, used), resetsAt(limit.Period, now)),
+			}
+		}
+	}
+	return nil
+}
+
+// resetsAt describes when the budget for period resets.
+func resetsAt(period Period, now time.Time) string {
+	if period == Monthly {
+		return "on " + time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location()).Format("Jan 2")
+	}
+	return "at midnight"
+}
+
+// RecordUsage implements llm.UsageRecorder. Failed requests count against
+// request budgets too.
+func (t *Tracker) RecordUsage(record llm.UsageRecord) error {
+	if len(t.limits(record.Provider)) == 0 {
+		return nil
+	}
+
+	unlock, err := lock(t.Path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	s, err := t.read()
+	if err != nil {
+		return err
+	}
+
+	now := t.Now()
+	price, _ := t.Prices.Lookup(record.Provider, record.Model)
+	periods := map[Period]Spent{}
+	for _, period := range []Period{Daily, Monthly} {
+		spent := s[record.Provider][period]
+		if key := periodKey(period, now); spent.Period != key {
+			spent = Spent{Period: key}
+		}
+		spent.Requests++
+		spent.Tokens += record.InputTokens + record.OutputTokens
+		spent.Cost += price.Cost(record.Usage)
+		periods[period] = spent
+	}
+	s[record.Provider] = periods
+
+	return t.write(s)
+}
+
+func (t *Tracker) read() (state, error) {
+	data, err := os.ReadFile(t.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return state{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read budget state: %w", err)
+	}
+
+	s := state{}
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to read budget state from %s: %w", t.Path, err)
+	}
+	return s, nil
+}
+
+// write replaces the state file atomically, so that readers that do not
+// take the lock never see a partial file.
+func (t *Tracker) write(s state) error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(t.Path), filepath.Base(t.Path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), t.Path)
+}
@@ -0,0 +1,262 @@
+package budget_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/budget"
+	"github.com/zombor/gen/llm"
+	"github.com/zombor/gen/usage"
+)
+
+var _ = Describe("Tracker", func() {
+	var (
+		path    string
+		now     time.Time
+		tracker *budget.Tracker
+		record  llm.UsageRecord
+
+		// spend is called before the check under test.
+		spend func()
+		err   error
+	)
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "gen", "budget.json")
+		now = time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)
+		tracker = budget.NewTracker(path, []budget.Limit{
+			{Provider: "bedrock", Period: budget.Daily, Unit: budget.USD, Amount: 5},
+			{Provider: "bedrock", Period: budget.Monthly, Unit: budget.Requests, Amount: 3},
+			{Provider: "openai", Period: budget.Daily, Unit: budget.Tokens, Amount: 1000},
+		}, usage.Prices{"bedrock amazon.nova-pro-v1:0": {Input: 2000, Output: 2000}})
+		tracker.Now = func() time.Time { return now }
+		record = llm.UsageRecord{Provider: "bedrock", Model: "amazon.nova-pro-v1:0", Usage: llm.Usage{InputTokens: 1000, OutputTokens: 500}}
+		spend = func() {
+			Expect(tracker.RecordUsage(record)).To(Succeed())
+		}
+	})
+
+	Describe("CheckPrice", func() {
+		var provider, model string
+
+		BeforeEach(func() {
+			provider, model = "bedrock", "amazon.nova-pro-v1:0"
+		})
+
+		JustBeforeEach(func() {
+			err = tracker.CheckPrice(provider, model)
+		})
+
+		When("the model of a dollar budget has a price", func() {
+			It("succeeds", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		When("the model of a dollar budget has no price", func() {
+			BeforeEach(func() {
+				model = "meta.llama3-70b-instruct-v1:0"
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(`the budget bedrock:daily:$5.00 needs the price of bedrock model "meta.llama3-70b-instruct-v1:0"; add "bedrock meta.llama3-70b-instruct-v1:0 <input> <output>" in USD per million tokens to the prices file`))
+			})
+		})
+
+		When("the provider has no dollar budget", func() {
+			BeforeEach(func() {
+				provider, model = "openai", "gpt-4o"
+			})
+
+			It("succeeds", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("CheckBudget", func() {
+		var provider string
+
+		BeforeEach(func() {
+			provider = "bedrock"
+		})
+
+		JustBeforeEach(func() {
+			spend()
+			err = tracker.CheckBudget(provider)
+		})
+
+		When("the provider is within its budgets", func() {
+			It("succeeds", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		When("the provider has used its dollar budget", func() {
+			BeforeEach(func() {
+				spend = func() {
+					Expect(tracker.RecordUsage(record)).To(Succeed())
+					Expect(tracker.RecordUsage(record)).To(Succeed())
+				}
+			})
+
+			It("returns a budget error", func() {
+				Expect(err).To(MatchError(llm.ErrBudgetExceeded))
+			})
+
+			It("says which budget was used and when it resets", func() {
+				Expect(err).To(MatchError(ContainSubstring("bedrock has used its daily budget of $5.00 ($6.00 spent, resets at midnight)")))
+			})
+
+			It("names the provider", func() {
+				Expect(err).To(WithTransform(func(err error) string {
+					var providerErr *llm.ProviderError
+					errors.As(err, &providerErr)
+					return providerErr.Provider
+				}, Equal("bedrock")))
+			})
+		})
+
+		When("the dollar budget was used on an earlier day", func() {
+			BeforeEach(func() {
+				spend = func() {
+					Expect(tracker.RecordUsage(record)).To(Succeed())
+					Expect(tracker.RecordUsage(record)).To(Succeed())
+					now = now.Add(24 * time.Hour)
+				}
+			})
+
+			It("succeeds", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		When("the provider has used its monthly requests", func() {
+			BeforeEach(func() {
+				record.Usage = llm.Usage{}
+				spend = func() {
+					for i := 0; i < 3; i++ {
+						Expect(tracker.RecordUsage(record)).To(Succeed())
+						now = now.Add(24 * time.Hour)
+					}
+				}
+			})
+
+			It("says when the budget resets", func() {
+				Expect(err).To(MatchError(ContainSubstring("bedrock has used its monthly budget of 3 requests (3 requests spent, resets on Apr 1)")))
+			})
+		})
+
+		When("the provider has used its tokens", func() {
+			BeforeEach(func() {
+				provider = "openai"
+				record.Provider = "openai"
+			})
+
+			It("returns a budget error", func() {
+				Expect(err).To(MatchError(llm.ErrBudgetExceeded))
+			})
+		})
+
+		When("the provider has no budget", func() {
+			BeforeEach(func() {
+				provider = "ollama"
+				record.Provider = "ollama"
+			})
+
+			It("succeeds", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+
+		When("the lock cannot be taken", func() {
+			BeforeEach(func() {
+				parent := filepath.Join(GinkgoT().TempDir(), "file")
+				Expect(os.WriteFile(parent, nil, 0o600)).To(Succeed())
+				tracker.Path = filepath.Join(parent, "budget.json")
+				spend = func() {}
+			})
+
+			It("refuses the request", func() {
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		When("the state file is corrupt", func() {
+			BeforeEach(func() {
+				spend = func() {
+					Expect(os.MkdirAll(filepath.Dir(path), 0o700)).To(Succeed())
+					Expect(os.WriteFile(path, []byte("{"), 0o600)).To(Succeed())
+				}
+			})
+
+			It("refuses the request", func() {
+				Expect(err).To(MatchError(ContainSubstring("failed to read budget state")))
+			})
+		})
+	})
+
+	Describe("RecordUsage", func() {
+		When("gen runs concurrently", func() {
+			BeforeEach(func() {
+				record.Usage = llm.Usage{}
+				spend = func() {
+					var wg sync.WaitGroup
+					for i := 0; i < 3; i++ {
+						wg.Add(1)
+						go func() {
+							defer GinkgoRecover()
+							defer wg.Done()
+							Expect(tracker.RecordUsage(record)).To(Succeed())
+						}()
+					}
+					wg.Wait()
+				}
+			})
+
+			JustBeforeEach(func() {
+				spend()
+				err = tracker.CheckBudget("bedrock")
+			})
+
+			It("counts every request", func() {
+				Expect(err).To(MatchError(ContainSubstring("3 requests spent")))
+			})
+		})
+
+		When("the provider has no budget", func() {
+			BeforeEach(func() {
+				record.Provider = "ollama"
+			})
+
+			JustBeforeEach(func() {
+				spend()
+			})
+
+			It("does not create the state file", func() {
+				Expect(path).ToNot(BeAnExistingFile())
+			})
+		})
+
+		When("a gen that died left the lock file behind", func() {
+			BeforeEach(func() {
+				Expect(os.MkdirAll(filepath.Dir(path), 0o700)).To(Succeed())
+				Expect(os.WriteFile(path+".lock", nil, 0o600)).To(Succeed())
+			})
+
+			JustBeforeEach(func() {
+				err = tracker.RecordUsage(record)
+			})
+
+			It("takes the lock", func() {
+				Expect(err).ToNot(HaveOccurred())
+			})
+		})
+	})
+})
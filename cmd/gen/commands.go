@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zombor/gen/cmd/gen/config"
+	"github.com/zombor/gen/cmd/gen/tui"
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/history"
+	"github.com/zombor/gen/llm"
+	"github.com/zombor/gen/usage"
+)
+
+// runSubcommand runs the subcommand named by args, if any, and reports
+// whether it did. Anything else, such as "gen usage of /var", is treated as
+// a prompt.
+func runSubcommand(cfg *config.Config, args []string) (bool, error) {
+	switch {
+	case len(args) == 2 && args[0] == "cache" && args[1] == "clear":
+		if err := llm.NewDiskCache(cfg.Cache.Dir, cfg.Cache.TTL).Clear(); err != nil {
+			return true, fmt.Errorf("failed to clear the cache: %w", err)
+		}
+		fmt.Printf("Cleared the cache in %s\n", cfg.Cache.Dir)
+		return true, nil
+	case len(args) > 0 && args[0] == "usage" && onlyFlags(args[1:]):
+		return true, runUsage(cfg, args[1:], time.Now())
+	case len(args) > 0 && args[0] == "history" && onlyFlags(args[1:]):
+		return true, runHistory(cfg, args[1:])
+	}
+	return false, nil
+}
+
+// onlyFlags reports whether args are flags and their values. Every flag of
+// the subcommands takes a value, so the word after a flag without "=" is its
+// value.
+func onlyFlags(args []string) bool {
+	for i := 0; i < len(args); i++ {
+		if !strings.HasPrefix(args[i], "-") {
+			return false
+		}
+		if !strings.Contains(args[i], "=") {
+			i++
+		}
+	}
+	return true
+}
+
+// dateFormat is the format of the dates subcommands take.
+const dateFormat = "2006-01-02"
+
+// runUsage prints the usage report for the dates in args, by default the
+// current month up to and including today.
+func runUsage(cfg *config.Config, args []string, now time.Time) error {
+	year, month, day := now.Date()
+
+	fs := flag.NewFlagSet("gen usage", flag.ContinueOnError)
+	from := fs.String("from", time.Date(year, month, 1, 0, 0, 0, 0, now.Location()).Format(dateFormat), "first day of the report (YYYY-MM-DD)")
+	to := fs.String("to", time.Date(year, month, day, 0, 0, 0, 0, now.Location()).Format(dateFormat), "last day of the report (YYYY-MM-DD)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	start, err := time.ParseInLocation(dateFormat, *from, now.Location())
+	if err != nil {
+		return fmt.Errorf("invalid --from date %q, expected YYYY-MM-DD", *from)
+	}
+	end, err := time.ParseInLocation(dateFormat, *to, now.Location())
+	if err != nil {
+		return fmt.Errorf("invalid --to date %q, expected YYYY-MM-DD", *to)
+	}
+
+	prices, err := usage.LoadPrices(cfg.PricesFile)
+	if err != nil {
+		return err
+	}
+	records, err := usage.NewLog(cfg.UsageFile).Read(start, end.AddDate(0, 0, 1))
+	if err != nil {
+		return fmt.Errorf("failed to read usage: %w", err)
+	}
+
+	fmt.Printf("Usage from %s to %s\n\n", *from, *to)
+	return usage.WriteReport(os.Stdout, usage.Summarize(records, prices))
+}
+
+// runHistory finds the commands in the history that match the flags in args
+// and runs the one the user picks, after editing it in the TUI.
+func runHistory(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("gen history", flag.ContinueOnError)
+	search := fs.String("search", "", "words to look for in the prompts and commands")
+	from := fs.String("from", "", "first day of the commands (YYYY-MM-DD)")
+	to := fs.String("to", "", "last day of the commands (YYYY-MM-DD)")
+	provider := fs.String("provider", "", "only the commands of this provider")
+	dir := fs.String("dir", "", "only the commands run in this directory or under it, e.g. . for the current one")
+	limit := fs.Int("limit", 20, "most commands to show")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	filter := history.Filter{Search: *search, Provider: *provider}
+	if *from != "" {
+		start, err := time.ParseInLocation(dateFormat, *from, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid --from date %q, expected YYYY-MM-DD", *from)
+		}
+		filter.From = start
+	}
+	if *to != "" {
+		end, err := time.ParseInLocation(dateFormat, *to, time.Local)
+		if err != nil {
+			return fmt.Errorf("invalid --to date %q, expected YYYY-MM-DD", *to)
+		}
+		filter.To = end.AddDate(0, 0, 1)
+	}
+	if *dir != "" {
+		abs, err := filepath.Abs(*dir)
+		if err != nil {
+			return err
+		}
+		filter.Dir = abs
+	}
+
+	entries, err := history.NewLog(cfg.HistoryFile).Read()
+	if err != nil {
+		return fmt.Errorf("failed to read the history: %w", err)
+	}
+
+	ex, closeExecutor, err := newExecutor(cfg, environment.NewCollector().Collect(context.Background()), "")
+	if err != nil {
+		return err
+	}
+	defer closeExecutor()
+
+	if cfg.TUI {
+		return editHistory(ex, entries, filter, *limit)
+	}
+	return chooseHistory(ex, history.Find(entries, filter), *limit)
+}
+
+// editHistory lets the user search the history in the TUI and runs the
+// command they picked and accepted in the directory it ran in before.
+func editHistory(ex *executor, entries []history.Entry, filter history.Filter, limit int) error {
+	finalModel, err := tui.Run(tui.NewHistoryModel(entries, filter, limit, ex.env, ex.policy))
+	if err != nil {
+		return fmt.Errorf("failed to run the tui: %w", err)
+	}
+	m := finalModel.(tui.Model)
+	if m.Command() == "" {
+		return nil
+	}
+	ex.invocation.Prompt = m.Prompt()
+	if !m.Accepted() {
+		ex.abort(m.Response(), m.Command())
+		return nil
+	}
+	if err := ex.runIn(m.HistoryDir()); err != nil {
+		return err
+	}
+	return historyError(ex.execute(m.Response(), m.Command(), tuiApproval(m)))
+}
+
+// chooseHistory prints up to limit of the commands found and runs the one
+// the user picks in the directory it ran in before.
+func chooseHistory(ex *executor, found []history.Entry, limit int) error {
+	if len(found) == 0 {
+		fmt.Println("No commands found.")
+		return nil
+	}
+	if len(found) > limit {
+		found = found[:limit]
+	}
+
+	for i, entry := range found {
+		fmt.Printf("%d) %s\n", i+1, entry.Command)
+		status := ""
+		if entry.ExitCode != 0 {
+			status = fmt.Sprintf(", exit status %d", entry.ExitCode)
+		}
+		fmt.Printf("   %s (%s in %s%s)\n", entry.Prompt, entry.Time.Local().Format("2006-01-02 15:04"), entry.Dir, status)
+	}
+	choice, ok := ex.askChoice(len(found))
+	if !ok {
+		return nil
+	}
+	entry := found[choice]
+	ex.invocation.Prompt = entry.Prompt
+	if err := ex.runIn(entry.Dir); err != nil {
+		return err
+	}
+	return historyError(runChoice(ex, llm.Response{Command: entry.Command, Provider: entry.Provider}))
+}
+
+// historyError reports a command from the history that failed. Unlike a
+// generated one, it is not sent to the provider to be fixed.
+func historyError(failure *commandFailure) error {
+	if failure == nil {
+		return nil
+	}
+	return fmt.Errorf("the command failed with exit status %d", failure.exitCode)
+}
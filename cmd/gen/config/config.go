@@ -5,20 +5,79 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/peterbourgon/ff/v3"
+
+	"github.com/zombor/gen/budget"
+	"github.com/zombor/gen/llm"
 )
 
 // Config holds the configuration for the application.
 type Config struct {
-	Provider  string
-	Gemini    GeminiConfig
-	OpenAI    OpenAIConfig
-	Ollama    OllamaConfig
-	Anthropic AnthropicConfig
-	Bedrock   BedrockConfig
-	Debug     bool
-	TUI       bool
+	Provider string
+	// Providers lists the providers to try, in order. It holds just Provider
+	// unless a fallback chain is configured. With Race they are all asked at
+	// once instead.
+	Providers       []string
+	ProviderTimeout time.Duration
+	Race            bool
+	Consensus       bool
+	RaceGrace       time.Duration
+	Retry           RetryConfig
+	Gemini          GeminiConfig
+	OpenAI          OpenAIConfig
+	// OpenAICompatible is kept apart from OpenAI so that the OpenAI key is
+	// never sent to another server.
+	OpenAICompatible OpenAICompatibleConfig
+	Azure            AzureOpenAIConfig
+	Ollama           OllamaConfig
+	Anthropic        AnthropicConfig
+	Bedrock          BedrockConfig
+	Debug            bool
+	TUI              bool
+	Candidates       int
+	PromptsDir       string
+	Cache            CacheConfig
+	UsageFile        string
+	PricesFile       string
+	Budgets          []budget.Limit
+	BudgetFile       string
+	Generation       llm.GenerationParams
+	// ProviderGeneration holds the generation parameters of single
+	// providers, which take the place of Generation's.
+	ProviderGeneration map[string]llm.GenerationParams
+	PolicyFile         string
+	// AuditFile is the log every command offered to the user is recorded in.
+	AuditFile string
+	// AuditSyslog records the commands with the system logger too.
+	AuditSyslog bool
+	// HistoryFile keeps the commands that ran, for gen history.
+	HistoryFile string
+	// Redact hides secrets in prompts from the providers.
+	Redact bool
+	// Preview runs commands in a sandbox first to show what they change.
+	Preview bool
+	// Repairs is the number of times a command that is not valid shell
+	// syntax is sent back to the provider to be fixed.
+	Repairs int
+}
+
+// CacheConfig holds the configuration of the response cache.
+type CacheConfig struct {
+	Enabled bool
+	Dir     string
+	TTL     time.Duration
+}
+
+// RetryConfig holds the retry policy for rate limited and timed out requests.
+type RetryConfig struct {
+	Retries   int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
 }
 
 // GeminiConfig holds the configuration for the Gemini provider.
@@ -29,8 +88,38 @@ type GeminiConfig struct {
 
 // OpenAIConfig holds the configuration for the OpenAI provider.
 type OpenAIConfig struct {
-	APIKey string
-	Model  string
+	APIKey       string
+	Model        string
+	BaseURL      string
+	Organization string
+	Headers      map[string]string
+}
+
+// OpenAICompatibleConfig holds the configuration for the OpenAI-compatible
+// provider, and which parts of the OpenAI API its server supports.
+type OpenAICompatibleConfig struct {
+	APIKey  string
+	Model   string
+	BaseURL string
+	Headers map[string]string
+	// ResponseFormat is json_schema, json_object or text.
+	ResponseFormat string
+	// Strict asks the server to follow the JSON schema strictly.
+	Strict bool
+	// MaxCompletionTokens sends --max-tokens as max_completion_tokens
+	// rather than max_tokens.
+	MaxCompletionTokens bool
+	// StreamUsage asks for the token usage at the end of a stream.
+	StreamUsage bool
+}
+
+// AzureOpenAIConfig holds the configuration for the Azure OpenAI provider.
+type AzureOpenAIConfig struct {
+	Endpoint   string
+	Deployment string
+	APIVersion string
+	APIKey     string
+	Token      string
 }
 
 // OllamaConfig holds the configuration for the Ollama provider.
@@ -50,17 +139,198 @@ type BedrockConfig struct {
 	Model            string
 	Region           string
 	InferenceProfile string
+	API              string
+}
+
+// headerFlag collects repeated "Name: value" flags into a map.
+type headerFlag map[string]string
+
+func (h headerFlag) String() string {
+	headers := make([]string, 0, len(h))
+	for name, value := range h {
+		headers = append(headers, name+": "+value)
+	}
+	return strings.Join(headers, ", ")
+}
+
+func (h headerFlag) Set(value string) error {
+	name, v, ok := strings.Cut(value, ":")
+	if !ok || strings.TrimSpace(name) == "" {
+		return fmt.Errorf("invalid header %q, expected \"Name: value\"", value)
+	}
+	h[strings.TrimSpace(name)] = strings.TrimSpace(v)
+	return nil
+}
+
+// providerParamsFlag collects repeated provider:name=value flags into the
+// generation parameters of each provider.
+type providerParamsFlag map[string]llm.GenerationParams
+
+func (f providerParamsFlag) String() string {
+	providers := make([]string, 0, len(f))
+	for provider := range f {
+		providers = append(providers, provider)
+	}
+	return strings.Join(providers, ", ")
+}
+
+func (f providerParamsFlag) Set(value string) error {
+	provider, param, ok := strings.Cut(value, ":")
+	name, v, hasValue := strings.Cut(param, "=")
+	if !ok || !hasValue || provider == "" {
+		return fmt.Errorf("invalid provider parameter %q, expected provider:name=value", value)
+	}
+	params := f[provider]
+	switch name {
+	case "temperature", "top-p":
+		var number float32Flag
+		if err := number.Set(v); err != nil {
+			return err
+		}
+		if name == "temperature" {
+			params.Temperature = number.value
+		} else {
+			params.TopP = number.value
+		}
+	case "max-tokens":
+		var number intFlag
+		if err := number.Set(v); err != nil {
+			return err
+		}
+		params.MaxTokens = *number.value
+	case "stop":
+		params.Stop = append(params.Stop, v)
+	case "seed":
+		var number intFlag
+		if err := number.Set(v); err != nil {
+			return err
+		}
+		params.Seed = number.value
+	default:
+		return fmt.Errorf("unknown generation parameter %q, expected temperature, top-p, max-tokens, stop or seed", name)
+	}
+	f[provider] = params
+	return nil
+}
+
+// maxTemperatures are the highest temperatures of the providers whose APIs
+// do not accept up to 2: Anthropic's, and those of the models on Bedrock.
+var maxTemperatures = map[string]float32{"anthropic": 1, "bedrock": 1}
+
+// GenerationFor returns the generation parameters of the named provider.
+func (c *Config) GenerationFor(provider string) llm.GenerationParams {
+	return c.Generation.With(c.ProviderGeneration[provider])
+}
+
+// budgetFlag collects repeated provider:period:amount flags.
+type budgetFlag []budget.Limit
+
+func (b *budgetFlag) String() string {
+	limits := make([]string, 0, len(*b))
+	for _, limit := range *b {
+		limits = append(limits, limit.String())
+	}
+	return strings.Join(limits, ", ")
+}
+
+func (b *budgetFlag) Set(value string) error {
+	limit, err := budget.ParseLimit(value)
+	if err != nil {
+		return err
+	}
+	*b = append(*b, limit)
+	return nil
+}
+
+// float32Flag is a float flag that records whether it was set.
+type float32Flag struct {
+	value *float32
+}
+
+func (f *float32Flag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return strconv.FormatFloat(float64(*f.value), 'g', -1, 32)
+}
+
+func (f *float32Flag) Set(value string) error {
+	v, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return fmt.Errorf("invalid number %q", value)
+	}
+	f.value = new(float32)
+	*f.value = float32(v)
+	return nil
+}
+
+// intFlag is an int flag that records whether it was set.
+type intFlag struct {
+	value *int
+}
+
+func (f *intFlag) String() string {
+	if f.value == nil {
+		return ""
+	}
+	return strconv.Itoa(*f.value)
+}
+
+func (f *intFlag) Set(value string) error {
+	v, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("invalid integer %q", value)
+	}
+	f.value = &v
+	return nil
+}
+
+// stringsFlag collects repeated flags.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringsFlag) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
 
 // Load loads the configuration from a file, environment variables, and flags.
 func Load(version, commit, date string) (*Config, []string, error) {
 	fs := flag.NewFlagSet("gen", flag.ExitOnError)
 	var (
-		provider                = fs.String("provider", "gemini", "LLM provider to use (gemini, openai, ollama, anthropic, or bedrock)")
+		provider                = fs.String("provider", "gemini", "LLM provider to use (gemini, openai, openai-compatible, azure-openai, ollama, anthropic, or bedrock)")
+		providers               = fs.String("providers", "", "comma separated providers to try in order, e.g. ollama,bedrock,openai (overrides --provider)")
+		providerTimeout         = fs.Duration("provider-timeout", 30*time.Second, "how long to wait for each provider before trying the next one")
+		race                    = fs.Bool("race", false, "ask all --providers at once and use the first answer")
+		consensus               = fs.Bool("consensus", false, "ask all --providers and compare their commands")
+		raceGrace               = fs.Duration("race-grace", 0, "with --race, how long to wait after the first answer for a better one")
+		repairs                 = fs.Int("repairs", 2, "number of times to ask the provider to fix a command that is not valid shell syntax (0 to disable)")
+		retries                 = fs.Int("retries", 2, "number of times to retry a rate limited or timed out request")
+		retryBaseDelay          = fs.Duration("retry-base-delay", 500*time.Millisecond, "delay before the first retry, doubled after each retry")
+		retryMaxDelay           = fs.Duration("retry-max-delay", 10*time.Second, "longest delay between retries, including a provider's Retry-After")
 		geminiAPIKey            = fs.String("gemini-api-key", "", "Gemini API key")
 		geminiModel             = fs.String("gemini-model", "gemini-1.5-flash", "Gemini model to use")
 		openaiAPIKey            = fs.String("openai-api-key", "", "OpenAI API key")
 		openaiModel             = fs.String("openai-model", "gpt-4o", "OpenAI model to use")
+		openaiBaseURL           = fs.String("openai-base-url", "", "base URL of an OpenAI-compatible API, e.g. http://localhost:8000/v1")
+		openaiOrganization      = fs.String("openai-organization", "", "OpenAI organization ID (optional)")
+		openaiHeaders           = headerFlag{}
+		compatibleAPIKey        = fs.String("openai-compatible-api-key", "", "API key of the OpenAI-compatible server (optional)")
+		compatibleModel         = fs.String("openai-compatible-model", "", "model of the OpenAI-compatible server to use")
+		compatibleBaseURL       = fs.String("openai-compatible-base-url", "", "base URL of the OpenAI-compatible server, e.g. http://localhost:8000/v1")
+		compatibleHeaders       = headerFlag{}
+		compatibleFormat        = fs.String("openai-compatible-response-format", "json_schema", "response_format the OpenAI-compatible server supports: json_schema, json_object or text (none)")
+		compatibleStrict        = fs.Bool("openai-compatible-strict", true, "ask the OpenAI-compatible server to follow the JSON schema strictly")
+		compatibleMaxCompletion = fs.Bool("openai-compatible-max-completion-tokens", false, "send --max-tokens to the OpenAI-compatible server as max_completion_tokens instead of max_tokens")
+		compatibleStreamUsage   = fs.Bool("openai-compatible-stream-usage", true, "ask the OpenAI-compatible server for the token usage of streamed answers")
+		azureEndpoint           = fs.String("azure-openai-endpoint", "", "Azure OpenAI endpoint, e.g. https://<resource>.openai.azure.com")
+		azureDeployment         = fs.String("azure-openai-deployment", "", "Azure OpenAI deployment name")
+		azureAPIVersion         = fs.String("azure-openai-api-version", "2024-10-21", "Azure OpenAI API version")
+		azureAPIKey             = fs.String("azure-openai-api-key", "", "Azure OpenAI API key")
+		azureToken              = fs.String("azure-openai-token", "", "Microsoft Entra ID bearer token for Azure OpenAI (instead of an API key)")
 		ollamaHost              = fs.String("ollama-host", "http://localhost:11434", "Ollama host")
 		ollamaModel             = fs.String("ollama-model", "llama2", "Ollama model")
 		anthropicAPIKey         = fs.String("anthropic-api-key", "", "Anthropic API key")
@@ -68,12 +338,43 @@ func Load(version, commit, date string) (*Config, []string, error) {
 		bedrockModel            = fs.String("bedrock-model", "amazon.nova-lite-v1:0", "Bedrock model to use")
 		bedrockRegion           = fs.String("bedrock-region", "us-east-1", "AWS region for Bedrock")
 		bedrockInferenceProfile = fs.String("bedrock-inference-profile", "", "Bedrock inference profile ID or ARN (optional)")
+		bedrockAPI              = fs.String("bedrock-api", "converse", "Bedrock API to use: converse (any model) or invoke (legacy, fixed set of models)")
 		configPath              = fs.String("config", "", "path to config file")
 		showVersion             = fs.Bool("version", false, "show version")
 		debug                   = fs.Bool("debug", false, "enable debug logging")
 		tui                     = fs.Bool("tui", true, "enable TUI")
+		preview                 = fs.Bool("preview", false, "run commands in a sandbox first and show the files they change before running them for real (Linux only)")
+		candidates              = fs.Int("candidates", 1, "number of alternative commands to generate")
+		promptsDir              = fs.String("prompts-dir", "", "directory of prompt template overrides (default ~/.gen/prompts)")
+		noRedact                = fs.Bool("no-redact", false, "send secrets such as API keys in the prompt to the provider instead of placeholders")
+		noCache                 = fs.Bool("no-cache", false, "always ask the provider instead of reusing a cached command")
+		cacheDir                = fs.String("cache-dir", "", "directory of cached commands (default the user cache directory, e.g. ~/.cache/gen)")
+		usageFile               = fs.String("usage-file", "", "file the tokens and latency of each request are recorded in (default ~/.gen/usage.jsonl)")
+		pricesFile              = fs.String("prices-file", "", "price table used to estimate costs in gen usage and dollar budgets (default ~/.gen/prices)")
+		budgetFile              = fs.String("budget-file", "", "file the spending of providers with a budget is tracked in (default ~/.gen/budget.json)")
+		policyFile              = fs.String("policy-file", "", "your own policy of allowed, confirmed and denied commands, on top of /etc/gen/policy (default ~/.gen/policy)")
+		auditFile               = fs.String("audit-file", "", "append-only log of every generated command and what became of it (default ~/.gen/audit.jsonl)")
+		auditSyslog             = fs.Bool("audit-syslog", false, "send the audit log to the system logger (journald on most Linux systems) as well")
+		historyFile             = fs.String("history-file", "", "file the commands you ran are kept in for gen history (default ~/.gen/history.jsonl)")
+		budgets                 budgetFlag
+		temperature             float32Flag
+		topP                    float32Flag
+		maxTokens               = fs.Int("max-tokens", 0, "longest answer in tokens; raise it for reasoning models (default the provider's, 200 for bedrock and 1000 for anthropic)")
+		stop                    stringsFlag
+		seed                    intFlag
+		providerParams          = providerParamsFlag{}
+		cacheTTL                = fs.Duration("cache-ttl", 7*24*time.Hour, "how long cached commands are reused (0 keeps them forever)")
 	)
 
+	fs.Var(&budgets, "budget", "daily or monthly budget of a provider as provider:period:amount, e.g. bedrock:daily:$5, openai:monthly:2000000tokens or anthropic:daily:500requests (repeatable)")
+	fs.Var(&temperature, "temperature", "sampling temperature; 0 gives the most repeatable answers (default the provider's)")
+	fs.Var(&topP, "top-p", "nucleus sampling probability between 0 and 1 (default the provider's)")
+	fs.Var(&stop, "stop", "sequence that ends the answer (repeatable)")
+	fs.Var(&seed, "seed", "seed for deterministic sampling, supported by openai and ollama")
+	fs.Var(providerParams, "provider-param", "generation parameter of one provider as provider:name=value, e.g. anthropic:temperature=0.5, in place of --temperature, --top-p, --max-tokens, --stop or --seed (repeatable)")
+	fs.Var(openaiHeaders, "openai-header", "extra \"Name: value\" header sent to the OpenAI API (repeatable)")
+	fs.Var(compatibleHeaders, "openai-compatible-header", "extra \"Name: value\" header sent to the OpenAI-compatible server (repeatable)")
+
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return nil, nil, err
@@ -101,10 +402,46 @@ func Load(version, commit, date string) (*Config, []string, error) {
 	}
 
 	cfg.Provider = *provider
+	cfg.Providers = []string{*provider}
+	if *providers != "" {
+		cfg.Providers = nil
+		for _, name := range strings.Split(*providers, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				cfg.Providers = append(cfg.Providers, name)
+			}
+		}
+		if len(cfg.Providers) == 0 {
+			return nil, nil, fmt.Errorf("providers must list at least one provider")
+		}
+		cfg.Provider = cfg.Providers[0]
+	}
+	cfg.ProviderTimeout = *providerTimeout
+	cfg.Race = *race
+	cfg.RaceGrace = *raceGrace
+	cfg.Consensus = *consensus
+	cfg.Retry.Retries = *retries
+	cfg.Retry.BaseDelay = *retryBaseDelay
+	cfg.Retry.MaxDelay = *retryMaxDelay
 	cfg.Gemini.APIKey = *geminiAPIKey
 	cfg.Gemini.Model = *geminiModel
 	cfg.OpenAI.APIKey = *openaiAPIKey
 	cfg.OpenAI.Model = *openaiModel
+	cfg.OpenAI.BaseURL = *openaiBaseURL
+	cfg.OpenAI.Organization = *openaiOrganization
+	cfg.OpenAI.Headers = openaiHeaders
+	cfg.OpenAICompatible.APIKey = *compatibleAPIKey
+	cfg.OpenAICompatible.Model = *compatibleModel
+	cfg.OpenAICompatible.BaseURL = *compatibleBaseURL
+	cfg.OpenAICompatible.Headers = compatibleHeaders
+	cfg.OpenAICompatible.ResponseFormat = *compatibleFormat
+	cfg.OpenAICompatible.Strict = *compatibleStrict
+	cfg.OpenAICompatible.MaxCompletionTokens = *compatibleMaxCompletion
+	cfg.OpenAICompatible.StreamUsage = *compatibleStreamUsage
+	cfg.Azure.Endpoint = *azureEndpoint
+	cfg.Azure.Deployment = *azureDeployment
+	cfg.Azure.APIVersion = *azureAPIVersion
+	cfg.Azure.APIKey = *azureAPIKey
+	cfg.Azure.Token = *azureToken
 	cfg.Ollama.Host = *ollamaHost
 	cfg.Ollama.Model = *ollamaModel
 	cfg.Anthropic.APIKey = *anthropicAPIKey
@@ -112,8 +449,69 @@ func Load(version, commit, date string) (*Config, []string, error) {
 	cfg.Bedrock.Model = *bedrockModel
 	cfg.Bedrock.Region = *bedrockRegion
 	cfg.Bedrock.InferenceProfile = *bedrockInferenceProfile
+	cfg.Bedrock.API = *bedrockAPI
 	cfg.Debug = *debug
 	cfg.TUI = *tui
+	cfg.Preview = *preview
+	cfg.Redact = !*noRedact
+	cfg.Candidates = *candidates
+	cfg.PromptsDir = *promptsDir
+	cfg.Cache.Enabled = !*noCache
+	cfg.Cache.Dir = *cacheDir
+	cfg.Cache.TTL = *cacheTTL
+	cfg.UsageFile = *usageFile
+	cfg.PricesFile = *pricesFile
+	cfg.Budgets = budgets
+	cfg.BudgetFile = *budgetFile
+	cfg.PolicyFile = *policyFile
+	cfg.AuditFile = *auditFile
+	cfg.AuditSyslog = *auditSyslog
+	cfg.HistoryFile = *historyFile
+	cfg.Repairs = *repairs
+	cfg.Generation = llm.GenerationParams{
+		Temperature: temperature.value,
+		TopP:        topP.value,
+		MaxTokens:   *maxTokens,
+		Stop:        stop,
+		Seed:        seed.value,
+	}
+	cfg.ProviderGeneration = providerParams
+
+	if cfg.PromptsDir == "" {
+		cfg.PromptsDir = filepath.Join(home, ".gen", "prompts")
+	}
+
+	if cfg.UsageFile == "" {
+		cfg.UsageFile = filepath.Join(home, ".gen", "usage.jsonl")
+	}
+
+	if cfg.BudgetFile == "" {
+		cfg.BudgetFile = filepath.Join(home, ".gen", "budget.json")
+	}
+
+	if cfg.PricesFile == "" {
+		cfg.PricesFile = filepath.Join(home, ".gen", "prices")
+	}
+
+	if cfg.PolicyFile == "" {
+		cfg.PolicyFile = filepath.Join(home, ".gen", "policy")
+	}
+
+	if cfg.AuditFile == "" {
+		cfg.AuditFile = filepath.Join(home, ".gen", "audit.jsonl")
+	}
+
+	if cfg.HistoryFile == "" {
+		cfg.HistoryFile = filepath.Join(home, ".gen", "history.jsonl")
+	}
+
+	if cfg.Cache.Dir == "" {
+		userCacheDir, err := os.UserCacheDir()
+		if err != nil {
+			userCacheDir = filepath.Join(home, ".cache")
+		}
+		cfg.Cache.Dir = filepath.Join(userCacheDir, "gen")
+	}
 
 	// When debug mode is enabled, force TUI off
 	if cfg.Debug {
@@ -125,5 +523,76 @@ func Load(version, commit, date string) (*Config, []string, error) {
 		os.Exit(0)
 	}
 
+	if cfg.Candidates < 1 {
+		return nil, nil, fmt.Errorf("candidates must be at least 1")
+	}
+
+	if cfg.Consensus && len(cfg.Providers) < 2 {
+		return nil, nil, fmt.Errorf("consensus needs at least two providers in --providers")
+	}
+
+	if cfg.Consensus && cfg.Race {
+		return nil, nil, fmt.Errorf("consensus and race cannot be used together")
+	}
+
+	if cfg.Consensus && cfg.Candidates > 1 {
+		return nil, nil, fmt.Errorf("consensus and candidates cannot be used together")
+	}
+
+	if cfg.RaceGrace < 0 {
+		return nil, nil, fmt.Errorf("race-grace must not be negative")
+	}
+
+	if t := cfg.Generation.Temperature; t != nil && (*t < 0 || *t > 2) {
+		return nil, nil, fmt.Errorf("temperature must be between 0 and 2")
+	}
+
+	if p := cfg.Generation.TopP; p != nil && (*p < 0 || *p > 1) {
+		return nil, nil, fmt.Errorf("top-p must be between 0 and 1")
+	}
+
+	if cfg.Generation.MaxTokens < 0 {
+		return nil, nil, fmt.Errorf("max-tokens must not be negative")
+	}
+
+	for _, name := range cfg.Providers {
+		params := cfg.GenerationFor(name)
+		maxTemperature, ok := maxTemperatures[name]
+		if !ok {
+			maxTemperature = 2
+		}
+		if t := params.Temperature; t != nil && (*t < 0 || *t > maxTemperature) {
+			return nil, nil, fmt.Errorf("temperature of %s must be between 0 and %g; set it with --provider-param %s:temperature=<value>", name, maxTemperature, name)
+		}
+		if p := params.TopP; p != nil && (*p < 0 || *p > 1) {
+			return nil, nil, fmt.Errorf("top-p of %s must be between 0 and 1", name)
+		}
+		if params.MaxTokens < 0 {
+			return nil, nil, fmt.Errorf("max-tokens of %s must not be negative", name)
+		}
+	}
+
+	if cfg.Repairs < 0 {
+		return nil, nil, fmt.Errorf("repairs must not be negative")
+	}
+
+	if cfg.Retry.Retries < 0 {
+		return nil, nil, fmt.Errorf("retries must not be negative")
+	}
+
+	if slices.Contains(cfg.Providers, "openai-compatible") && cfg.OpenAICompatible.BaseURL == "" {
+		return nil, nil, fmt.Errorf("the openai-compatible provider requires --openai-compatible-base-url")
+	}
+
+	if slices.Contains(cfg.Providers, "openai-compatible") && cfg.OpenAICompatible.Model == "" {
+		return nil, nil, fmt.Errorf("the openai-compatible provider requires --openai-compatible-model")
+	}
+
+	switch cfg.OpenAICompatible.ResponseFormat {
+	case "json_schema", "json_object", "text":
+	default:
+		return nil, nil, fmt.Errorf("openai-compatible-response-format must be json_schema, json_object or text")
+	}
+
 	return cfg, fs.Args(), nil
 }
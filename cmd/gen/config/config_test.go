@@ -0,0 +1,155 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/cmd/gen/config"
+)
+
+var _ = Describe("Load", func() {
+	var (
+		home string
+		args []string
+		cfg  *config.Config
+		rest []string
+		err  error
+	)
+
+	BeforeEach(func() {
+		home = GinkgoT().TempDir()
+		GinkgoT().Setenv("HOME", home)
+		Expect(os.MkdirAll(filepath.Join(home, ".gen"), 0o700)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(home, ".gen", "config"), nil, 0o600)).To(Succeed())
+		args = []string{"list", "files"}
+		osArgs := os.Args
+		DeferCleanup(func() { os.Args = osArgs })
+	})
+
+	JustBeforeEach(func() {
+		os.Args = append([]string{"gen"}, args...)
+		cfg, rest, err = config.Load("1.0.0", "abc", "today")
+	})
+
+	It("returns the arguments after the flags", func() {
+		Expect(rest, err).To(Equal([]string{"list", "files"}))
+	})
+
+	It("uses gemini by default", func() {
+		Expect(cfg.Providers, err).To(Equal([]string{"gemini"}))
+	})
+
+	It("keeps the audit log in the home directory", func() {
+		Expect(cfg.AuditFile, err).To(Equal(filepath.Join(home, ".gen", "audit.jsonl")))
+	})
+
+	It("keeps the history in the home directory", func() {
+		Expect(cfg.HistoryFile, err).To(Equal(filepath.Join(home, ".gen", "history.jsonl")))
+	})
+
+	Context("with --providers", func() {
+		BeforeEach(func() {
+			args = []string{"--providers", "ollama, bedrock", "list"}
+		})
+
+		It("lists the providers in order", func() {
+			Expect(cfg.Providers, err).To(Equal([]string{"ollama", "bedrock"}))
+		})
+
+		It("makes the first one the provider", func() {
+			Expect(cfg.Provider, err).To(Equal("ollama"))
+		})
+	})
+
+	Context("with the openai-compatible provider", func() {
+		BeforeEach(func() {
+			args = []string{"--provider", "openai-compatible", "--openai-compatible-base-url", "http://localhost:8000/v1", "--openai-compatible-model", "llama", "--openai-compatible-strict=false"}
+		})
+
+		It("keeps its settings apart from openai", func() {
+			Expect(cfg.OpenAICompatible, err).To(Equal(config.OpenAICompatibleConfig{
+				BaseURL:        "http://localhost:8000/v1",
+				Model:          "llama",
+				Headers:        map[string]string{},
+				ResponseFormat: "json_schema",
+				StreamUsage:    true,
+			}))
+		})
+	})
+
+	Context("with --provider-param", func() {
+		BeforeEach(func() {
+			args = []string{"--providers", "ollama,openai", "--temperature", "0.2", "--max-tokens", "500", "--provider-param", "ollama:temperature=1.5", "--provider-param", "ollama:stop=END", "--provider-param", "ollama:seed=42"}
+		})
+
+		It("overrides the parameters of that provider", func() {
+			Expect(cfg.GenerationFor("ollama"), err).To(And(
+				HaveField("Temperature", HaveValue(BeNumerically("~", 1.5))),
+				HaveField("Stop", Equal([]string{"END"})),
+				HaveField("Seed", HaveValue(Equal(42))),
+			))
+		})
+
+		It("keeps the parameters it does not set", func() {
+			Expect(cfg.GenerationFor("ollama"), err).To(HaveField("MaxTokens", 500))
+		})
+
+		It("leaves the other providers alone", func() {
+			Expect(cfg.GenerationFor("openai"), err).To(HaveField("Temperature", HaveValue(BeNumerically("~", 0.2))))
+		})
+	})
+
+	Context("with an environment variable", func() {
+		BeforeEach(func() {
+			GinkgoT().Setenv("GEN_PROVIDER", "ollama")
+		})
+
+		It("uses it", func() {
+			Expect(cfg.Provider, err).To(Equal("ollama"))
+		})
+	})
+
+	Context("with a config file", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(home, ".gen", "config"), []byte("provider anthropic\n"), 0o600)).To(Succeed())
+		})
+
+		It("uses it", func() {
+			Expect(cfg.Provider, err).To(Equal("anthropic"))
+		})
+	})
+
+	Context("with --debug", func() {
+		BeforeEach(func() {
+			args = []string{"--debug"}
+		})
+
+		It("turns the TUI off", func() {
+			Expect(cfg.TUI, err).To(BeFalse())
+		})
+	})
+
+	DescribeTable("rejects invalid flags",
+		func(flags []string, message string) {
+			os.Args = append([]string{"gen"}, flags...)
+			_, _, err := config.Load("1.0.0", "abc", "today")
+			Expect(err).To(MatchError(message))
+		},
+		Entry("no candidates", []string{"--candidates", "0"}, "candidates must be at least 1"),
+		Entry("consensus of one provider", []string{"--consensus"}, "consensus needs at least two providers in --providers"),
+		Entry("an empty list of providers", []string{"--providers", ","}, "providers must list at least one provider"),
+		Entry("a temperature above 2", []string{"--temperature", "3"}, "temperature must be between 0 and 2"),
+		Entry("a top-p above 1", []string{"--top-p", "2"}, "top-p must be between 0 and 1"),
+		Entry("an anthropic temperature above 1", []string{"--provider", "anthropic", "--temperature", "1.5"}, "temperature of anthropic must be between 0 and 1; set it with --provider-param anthropic:temperature=<value>"),
+		Entry("a bedrock temperature above 1", []string{"--providers", "openai,bedrock", "--provider-param", "bedrock:temperature=2"}, "temperature of bedrock must be between 0 and 1; set it with --provider-param bedrock:temperature=<value>"),
+		Entry("a provider top-p above 1", []string{"--provider-param", "gemini:top-p=1.5"}, "top-p of gemini must be between 0 and 1"),
+		Entry("negative provider max-tokens", []string{"--provider-param", "gemini:max-tokens=-1"}, "max-tokens of gemini must not be negative"),
+		Entry("negative repairs", []string{"--repairs", "-1"}, "repairs must not be negative"),
+		Entry("openai-compatible without a base URL", []string{"--provider", "openai-compatible", "--openai-base-url", "http://localhost:8000/v1"}, "the openai-compatible provider requires --openai-compatible-base-url"),
+		Entry("openai-compatible without a model", []string{"--provider", "openai-compatible", "--openai-compatible-base-url", "http://localhost:8000/v1"}, "the openai-compatible provider requires --openai-compatible-model"),
+		Entry("an unknown response format", []string{"--openai-compatible-response-format", "xml"}, "openai-compatible-response-format must be json_schema, json_object or text"),
+	)
+})
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/zombor/gen/cmd/gen/config"
+	"github.com/zombor/gen/llm"
+)
+
+// printError prints err followed by a hint on how to fix each provider
+// failure it contains.
+func printError(cfg *config.Config, err error) {
+	fmt.Printf("Error: %v\n", err)
+	for _, hint := range errorHints(cfg, err) {
+		fmt.Printf("Hint: %s\n", hint)
+	}
+}
+
+// errorHints returns a hint for every provider error in the err tree. A
+// provider error without a provider name came from the only configured
+// provider.
+func errorHints(cfg *config.Config, err error) []string {
+	var hints []string
+	seen := map[string]bool{}
+	for _, providerErr := range providerErrors(err) {
+		name := providerErr.Provider
+		if name == "" && len(cfg.Providers) > 0 {
+			name = cfg.Providers[0]
+		}
+		hint := errorHint(cfg, name, providerErr)
+		if hint != "" && !seen[hint] {
+			seen[hint] = true
+			hints = append(hints, hint)
+		}
+	}
+	return hints
+}
+
+// providerErrors collects the provider errors in the err tree, such as the
+// joined errors of a fallback chain.
+func providerErrors(err error) []*llm.ProviderError {
+	if providerErr, ok := err.(*llm.ProviderError); ok {
+		return []*llm.ProviderError{providerErr}
+	}
+
+	switch err := err.(type) {
+	case interface{ Unwrap() error }:
+		return providerErrors(err.Unwrap())
+	case interface{ Unwrap() []error }:
+		var providerErrs []*llm.ProviderError
+		for _, err := range err.Unwrap() {
+			providerErrs = append(providerErrs, providerErrors(err)...)
+		}
+		return providerErrs
+	}
+	return nil
+}
+
+// errorHint tells the user what to do about a failure of the named provider.
+func errorHint(cfg *config.Config, name string, err *llm.ProviderError) string {
+	switch {
+	case errors.Is(err, llm.ErrAuth):
+		return authHint(name)
+	case errors.Is(err, llm.ErrModelNotFound):
+		return modelHint(cfg, name)
+	case errors.Is(err, llm.ErrRateLimited):
+		return fmt.Sprintf("%s is rate limiting requests; wait a moment and try again, or raise --retries and --retry-max-delay", name)
+	case errors.Is(err, llm.ErrQuotaExceeded):
+		return fmt.Sprintf("the %s account is out of quota; check its plan and billing, or add another provider with --providers", name)
+	case errors.Is(err, llm.ErrContentFiltered):
+		return fmt.Sprintf("%s refused to answer; rephrase the prompt", name)
+	case errors.Is(err, llm.ErrEmptyResponse):
+		return fmt.Sprintf("%s did not return a command; rephrase the prompt or try another model", name)
+	case errors.Is(err, llm.ErrBudgetExceeded):
+		return fmt.Sprintf("raise or remove the %s budget with --budget, wait for it to reset, or add another provider with --providers", name)
+	case errors.Is(err, llm.ErrTimeout):
+		return fmt.Sprintf("%s did not answer in time; try again or raise --provider-timeout", name)
+	}
+	return ""
+}
+
+func authHint(name string) string {
+	switch name {
+	case "gemini":
+		return "set a valid Gemini API key with GEN_GEMINI_API_KEY or --gemini-api-key"
+	case "openai":
+		return "set a valid API key with GEN_OPENAI_API_KEY or --openai-api-key"
+	case "openai-compatible":
+		return "set a valid API key with GEN_OPENAI_COMPATIBLE_API_KEY or --openai-compatible-api-key"
+	case "azure-openai":
+		return "set a valid key with GEN_AZURE_OPENAI_API_KEY, or an Entra ID token with GEN_AZURE_OPENAI_TOKEN"
+	case "anthropic":
+		return "set a valid Anthropic API key with GEN_ANTHROPIC_API_KEY or --anthropic-api-key"
+	case "bedrock":
+		return "check your AWS credentials (AWS_PROFILE or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY) and that they allow bedrock:InvokeModel"
+	}
+	return fmt.Sprintf("check the credentials for %s", name)
+}
+
+func modelHint(cfg *config.Config, name string) string {
+	switch name {
+	case "gemini":
+		return fmt.Sprintf("the Gemini model %q does not exist; set another with GEN_GEMINI_MODEL", cfg.Gemini.Model)
+	case "openai":
+		return fmt.Sprintf("the model %q does not exist; set another with GEN_OPENAI_MODEL", cfg.OpenAI.Model)
+	case "openai-compatible":
+		return fmt.Sprintf("the model %q does not exist; set another with GEN_OPENAI_COMPATIBLE_MODEL", cfg.OpenAICompatible.Model)
+	case "azure-openai":
+		return fmt.Sprintf("the deployment %q does not exist; set another with GEN_AZURE_OPENAI_DEPLOYMENT", cfg.Azure.Deployment)
+	case "ollama":
+		return fmt.Sprintf("the model %q is not installed; run `ollama pull %s`", cfg.Ollama.Model, cfg.Ollama.Model)
+	case "anthropic":
+		return fmt.Sprintf("the Anthropic model %q does not exist; set another with GEN_ANTHROPIC_MODEL", cfg.Anthropic.Model)
+	case "bedrock":
+		return fmt.Sprintf("the Bedrock model %q is not available in %s; request access in the Bedrock console or set another with GEN_BEDROCK_MODEL", cfg.Bedrock.Model, cfg.Bedrock.Region)
+	}
+	return fmt.Sprintf("check the model configured for %s", name)
+}
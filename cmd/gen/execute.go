@@ -0,0 +1,416 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/zombor/gen/audit"
+	"github.com/zombor/gen/cmd/gen/config"
+	"github.com/zombor/gen/cmd/gen/tui"
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/history"
+	"github.com/zombor/gen/llm"
+	"github.com/zombor/gen/policy"
+	"github.com/zombor/gen/preview"
+	"github.com/zombor/gen/shell"
+)
+
+// executor runs the commands the user accepted. It is the only place
+// commands are run, so every one of them is checked against the policy and
+// recorded in the audit log.
+type executor struct {
+	env    environment.Environment
+	policy *policy.Policy
+	// preview runs commands in a sandbox first to show what they change.
+	preview bool
+	audit   audit.Recorder
+	// invocation holds what is known before a command is generated: the
+	// user, host, working directory, prompt and the first provider.
+	invocation audit.Entry
+	// models maps the providers to their models.
+	models map[string]string
+	// history keeps the commands that ran, to be found with gen history.
+	history *history.Log
+
+	// readLine reads the user's answer to a question.
+	readLine func() string
+	// run runs a command with a shell in a directory.
+	run func(shell, dir, command string) (*commandFailure, error)
+	// sandbox runs a command with a shell in the preview sandbox of a
+	// directory.
+	sandbox func(shell, dir, command string) (preview.Result, error)
+	// exit ends gen with a status.
+	exit func(code int)
+}
+
+// newExecutor creates the executor of the commands generated for prompt in
+// env. The returned func releases its resources.
+func newExecutor(cfg *config.Config, env environment.Environment, prompt string) (*executor, func(), error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, nil, err
+	}
+	pol, err := policy.Load(env.Cwd, home, policy.SystemPath, cfg.PolicyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recorders := audit.Recorders{audit.NewLog(cfg.AuditFile)}
+	closeAudit := func() {}
+	if cfg.AuditSyslog {
+		sink, err := audit.NewSyslog("", "")
+		if err != nil {
+			return nil, nil, err
+		}
+		closeAudit = func() { sink.Close() }
+		recorders = append(recorders, sink)
+	}
+
+	host, _ := os.Hostname()
+	models := map[string]string{}
+	for _, name := range cfg.Providers {
+		models[name] = providerModel(cfg, name)
+	}
+	return &executor{
+		env:     env,
+		policy:  pol,
+		preview: cfg.Preview,
+		audit:   recorders,
+		invocation: audit.Entry{
+			User:     currentUser(),
+			Host:     host,
+			Cwd:      env.Cwd,
+			Prompt:   prompt,
+			Provider: cfg.Providers[0],
+		},
+		models:   models,
+		history:  history.NewLog(cfg.HistoryFile),
+		readLine: func() string { return readLine(os.Stdin) },
+		run:      runCommand,
+		sandbox:  preview.Run,
+		exit:     os.Exit,
+	}, closeAudit, nil
+}
+
+// currentUser returns the name of the user running gen.
+func currentUser() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// approval is how far the user approved a command before it is executed.
+type approval int
+
+const (
+	// unapproved commands have not been put to the user yet.
+	unapproved approval = iota
+	// accepted commands were accepted, e.g. in the TUI or from a list.
+	accepted
+	// confirmed commands were accepted with the confirmation phrase.
+	confirmed
+)
+
+// tuiApproval returns how far the user approved the command they accepted
+// in the TUI.
+func tuiApproval(m tui.Model) approval {
+	if m.Confirmed() {
+		return confirmed
+	}
+	return accepted
+}
+
+// execute runs command, which the user made of response. Commands the
+// policy denies are not run at all. With preview, the user first sees what
+// the command changes in a sandbox. The user is then asked once, unless
+// they already approved enough: for the confirmation phrase if the policy
+// wants the command confirmed or it is high risk, and otherwise whether to
+// run it. It returns the failure of a command that ran and failed.
+func (e *executor) execute(response llm.Response, command string, approved approval) *commandFailure {
+	entry := e.entry(response, command)
+	decision := e.policy.Check(command)
+	if decision.Action == policy.Deny {
+		entry.Decision = audit.Denied
+		e.record(entry)
+		fmt.Printf("Blocked by policy: %s\n", decision)
+		e.exit(1)
+		return nil
+	}
+	if !e.approve(command, decision, approved) {
+		entry.Decision = audit.Aborted
+		e.record(entry)
+		return nil
+	}
+
+	entry.Decision = audit.Accepted
+	start := time.Now()
+	failure, err := e.run(e.env.Shell, e.env.Cwd, command)
+	entry.Duration = time.Since(start)
+	if err != nil {
+		entry.Error = err.Error()
+		e.record(entry)
+		fmt.Printf("Error executing command: %v\n", err)
+		e.exit(1)
+		return nil
+	}
+	exitCode := 0
+	if failure != nil {
+		exitCode = failure.exitCode
+	}
+	entry.ExitCode = &exitCode
+	e.record(entry)
+	e.remember(entry)
+	return failure
+}
+
+// approve shows the preview and asks the user about command if it needs
+// more approval than they gave, and reports whether they want to run it.
+// A command that was previewed is always put to the user, who has not seen
+// the preview yet.
+func (e *executor) approve(command string, decision policy.Decision, approved approval) bool {
+	if e.preview {
+		e.showPreview(command)
+	}
+
+	switch {
+	case approved == confirmed:
+	case decision.Action == policy.Confirm:
+		fmt.Printf("Policy: %s\n", decision)
+		return e.confirmPhrase()
+	case shell.Assess(command).Risk == shell.RiskHigh:
+		fmt.Println("This command is high risk.")
+		return e.confirmPhrase()
+	}
+	if approved == unapproved || e.preview {
+		return e.askExecute()
+	}
+	return true
+}
+
+// runIn makes the executor run commands in dir, where a command from the
+// history ran before, rather than the current directory, and tells the user
+// when they differ. The policy's paths are resolved from dir too.
+func (e *executor) runIn(dir string) error {
+	if dir == "" || dir == e.env.Cwd {
+		return nil
+	}
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		return fmt.Errorf("the command ran in %s, which is no longer a directory", dir)
+	}
+	fmt.Printf("Running in %s, where the command ran before.\n", dir)
+	e.env.Cwd = dir
+	e.invocation.Cwd = dir
+	if e.policy != nil {
+		pol := *e.policy
+		pol.Dir = dir
+		e.policy = &pol
+	}
+	return nil
+}
+
+// abort records that the user turned down command, which they made of
+// response.
+func (e *executor) abort(response llm.Response, command string) {
+	entry := e.entry(response, command)
+	entry.Decision = audit.Aborted
+	e.record(entry)
+}
+
+// fail records that no command could be generated.
+func (e *executor) fail(err error) {
+	entry := e.entry(llm.Response{}, "")
+	entry.Decision = audit.Failed
+	entry.Error = err.Error()
+	e.record(entry)
+}
+
+// remember adds the command of entry, which ran, to the history.
+func (e *executor) remember(entry audit.Entry) {
+	err := e.history.Add(history.Entry{
+		Time:     entry.Time,
+		Prompt:   entry.Prompt,
+		Command:  entry.Command,
+		Provider: entry.Provider,
+		Model:    entry.Model,
+		Dir:      entry.Cwd,
+		ExitCode: *entry.ExitCode,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write the history: %v\n", err)
+	}
+}
+
+// entry returns the audit entry of command, made of response.
+func (e *executor) entry(response llm.Response, command string) audit.Entry {
+	entry := e.invocation
+	entry.Time = time.Now()
+	if response.Provider != "" {
+		entry.Provider = response.Provider
+	}
+	entry.Model = e.models[entry.Provider]
+	entry.Generated = response.Command
+	entry.Command = command
+	return entry
+}
+
+// record adds entry to the audit log. A log that cannot be written does not
+// stop the command, but the user is told.
+func (e *executor) record(entry audit.Entry) {
+	if err := e.audit.Record(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write the audit log: %v\n", err)
+	}
+}
+
+// maxPreviewLines is how many changes and lines of output a preview shows.
+const maxPreviewLines = 20
+
+// showPreview runs command in a sandbox and shows what it changed.
+func (e *executor) showPreview(command string) {
+	result, err := e.sandbox(e.env.Shell, e.env.Cwd, command)
+	if err != nil {
+		fmt.Printf("Preview failed: %v\n\n", err)
+		return
+	}
+	printPreview(result)
+}
+
+// printPreview prints the changes and output of a command run in a sandbox.
+func printPreview(result preview.Result) {
+	counts := map[preview.ChangeKind]int{}
+	for _, change := range result.Changes {
+		counts[change.Kind]++
+	}
+	fmt.Printf("Preview: %d created, %d modified, %d deleted (exit status %d)\n", counts[preview.Created], counts[preview.Modified], counts[preview.Deleted], result.ExitCode)
+	for i, change := range result.Changes {
+		if i == maxPreviewLines {
+			fmt.Printf("  ... and %d more\n", len(result.Changes)-i)
+			break
+		}
+		fmt.Printf("  %-8s %s\n", change.Kind, change.Path)
+	}
+	printOutput("Output", result.Stdout)
+	printOutput("Errors", result.Stderr)
+	fmt.Println()
+}
+
+// printOutput prints the first lines of output under title.
+func printOutput(title, output string) {
+	output = strings.TrimRight(output, "\n")
+	if output == "" {
+		return
+	}
+	lines := strings.Split(output, "\n")
+	fmt.Printf("%s:\n", title)
+	for i, line := range lines {
+		if i == maxPreviewLines {
+			fmt.Printf("  ... and %d more lines\n", len(lines)-i)
+			break
+		}
+		fmt.Printf("  %s\n", line)
+	}
+}
+
+// askExecute asks the user whether to execute the command.
+func (e *executor) askExecute() bool {
+	fmt.Print("Execute? (y/N) ")
+
+	if strings.ToLower(e.readLine()) != "y" {
+		fmt.Println("Command execution aborted.")
+		return false
+	}
+	return true
+}
+
+// confirmPhrase asks the user to type the confirmation phrase.
+func (e *executor) confirmPhrase() bool {
+	fmt.Printf("Type %q to execute it: ", tui.ConfirmationPhrase)
+
+	if e.readLine() != tui.ConfirmationPhrase {
+		fmt.Println("Command execution aborted.")
+		return false
+	}
+	return true
+}
+
+// readLine reads a line from r, without the spaces around it. It reads one
+// byte at a time, so that nothing after the line is taken from r before the
+// next question.
+func readLine(r io.Reader) string {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n == 1 && b[0] == '\n' || err != nil {
+			break
+		}
+		line = append(line, b[:n]...)
+	}
+	return strings.TrimSpace(string(line))
+}
+
+// maxStderr is how much of the end of a failed command's stderr is sent to
+// the provider to fix it.
+const maxStderr = 4096
+
+// commandFailure is a command that exited with a non-zero status.
+type commandFailure struct {
+	command  string
+	exitCode int
+	// stderr is the end of what the command printed on stderr.
+	stderr string
+}
+
+// fixPrompt asks the provider to fix the failed command generated for
+// prompt.
+func (f *commandFailure) fixPrompt(prompt string) string {
+	return llm.FixPrompt(prompt, f.command, f.exitCode, f.stderr)
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	max int
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > b.max {
+		b.buf = b.buf[len(b.buf)-b.max:]
+	}
+	return len(p), nil
+}
+
+// runCommand runs command in dir, showing its output as it is printed, and
+// returns its failure if it exits with a non-zero status. The error is set
+// when the command could not be run at all.
+func runCommand(shell, dir, command string) (*commandFailure, error) {
+	stderr := &tailBuffer{max: maxStderr}
+	cmd := exec.Command(shell, "-c", command)
+	cmd.Dir = dir
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return nil, nil
+	case errors.As(err, &exitErr):
+		return &commandFailure{command: command, exitCode: exitErr.ExitCode(), stderr: string(stderr.buf)}, nil
+	}
+	return nil, err
+}
+
+// askFix tells the user the command failed and asks whether to send the
+// error to the provider for a corrected command.
+func (e *executor) askFix(failure *commandFailure) bool {
+	fmt.Printf("\nCommand failed with exit status %d. Ask for a corrected command? (y/N) ", failure.exitCode)
+
+	return strings.ToLower(e.readLine()) == "y"
+}
@@ -0,0 +1,439 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/audit"
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/history"
+	"github.com/zombor/gen/llm"
+	"github.com/zombor/gen/policy"
+	"github.com/zombor/gen/preview"
+)
+
+// recorder keeps the audit entries recorded.
+type recorder []audit.Entry
+
+func (r *recorder) Record(entry audit.Entry) error {
+	*r = append(*r, entry)
+	return nil
+}
+
+var _ = Describe("executor", func() {
+	var (
+		e         *executor
+		recorded  *recorder
+		log       *history.Log
+		rules     string
+		answers   []string
+		ran       []string
+		ranIn     []string
+		previewed []string
+		exited    []int
+		runResult *commandFailure
+		runErr    error
+		command   string
+		approved  approval
+		failure   *commandFailure
+	)
+
+	BeforeEach(func() {
+		recorded = &recorder{}
+		log = history.NewLog(filepath.Join(GinkgoT().TempDir(), "history.jsonl"))
+		rules = ""
+		answers = nil
+		ran, ranIn, previewed, exited = nil, nil, nil, nil
+		runResult, runErr = nil, nil
+		command = "ls -l"
+		approved = unapproved
+		e = &executor{
+			env:        environment.Environment{Shell: "/bin/sh", Cwd: "/home/me/src"},
+			audit:      recorded,
+			invocation: audit.Entry{User: "me", Cwd: "/home/me/src", Prompt: "list files", Provider: "ollama"},
+			models:     map[string]string{"ollama": "llama3"},
+			history:    log,
+			readLine: func() string {
+				if len(answers) == 0 {
+					return ""
+				}
+				answer := answers[0]
+				answers = answers[1:]
+				return answer
+			},
+			run: func(shell, dir, command string) (*commandFailure, error) {
+				ran = append(ran, command)
+				ranIn = append(ranIn, dir)
+				return runResult, runErr
+			},
+			sandbox: func(shell, dir, command string) (preview.Result, error) {
+				previewed = append(previewed, command)
+				return preview.Result{}, nil
+			},
+			exit: func(code int) {
+				exited = append(exited, code)
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		if rules != "" {
+			file, err := policy.Parse("policy", []byte(rules))
+			Expect(err).ToNot(HaveOccurred())
+			e.policy = &policy.Policy{Files: []policy.File{file}, Dir: "/home/me/src", Home: "/home/me"}
+		}
+		failure = e.execute(llm.Response{Command: command, Provider: "ollama"}, command, approved)
+	})
+
+	Context("when the user agrees", func() {
+		BeforeEach(func() {
+			answers = []string{"y"}
+		})
+
+		It("runs the command", func() {
+			Expect(ran).To(Equal([]string{"ls -l"}))
+		})
+
+		It("runs it in the working directory", func() {
+			Expect(ranIn).To(Equal([]string{"/home/me/src"}))
+		})
+
+		It("records that the command was accepted", func() {
+			Expect(*recorded).To(ConsistOf(HaveField("Decision", audit.Accepted)))
+		})
+
+		It("records the exit code", func() {
+			Expect(*(*recorded)[0].ExitCode).To(Equal(0))
+		})
+
+		It("records the model", func() {
+			Expect((*recorded)[0].Model).To(Equal("llama3"))
+		})
+
+		It("adds the command to the history", func() {
+			Expect(log.Read()).To(ConsistOf(HaveField("Command", "ls -l")))
+		})
+
+		It("returns no failure", func() {
+			Expect(failure).To(BeNil())
+		})
+	})
+
+	Context("when the user does not agree", func() {
+		BeforeEach(func() {
+			answers = []string{"n"}
+		})
+
+		It("does not run the command", func() {
+			Expect(ran).To(BeEmpty())
+		})
+
+		It("records that the command was aborted", func() {
+			Expect(*recorded).To(ConsistOf(HaveField("Decision", audit.Aborted)))
+		})
+
+		It("does not add the command to the history", func() {
+			Expect(log.Read()).To(BeEmpty())
+		})
+	})
+
+	Context("when the user already accepted the command", func() {
+		BeforeEach(func() {
+			approved = accepted
+		})
+
+		It("runs it without asking", func() {
+			Expect(ran).To(Equal([]string{"ls -l"}))
+		})
+	})
+
+	Context("when the policy denies the command", func() {
+		BeforeEach(func() {
+			rules = "deny binary ls"
+			approved = confirmed
+		})
+
+		It("does not run it", func() {
+			Expect(ran).To(BeEmpty())
+		})
+
+		It("exits with status 1", func() {
+			Expect(exited).To(Equal([]int{1}))
+		})
+
+		It("records that the command was denied", func() {
+			Expect(*recorded).To(ConsistOf(HaveField("Decision", audit.Denied)))
+		})
+	})
+
+	Context("when the policy wants the command confirmed", func() {
+		BeforeEach(func() {
+			rules = "confirm binary ls"
+			approved = accepted
+		})
+
+		Context("and the user types the confirmation phrase", func() {
+			BeforeEach(func() {
+				answers = []string{"run it anyway"}
+			})
+
+			It("runs the command", func() {
+				Expect(ran).To(Equal([]string{"ls -l"}))
+			})
+		})
+
+		Context("and the user types another phrase", func() {
+			BeforeEach(func() {
+				answers = []string{"y"}
+			})
+
+			It("does not run the command", func() {
+				Expect(ran).To(BeEmpty())
+			})
+
+			It("records that the command was aborted", func() {
+				Expect(*recorded).To(ConsistOf(HaveField("Decision", audit.Aborted)))
+			})
+		})
+
+		Context("and the user confirmed it in the TUI", func() {
+			BeforeEach(func() {
+				approved = confirmed
+			})
+
+			It("runs it without asking", func() {
+				Expect(ran).To(Equal([]string{"ls -l"}))
+			})
+		})
+	})
+
+	Context("when the command is high risk", func() {
+		BeforeEach(func() {
+			command = "rm -rf /"
+		})
+
+		Context("and the user types the confirmation phrase", func() {
+			BeforeEach(func() {
+				answers = []string{"run it anyway"}
+			})
+
+			It("runs the command", func() {
+				Expect(ran).To(Equal([]string{"rm -rf /"}))
+			})
+
+			It("asks only once", func() {
+				Expect(answers).To(BeEmpty())
+			})
+		})
+
+		Context("and the user types another phrase", func() {
+			BeforeEach(func() {
+				answers = []string{"run it"}
+			})
+
+			It("does not run the command", func() {
+				Expect(ran).To(BeEmpty())
+			})
+		})
+	})
+
+	Context("with preview", func() {
+		BeforeEach(func() {
+			e.preview = true
+			approved = accepted
+			answers = []string{"y"}
+		})
+
+		It("previews the command", func() {
+			Expect(previewed).To(Equal([]string{"ls -l"}))
+		})
+
+		It("asks once after the preview", func() {
+			Expect(answers).To(BeEmpty())
+		})
+
+		It("runs the command", func() {
+			Expect(ran).To(Equal([]string{"ls -l"}))
+		})
+
+		Context("when the sandbox cannot be set up", func() {
+			BeforeEach(func() {
+				e.sandbox = func(shell, dir, command string) (preview.Result, error) {
+					return preview.Result{}, preview.ErrUnsupported
+				}
+			})
+
+			It("still asks whether to run the command", func() {
+				Expect(ran).To(Equal([]string{"ls -l"}))
+			})
+		})
+	})
+
+	Context("when the command fails", func() {
+		BeforeEach(func() {
+			approved = accepted
+			runResult = &commandFailure{command: "ls -l", exitCode: 2, stderr: "no such file"}
+		})
+
+		It("returns the failure", func() {
+			Expect(failure).To(Equal(runResult))
+		})
+
+		It("records the exit code", func() {
+			Expect(*(*recorded)[0].ExitCode).To(Equal(2))
+		})
+
+		It("adds the exit code to the history", func() {
+			Expect(log.Read()).To(ConsistOf(HaveField("ExitCode", 2)))
+		})
+	})
+
+	Context("when the command cannot be run", func() {
+		BeforeEach(func() {
+			approved = accepted
+			runErr = errors.New("no shell")
+		})
+
+		It("exits with status 1", func() {
+			Expect(exited).To(Equal([]int{1}))
+		})
+
+		It("records the error", func() {
+			Expect(*recorded).To(ConsistOf(HaveField("Error", "no shell")))
+		})
+	})
+})
+
+var _ = Describe("readLine", func() {
+	var (
+		input  *strings.Reader
+		line   string
+		second string
+	)
+
+	BeforeEach(func() {
+		input = strings.NewReader("  run it anyway \ny\n")
+	})
+
+	JustBeforeEach(func() {
+		line = readLine(input)
+		second = readLine(input)
+	})
+
+	It("returns the line without spaces around it", func() {
+		Expect(line).To(Equal("run it anyway"))
+	})
+
+	It("leaves the next line to be read", func() {
+		Expect(second).To(Equal("y"))
+	})
+
+	When("the input ends without a newline", func() {
+		BeforeEach(func() {
+			input = strings.NewReader("y")
+		})
+
+		It("returns what was read", func() {
+			Expect(line).To(Equal("y"))
+		})
+	})
+})
+
+var _ = Describe("askChoice", func() {
+	var (
+		answer string
+		choice int
+		ok     bool
+	)
+
+	BeforeEach(func() {
+		answer = "2"
+	})
+
+	JustBeforeEach(func() {
+		e := &executor{readLine: func() string { return answer }}
+		choice, ok = e.askChoice(3)
+	})
+
+	It("returns the index of the choice", func() {
+		Expect(choice).To(Equal(1))
+	})
+
+	Context("when the choice is out of range", func() {
+		BeforeEach(func() {
+			answer = "4"
+		})
+
+		It("aborts", func() {
+			Expect(ok).To(BeFalse())
+		})
+	})
+})
+
+var _ = Describe("askFix", func() {
+	It("reports whether the user wants a fix", func() {
+		e := &executor{readLine: func() string { return "Y" }}
+		Expect(e.askFix(&commandFailure{exitCode: 1})).To(BeTrue())
+	})
+})
+
+var _ = Describe("runIn", func() {
+	var (
+		e   *executor
+		dir string
+		err error
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		e = &executor{
+			env:        environment.Environment{Cwd: "/home/me/src"},
+			policy:     &policy.Policy{Dir: "/home/me/src"},
+			invocation: audit.Entry{Cwd: "/home/me/src"},
+		}
+	})
+
+	JustBeforeEach(func() {
+		err = e.runIn(dir)
+	})
+
+	It("runs the commands in the directory", func() {
+		Expect(e.env.Cwd, err).To(Equal(dir))
+	})
+
+	It("records the directory in the audit log", func() {
+		Expect(e.invocation.Cwd, err).To(Equal(dir))
+	})
+
+	It("resolves the policy's paths from the directory", func() {
+		Expect(e.policy.Dir, err).To(Equal(dir))
+	})
+
+	When("it is the working directory", func() {
+		BeforeEach(func() {
+			dir = "/home/me/src"
+		})
+
+		It("keeps it", func() {
+			Expect(e.env.Cwd, err).To(Equal("/home/me/src"))
+		})
+	})
+
+	When("it no longer exists", func() {
+		BeforeEach(func() {
+			dir = filepath.Join(dir, "gone")
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(MatchError(ContainSubstring("which is no longer a directory")))
+		})
+
+		It("keeps the working directory", func() {
+			Expect(e.env.Cwd).To(Equal("/home/me/src"))
+		})
+	})
+})
@@ -6,19 +6,22 @@ import (
 	"io/ioutil"
 	"log"
 	"log/slog"
-	"net/http"
 	"net/url"
 	"os"
-	"os/exec"
-	"path/filepath"
+	"strconv"
 	"strings"
 
+	"github.com/zombor/gen/budget"
 	"github.com/zombor/gen/cmd/gen/config"
 	"github.com/zombor/gen/cmd/gen/tui"
+	"github.com/zombor/gen/environment"
 	"github.com/zombor/gen/llm"
+	llmprompt "github.com/zombor/gen/llm/prompt"
+	"github.com/zombor/gen/redact"
+	"github.com/zombor/gen/shell"
+	"github.com/zombor/gen/usage"
 
 	"github.com/google/generative-ai-go/genai"
-	"github.com/liushuangls/go-anthropic"
 	"github.com/ollama/ollama/api"
 	openai "github.com/sashabaranov/go-openai"
 	opts "google.golang.org/api/option"
@@ -30,14 +33,6 @@ var (
 	date    = "unknown"
 )
 
-func getShell() string {
-	shellPath := os.Getenv("SHELL")
-	if shellPath == "" {
-		return "sh"
-	}
-	return filepath.Base(shellPath)
-}
-
 func main() {
 	cfg, args, err := config.Load(version, commit, date)
 	if err != nil {
@@ -53,93 +48,366 @@ func main() {
 	}
 	slog.SetDefault(logger)
 
+	if handled, err := runSubcommand(cfg, args); handled {
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	templates, err := llmprompt.Load(os.DirFS(cfg.PromptsDir))
+	if err != nil {
+		fmt.Printf("Error loading prompt templates: %v\n", err)
+		os.Exit(1)
+	}
+
 	ctx := context.Background()
-	var provider llm.LLMProvider
 
-	switch cfg.Provider {
-	case "gemini":
-		client, err := genai.NewClient(ctx, opts.WithAPIKey(cfg.Gemini.APIKey))
+	cache := llm.NewDiskCache(cfg.Cache.Dir, cfg.Cache.TTL)
+	var recorder llm.UsageRecorder = usage.NewLog(cfg.UsageFile)
+	var tracker *budget.Tracker
+	if len(cfg.Budgets) > 0 {
+		prices, err := usage.LoadPrices(cfg.PricesFile)
 		if err != nil {
 			log.Fatal(err)
 		}
-		defer client.Close()
-		model := client.GenerativeModel(cfg.Gemini.Model)
-		provider = &llm.GeminiProvider{GenerateContent: model.GenerateContent}
-	case "openai":
-		client := openai.NewClient(cfg.OpenAI.APIKey)
-		provider = &llm.OpenAIProvider{CreateChatCompletion: client.CreateChatCompletion, Model: cfg.OpenAI.Model}
-	case "ollama":
-		hostURL, err := url.Parse(cfg.Ollama.Host)
+		tracker = budget.NewTracker(cfg.BudgetFile, cfg.Budgets, prices)
+		recorder = llm.UsageRecorders{recorder, tracker}
+	}
+
+	var providers []llm.NamedProvider
+	for _, name := range cfg.Providers {
+		provider, closeProvider, err := newProvider(ctx, cfg, name, templates)
 		if err != nil {
 			log.Fatal(err)
 		}
-		client := api.NewClient(hostURL, &http.Client{})
-		provider = llm.NewOllamaProvider(client, cfg.Ollama.Model)
-	case "anthropic":
-		client := anthropic.NewClient(cfg.Anthropic.APIKey)
-		provider = &llm.AnthropicProvider{CreateMessages: client.CreateMessages, Model: cfg.Anthropic.Model}
-	case "bedrock":
-		bedrockClient, err := llm.NewBedrock(ctx, cfg.Bedrock.Model, cfg.Bedrock.Region, cfg.Bedrock.InferenceProfile)
-		if err != nil {
-			log.Fatal(err)
+		defer closeProvider()
+		provider = llm.NewUsageProvider(provider, recorder, name, providerModel(cfg, name))
+		// The budget is checked inside the retries, before every attempt.
+		if tracker != nil {
+			if err := tracker.CheckPrice(name, providerModel(cfg, name)); err != nil {
+				log.Fatal(err)
+			}
+			provider = llm.NewBudgetProvider(provider, tracker, name)
 		}
-		provider = bedrockClient
-	default:
-		fmt.Printf("Unknown provider: %s\n", cfg.Provider)
-		os.Exit(1)
+		provider = llm.NewRetryProvider(provider, llm.RetryPolicy{
+			Retries:   cfg.Retry.Retries,
+			BaseDelay: cfg.Retry.BaseDelay,
+			MaxDelay:  cfg.Retry.MaxDelay,
+		})
+		if cfg.Cache.Enabled {
+			provider = llm.NewCacheProvider(provider, cache, llm.CacheKey{
+				Provider:  name,
+				Model:     providerModel(cfg, name),
+				Templates: templates.Version(),
+				Params:    cfg.GenerationFor(name),
+			})
+		}
+		if cfg.Repairs > 0 {
+			provider = llm.NewRepairProvider(provider, shell.Validate, cfg.Repairs)
+		}
+		if cfg.Redact {
+			provider = llm.NewRedactProvider(provider, func() llm.Redactor { return redact.New() })
+		}
+		providers = append(providers, llm.NamedProvider{Name: name, LLMProvider: provider})
+	}
+
+	var provider llm.LLMProvider = providers[0].LLMProvider
+	switch {
+	case len(providers) > 1 && cfg.Race:
+		provider = llm.NewRaceProvider(cfg.ProviderTimeout, cfg.RaceGrace, providers...)
+	case len(providers) > 1:
+		provider = llm.NewFallbackProvider(cfg.ProviderTimeout, providers...)
+	}
+
+	var consensusProvider *llm.ConsensusProvider
+	if cfg.Consensus {
+		consensusProvider = llm.NewConsensusProvider(cfg.ProviderTimeout, shell.Normalize, providers...)
 	}
 
 	prompt := strings.Join(args, " ")
+	env := environment.NewCollector().Collect(ctx)
+	logger.Debug("environment", "environment", env)
+
+	ex, closeExecutor, err := newExecutor(cfg, env, prompt)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer closeExecutor()
 
 	if cfg.TUI {
-		model := tui.NewModel(prompt, provider)
-		finalModel, err := tui.Run(model)
+		model := tui.NewModel(prompt, provider, env, cfg.Candidates, consensusProvider, ex.policy)
+		for {
+			finalModel, err := tui.Run(model)
+			if err != nil {
+				fmt.Printf("Error running tui: %v\n", err)
+				os.Exit(1)
+			}
+			m := finalModel.(tui.Model)
+			ex.invocation.Prompt = m.Prompt()
+			if err := m.Err(); err != nil {
+				ex.fail(err)
+				printError(cfg, err)
+				os.Exit(1)
+			}
+			if !m.Accepted() {
+				ex.abort(m.Response(), m.Command())
+				return
+			}
+			failure := ex.execute(m.Response(), m.Command(), tuiApproval(m))
+			if failure == nil {
+				return
+			}
+			if !ex.askFix(failure) {
+				os.Exit(1)
+			}
+			// The corrected command is edited and accepted like the first.
+			model = tui.NewModel(m.Prompt(), provider, env, 1, nil, ex.policy).Fixing(failure.fixPrompt(m.Prompt()))
+		}
+	}
+
+	if prompt == "" {
+		fmt.Println("Usage: gen <prompt>")
+		os.Exit(1)
+	}
+
+	var failure *commandFailure
+	switch {
+	case consensusProvider != nil:
+		failure = compareAnswers(ctx, cfg, logger, consensusProvider, prompt, ex)
+	case cfg.Candidates > 1:
+		failure = selectCandidate(ctx, cfg, logger, provider, prompt, ex)
+	default:
+		response, err := provider.GenerateCommand(ctx, logger, prompt, env)
 		if err != nil {
-			fmt.Printf("Error running tui: %v\n", err)
+			ex.fail(err)
+			printError(cfg, err)
 			os.Exit(1)
 		}
-		m := finalModel.(tui.Model)
-		if m.Accepted() {
-			runCommand(m.Command())
-		}
-	} else {
-		if prompt == "" {
-			fmt.Println("Usage: gen <prompt>")
+		failure = confirmCommand(ex, response)
+	}
+
+	for failure != nil {
+		if !ex.askFix(failure) {
 			os.Exit(1)
 		}
-
-		shell := getShell()
-		command, err := provider.GenerateCommand(ctx, logger, prompt, shell)
+		response, err := provider.GenerateCommand(ctx, logger, failure.fixPrompt(prompt), env)
 		if err != nil {
-			fmt.Printf("Error: %v\n", err)
+			ex.fail(err)
+			printError(cfg, err)
 			os.Exit(1)
 		}
+		failure = confirmCommand(ex, response)
+	}
+}
 
-		// The model sometimes returns the command wrapped in backticks, so we remove them.
-		command = strings.Trim(command, "`")
+// confirmCommand shows the generated command and executes it if the user
+// agrees.
+func confirmCommand(ex *executor, response llm.Response) *commandFailure {
+	command := llm.ExtractCommand(response.Command)
 
-		fmt.Printf("Generated command: \n\n%s\n\n", command)
-		fmt.Print("Execute? (y/N) ")
+	fmt.Printf("Generated command: \n\n%s\n\n", command)
+	printDetails(response)
 
-		var response string
-		fmt.Scanln(&response)
+	printSyntaxError(ex.env, command)
+	printAssessment(shell.Assess(command))
+	return ex.execute(response, command, unapproved)
+}
 
-		if strings.ToLower(response) == "y" {
-			runCommand(command)
-		} else {
-			fmt.Println("Command execution aborted.")
+// newProvider creates the named provider. The returned func releases its
+// resources.
+func newProvider(ctx context.Context, cfg *config.Config, name string, templates *llmprompt.Templates) (llm.LLMProvider, func(), error) {
+	params := cfg.GenerationFor(name)
+	switch name {
+	case "gemini":
+		client, err := genai.NewClient(ctx, opts.WithAPIKey(cfg.Gemini.APIKey))
+		if err != nil {
+			return nil, nil, err
 		}
+		model := client.GenerativeModel(cfg.Gemini.Model)
+		return llm.NewGeminiProvider(model, templates, params), func() { client.Close() }, nil
+	case "openai":
+		client := openai.NewClientWithConfig(llm.OpenAIClientConfig(cfg.OpenAI.APIKey, cfg.OpenAI.BaseURL, cfg.OpenAI.Organization, cfg.OpenAI.Headers))
+		return llm.NewOpenAIProvider(client, cfg.OpenAI.Model, templates, params), func() {}, nil
+	case "openai-compatible":
+		compatible := cfg.OpenAICompatible
+		client := openai.NewClientWithConfig(llm.OpenAIClientConfig(compatible.APIKey, compatible.BaseURL, "", compatible.Headers))
+		provider := llm.NewOpenAIProvider(client, compatible.Model, templates, params)
+		provider.Compatibility = llm.OpenAICompatibility{
+			ResponseFormat: openai.ChatCompletionResponseFormatType(compatible.ResponseFormat),
+			NotStrict:      !compatible.Strict,
+			MaxTokens:      !compatible.MaxCompletionTokens,
+			NoStreamUsage:  !compatible.StreamUsage,
+		}
+		return provider, func() {}, nil
+	case "azure-openai":
+		clientConfig, err := llm.AzureOpenAIClientConfig(cfg.Azure.Endpoint, cfg.Azure.Deployment, cfg.Azure.APIVersion, cfg.Azure.APIKey, cfg.Azure.Token)
+		if err != nil {
+			return nil, nil, err
+		}
+		return llm.NewOpenAIProvider(openai.NewClientWithConfig(clientConfig), cfg.Azure.Deployment, templates, params), func() {}, nil
+	case "ollama":
+		hostURL, err := url.Parse(cfg.Ollama.Host)
+		if err != nil {
+			return nil, nil, err
+		}
+		client := api.NewClient(hostURL, llm.NewHTTPClient())
+		return llm.NewOllamaProvider(client, cfg.Ollama.Model, templates, params), func() {}, nil
+	case "anthropic":
+		return llm.NewAnthropicProvider(llm.NewAnthropicClient(cfg.Anthropic.APIKey), cfg.Anthropic.Model, templates, params), func() {}, nil
+	case "bedrock":
+		bedrockClient, err := llm.NewBedrock(ctx, cfg.Bedrock.Model, cfg.Bedrock.Region, cfg.Bedrock.InferenceProfile, cfg.Bedrock.API, templates, params)
+		if err != nil {
+			return nil, nil, err
+		}
+		return bedrockClient, func() {}, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown provider: %s", name)
+	}
+}
+
+// providerModel returns the model the named provider is configured to use.
+func providerModel(cfg *config.Config, name string) string {
+	switch name {
+	case "gemini":
+		return cfg.Gemini.Model
+	case "openai":
+		return cfg.OpenAI.Model
+	case "openai-compatible":
+		return cfg.OpenAICompatible.Model
+	case "azure-openai":
+		return cfg.Azure.Deployment
+	case "ollama":
+		return cfg.Ollama.Model
+	case "anthropic":
+		return cfg.Anthropic.Model
+	case "bedrock":
+		return cfg.Bedrock.Model
+	}
+	return ""
+}
+
+// selectCandidate generates cfg.Candidates alternative commands and asks the user which
+// one, if any, to execute.
+func selectCandidate(ctx context.Context, cfg *config.Config, logger *slog.Logger, provider llm.LLMProvider, prompt string, ex *executor) *commandFailure {
+	candidates, err := llm.GenerateCommands(ctx, logger, provider, prompt, ex.env, cfg.Candidates)
+	if err != nil {
+		ex.fail(err)
+		printError(cfg, err)
+		os.Exit(1)
+	}
+
+	fmt.Print("Generated commands: \n\n")
+	for i, c := range candidates {
+		fmt.Printf("%d) %s\n", i+1, llm.ExtractCommand(c.Command))
+		printDetails(c.Response)
+	}
+	if choice, ok := ex.askChoice(len(candidates)); ok {
+		return runChoice(ex, candidates[choice].Response)
 	}
+	ex.abort(llm.Response{}, "")
+	return nil
 }
 
-func runCommand(command string) {
-	shell := getShell()
-	cmd := exec.Command(shell, "-c", command)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Run()
+// compareAnswers asks every provider for a command. When they agree, the
+// command is confirmed as usual; otherwise the user picks one of the answers.
+func compareAnswers(ctx context.Context, cfg *config.Config, logger *slog.Logger, provider *llm.ConsensusProvider, prompt string, ex *executor) *commandFailure {
+	consensus, err := provider.Ask(ctx, logger, prompt, ex.env)
 	if err != nil {
-		fmt.Printf("Error executing command: %v\n", err)
+		ex.fail(err)
+		printError(cfg, err)
 		os.Exit(1)
 	}
+
+	var names []string
+	for _, answer := range consensus.Answers {
+		names = append(names, answer.Provider)
+	}
+	switch {
+	case consensus.Agreed():
+		fmt.Printf("Consensus: %s agree\n\n", strings.Join(names, ", "))
+		return confirmCommand(ex, consensus.Answers[0])
+	case len(consensus.Answers) == 1:
+		fmt.Printf("Consensus: only %s answered, no second opinion\n\n", names[0])
+		return confirmCommand(ex, consensus.Answers[0])
+	}
+
+	fmt.Print("The providers disagree: \n\n")
+	for i, answer := range consensus.Answers {
+		fmt.Printf("%d) %s\n", i+1, llm.ExtractCommand(answer.Command))
+		printDetails(answer)
+	}
+	if choice, ok := ex.askChoice(len(consensus.Answers)); ok {
+		return runChoice(ex, consensus.Answers[choice])
+	}
+	ex.abort(llm.Response{}, "")
+	return nil
+}
+
+// askChoice asks the user which of n numbered commands to execute and
+// returns its index.
+func (e *executor) askChoice(n int) (int, bool) {
+	fmt.Printf("\nExecute which? (1-%d, empty to abort) ", n)
+
+	choice, err := strconv.Atoi(e.readLine())
+	if err != nil || choice < 1 || choice > n {
+		fmt.Println("Command execution aborted.")
+		return 0, false
+	}
+	return choice - 1, true
+}
+
+// runChoice executes the command of the response the user chose.
+func runChoice(ex *executor, response llm.Response) *commandFailure {
+	command := llm.ExtractCommand(response.Command)
+	printSyntaxError(ex.env, command)
+	printAssessment(shell.Assess(command))
+	return ex.execute(response, command, accepted)
+}
+
+// printAssessment prints the risky patterns found in a command.
+func printAssessment(assessment shell.Assessment) {
+	if len(assessment.Findings) == 0 {
+		return
+	}
+	fmt.Printf("Risk check: %s\n", assessment.Risk)
+	for _, finding := range assessment.Findings {
+		fmt.Printf("- %s\n", finding.Reason)
+	}
+	fmt.Println()
+}
+
+// printSyntaxError prints the error the shell would reject command with.
+func printSyntaxError(env environment.Environment, command string) {
+	if err := shell.Validate(env.Shell, command); err != nil {
+		fmt.Printf("Syntax error: %v\n\n", err)
+	}
+}
+
+// printDetails prints the explanation and risk assessment of a response.
+func printDetails(response llm.Response) {
+	if response.Explanation == "" && response.Risk == "" && response.Provider == "" && !response.Cached && response.Repairs == 0 {
+		return
+	}
+	if response.Explanation != "" {
+		fmt.Println(response.Explanation)
+	}
+	if response.Risk != "" {
+		sudo := ""
+		if response.RequiresSudo {
+			sudo = " (requires sudo)"
+		}
+		fmt.Printf("Risk: %s%s\n", response.Risk, sudo)
+	}
+	if response.Provider != "" {
+		fmt.Printf("Provider: %s\n", response.Provider)
+	}
+	if response.Cached {
+		fmt.Println("Cached: yes (use --no-cache to generate a new command)")
+	}
+	if response.Repairs > 0 {
+		fmt.Printf("Repaired: the provider fixed the syntax of the command %d time(s)\n", response.Repairs)
+	}
+	fmt.Println()
 }
@@ -0,0 +1,49 @@
+package tui_test
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/charmbracelet/bubbles/spinner"
+	tea "github.com/charmbracelet/bubbletea"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/cmd/gen/tui"
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm"
+)
+
+// fencedProvider answers with the command in a Markdown code fence.
+type fencedProvider struct{}
+
+func (fencedProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (llm.Response, error) {
+	return llm.Response{Command: "```bash\nls -l\n```"}, nil
+}
+
+// start runs the commands m starts with and passes the messages they return
+// to m, leaving out the ticks of the spinner.
+func start(m tui.Model) tui.Model {
+	batch, _ := m.Init()().(tea.BatchMsg)
+	for _, cmd := range batch {
+		msg := cmd()
+		if _, ok := msg.(spinner.TickMsg); ok {
+			continue
+		}
+		updated, _ := m.Update(msg)
+		m = updated.(tui.Model)
+	}
+	return m
+}
+
+var _ = Describe("choosing between candidates", func() {
+	var m tui.Model
+
+	JustBeforeEach(func() {
+		m = start(tui.NewModel("list files", fencedProvider{}, environment.Environment{Shell: "/bin/sh"}, 2, nil, nil))
+	})
+
+	It("lists the command without its code fence", func() {
+		Expect(m.View()).To(ContainSubstring("> 1. ls -l (x2)\n"))
+	})
+})
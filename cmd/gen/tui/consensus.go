@@ -0,0 +1,91 @@
+package tui
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/zombor/gen/llm"
+	"github.com/zombor/gen/shell"
+)
+
+var (
+	differsStyle  = lipgloss.NewStyle().Foreground(lipgloss.Color("1")).Bold(true)
+	selectedStyle = lipgloss.NewStyle().Bold(true)
+)
+
+type consensusMsg struct {
+	consensus llm.Consensus
+}
+
+func (m Model) generateConsensus() tea.Msg {
+	consensus, err := m.consensusProvider.Ask(context.Background(), slog.Default(), m.request, m.env)
+	if err != nil {
+		return errMsg{err: err}
+	}
+	return consensusMsg{consensus: consensus}
+}
+
+// chooseAnswer moves on to editing the chosen answer of the consensus.
+func (m Model) chooseAnswer(i int) Model {
+	m.state = commandState
+	m.response = m.consensus.Answers[i]
+	m.textarea.SetValue(llm.ExtractCommand(m.response.Command))
+	return m
+}
+
+// consensusSummary tells whether the providers agreed on the command.
+func (m Model) consensusSummary() string {
+	var names []string
+	for _, answer := range m.consensus.Answers {
+		names = append(names, answer.Provider)
+	}
+	switch {
+	case len(m.consensus.Answers) == 1:
+		return "only " + names[0] + " answered, no second opinion"
+	case m.consensus.Agreed():
+		return strings.Join(names, ", ") + " agree"
+	}
+	return fmt.Sprintf("providers disagree, showing the answer of %s", m.response.Provider)
+}
+
+// consensusView shows the answers of the providers side by side with the
+// words that differ highlighted.
+func (m Model) consensusView() string {
+	width := m.width
+	if width <= 0 {
+		width = 80
+	}
+	columnWidth := max(width/len(m.consensus.Answers)-2, 20)
+	column := lipgloss.NewStyle().Width(columnWidth).MarginRight(2)
+
+	words := shell.Compare(m.consensus.Normalized...)
+	columns := make([]string, len(m.consensus.Answers))
+	for i, answer := range m.consensus.Answers {
+		header := fmt.Sprintf("  %d. %s", i+1, answer.Provider)
+		if i == m.cursor {
+			header = selectedStyle.Render(fmt.Sprintf("> %d. %s", i+1, answer.Provider))
+		}
+
+		command := make([]string, len(words[i]))
+		for k, word := range words[i] {
+			command[k] = word.Text
+			if word.Differs {
+				command[k] = differsStyle.Render(word.Text)
+			}
+		}
+
+		lines := []string{header, "", strings.Join(command, " "), ""}
+		if answer.Risk != "" {
+			lines = append(lines, "Risk: "+string(answer.Risk))
+		}
+		if answer.Explanation != "" {
+			lines = append(lines, answer.Explanation)
+		}
+		columns[i] = column.Render(strings.Join(lines, "\n"))
+	}
+	return "The providers disagree:\n\n" + lipgloss.JoinHorizontal(lipgloss.Top, columns...) + "\n"
+}
@@ -0,0 +1,113 @@
+package tui
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/history"
+	"github.com/zombor/gen/llm"
+	"github.com/zombor/gen/policy"
+)
+
+var historyDetailStyle = lipgloss.NewStyle().Faint(true)
+
+// NewHistoryModel creates a TUI model that searches entries as the user
+// types, showing up to limit of the entries filter finds, and edits the one
+// the user picks like a generated command. No provider is asked.
+func NewHistoryModel(entries []history.Entry, filter history.Filter, limit int, env environment.Environment, policy *policy.Policy) Model {
+	m := NewModel("", nil, env, 1, nil, policy)
+	m.state = historyState
+	m.history = entries
+	m.historyFilter = filter
+	m.historyLimit = limit
+	m.textarea.Placeholder = "Enter your command here..."
+
+	m.search = textinput.New()
+	m.search.Placeholder = "search prompts and commands"
+	m.search.Prompt = "> "
+	m.search.SetValue(filter.Search)
+	m.search.Focus()
+	return m.findHistory()
+}
+
+// findHistory lists the entries that match the search.
+func (m Model) findHistory() Model {
+	m.historyFilter.Search = m.search.Value()
+	m.historyMatches = history.Find(m.history, m.historyFilter)
+	if len(m.historyMatches) > m.historyLimit {
+		m.historyMatches = m.historyMatches[:m.historyLimit]
+	}
+	m.cursor = 0
+	return m
+}
+
+// updateHistory handles a key press while searching the history.
+func (m Model) updateHistory(msg tea.KeyMsg) (Model, tea.Cmd) {
+	switch msg.String() {
+	case "up", "ctrl+p":
+		if m.cursor > 0 {
+			m.cursor--
+		}
+		return m, nil
+	case "down", "ctrl+n":
+		if m.cursor < len(m.historyMatches)-1 {
+			m.cursor++
+		}
+		return m, nil
+	case "enter":
+		if len(m.historyMatches) == 0 {
+			return m, nil
+		}
+		entry := m.historyMatches[m.cursor]
+		m.state = commandState
+		m.prompt = entry.Prompt
+		m.request = entry.Prompt
+		m.response = llm.Response{Command: entry.Command, Provider: entry.Provider}
+		m.historyEntry = &entry
+		m.textarea.SetValue(entry.Command)
+		m.search.Blur()
+		return m, nil
+	}
+	previous := m.search.Value()
+	var cmd tea.Cmd
+	m.search, cmd = m.search.Update(msg)
+	if m.search.Value() != previous {
+		m = m.findHistory()
+	}
+	return m, cmd
+}
+
+// historyView shows the search and the entries that match it.
+func (m Model) historyView() string {
+	var b strings.Builder
+	b.WriteString("Search history:\n\n" + m.search.View() + "\n\n")
+	if len(m.historyMatches) == 0 {
+		b.WriteString("No commands found.\n")
+	}
+	for i, entry := range m.historyMatches {
+		cursor := "  "
+		if i == m.cursor {
+			cursor = "> "
+		}
+		fmt.Fprintf(&b, "%s%d. %s\n", cursor, i+1, entry.Command)
+		b.WriteString(historyDetailStyle.Render("     "+historyDetails(entry)) + "\n")
+	}
+	b.WriteString("\n(type to search, ↑/↓ to choose, enter to edit, ctrl+c to quit)")
+	return b.String()
+}
+
+// historyDetails describes where an entry came from.
+func historyDetails(entry history.Entry) string {
+	details := fmt.Sprintf("%s · %s · %s", entry.Prompt, entry.Time.Local().Format("2006-01-02 15:04"), entry.Dir)
+	if entry.Provider != "" {
+		details += " · " + entry.Provider
+	}
+	if entry.ExitCode != 0 {
+		details += fmt.Sprintf(" · exit status %d", entry.ExitCode)
+	}
+	return details
+}
@@ -0,0 +1,41 @@
+package tui_test
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/cmd/gen/tui"
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/history"
+)
+
+var _ = Describe("HistoryModel", func() {
+	var (
+		keys []tea.KeyMsg
+		m    tui.Model
+	)
+
+	BeforeEach(func() {
+		keys = []tea.KeyMsg{enter}
+	})
+
+	JustBeforeEach(func() {
+		entries := []history.Entry{{Prompt: "list files", Command: "ls -l", Dir: "/home/me/src"}}
+		m = press(tui.NewHistoryModel(entries, history.Filter{}, 10, environment.Environment{Shell: "/bin/sh"}, nil), keys...)
+	})
+
+	It("returns the directory of the picked command", func() {
+		Expect(m.HistoryDir()).To(Equal("/home/me/src"))
+	})
+
+	When("no command was picked", func() {
+		BeforeEach(func() {
+			keys = nil
+		})
+
+		It("returns no directory", func() {
+			Expect(m.HistoryDir()).To(BeEmpty())
+		})
+	})
+})
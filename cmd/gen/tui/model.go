@@ -2,33 +2,79 @@ package tui
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"strings"
 
 	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/history"
 	"github.com/zombor/gen/llm"
+	"github.com/zombor/gen/policy"
 )
 
 type state int
 
 const (
 	promptState state = iota
+	candidateState
+	consensusState
 	commandState
+	confirmState
+	historyState
 )
 
 type Model struct {
-	spinner     spinner.Model
-	loading     bool
-	command     string
-	textarea    textarea.Model
-	accepted    bool
-	prompt      string
+	spinner   spinner.Model
+	loading   bool
+	streaming bool
+	response  llm.Response
+	textarea  textarea.Model
+	accepted  bool
+	prompt    string
+	// request is what the provider is asked: the prompt, unless the model
+	// is fixing a command that failed.
+	request     string
 	llmProvider llm.LLMProvider
+	env         environment.Environment
 	state       state
+	n           int
+	candidates  []llm.Candidate
+	cursor      int
+	err         error
+	width       int
+
+	consensusProvider *llm.ConsensusProvider
+	consensus         llm.Consensus
+
+	// policy decides which commands may be accepted.
+	policy *policy.Policy
+
+	// confirm takes the confirmation phrase of a high risk command, or of
+	// one the policy wants confirmed.
+	confirm   textinput.Model
+	mismatch  bool
+	confirmed bool
+
+	// history holds the entries searched in the history, and historyEntry
+	// the one the command being edited came from.
+	history        []history.Entry
+	historyFilter  history.Filter
+	historyLimit   int
+	historyMatches []history.Entry
+	historyEntry   *history.Entry
+	search         textinput.Model
 }
 
-func NewModel(prompt string, llmProvider llm.LLMProvider) Model {
+// NewModel creates the TUI model. When n is greater than one, n alternative
+// commands are generated and offered in a list before the edit textarea.
+// With a consensusProvider, the commands of all of its providers are compared
+// instead, and shown side by side when they disagree. Commands the policy
+// denies cannot be accepted.
+func NewModel(prompt string, llmProvider llm.LLMProvider, env environment.Environment, n int, consensusProvider *llm.ConsensusProvider, policy *policy.Policy) Model {
 	s := spinner.New()
 	s.Spinner = spinner.Dot
 
@@ -40,8 +86,15 @@ func NewModel(prompt string, llmProvider llm.LLMProvider) Model {
 		spinner:     s,
 		loading:     true,
 		prompt:      prompt,
+		request:     prompt,
 		llmProvider: llmProvider,
+		env:         env,
 		textarea:    ta,
+		n:           n,
+		confirm:     newConfirmInput(),
+		policy:      policy,
+
+		consensusProvider: consensusProvider,
 	}
 
 	if prompt == "" {
@@ -55,22 +108,73 @@ func NewModel(prompt string, llmProvider llm.LLMProvider) Model {
 }
 
 func (m Model) Init() tea.Cmd {
-	if m.state == commandState {
-		return tea.Batch(m.spinner.Tick, m.generateCommand)
+	switch m.state {
+	case commandState:
+		return tea.Batch(m.spinner.Tick, m.generate)
+	case historyState:
+		return textinput.Blink
 	}
 	return nil
 }
 
 type commandGeneratedMsg struct {
-	command string
+	response llm.Response
 }
 
-func (m Model) generateCommand() tea.Msg {
-	command, err := m.llmProvider.GenerateCommand(context.Background(), slog.Default(), m.prompt, "bash")
+// errMsg ends the program when the command could not be generated.
+type errMsg struct {
+	err error
+}
+
+type candidatesGeneratedMsg struct {
+	candidates []llm.Candidate
+}
+
+// tokenMsg carries a streamed piece of the command along with the stream it
+// came from, so that Update can keep listening for the next piece.
+type tokenMsg struct {
+	token  string
+	stream <-chan tea.Msg
+}
+
+func (m Model) generate() tea.Msg {
+	if m.consensusProvider != nil {
+		return m.generateConsensus()
+	}
+	if m.n > 1 {
+		return m.generateCandidates()
+	}
+	return m.generateCommand()
+}
+
+func (m Model) generateCandidates() tea.Msg {
+	candidates, err := llm.GenerateCommands(context.Background(), slog.Default(), m.llmProvider, m.request, m.env, m.n)
 	if err != nil {
-		return tea.Quit
+		return errMsg{err: err}
+	}
+	return candidatesGeneratedMsg{candidates: candidates}
+}
+
+func (m Model) generateCommand() tea.Msg {
+	stream := make(chan tea.Msg)
+	go func() {
+		defer close(stream)
+		response, err := llm.StreamCommand(context.Background(), slog.Default(), m.llmProvider, m.request, m.env, func(token string) {
+			stream <- tokenMsg{token: token, stream: stream}
+		})
+		if err != nil {
+			stream <- errMsg{err: err}
+			return
+		}
+		stream <- commandGeneratedMsg{response: response}
+	}()
+	return <-stream
+}
+
+func waitForToken(stream <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-stream
 	}
-	return commandGeneratedMsg{command: command}
 }
 
 func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
@@ -79,50 +183,239 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.WindowSizeMsg:
 		m.textarea.SetWidth(msg.Width)
+		m.width = msg.Width
 	case tea.KeyMsg:
+		if m.state == confirmState && msg.String() != "ctrl+c" {
+			return m.updateConfirm(msg)
+		}
+		if m.state == historyState && msg.String() != "ctrl+c" {
+			return m.updateHistory(msg)
+		}
 		switch msg.String() {
 		case "ctrl+c":
 			return m, tea.Quit
+		case "up", "k", "left", "h":
+			if (m.state == candidateState || m.state == consensusState) && m.cursor > 0 {
+				m.cursor--
+				return m, nil
+			}
+		case "down", "j":
+			if m.state == candidateState && m.cursor < len(m.candidates)-1 {
+				m.cursor++
+				return m, nil
+			}
+			if m.state == consensusState && m.cursor < len(m.consensus.Answers)-1 {
+				m.cursor++
+				return m, nil
+			}
+		case "right", "l":
+			if m.state == consensusState && m.cursor < len(m.consensus.Answers)-1 {
+				m.cursor++
+				return m, nil
+			}
+		case "enter":
+			if m.state == consensusState {
+				return m.chooseAnswer(m.cursor), nil
+			}
+			if m.state == candidateState {
+				m.state = commandState
+				m.response = m.candidates[m.cursor].Response
+				m.textarea.SetValue(llm.ExtractCommand(m.response.Command))
+				return m, nil
+			}
 		case "ctrl+s":
+			if m.loading {
+				return m, nil
+			}
 			if m.state == promptState {
 				m.prompt = m.textarea.Value()
+				m.request = m.prompt
 				m.state = commandState
 				m.loading = true
 				m.textarea.Reset()
 				m.textarea.Placeholder = "Enter your command here..."
-				return m, tea.Batch(m.spinner.Tick, m.generateCommand)
+				return m, tea.Batch(m.spinner.Tick, m.generate)
 			}
-			m.accepted = true
-			return m, tea.Quit
+			return m.accept()
+		}
+	case tokenMsg:
+		m.streaming = true
+		m.textarea.SetValue(m.textarea.Value() + msg.token)
+		return m, waitForToken(msg.stream)
+	case errMsg:
+		m.loading = false
+		m.streaming = false
+		m.err = msg.err
+		return m, tea.Quit
+	case candidatesGeneratedMsg:
+		m.loading = false
+		m.state = candidateState
+		m.candidates = msg.candidates
+		m.cursor = 0
+	case consensusMsg:
+		m.loading = false
+		m.consensus = msg.consensus
+		m.cursor = 0
+		if m.consensus.Agreed() || len(m.consensus.Answers) == 1 {
+			m = m.chooseAnswer(0)
+		} else {
+			m.state = consensusState
 		}
 	case commandGeneratedMsg:
 		m.loading = false
-		m.command = msg.command
-		m.textarea.SetValue(msg.command)
+		m.streaming = false
+		m.response = msg.response
+		m.textarea.SetValue(llm.ExtractCommand(msg.response.Command))
 	}
 
 	m.spinner, cmd = m.spinner.Update(msg)
-	m.textarea, _ = m.textarea.Update(msg)
+	switch {
+	case m.state == confirmState:
+		var confirmCmd tea.Cmd
+		m.confirm, confirmCmd = m.confirm.Update(msg)
+		cmd = tea.Batch(cmd, confirmCmd)
+	case m.state == historyState:
+		var searchCmd tea.Cmd
+		m.search, searchCmd = m.search.Update(msg)
+		cmd = tea.Batch(cmd, searchCmd)
+	case !m.loading && m.state != candidateState && m.state != consensusState:
+		m.textarea, _ = m.textarea.Update(msg)
+	}
 
 	return m, cmd
 }
 
 func (m Model) View() string {
+	if m.err != nil {
+		return ""
+	}
+
+	if m.streaming {
+		return "Prompt:\n\n" + m.prompt + "\n\n" + m.textarea.View() + "\n\n" + m.spinner.View() + " Generating..."
+	}
+
 	if m.loading {
 		return m.spinner.View() + " Thinking..."
 	}
 
+	if m.state == candidateState {
+		return "Prompt:\n\n" + m.prompt + "\n\n" + m.candidatesView() + "\n(↑/↓ to choose, enter to edit, ctrl+c to quit)"
+	}
+
+	if m.state == consensusState {
+		return "Prompt:\n\n" + m.prompt + "\n\n" + m.consensusView() + "\n(←/→ to choose, enter to edit, ctrl+c to quit)"
+	}
+
+	if m.state == confirmState {
+		return m.confirmView()
+	}
+
+	if m.state == historyState {
+		return m.historyView()
+	}
+
 	if m.state == promptState {
 		return "Enter a prompt to generate a command:\n\n" + m.textarea.View() + "\n\n(ctrl+s to submit, ctrl+c to quit)"
 	}
 
-	return "Prompt:\n\n" + m.prompt + "\n\n" + m.textarea.View() + "\n\n(ctrl+s to accept, ctrl+c to quit)"
+	return "Prompt:\n\n" + m.prompt + "\n\n" + m.textarea.View() + "\n\n" + m.riskView() + "\n" + m.detailsView() + "(ctrl+s to accept, ctrl+c to quit)"
+}
+
+// detailsView describes the generated command, if the model explained it.
+func (m Model) detailsView() string {
+	var b strings.Builder
+	if m.response.Explanation != "" {
+		b.WriteString(m.response.Explanation + "\n")
+	}
+	if m.response.Risk != "" {
+		b.WriteString("Risk: " + string(m.response.Risk))
+		if m.response.RequiresSudo {
+			b.WriteString(" (requires sudo)")
+		}
+		b.WriteString("\n")
+	}
+	if m.response.Provider != "" {
+		b.WriteString("Provider: " + m.response.Provider + "\n")
+	}
+	if m.response.Cached {
+		b.WriteString("Cached: yes\n")
+	}
+	if m.request != m.prompt {
+		b.WriteString("Fix: the previous command failed\n")
+	}
+	if m.historyEntry != nil {
+		fmt.Fprintf(&b, "History: run %s in %s\n", m.historyEntry.Time.Local().Format("2006-01-02 15:04"), m.historyEntry.Dir)
+	}
+	if m.response.Repairs > 0 {
+		fmt.Fprintf(&b, "Repaired: %d time(s)\n", m.response.Repairs)
+	}
+	if len(m.consensus.Answers) > 0 {
+		b.WriteString("Consensus: " + m.consensusSummary() + "\n")
+	}
+	if b.Len() > 0 {
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+func (m Model) candidatesView() string {
+	var b strings.Builder
+	for i, c := range m.candidates {
+		cursor := "  "
+		if i == m.cursor {
+			cursor = "> "
+		}
+		votes := ""
+		if c.Votes > 1 {
+			votes = fmt.Sprintf(" (x%d)", c.Votes)
+		}
+		fmt.Fprintf(&b, "%s%d. %s%s\n", cursor, i+1, llm.ExtractCommand(c.Command), votes)
+	}
+	return b.String()
+}
+
+// Fixing returns a copy of the model that asks the provider for request, a
+// prompt to fix a command that failed, while showing the original prompt.
+func (m Model) Fixing(request string) Model {
+	m.request = request
+	return m
+}
+
+// Err returns the error that ended the program, if generating the command
+// failed.
+func (m Model) Err() error {
+	return m.err
 }
 
 func (m Model) Accepted() bool {
 	return m.accepted
 }
 
+// Confirmed reports whether the user typed the confirmation phrase before
+// accepting the command.
+func (m Model) Confirmed() bool {
+	return m.confirmed
+}
+
+// Prompt returns the prompt, which the user may have typed in the TUI.
+func (m Model) Prompt() string {
+	return m.prompt
+}
+
+// Response returns the response the command being edited came from.
+func (m Model) Response() llm.Response {
+	return m.response
+}
+
 func (m Model) Command() string {
 	return m.textarea.Value()
 }
+
+// HistoryDir returns the directory the command being edited ran in, when it
+// came from the history.
+func (m Model) HistoryDir() string {
+	if m.historyEntry == nil {
+		return ""
+	}
+	return m.historyEntry.Dir
+}
@@ -0,0 +1,111 @@
+package tui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/zombor/gen/policy"
+	"github.com/zombor/gen/shell"
+)
+
+// ConfirmationPhrase must be typed to run a high risk command, or one the
+// policy wants confirmed.
+const ConfirmationPhrase = "run it anyway"
+
+var (
+	badgeStyle  = lipgloss.NewStyle().Bold(true).Padding(0, 1).Foreground(lipgloss.Color("0"))
+	badgeColors = map[shell.Risk]lipgloss.Color{
+		shell.RiskLow:    lipgloss.Color("2"),
+		shell.RiskMedium: lipgloss.Color("3"),
+		shell.RiskHigh:   lipgloss.Color("1"),
+	}
+	mismatchStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("1"))
+)
+
+func newConfirmInput() textinput.Model {
+	ti := textinput.New()
+	ti.Placeholder = ConfirmationPhrase
+	ti.Prompt = "> "
+	return ti
+}
+
+// riskBadge renders the risk of a command as a colored badge.
+func riskBadge(risk shell.Risk) string {
+	return badgeStyle.Background(badgeColors[risk]).Render(strings.ToUpper(risk.String()) + " RISK")
+}
+
+// riskView shows the badge of the command being edited, the risky patterns
+// and syntax errors found in it and what the policy says about it.
+func (m Model) riskView() string {
+	assessment := shell.Assess(m.textarea.Value())
+	var b strings.Builder
+	b.WriteString(riskBadge(assessment.Risk) + "\n")
+	for _, finding := range assessment.Findings {
+		b.WriteString("- " + finding.Reason + "\n")
+	}
+	if err := shell.Validate(m.env.Shell, m.textarea.Value()); err != nil {
+		b.WriteString(mismatchStyle.Render("Syntax error: "+err.Error()) + "\n")
+	}
+	switch decision := m.policy.Check(m.textarea.Value()); decision.Action {
+	case policy.Deny:
+		b.WriteString(badgeStyle.Background(badgeColors[shell.RiskHigh]).Render("BLOCKED BY POLICY") + "\n- " + decision.String() + "\n")
+	case policy.Confirm:
+		b.WriteString(badgeStyle.Background(badgeColors[shell.RiskMedium]).Render("POLICY: CONFIRM") + "\n- " + decision.String() + "\n")
+	}
+	return b.String()
+}
+
+// accept accepts the command, asking for the confirmation phrase first if it
+// is high risk or the policy wants it confirmed. Commands the policy denies
+// are not accepted.
+func (m Model) accept() (Model, tea.Cmd) {
+	decision := m.policy.Check(m.textarea.Value())
+	if decision.Action == policy.Deny {
+		return m, nil
+	}
+	if decision.Action == policy.Confirm || shell.Assess(m.textarea.Value()).Risk == shell.RiskHigh {
+		m.state = confirmState
+		m.mismatch = false
+		m.confirm.Reset()
+		return m, m.confirm.Focus()
+	}
+	m.accepted = true
+	return m, tea.Quit
+}
+
+// updateConfirm handles a key press while asking for the confirmation
+// phrase.
+func (m Model) updateConfirm(msg tea.KeyMsg) (Model, tea.Cmd) {
+	switch msg.String() {
+	case "enter":
+		if strings.TrimSpace(m.confirm.Value()) == ConfirmationPhrase {
+			m.accepted = true
+			m.confirmed = true
+			return m, tea.Quit
+		}
+		m.mismatch = true
+		m.confirm.Reset()
+		return m, nil
+	case "esc":
+		m.state = commandState
+		m.confirm.Blur()
+		return m, nil
+	}
+	var cmd tea.Cmd
+	m.confirm, cmd = m.confirm.Update(msg)
+	return m, cmd
+}
+
+// confirmView asks for the confirmation phrase.
+func (m Model) confirmView() string {
+	var b strings.Builder
+	b.WriteString("Command:\n\n" + m.textarea.Value() + "\n\n" + m.riskView() + "\n")
+	b.WriteString("Type \"" + ConfirmationPhrase + "\" to run this command:\n\n" + m.confirm.View() + "\n\n")
+	if m.mismatch {
+		b.WriteString(mismatchStyle.Render("That is not the confirmation phrase.") + "\n\n")
+	}
+	b.WriteString("(enter to confirm, esc to edit, ctrl+c to quit)")
+	return b.String()
+}
@@ -0,0 +1,145 @@
+package tui_test
+
+import (
+	tea "github.com/charmbracelet/bubbletea"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/cmd/gen/tui"
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/history"
+	"github.com/zombor/gen/policy"
+)
+
+// press sends keys to m, one message each.
+func press(m tui.Model, keys ...tea.KeyMsg) tui.Model {
+	for _, key := range keys {
+		updated, _ := m.Update(key)
+		m = updated.(tui.Model)
+	}
+	return m
+}
+
+// typed is the key message of typing text.
+func typed(text string) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(text)}
+}
+
+var (
+	enter = tea.KeyMsg{Type: tea.KeyEnter}
+	save  = tea.KeyMsg{Type: tea.KeyCtrlS}
+	esc   = tea.KeyMsg{Type: tea.KeyEsc}
+)
+
+var _ = Describe("accepting a command", func() {
+	var (
+		command string
+		rules   string
+		keys    []tea.KeyMsg
+		m       tui.Model
+	)
+
+	BeforeEach(func() {
+		command = "ls -l"
+		rules = ""
+		keys = []tea.KeyMsg{save}
+	})
+
+	JustBeforeEach(func() {
+		var p *policy.Policy
+		if rules != "" {
+			file, err := policy.Parse("policy", []byte(rules))
+			Expect(err).ToNot(HaveOccurred())
+			p = &policy.Policy{Files: []policy.File{file}, Dir: "/home/me/src", Home: "/home/me"}
+		}
+		entries := []history.Entry{{Prompt: "do it", Command: command}}
+		m = tui.NewHistoryModel(entries, history.Filter{}, 10, environment.Environment{Shell: "/bin/sh"}, p)
+		// enter picks the command from the history to be edited.
+		m = press(m, append([]tea.KeyMsg{enter}, keys...)...)
+	})
+
+	It("accepts a low risk command", func() {
+		Expect(m.Accepted()).To(BeTrue())
+	})
+
+	It("does not ask for the confirmation phrase", func() {
+		Expect(m.Confirmed()).To(BeFalse())
+	})
+
+	Context("with a high risk command", func() {
+		BeforeEach(func() {
+			command = "rm -rf /"
+		})
+
+		It("does not accept it yet", func() {
+			Expect(m.Accepted()).To(BeFalse())
+		})
+
+		It("asks for the confirmation phrase", func() {
+			Expect(m.View()).To(ContainSubstring(`Type "run it anyway" to run this command`))
+		})
+
+		Context("when the user types the confirmation phrase", func() {
+			BeforeEach(func() {
+				keys = []tea.KeyMsg{save, typed(tui.ConfirmationPhrase), enter}
+			})
+
+			It("accepts the command", func() {
+				Expect(m.Accepted()).To(BeTrue())
+			})
+
+			It("reports that it was confirmed", func() {
+				Expect(m.Confirmed()).To(BeTrue())
+			})
+		})
+
+		Context("when the user types another phrase", func() {
+			BeforeEach(func() {
+				keys = []tea.KeyMsg{save, typed("run it"), enter}
+			})
+
+			It("does not accept the command", func() {
+				Expect(m.Accepted()).To(BeFalse())
+			})
+
+			It("says the phrase is wrong", func() {
+				Expect(m.View()).To(ContainSubstring("That is not the confirmation phrase."))
+			})
+		})
+
+		Context("when the user goes back to edit the command", func() {
+			BeforeEach(func() {
+				keys = []tea.KeyMsg{save, esc}
+			})
+
+			It("shows the command again", func() {
+				Expect(m.View()).To(ContainSubstring("ctrl+s to accept"))
+			})
+		})
+	})
+
+	Context("when the policy wants the command confirmed", func() {
+		BeforeEach(func() {
+			rules = "confirm binary ls"
+		})
+
+		It("asks for the confirmation phrase", func() {
+			Expect(m.Accepted()).To(BeFalse())
+		})
+	})
+
+	Context("when the policy denies the command", func() {
+		BeforeEach(func() {
+			rules = "deny binary ls"
+			keys = []tea.KeyMsg{save, typed(tui.ConfirmationPhrase), enter}
+		})
+
+		It("does not accept it", func() {
+			Expect(m.Accepted()).To(BeFalse())
+		})
+
+		It("shows that it is blocked", func() {
+			Expect(m.View()).To(ContainSubstring("BLOCKED BY POLICY"))
+		})
+	})
+})
@@ -0,0 +1,210 @@
+// Package environment describes the system a generated command will run on.
+package environment
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// Coreutils identifies the flavor of the core userland tools (ls, sed, find,
+// ...), which decides which flags are available.
+type Coreutils string
+
+const (
+	CoreutilsGNU     Coreutils = "GNU"
+	CoreutilsBSD     Coreutils = "BSD"
+	CoreutilsBusyBox Coreutils = "BusyBox"
+)
+
+// Environment is the runtime context passed to the model along with the
+// user's prompt. Fields that could not be detected are left empty.
+type Environment struct {
+	// OS is the operating system, as reported by runtime.GOOS.
+	OS string
+	// Distro is the name and version of the distribution, e.g. "Ubuntu 24.04 LTS".
+	Distro string
+	// Kernel is the kernel release.
+	Kernel string
+	// Arch is the CPU architecture, as reported by runtime.GOARCH.
+	Arch string
+	// Shell is the name of the shell commands are executed with.
+	Shell string
+	// ShellVersion is the version of Shell.
+	ShellVersion string
+	// Cwd is the working directory commands are executed in.
+	Cwd string
+	// Coreutils is the flavor of the installed core utilities.
+	Coreutils Coreutils
+}
+
+// Summary describes the environment to the model, one fact per line.
+func (e Environment) Summary() string {
+	var lines []string
+	add := func(label, value string) {
+		if value != "" {
+			lines = append(lines, fmt.Sprintf("%s: %s", label, value))
+		}
+	}
+
+	system := e.OS
+	if e.Distro != "" {
+		system = fmt.Sprintf("%s (%s)", e.OS, e.Distro)
+	}
+	add("OS", system)
+	add("Kernel", e.Kernel)
+	add("Architecture", e.Arch)
+	add("Shell", strings.TrimSpace(e.Shell+" "+e.ShellVersion))
+	add("Working directory", e.Cwd)
+
+	switch e.Coreutils {
+	case CoreutilsBSD:
+		add("Coreutils", "BSD (GNU-only flags such as `sed -i` without a suffix argument or `find -printf` are not available)")
+	default:
+		add("Coreutils", string(e.Coreutils))
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Fingerprint identifies the system a command is generated for. Unlike
+// Summary it leaves out Cwd, so that the same request made from another
+// directory is recognised as the same.
+func (e Environment) Fingerprint() string {
+	h := sha256.New()
+	for _, field := range []string{e.OS, e.Distro, e.Kernel, e.Arch, e.Shell, e.ShellVersion, string(e.Coreutils)} {
+		fmt.Fprintf(h, "%s\x00", field)
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// commandTimeout bounds each probe run while collecting the environment.
+const commandTimeout = 2 * time.Second
+
+// versionPattern matches the first dotted version number in a line of text.
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// Collector detects the Environment of the current process.
+type Collector struct {
+	GOOS     string
+	GOARCH   string
+	Getenv   func(string) string
+	Getwd    func() (string, error)
+	ReadFile func(string) ([]byte, error)
+	// Run runs a command and returns its combined output.
+	Run func(ctx context.Context, name string, args ...string) (string, error)
+}
+
+// NewCollector returns a Collector for the current process.
+func NewCollector() *Collector {
+	return &Collector{
+		GOOS:     runtime.GOOS,
+		GOARCH:   runtime.GOARCH,
+		Getenv:   os.Getenv,
+		Getwd:    os.Getwd,
+		ReadFile: os.ReadFile,
+		Run:      run,
+	}
+}
+
+// Collect detects the environment. Detection is best effort: anything that
+// cannot be determined is left empty.
+func (c *Collector) Collect(ctx context.Context) Environment {
+	shellPath := c.Getenv("SHELL")
+	if shellPath == "" {
+		shellPath = "sh"
+	}
+
+	env := Environment{
+		OS:           c.GOOS,
+		Distro:       c.distro(ctx),
+		Kernel:       c.output(ctx, "uname", "-r"),
+		Arch:         c.GOARCH,
+		Shell:        filepath.Base(shellPath),
+		ShellVersion: versionPattern.FindString(c.output(ctx, shellPath, "--version")),
+		Coreutils:    c.coreutils(ctx),
+	}
+
+	if cwd, err := c.Getwd(); err == nil {
+		env.Cwd = cwd
+	}
+
+	return env
+}
+
+func (c *Collector) distro(ctx context.Context) string {
+	switch c.GOOS {
+	case "linux":
+		data, err := c.ReadFile("/etc/os-release")
+		if err != nil {
+			return ""
+		}
+		return parseOSRelease(data)
+	case "darwin":
+		if version := c.output(ctx, "sw_vers", "-productVersion"); version != "" {
+			return "macOS " + version
+		}
+	}
+	return ""
+}
+
+func (c *Collector) coreutils(ctx context.Context) Coreutils {
+	out, err := c.Run(ctx, "ls", "--version")
+	switch {
+	case strings.Contains(out, "BusyBox"):
+		return CoreutilsBusyBox
+	case strings.Contains(strings.ToLower(out), "coreutils"):
+		return CoreutilsGNU
+	case err != nil && c.GOOS != "windows":
+		// BSD ls has no --version flag.
+		return CoreutilsBSD
+	}
+	return ""
+}
+
+// output returns the first line of a command's output, or "" if it failed.
+func (c *Collector) output(ctx context.Context, name string, args ...string) string {
+	out, err := c.Run(ctx, name, args...)
+	if err != nil {
+		return ""
+	}
+	line, _, _ := strings.Cut(out, "\n")
+	return strings.TrimSpace(line)
+}
+
+// parseOSRelease returns the distribution name from the contents of an
+// os-release file.
+func parseOSRelease(data []byte) string {
+	fields := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok || strings.HasPrefix(key, "#") {
+			continue
+		}
+		fields[key] = strings.Trim(value, `"'`)
+	}
+
+	if name := fields["PRETTY_NAME"]; name != "" {
+		return name
+	}
+	return strings.TrimSpace(fields["NAME"] + " " + fields["VERSION_ID"])
+}
+
+func run(ctx context.Context, name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	return string(out), err
+}
@@ -0,0 +1,212 @@
+package environment_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/environment"
+)
+
+var _ = Describe("Collector", func() {
+	var (
+		collector *environment.Collector
+		files     map[string]string
+		outputs   map[string]string
+		env       environment.Environment
+	)
+
+	BeforeEach(func() {
+		files = map[string]string{
+			"/etc/os-release": "NAME=\"Ubuntu\"\nVERSION_ID=\"24.04\"\nPRETTY_NAME=\"Ubuntu 24.04 LTS\"\n",
+		}
+		outputs = map[string]string{
+			"uname -r":                "6.8.0-31-generic\n",
+			"/bin/bash --version":     "GNU bash, version 5.2.21(1)-release (x86_64-pc-linux-gnu)\nCopyright (C) 2022\n",
+			"ls --version":            "ls (GNU coreutils) 9.4\n",
+			"sw_vers -productVersion": "14.5\n",
+		}
+		collector = &environment.Collector{
+			GOOS:   "linux",
+			GOARCH: "amd64",
+			Getenv: func(key string) string {
+				if key == "SHELL" {
+					return "/bin/bash"
+				}
+				return ""
+			},
+			Getwd: func() (string, error) { return "/home/me", nil },
+			ReadFile: func(name string) ([]byte, error) {
+				if data, ok := files[name]; ok {
+					return []byte(data), nil
+				}
+				return nil, errors.New("no such file")
+			},
+			Run: func(ctx context.Context, name string, args ...string) (string, error) {
+				if out, ok := outputs[strings.Join(append([]string{name}, args...), " ")]; ok {
+					return out, nil
+				}
+				return "unrecognized option", errors.New("exit status 1")
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		env = collector.Collect(context.Background())
+	})
+
+	It("detects a GNU/Linux environment", func() {
+		Expect(env).To(Equal(environment.Environment{
+			OS:           "linux",
+			Distro:       "Ubuntu 24.04 LTS",
+			Kernel:       "6.8.0-31-generic",
+			Arch:         "amd64",
+			Shell:        "bash",
+			ShellVersion: "5.2.21",
+			Cwd:          "/home/me",
+			Coreutils:    environment.CoreutilsGNU,
+		}))
+	})
+
+	When("os-release has no PRETTY_NAME", func() {
+		BeforeEach(func() {
+			files["/etc/os-release"] = "# comment\nNAME=Alpine Linux\nVERSION_ID=3.20.0\n"
+		})
+
+		It("uses the name and version", func() {
+			Expect(env.Distro).To(Equal("Alpine Linux 3.20.0"))
+		})
+	})
+
+	When("ls is provided by BusyBox", func() {
+		BeforeEach(func() {
+			delete(outputs, "ls --version")
+			collector.Run = func(ctx context.Context, name string, args ...string) (string, error) {
+				if name == "ls" {
+					return "ls: unrecognized option: version\nBusyBox v1.36.1 multi-call binary.\n", errors.New("exit status 1")
+				}
+				return outputs[strings.Join(append([]string{name}, args...), " ")], nil
+			}
+		})
+
+		It("reports BusyBox", func() {
+			Expect(env.Coreutils).To(Equal(environment.CoreutilsBusyBox))
+		})
+	})
+
+	When("running on macOS", func() {
+		BeforeEach(func() {
+			collector.GOOS = "darwin"
+			collector.GOARCH = "arm64"
+			delete(outputs, "ls --version")
+			delete(outputs, "/bin/bash --version")
+			outputs["/bin/zsh --version"] = "zsh 5.9 (arm64-apple-darwin23.0)\n"
+			collector.Getenv = func(string) string { return "/bin/zsh" }
+		})
+
+		It("detects the macOS version", func() {
+			Expect(env.Distro).To(Equal("macOS 14.5"))
+		})
+
+		It("detects zsh", func() {
+			Expect(env.Shell).To(Equal("zsh"))
+		})
+
+		It("detects the zsh version", func() {
+			Expect(env.ShellVersion).To(Equal("5.9"))
+		})
+
+		It("reports BSD coreutils", func() {
+			Expect(env.Coreutils).To(Equal(environment.CoreutilsBSD))
+		})
+	})
+
+	When("SHELL is not set", func() {
+		BeforeEach(func() {
+			collector.Getenv = func(string) string { return "" }
+		})
+
+		It("falls back to sh", func() {
+			Expect(env.Shell).To(Equal("sh"))
+		})
+
+		It("leaves the shell version empty", func() {
+			Expect(env.ShellVersion).To(BeEmpty())
+		})
+	})
+
+	When("nothing can be detected", func() {
+		BeforeEach(func() {
+			files = map[string]string{}
+			outputs = map[string]string{}
+			collector.Getwd = func() (string, error) { return "", errors.New("getwd failed") }
+		})
+
+		It("leaves the distro empty", func() {
+			Expect(env.Distro).To(BeEmpty())
+		})
+
+		It("leaves the kernel empty", func() {
+			Expect(env.Kernel).To(BeEmpty())
+		})
+
+		It("leaves the working directory empty", func() {
+			Expect(env.Cwd).To(BeEmpty())
+		})
+	})
+})
+
+var _ = Describe("Environment", func() {
+	Describe("Summary", func() {
+		It("describes each detected field on its own line", func() {
+			env := environment.Environment{
+				OS:           "linux",
+				Distro:       "Ubuntu 24.04 LTS",
+				Kernel:       "6.8.0",
+				Arch:         "amd64",
+				Shell:        "bash",
+				ShellVersion: "5.2.21",
+				Cwd:          "/home/me",
+				Coreutils:    environment.CoreutilsGNU,
+			}
+
+			Expect(env.Summary()).To(Equal(`OS: linux (Ubuntu 24.04 LTS)
+Kernel: 6.8.0
+Architecture: amd64
+Shell: bash 5.2.21
+Working directory: /home/me
+Coreutils: GNU`))
+		})
+
+		It("omits fields that were not detected", func() {
+			env := environment.Environment{OS: "linux", Shell: "sh"}
+
+			Expect(env.Summary()).To(Equal("OS: linux\nShell: sh"))
+		})
+
+		It("warns about GNU-only flags on BSD systems", func() {
+			env := environment.Environment{OS: "darwin", Coreutils: environment.CoreutilsBSD}
+
+			Expect(env.Summary()).To(ContainSubstring("`find -printf` are not available"))
+		})
+	})
+
+	Describe("Fingerprint", func() {
+		It("ignores the working directory", func() {
+			a := environment.Environment{OS: "linux", Shell: "bash", Cwd: "/home/me"}
+			b := environment.Environment{OS: "linux", Shell: "bash", Cwd: "/tmp"}
+
+			Expect(a.Fingerprint()).To(Equal(b.Fingerprint()))
+		})
+
+		It("changes with the shell", func() {
+			a := environment.Environment{OS: "linux", Shell: "bash"}
+			b := environment.Environment{OS: "linux", Shell: "zsh"}
+
+			Expect(a.Fingerprint()).ToNot(Equal(b.Fingerprint()))
+		})
+	})
+})
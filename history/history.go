@@ -0,0 +1,177 @@
+// Package history keeps the commands the user accepted and the prompts they
+// were generated for, so they can be found and run again without asking the
+// provider.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/zombor/gen/jsonl"
+)
+
+// Entry is a command the user accepted.
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Prompt   string    `json:"prompt"`
+	Command  string    `json:"command"`
+	Provider string    `json:"provider"`
+	Model    string    `json:"model"`
+	// Dir is the directory the command ran in.
+	Dir      string `json:"dir"`
+	ExitCode int    `json:"exit_code"`
+}
+
+// Log is a JSON lines file of history entries.
+type Log struct {
+	Path string
+}
+
+// NewLog returns a Log that reads and writes path.
+func NewLog(path string) *Log {
+	return &Log{Path: path}
+}
+
+// Add appends entry to the log.
+func (l *Log) Add(entry Entry) error {
+	return jsonl.Append(l.Path, entry)
+}
+
+// Read returns the entries of the log, oldest first. A missing log has no
+// entries, and lines that cannot be decoded are skipped.
+func (l *Log) Read() ([]Entry, error) {
+	f, err := os.Open(l.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// Filter selects history entries. Zero fields match everything.
+type Filter struct {
+	// Search is matched fuzzily against the prompt and the command. Every
+	// word of it must match one of them.
+	Search string
+	// From and To bound the time of the entries; To is excluded.
+	From, To time.Time
+	Provider string
+	// Dir matches entries run in it or in a directory under it.
+	Dir string
+}
+
+// Find returns the entries that match filter, best match first and newest
+// first among equal matches. A command is returned once, as its newest
+// entry, however often it was run.
+func Find(entries []Entry, filter Filter) []Entry {
+	type match struct {
+		entry Entry
+		score int
+	}
+	var matches []match
+	seen := map[string]bool{}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if seen[entry.Command] || !filter.selects(entry) {
+			continue
+		}
+		seen[entry.Command] = true
+		if score, ok := filter.score(entry); ok {
+			matches = append(matches, match{entry: entry, score: score})
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+	found := make([]Entry, len(matches))
+	for i, m := range matches {
+		found[i] = m.entry
+	}
+	return found
+}
+
+// selects reports whether entry passes the filters other than Search.
+func (f Filter) selects(entry Entry) bool {
+	switch {
+	case !f.From.IsZero() && entry.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && !entry.Time.Before(f.To):
+		return false
+	case f.Provider != "" && entry.Provider != f.Provider:
+		return false
+	case f.Dir != "" && !inDir(entry.Dir, f.Dir):
+		return false
+	}
+	return true
+}
+
+// inDir reports whether path is dir or under it.
+func inDir(path, dir string) bool {
+	dir = filepath.Clean(dir)
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
+
+// score rates how well entry matches the search, summing the best score of
+// each word in the prompt or the command.
+func (f Filter) score(entry Entry) (int, bool) {
+	total := 0
+	for _, word := range strings.Fields(f.Search) {
+		best, found := 0, false
+		for _, text := range []string{entry.Prompt, entry.Command} {
+			if score, ok := fuzzyScore(word, text); ok && (!found || score > best) {
+				best, found = score, true
+			}
+		}
+		if !found {
+			return 0, false
+		}
+		total += best
+	}
+	return total, true
+}
+
+// fuzzyScore reports whether the letters of pattern appear in text in
+// order, ignoring case, and scores the match higher the more of them are
+// next to each other or start a word.
+func fuzzyScore(pattern, text string) (int, bool) {
+	p := []rune(strings.ToLower(pattern))
+	t := []rune(strings.ToLower(text))
+	score, prev, j := 0, -2, 0
+	for i := 0; i < len(t) && j < len(p); i++ {
+		if t[i] != p[j] {
+			continue
+		}
+		score++
+		if i == prev+1 {
+			score += 2
+		}
+		if i == 0 || !unicode.IsLetter(t[i-1]) && !unicode.IsDigit(t[i-1]) {
+			score += 3
+		}
+		prev = i
+		j++
+	}
+	return score, j == len(p)
+}
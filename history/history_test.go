@@ -0,0 +1,234 @@
+package history_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/history"
+)
+
+var day = time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+
+var _ = Describe("Log", func() {
+	var (
+		log     *history.Log
+		entry   history.Entry
+		entries []history.Entry
+		err     error
+	)
+
+	BeforeEach(func() {
+		log = history.NewLog(filepath.Join(GinkgoT().TempDir(), "gen", "history.jsonl"))
+		entry = history.Entry{
+			Time:     day,
+			Prompt:   "list running containers",
+			Command:  "docker ps",
+			Provider: "ollama",
+			Model:    "llama2",
+			Dir:      "/home/alice/project",
+		}
+	})
+
+	Describe("Add", func() {
+		JustBeforeEach(func() {
+			err = log.Add(entry)
+		})
+
+		It("succeeds", func() {
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("keeps the log private", func() {
+			info, err := os.Stat(log.Path)
+			Expect(info.Mode().Perm(), err).To(Equal(os.FileMode(0o600)))
+		})
+
+		When("the directory cannot be created", func() {
+			BeforeEach(func() {
+				parent := filepath.Join(GinkgoT().TempDir(), "file")
+				Expect(os.WriteFile(parent, nil, 0o600)).To(Succeed())
+				log = history.NewLog(filepath.Join(parent, "history.jsonl"))
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(HaveOccurred())
+			})
+		})
+
+		When("the log cannot be opened", func() {
+			BeforeEach(func() {
+				log = history.NewLog(GinkgoT().TempDir())
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+
+	Describe("Read", func() {
+		var later history.Entry
+
+		BeforeEach(func() {
+			later = entry
+			later.Time = day.Add(time.Hour)
+			later.Command = "docker ps -a"
+			later.ExitCode = 1
+			Expect(log.Add(entry)).To(Succeed())
+			Expect(log.Add(later)).To(Succeed())
+		})
+
+		JustBeforeEach(func() {
+			entries, err = log.Read()
+		})
+
+		It("returns the entries, oldest first", func() {
+			Expect(entries, err).To(Equal([]history.Entry{entry, later}))
+		})
+
+		When("a line is corrupt", func() {
+			BeforeEach(func() {
+				f, err := os.OpenFile(log.Path, os.O_APPEND|os.O_WRONLY, 0)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = f.WriteString("{not json\n")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+			})
+
+			It("skips it", func() {
+				Expect(entries, err).To(Equal([]history.Entry{entry, later}))
+			})
+		})
+
+		When("the log does not exist", func() {
+			BeforeEach(func() {
+				log = history.NewLog(filepath.Join(GinkgoT().TempDir(), "missing.jsonl"))
+			})
+
+			It("returns no entries", func() {
+				Expect(entries, err).To(BeEmpty())
+			})
+		})
+
+		When("the log cannot be read", func() {
+			BeforeEach(func() {
+				log = history.NewLog(filepath.Join(GinkgoT().TempDir(), "file", "history.jsonl"))
+				Expect(os.WriteFile(filepath.Dir(log.Path), nil, 0o600)).To(Succeed())
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(HaveOccurred())
+			})
+		})
+	})
+})
+
+var _ = Describe("Find", func() {
+	var (
+		entries []history.Entry
+		filter  history.Filter
+	)
+
+	// commands returns the commands of the entries Find returns.
+	commands := func() []string {
+		var commands []string
+		for _, entry := range history.Find(entries, filter) {
+			commands = append(commands, entry.Command)
+		}
+		return commands
+	}
+
+	BeforeEach(func() {
+		entries = []history.Entry{
+			{Time: day, Prompt: "list running containers", Command: "docker ps", Provider: "ollama", Dir: "/home/alice/app"},
+			{Time: day.Add(time.Hour), Prompt: "show disk usage", Command: "df -h", Provider: "openai", Dir: "/home/alice"},
+			{Time: day.AddDate(0, 0, 1), Prompt: "find large files", Command: "find . -size +100M", Provider: "openai", Dir: "/home/alice/app/src"},
+			{Time: day.AddDate(0, 0, 2), Prompt: "list containers again", Command: "docker ps", Provider: "openai", Dir: "/tmp"},
+			{Time: day.AddDate(0, 0, 3), Prompt: "delete stopped containers", Command: "docker container prune", Provider: "ollama", Dir: "/home/alice/application"},
+		}
+		filter = history.Filter{}
+	})
+
+	It("returns each command once, newest first", func() {
+		Expect(commands()).To(Equal([]string{"docker container prune", "docker ps", "find . -size +100M", "df -h"}))
+	})
+
+	It("returns the newest entry of a command", func() {
+		Expect(history.Find(entries, filter)[1].Prompt).To(Equal("list containers again"))
+	})
+
+	When("searching", func() {
+		BeforeEach(func() {
+			filter.Search = "dkps"
+		})
+
+		It("matches the letters in order", func() {
+			Expect(commands()).To(Equal([]string{"docker ps"}))
+		})
+	})
+
+	When("searching the prompts", func() {
+		BeforeEach(func() {
+			filter.Search = "DISK"
+		})
+
+		It("ignores case", func() {
+			Expect(commands()).To(Equal([]string{"df -h"}))
+		})
+	})
+
+	When("searching for several words", func() {
+		BeforeEach(func() {
+			filter.Search = "docker stop"
+		})
+
+		It("returns the entries that match all of them", func() {
+			Expect(commands()).To(Equal([]string{"docker container prune"}))
+		})
+	})
+
+	When("some matches are better than others", func() {
+		BeforeEach(func() {
+			filter.Search = "ps"
+		})
+
+		It("returns the best first", func() {
+			Expect(commands()).To(Equal([]string{"docker ps", "docker container prune"}))
+		})
+	})
+
+	When("filtering by date", func() {
+		BeforeEach(func() {
+			filter.From = day.Add(time.Hour)
+			filter.To = day.AddDate(0, 0, 2)
+		})
+
+		It("returns the entries in the range", func() {
+			Expect(commands()).To(Equal([]string{"find . -size +100M", "df -h"}))
+		})
+	})
+
+	When("filtering by provider", func() {
+		BeforeEach(func() {
+			filter.Provider = "ollama"
+		})
+
+		It("returns the entries of the provider", func() {
+			Expect(commands()).To(Equal([]string{"docker container prune", "docker ps"}))
+		})
+	})
+
+	When("filtering by directory", func() {
+		BeforeEach(func() {
+			filter.Dir = "/home/alice/app/"
+		})
+
+		It("returns the entries run in it or under it", func() {
+			Expect(commands()).To(Equal([]string{"find . -size +100M", "docker ps"}))
+		})
+	})
+})
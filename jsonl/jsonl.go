@@ -0,0 +1,33 @@
+// Package jsonl appends values to JSON lines files, such as the audit log,
+// the usage log and the history.
+package jsonl
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+)
+
+// Append writes v as a line at the end of the file at path, creating the
+// file and its directory, readable only by the user, if they do not exist.
+// Each line is written with a single append, so concurrent gen processes do
+// not interleave lines.
+func Append(path string, v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,83 @@
+package jsonl_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/jsonl"
+)
+
+var _ = Describe("Append", func() {
+	var (
+		path  string
+		value any
+		err   error
+	)
+
+	BeforeEach(func() {
+		path = filepath.Join(GinkgoT().TempDir(), "gen", "log.jsonl")
+		value = map[string]string{"command": "ls"}
+	})
+
+	JustBeforeEach(func() {
+		err = jsonl.Append(path, value)
+	})
+
+	It("succeeds", func() {
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("writes the value as a line", func() {
+		Expect(os.ReadFile(path)).To(Equal([]byte("{\"command\":\"ls\"}\n")))
+	})
+
+	It("keeps the file private", func() {
+		info, err := os.Stat(path)
+		Expect(info.Mode().Perm(), err).To(Equal(os.FileMode(0o600)))
+	})
+
+	When("the file has lines", func() {
+		BeforeEach(func() {
+			Expect(jsonl.Append(path, map[string]string{"command": "pwd"})).To(Succeed())
+		})
+
+		It("appends the line", func() {
+			Expect(os.ReadFile(path)).To(Equal([]byte("{\"command\":\"pwd\"}\n{\"command\":\"ls\"}\n")))
+		})
+	})
+
+	When("the value cannot be encoded", func() {
+		BeforeEach(func() {
+			value = make(chan int)
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	When("the directory cannot be created", func() {
+		BeforeEach(func() {
+			parent := filepath.Join(GinkgoT().TempDir(), "file")
+			Expect(os.WriteFile(parent, nil, 0o600)).To(Succeed())
+			path = filepath.Join(parent, "log.jsonl")
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+
+	When("the file cannot be opened", func() {
+		BeforeEach(func() {
+			path = GinkgoT().TempDir()
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
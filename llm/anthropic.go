@@ -1,45 +1,232 @@
 package llm
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
-	"os"
+	"net/http"
+	"strings"
 
-	anthropic "github.com/liushuangls/go-anthropic"
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm/prompt"
 )
 
+// anthropicVersion is the version of the Messages API the requests are
+// written for.
+const anthropicVersion = "2023-06-01"
+
+// AnthropicClient sends requests to the Anthropic Messages API. The Go SDKs
+// gen could use do not support tool use, so it speaks HTTP itself.
+type AnthropicClient struct {
+	HTTPClient *http.Client
+	BaseURL    string
+	APIKey     string
+}
+
+// NewAnthropicClient creates an AnthropicClient for the Anthropic API.
+func NewAnthropicClient(apiKey string) *AnthropicClient {
+	return &AnthropicClient{
+		HTTPClient: NewHTTPClient(),
+		BaseURL:    "https://api.anthropic.com/v1",
+		APIKey:     apiKey,
+	}
+}
+
+// anthropicAPIError is an error returned by the Anthropic API, either as
+// the body of a failed request or as an error event of a stream.
+type anthropicAPIError struct {
+	StatusCode int    `json:"-"`
+	Type       string `json:"type"`
+	Message    string `json:"message"`
+}
+
+func (e *anthropicAPIError) Error() string {
+	return fmt.Sprintf("anthropic: %s: %s", e.Type, e.Message)
+}
+
+// Messages sends a request body to the Messages API and returns the body of
+// the response. A request that fails returns an *anthropicAPIError.
+func (c *AnthropicClient) Messages(ctx context.Context, body []byte) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.BaseURL+"/messages", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Api-Key", c.APIKey)
+	req.Header.Set("Anthropic-Version", anthropicVersion)
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp.Body, nil
+	}
+	defer resp.Body.Close()
+
+	var failure struct {
+		Error anthropicAPIError `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&failure); err != nil || failure.Error.Type == "" {
+		failure.Error = anthropicAPIError{Type: "api_error", Message: resp.Status}
+	}
+	failure.Error.StatusCode = resp.StatusCode
+	return nil, &failure.Error
+}
+
 // AnthropicProvider is an implementation of LLMProvider for Anthropic.
 type AnthropicProvider struct {
-	CreateMessages func(context.Context, anthropic.MessagesRequest) (anthropic.MessagesResponse, error)
-	Model          string
+	Messages  func(ctx context.Context, body []byte) (io.ReadCloser, error)
+	Model     string
+	Templates *prompt.Templates
+	Params    GenerationParams
 }
 
-// GenerateCommand generates a command using the Anthropic LLM.
-func (p *AnthropicProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt, shell string) (string, error) {
-	fullPrompt := fmt.Sprintf(`Given the following prompt, generate a single shell command. The command should be able to be executed on a %s machine in a %s shell. The command should be reasonable and not destructive. Return only the command, with no explanation or other text.
-
-Prompt: %s`, os.Getenv("GOOS"), shell, prompt)
-	logger.Debug("anthropic prompt", "prompt", fullPrompt)
-
-	resp, err := p.CreateMessages(
-		ctx,
-		anthropic.MessagesRequest{
-			Model: p.Model,
-			Messages: []anthropic.Message{
-				anthropic.NewUserTextMessage(fullPrompt),
+// anthropicMaxTokens is the answer length used when MaxTokens is not set. The
+// Messages API requires one.
+const anthropicMaxTokens = 1000
+
+func NewAnthropicProvider(client *AnthropicClient, model string, templates *prompt.Templates, params GenerationParams) *AnthropicProvider {
+	return &AnthropicProvider{
+		Messages:  client.Messages,
+		Model:     model,
+		Templates: templates,
+		Params:    params,
+	}
+}
+
+// anthropicError maps Anthropic API errors onto the sentinel errors.
+func anthropicError(ctx context.Context, err error) error {
+	var apiErr *anthropicAPIError
+	if errors.As(err, &apiErr) {
+		if apiErr.Type == "overloaded_error" {
+			return providerError(ctx, ErrRateLimited, err)
+		}
+		return statusError(ctx, apiErr.StatusCode, err)
+	}
+	return contextError(ctx, err)
+}
+
+// anthropicResponseTool makes an Anthropic model answer by calling a tool
+// whose input schema is the response schema.
+func anthropicResponseTool(request map[string]any) {
+	request["tools"] = []any{
+		map[string]any{
+			"name":         responseToolName,
+			"description":  responseToolDescription,
+			"input_schema": json.RawMessage(responseSchema),
+		},
+	}
+	request["tool_choice"] = map[string]any{"type": "tool", "name": responseToolName}
+}
+
+// anthropicMessageChunk is an event of a streamed Anthropic message.
+type anthropicMessageChunk struct {
+	Type    string `json:"type"`
+	Message struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	Delta struct {
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+	} `json:"delta"`
+	Usage anthropicUsage     `json:"usage"`
+	Error *anthropicAPIError `json:"error"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+func (p *AnthropicProvider) body(logger *slog.Logger, prompt string, env environment.Environment) ([]byte, error) {
+	fullPrompt, err := renderPrompt(logger, p.Templates, "anthropic", prompt, env)
+	if err != nil {
+		return nil, err
+	}
+
+	request := map[string]any{
+		"model": p.Model,
+		"messages": []any{
+			map[string]any{
+				"role": "user",
+				"content": []any{
+					map[string]any{"type": "text", "text": fullPrompt},
+				},
 			},
-			MaxTokens: 1000, // A reasonable default, can be made configurable if needed
 		},
-	)
+		"max_tokens": anthropicMaxTokens,
+		"stream":     true,
+	}
+	anthropicResponseTool(request)
+	p.Params.set(request, paramNames{Temperature: "temperature", TopP: "top_p", MaxTokens: "max_tokens", Stop: "stop_sequences"})
+
+	body, err := json.Marshal(request)
 	if err != nil {
-		return "", err
+		return nil, fmt.Errorf("failed to marshal prompt: %w", err)
+	}
+	return body, nil
+}
+
+// GenerateCommand generates a command using the Anthropic LLM.
+func (p *AnthropicProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	return p.StreamCommand(ctx, logger, prompt, env, discardTokens)
+}
+
+// StreamCommand generates a command using the Anthropic LLM, passing the
+// command to onToken as it is decoded from the streamed input of the
+// response tool.
+func (p *AnthropicProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	body, err := p.body(logger, prompt, env)
+	if err != nil {
+		return Response{}, err
+	}
+
+	ctx = withRetryAfter(ctx)
+	stream, err := p.Messages(ctx, body)
+	if err != nil {
+		return Response{}, anthropicError(ctx, err)
+	}
+	defer stream.Close()
+
+	content := newJSONFieldStream("command", onToken)
+	var usage Usage
+	scanner := bufio.NewScanner(stream)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var chunk anthropicMessageChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return Response{}, fmt.Errorf("failed to read the Anthropic stream: %w", err)
+		}
+		switch chunk.Type {
+		case "message_start":
+			usage = Usage{InputTokens: chunk.Message.Usage.InputTokens, OutputTokens: chunk.Message.Usage.OutputTokens}
+		case "content_block_delta":
+			content.Write(chunk.Delta.Text + chunk.Delta.PartialJSON)
+		case "message_delta":
+			usage.OutputTokens = chunk.Usage.OutputTokens
+		case "error":
+			return Response{}, anthropicError(ctx, chunk.Error)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return Response{}, anthropicError(ctx, err)
 	}
 
-	if len(resp.Content) > 0 && resp.Content[0].Type == "text" {
-		logger.Debug("anthropic response", "response", resp.Content[0].Text)
-		return resp.Content[0].Text, nil
+	text := content.String()
+	if text == "" {
+		return Response{}, emptyResponse()
 	}
 
-	return "", fmt.Errorf("no command generated")
+	logger.Debug("anthropic response", "response", text)
+	return withUsage(parseResponseOrText(text), usage), nil
 }
@@ -2,83 +2,152 @@ package llm_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log/slog"
-	"os"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing/iotest"
 
-	anthropic "github.com/liushuangls/go-anthropic"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/zombor/gen/environment"
 	"github.com/zombor/gen/llm"
 )
 
+// anthropicEvents formats events as the server-sent events of a streamed
+// Anthropic message.
+func anthropicEvents(events ...map[string]any) string {
+	var b strings.Builder
+	for _, event := range events {
+		data, _ := json.Marshal(event)
+		fmt.Fprintf(&b, "event: %s\ndata: %s\n\n", event["type"], data)
+	}
+	return b.String()
+}
+
+func anthropicInputDelta(partial string) map[string]any {
+	return map[string]any{"type": "content_block_delta", "index": 0, "delta": map[string]any{"type": "input_json_delta", "partial_json": partial}}
+}
+
+var anthropicToolEvents = anthropicEvents(
+	map[string]any{"type": "message_start", "message": map[string]any{"usage": map[string]any{"input_tokens": 250, "output_tokens": 1}}},
+	map[string]any{"type": "content_block_start", "index": 0, "content_block": map[string]any{"type": "tool_use", "name": "shell_command", "input": map[string]any{}}},
+	anthropicInputDelta(`{"command": "ls`),
+	anthropicInputDelta(` -l", "explanation": "Lists files.", "risk": "low", "requires_sudo": false}`),
+	map[string]any{"type": "content_block_stop", "index": 0},
+	map[string]any{"type": "message_delta", "delta": map[string]any{"stop_reason": "tool_use"}, "usage": map[string]any{"output_tokens": 40}},
+	map[string]any{"type": "message_stop"},
+)
+
 var _ = Describe("AnthropicProvider", func() {
 	var (
-		provider           *llm.AnthropicProvider
-		mockCreateMessages func(context.Context, anthropic.MessagesRequest) (anthropic.MessagesResponse, error)
-		model              string
-		logger             *slog.Logger
+		provider    *llm.AnthropicProvider
+		events      string
+		messagesErr error
+		sent        map[string]any
+		logger      *slog.Logger
 	)
 
 	BeforeEach(func() {
-		model = "claude-test"
 		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+		events, messagesErr, sent = anthropicToolEvents, nil, nil
 		provider = &llm.AnthropicProvider{
-			CreateMessages: func(ctx context.Context, req anthropic.MessagesRequest) (anthropic.MessagesResponse, error) {
-				return mockCreateMessages(ctx, req)
+			Messages: func(ctx context.Context, body []byte) (io.ReadCloser, error) {
+				Expect(json.Unmarshal(body, &sent)).To(Succeed())
+				if messagesErr != nil {
+					return nil, messagesErr
+				}
+				return io.NopCloser(strings.NewReader(events)), nil
 			},
-			Model: model,
+			Model: "claude-test",
 		}
 	})
 
 	Describe("GenerateCommand", func() {
 		var (
-			prompt  string
-			shell   string
-			command string
+			env     environment.Environment
+			command llm.Response
 			err     error
 		)
 
 		BeforeEach(func() {
-			prompt = "list files"
-			shell = "bash"
+			env = environment.Environment{OS: "linux", Shell: "bash"}
 		})
 
 		JustBeforeEach(func() {
-			command, err = provider.GenerateCommand(context.Background(), logger, prompt, shell)
+			command, err = provider.GenerateCommand(context.Background(), logger, "list files", env)
 		})
 
-		Context("when the API call is successful", func() {
-			BeforeEach(func() {
-				mockCreateMessages = func(ctx context.Context, req anthropic.MessagesRequest) (anthropic.MessagesResponse, error) {
-					Expect(*req.Messages[0].Content[0].Text).To(ContainSubstring(prompt))
-					Expect(*req.Messages[0].Content[0].Text).To(ContainSubstring(os.Getenv("GOOS")))
-					Expect(*req.Messages[0].Content[0].Text).To(ContainSubstring(shell))
-					text := "ls -l"
-					return anthropic.MessagesResponse{
-						Content: []anthropic.MessagesContent{
-							{Type: "text", Text: text},
-						},
-					}, nil
-				}
+		It("returns the command from the input of the response tool", func() {
+			Expect(command, err).To(Equal(llm.Response{Command: "ls -l", Explanation: "Lists files.", Risk: llm.RiskLow, Usage: llm.Usage{InputTokens: 250, OutputTokens: 40}}))
+		})
+
+		Context("the request", func() {
+			It("is sent to the configured model", func() {
+				Expect(sent).To(HaveKeyWithValue("model", "claude-test"))
+			})
+
+			It("contains the prompt", func() {
+				Expect(sent["messages"]).To(ContainElement(HaveKeyWithValue("content", ContainElement(HaveKeyWithValue("text", ContainSubstring("list files"))))))
+			})
+
+			It("contains the shell", func() {
+				Expect(sent["messages"]).To(ContainElement(HaveKeyWithValue("content", ContainElement(HaveKeyWithValue("text", ContainSubstring("bash"))))))
 			})
 
-			It("returns the generated command", func() {
-				Expect(command).To(Equal("ls -l"))
+			It("has no prefilled reply", func() {
+				Expect(sent["messages"]).To(HaveLen(1))
 			})
 
-			It("does not return an error", func() {
-				Expect(err).ToNot(HaveOccurred())
+			It("forces the model to use the response tool", func() {
+				Expect(sent).To(HaveKeyWithValue("tool_choice", map[string]any{"type": "tool", "name": "shell_command"}))
+			})
+
+			It("gives the tool the response schema", func() {
+				Expect(sent["tools"]).To(ConsistOf(HaveKeyWithValue("input_schema", HaveKeyWithValue("required", []any{"command", "explanation", "risk", "requires_sudo"}))))
+			})
+
+			It("is streamed", func() {
+				Expect(sent).To(HaveKeyWithValue("stream", true))
+			})
+
+			It("uses the default max tokens", func() {
+				Expect(sent).To(HaveKeyWithValue("max_tokens", 1000.0))
+			})
+
+			Context("with generation params", func() {
+				BeforeEach(func() {
+					temperature, topP := float32(0), float32(0.5)
+					provider.Params = llm.GenerationParams{Temperature: &temperature, TopP: &topP, MaxTokens: 16000, Stop: []string{"END"}}
+				})
+
+				It("sets them on the request", func() {
+					Expect([]any{sent["temperature"], sent["top_p"], sent["max_tokens"], sent["stop_sequences"]}).To(Equal([]any{0.0, 0.5, 16000.0, []any{"END"}}))
+				})
+			})
+		})
+
+		Context("when the model answers with text", func() {
+			BeforeEach(func() {
+				events = anthropicEvents(
+					map[string]any{"type": "content_block_delta", "index": 0, "delta": map[string]any{"type": "text_delta", "text": "ls -l\n"}},
+				)
+			})
+
+			It("uses the text as the command", func() {
+				Expect(command, err).To(Equal(llm.Response{Command: "ls -l"}))
 			})
 		})
 
 		Context("when the API call returns an error", func() {
 			BeforeEach(func() {
-				mockCreateMessages = func(ctx context.Context, req anthropic.MessagesRequest) (anthropic.MessagesResponse, error) {
-					return anthropic.MessagesResponse{}, errors.New("anthropic API error")
-				}
+				messagesErr = errors.New("anthropic API error")
 			})
 
 			It("returns an error", func() {
@@ -86,45 +155,163 @@ var _ = Describe("AnthropicProvider", func() {
 			})
 
 			It("returns an empty command", func() {
-				Expect(command).To(BeEmpty())
+				Expect(command).To(BeZero())
 			})
 		})
 
-		Context("when no command is generated", func() {
+		Context("when the stream reports that the API is overloaded", func() {
 			BeforeEach(func() {
-				mockCreateMessages = func(ctx context.Context, req anthropic.MessagesRequest) (anthropic.MessagesResponse, error) {
-					return anthropic.MessagesResponse{},
-						nil
-				}
+				events = anthropicEvents(map[string]any{"type": "error", "error": map[string]any{"type": "overloaded_error", "message": "Overloaded"}})
 			})
 
-			It("returns an error", func() {
-				Expect(err).To(MatchError(ContainSubstring("no command generated")))
+			It("returns a rate limited error", func() {
+				Expect(err).To(MatchError(llm.ErrRateLimited))
 			})
+		})
 
-			It("returns an empty command", func() {
-				Expect(command).To(BeEmpty())
+		Context("when an event is not JSON", func() {
+			BeforeEach(func() {
+				events = "event: ping\ndata: {\n\n"
+			})
+
+			It("returns an error", func() {
+				Expect(err).To(MatchError(ContainSubstring("failed to read the Anthropic stream")))
 			})
 		})
 
-		Context("when the content type is not text", func() {
+		Context("when reading the stream fails", func() {
 			BeforeEach(func() {
-				mockCreateMessages = func(ctx context.Context, req anthropic.MessagesRequest) (anthropic.MessagesResponse, error) {
-					return anthropic.MessagesResponse{
-						Content: []anthropic.MessagesContent{
-							{Type: "image"},
-						},
-					}, nil
+				provider.Messages = func(ctx context.Context, body []byte) (io.ReadCloser, error) {
+					return io.NopCloser(iotest.ErrReader(errors.New("connection reset"))), nil
 				}
 			})
 
+			It("returns the error", func() {
+				Expect(err).To(MatchError("connection reset"))
+			})
+		})
+
+		Context("when no command is generated", func() {
+			BeforeEach(func() {
+				events = anthropicEvents(map[string]any{"type": "message_stop"})
+			})
+
 			It("returns an error", func() {
 				Expect(err).To(MatchError(ContainSubstring("no command generated")))
 			})
 
 			It("returns an empty command", func() {
-				Expect(command).To(BeEmpty())
+				Expect(command).To(BeZero())
+			})
+		})
+	})
+
+	Describe("StreamCommand", func() {
+		var (
+			tokens  []string
+			command llm.Response
+			err     error
+		)
+
+		BeforeEach(func() {
+			tokens = nil
+		})
+
+		JustBeforeEach(func() {
+			command, err = provider.StreamCommand(context.Background(), logger, "list files", testEnv, func(token string) {
+				tokens = append(tokens, token)
 			})
 		})
+
+		It("returns the generated command", func() {
+			Expect(command.Command, err).To(Equal("ls -l"))
+		})
+
+		It("passes the command to the token func as it is decoded", func() {
+			Expect(tokens).To(Equal([]string{"ls", " -l"}))
+		})
+	})
+})
+
+var _ = Describe("AnthropicClient", func() {
+	var (
+		server   *httptest.Server
+		request  *http.Request
+		status   int
+		body     string
+		provider *llm.AnthropicProvider
+		command  llm.Response
+		err      error
+	)
+
+	BeforeEach(func() {
+		status, body = http.StatusOK, anthropicToolEvents
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			request = r
+			w.WriteHeader(status)
+			fmt.Fprint(w, body)
+		}))
+
+		client := llm.NewAnthropicClient("test-key")
+		client.BaseURL = server.URL + "/v1"
+		provider = llm.NewAnthropicProvider(client, "claude-test", nil, llm.GenerationParams{})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	JustBeforeEach(func() {
+		command, err = provider.GenerateCommand(context.Background(), slog.New(slog.NewJSONHandler(ioutil.Discard, nil)), "list files", testEnv)
+	})
+
+	It("returns the command of the streamed reply", func() {
+		Expect(command.Command, err).To(Equal("ls -l"))
+	})
+
+	It("posts to the Messages API", func() {
+		Expect(request.URL.Path).To(Equal("/v1/messages"))
+	})
+
+	It("sends the API key", func() {
+		Expect(request.Header.Get("X-Api-Key")).To(Equal("test-key"))
+	})
+
+	It("sends the API version", func() {
+		Expect(request.Header.Get("Anthropic-Version")).To(Equal("2023-06-01"))
+	})
+
+	When("the API key is rejected", func() {
+		BeforeEach(func() {
+			status, body = http.StatusUnauthorized, `{"type": "error", "error": {"type": "authentication_error", "message": "invalid x-api-key"}}`
+		})
+
+		It("returns an auth error", func() {
+			Expect(err).To(MatchError(llm.ErrAuth))
+		})
+
+		It("returns the message of the API", func() {
+			Expect(err).To(MatchError(ContainSubstring("anthropic: authentication_error: invalid x-api-key")))
+		})
+	})
+
+	When("the error is not JSON", func() {
+		BeforeEach(func() {
+			status, body = http.StatusBadGateway, "<html>Bad Gateway</html>"
+		})
+
+		It("returns the status", func() {
+			Expect(err).To(MatchError("anthropic: api_error: 502 Bad Gateway"))
+		})
+	})
+
+	When("the server cannot be reached", func() {
+		BeforeEach(func() {
+			server.Close()
+		})
+
+		It("returns an error", func() {
+			Expect(err).To(HaveOccurred())
+		})
 	})
 })
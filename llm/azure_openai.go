@@ -0,0 +1,51 @@
+package llm
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// DefaultAzureOpenAIAPIVersion is the Azure OpenAI API version used when none
+// is configured. It is the first GA version that supports JSON schema
+// structured outputs.
+const DefaultAzureOpenAIAPIVersion = "2024-10-21"
+
+// AzureOpenAIClientConfig returns the client configuration for an Azure OpenAI
+// deployment. Exactly one of apiKey and token must be set; token is a
+// Microsoft Entra ID bearer token.
+func AzureOpenAIClientConfig(endpoint, deployment, apiVersion, apiKey, token string) (openai.ClientConfig, error) {
+	if endpoint == "" {
+		return openai.ClientConfig{}, fmt.Errorf("azure-openai requires an endpoint, e.g. https://<resource>.openai.azure.com")
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return openai.ClientConfig{}, fmt.Errorf("azure-openai endpoint %q must be an https URL such as https://<resource>.openai.azure.com", endpoint)
+	}
+	if deployment == "" {
+		return openai.ClientConfig{}, fmt.Errorf("azure-openai requires a deployment: the name given to the model deployment in your Azure OpenAI resource")
+	}
+	if apiKey != "" && token != "" {
+		return openai.ClientConfig{}, fmt.Errorf("azure-openai accepts either an API key or a bearer token, not both")
+	}
+	if apiKey == "" && token == "" {
+		return openai.ClientConfig{}, fmt.Errorf("azure-openai requires an API key from the resource's Keys and Endpoint page or a Microsoft Entra ID bearer token")
+	}
+	if apiVersion == "" {
+		apiVersion = DefaultAzureOpenAIAPIVersion
+	}
+
+	config := openai.DefaultAzureConfig(apiKey, strings.TrimRight(endpoint, "/"))
+	if token != "" {
+		config = openai.DefaultAzureConfig(token, strings.TrimRight(endpoint, "/"))
+		config.APIType = openai.APITypeAzureAD
+	}
+	config.APIVersion = apiVersion
+	config.AzureModelMapperFunc = func(string) string {
+		return deployment
+	}
+	config.HTTPClient = NewHTTPClient()
+	return config, nil
+}
@@ -0,0 +1,125 @@
+package llm_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/zombor/gen/llm"
+)
+
+var _ = Describe("AzureOpenAIClientConfig", func() {
+	DescribeTable("rejects invalid settings",
+		func(endpoint, deployment, apiKey, token, message string) {
+			_, err := llm.AzureOpenAIClientConfig(endpoint, deployment, "", apiKey, token)
+			Expect(err).To(MatchError(ContainSubstring(message)))
+		},
+		Entry("missing endpoint", "", "gpt-4o", "key", "", "azure-openai requires an endpoint"),
+		Entry("plain http endpoint", "http://example.openai.azure.com", "gpt-4o", "key", "", "must be an https URL"),
+		Entry("missing deployment", "https://example.openai.azure.com", "", "key", "", "azure-openai requires a deployment"),
+		Entry("no credentials", "https://example.openai.azure.com", "gpt-4o", "", "", "requires an API key"),
+		Entry("both credentials", "https://example.openai.azure.com", "gpt-4o", "key", "token", "not both"),
+	)
+
+	It("defaults the API version", func() {
+		config, err := llm.AzureOpenAIClientConfig("https://example.openai.azure.com", "gpt-4o", "", "key", "")
+		Expect(config.APIVersion, err).To(Equal(llm.DefaultAzureOpenAIAPIVersion))
+	})
+
+	Describe("requests", func() {
+		var (
+			server  *httptest.Server
+			request *http.Request
+			body    map[string]any
+			apiKey  string
+			token   string
+			command llm.Response
+			err     error
+		)
+
+		BeforeEach(func() {
+			apiKey, token = "", ""
+			server = httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				request = r
+				Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+				w.Header().Set("Content-Type", "application/json")
+				_ = json.NewEncoder(w).Encode(map[string]any{
+					"choices": []any{map[string]any{"index": 0, "message": map[string]any{
+						"role":    "assistant",
+						"content": `{"command": "ls", "explanation": "Lists files.", "risk": "low", "requires_sudo": false}`,
+					}}},
+				})
+			}))
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		JustBeforeEach(func() {
+			config, configErr := llm.AzureOpenAIClientConfig(server.URL+"/", "my-deployment", "2024-10-21", apiKey, token)
+			Expect(configErr).ToNot(HaveOccurred())
+			config.HTTPClient = server.Client()
+
+			provider := llm.NewOpenAIProvider(openai.NewClientWithConfig(config), "my-deployment", nil, llm.GenerationParams{})
+			logger := slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+			command, err = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+		})
+
+		When("an API key is set", func() {
+			BeforeEach(func() {
+				apiKey = "secret"
+			})
+
+			It("returns the command", func() {
+				Expect(command, err).To(Equal(llm.Response{Command: "ls", Explanation: "Lists files.", Risk: llm.RiskLow}))
+			})
+
+			It("calls the deployment", func() {
+				Expect(request.URL.Path).To(Equal("/openai/deployments/my-deployment/chat/completions"))
+			})
+
+			It("sends the API version", func() {
+				Expect(request.URL.Query().Get("api-version")).To(Equal("2024-10-21"))
+			})
+
+			It("sends the API key", func() {
+				Expect(request.Header.Get("api-key")).To(Equal("secret"))
+			})
+
+			It("does not send a bearer token", func() {
+				Expect(request.Header.Get("Authorization")).To(BeEmpty())
+			})
+		})
+
+		When("a bearer token is set", func() {
+			BeforeEach(func() {
+				token = "entra-token"
+			})
+
+			It("returns the command", func() {
+				Expect(command, err).To(Equal(llm.Response{Command: "ls", Explanation: "Lists files.", Risk: llm.RiskLow}))
+			})
+
+			It("calls the deployment", func() {
+				Expect(request.URL.Path).To(Equal("/openai/deployments/my-deployment/chat/completions"))
+			})
+
+			It("sends the bearer token", func() {
+				Expect(request.Header.Get("Authorization")).To(Equal("Bearer entra-token"))
+			})
+
+			It("does not send an API key", func() {
+				Expect(request.Header.Get("api-key")).To(BeEmpty())
+			})
+		})
+	})
+})
@@ -6,25 +6,56 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
-	"os"
-	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm/prompt"
 )
 
 // BedrockModel is an interface for Bedrock models.
 type BedrockModel interface {
-	GenerateCommand(ctx context.Context, logger *slog.Logger, prompt, shell string) (string, error)
+	GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error)
+	StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error)
 }
 
-// NewBedrock creates a new BedrockModel.
+// bedrockMaxTokens is the answer length used when MaxTokens is not set.
+const bedrockMaxTokens = 200
+
+// errBedrockNoContent is the cause of ErrEmptyResponse for Bedrock replies
+// without any content.
+var errBedrockNoContent = errors.New("bedrock response did not contain any content")
+
+// BedrockInvokeModelFunc invokes a Bedrock model and returns the whole response.
+type BedrockInvokeModelFunc func(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error)
+
+// BedrockInvokeModelStreamFunc invokes a Bedrock model and returns a reader
+// for the streamed response chunks.
+type BedrockInvokeModelStreamFunc func(ctx context.Context, params *bedrockruntime.InvokeModelWithResponseStreamInput) (bedrockruntime.ResponseStreamReader, error)
+
+// Bedrock APIs selectable with NewBedrock.
+const (
+	// BedrockAPIConverse uses the Converse API, which works with any model
+	// that supports it.
+	BedrockAPIConverse = "converse"
+	// BedrockAPIInvoke uses InvokeModel with a request body built for each of
+	// a fixed set of models.
+	BedrockAPIInvoke = "invoke"
+)
+
+// NewBedrock creates a new BedrockModel using the given Bedrock api.
 // If inferenceProfile is provided, it will be used as the ModelId for InvokeModel,
 // while the explicit model string is still used to choose the request/response schema.
-func NewBedrock(ctx context.Context, model, region, inferenceProfile string) (BedrockModel, error) {
+func NewBedrock(ctx context.Context, model, region, inferenceProfile, api string, templates *prompt.Templates, params GenerationParams) (BedrockModel, error) {
 	cfg, err := config.LoadDefaultConfig(ctx, config.WithRegion(region))
 	if err != nil {
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
@@ -37,36 +68,196 @@ func NewBedrock(ctx context.Context, model, region, inferenceProfile string) (Be
 		targetModelId = inferenceProfile
 	}
 
+	switch api {
+	case BedrockAPIConverse:
+		return &ConverseModel{
+			Converse: client.Converse,
+			ConverseStream: func(ctx context.Context, params *bedrockruntime.ConverseStreamInput) (bedrockruntime.ConverseStreamOutputReader, error) {
+				output, err := client.ConverseStream(ctx, params)
+				if err != nil {
+					return nil, err
+				}
+				return output.GetStream(), nil
+			},
+			Model:     targetModelId,
+			Templates: templates,
+			Params:    params,
+		}, nil
+	case BedrockAPIInvoke:
+	default:
+		return nil, fmt.Errorf("unsupported Bedrock API: %s (expected %s or %s)", api, BedrockAPIConverse, BedrockAPIInvoke)
+	}
+
+	invokeStream := func(ctx context.Context, params *bedrockruntime.InvokeModelWithResponseStreamInput) (bedrockruntime.ResponseStreamReader, error) {
+		output, err := client.InvokeModelWithResponseStream(ctx, params)
+		if err != nil {
+			return nil, err
+		}
+		return output.GetStream(), nil
+	}
+
 	switch model {
 	case "amazon.nova-lite-v1:0":
 		return &NovaLiteModel{
-			InvokeModel: client.InvokeModel,
-			Model:       targetModelId,
+			InvokeModel:                   client.InvokeModel,
+			InvokeModelWithResponseStream: invokeStream,
+			Model:                         targetModelId,
+			Templates:                     templates,
+			Params:                        params,
 		}, nil
 	case "amazon.titan-text-lite-v1":
 		return &TitanLiteModel{
-			InvokeModel: client.InvokeModel,
-			Model:       targetModelId,
+			InvokeModel:                   client.InvokeModel,
+			InvokeModelWithResponseStream: invokeStream,
+			Model:                         targetModelId,
+			Templates:                     templates,
+			Params:                        params,
 		}, nil
 	case "openai.gpt-oss-120b-1:0":
 		return &OpenAIGPTOSSModel{
-			InvokeModel: client.InvokeModel,
-			Model:       targetModelId,
+			InvokeModel:                   client.InvokeModel,
+			InvokeModelWithResponseStream: invokeStream,
+			Model:                         targetModelId,
+			Templates:                     templates,
+			Params:                        params,
 		}, nil
 	case "anthropic.claude-sonnet-4-20250514-v1:0":
 		return &AnthropicSonnet4Model{
-			InvokeModel: client.InvokeModel,
-			Model:       targetModelId,
+			InvokeModel:                   client.InvokeModel,
+			InvokeModelWithResponseStream: invokeStream,
+			Model:                         targetModelId,
+			Templates:                     templates,
+			Params:                        params,
 		}, nil
 	default:
-		return nil, fmt.Errorf("unsupported Bedrock model: %s", model)
+		return nil, fmt.Errorf("unsupported Bedrock model for the %s API: %s (use the %s API instead)", BedrockAPIInvoke, model, BedrockAPIConverse)
+	}
+}
+
+// invokeBedrock sends body to the model and returns the raw response body
+// along with the token usage Bedrock reports in the response headers.
+func invokeBedrock(ctx context.Context, invoke BedrockInvokeModelFunc, model string, body []byte) ([]byte, Usage, error) {
+	output, err := invoke(ctx, &bedrockruntime.InvokeModelInput{
+		ModelId:     aws.String(model),
+		ContentType: aws.String("application/json"),
+		Body:        body,
+		Accept:      aws.String("application/json"),
+	})
+	if err != nil {
+		return nil, Usage{}, bedrockInvokeError(ctx, err)
+	}
+
+	var usage Usage
+	if response, ok := awsmiddleware.GetRawResponse(output.ResultMetadata).(*smithyhttp.Response); ok {
+		usage.InputTokens, _ = strconv.Atoi(response.Header.Get("X-Amzn-Bedrock-Input-Token-Count"))
+		usage.OutputTokens, _ = strconv.Atoi(response.Header.Get("X-Amzn-Bedrock-Output-Token-Count"))
+	}
+	return output.Body, usage, nil
+}
+
+// bedrockInvocationMetrics is added by Bedrock to the last chunk of every
+// streamed response, whatever the model.
+type bedrockInvocationMetrics struct {
+	Metrics *struct {
+		InputTokenCount  int `json:"inputTokenCount"`
+		OutputTokenCount int `json:"outputTokenCount"`
+	} `json:"amazon-bedrock-invocationMetrics"`
+}
+
+// streamBedrock sends body to the model and passes the text decoded from each
+// response chunk by chunkText to onToken. It returns the concatenated text
+// and the token usage.
+func streamBedrock(ctx context.Context, invoke BedrockInvokeModelStreamFunc, model string, body []byte, chunkText func([]byte) (string, error), onToken TokenFunc) (string, Usage, error) {
+	stream, err := invoke(ctx, &bedrockruntime.InvokeModelWithResponseStreamInput{
+		ModelId:     aws.String(model),
+		ContentType: aws.String("application/json"),
+		Body:        body,
+		Accept:      aws.String("application/json"),
+	})
+	if err != nil {
+		return "", Usage{}, bedrockInvokeError(ctx, err)
+	}
+	defer stream.Close()
+
+	var (
+		text  strings.Builder
+		usage Usage
+	)
+	for event := range stream.Events() {
+		chunk, ok := event.(*types.ResponseStreamMemberChunk)
+		if !ok {
+			continue
+		}
+		var metrics bedrockInvocationMetrics
+		if json.Unmarshal(chunk.Value.Bytes, &metrics) == nil && metrics.Metrics != nil {
+			usage = Usage{InputTokens: metrics.Metrics.InputTokenCount, OutputTokens: metrics.Metrics.OutputTokenCount}
+		}
+		t, err := chunkText(chunk.Value.Bytes)
+		if err != nil {
+			return "", usage, fmt.Errorf("failed to unmarshal Bedrock response chunk: %w", err)
+		}
+		if t == "" {
+			continue
+		}
+		onToken(t)
+		text.WriteString(t)
+	}
+	if err := stream.Err(); err != nil {
+		return "", usage, bedrockInvokeError(ctx, err)
+	}
+
+	return text.String(), usage, nil
+}
+
+// withUsage returns response with its Usage set.
+func withUsage(response Response, usage Usage) Response {
+	response.Usage = usage
+	return response
+}
+
+// bedrockInvokeError maps Bedrock errors onto the sentinel errors.
+func bedrockInvokeError(ctx context.Context, err error) error {
+	var retryAfter time.Duration
+	var responseErr *smithyhttp.ResponseError
+	if errors.As(err, &responseErr) && responseErr.Response != nil {
+		retryAfter = parseRetryAfter(responseErr.Response.Header.Get("Retry-After"), time.Now())
+	}
+	classified := func(kind error, err error) error {
+		return &ProviderError{Kind: kind, RetryAfter: retryAfter, Err: err}
 	}
+
+	var (
+		accessDenied     *types.AccessDeniedException
+		throttling       *types.ThrottlingException
+		quota            *types.ServiceQuotaExceededException
+		resourceNotFound *types.ResourceNotFoundException
+		modelTimeout     *types.ModelTimeoutException
+		apiErr           smithy.APIError
+	)
+	switch {
+	case errors.As(err, &accessDenied):
+		return classified(ErrAuth, fmt.Errorf("access denied to Bedrock API. Please check your AWS credentials and permissions: %w", err))
+	case errors.As(err, &apiErr) && (apiErr.ErrorCode() == "UnrecognizedClientException" || apiErr.ErrorCode() == "ExpiredTokenException"):
+		return classified(ErrAuth, fmt.Errorf("invalid or expired AWS credentials: %w", err))
+	case errors.As(err, &throttling):
+		return classified(ErrRateLimited, fmt.Errorf("failed to invoke Bedrock model: %w", err))
+	case errors.As(err, &quota):
+		return classified(ErrQuotaExceeded, fmt.Errorf("failed to invoke Bedrock model: %w", err))
+	case errors.As(err, &resourceNotFound):
+		return classified(ErrModelNotFound, fmt.Errorf("failed to invoke Bedrock model: %w", err))
+	case errors.As(err, &modelTimeout), errors.Is(err, context.DeadlineExceeded):
+		return classified(ErrTimeout, fmt.Errorf("failed to invoke Bedrock model: %w", err))
+	}
+	return fmt.Errorf("failed to invoke Bedrock model: %w", err)
 }
 
 // NovaLiteModel represents the amazon.nova-lite-v1:0 model.
 type NovaLiteModel struct {
-	InvokeModel func(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error)
-	Model       string
+	InvokeModel                   BedrockInvokeModelFunc
+	InvokeModelWithResponseStream BedrockInvokeModelStreamFunc
+	Model                         string
+	Templates                     *prompt.Templates
+	Params                        GenerationParams
 }
 
 type novaLiteResponse struct {
@@ -79,12 +270,22 @@ type novaLiteResponse struct {
 	} `json:"output"`
 }
 
-// GenerateCommand implements the BedrockModel interface for NovaLiteModel.
-func (c *NovaLiteModel) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt, shell string) (string, error) {
-	fullPrompt := fmt.Sprintf(`Given the following prompt, generate a single shell command. The command should be able to be executed on a %s machine in a %s shell. The command should be reasonable and not destructive. Return only the command, with no explanation or other text.
+type novaLiteChunk struct {
+	ContentBlockDelta struct {
+		Delta struct {
+			Text string `json:"text"`
+		} `json:"delta"`
+	} `json:"contentBlockDelta"`
+}
+
+func (c *NovaLiteModel) body(logger *slog.Logger, prompt string, env environment.Environment) ([]byte, error) {
+	fullPrompt, err := renderPrompt(logger, c.Templates, "bedrock-nova-lite", prompt, env)
+	if err != nil {
+		return nil, err
+	}
 
-Prompt: %s`, os.Getenv("GOOS"), shell, prompt)
-	logger.Debug("bedrock prompt", "prompt", fullPrompt)
+	inferenceConfig := map[string]any{"maxTokens": bedrockMaxTokens}
+	c.Params.set(inferenceConfig, paramNames{Temperature: "temperature", TopP: "topP", MaxTokens: "maxTokens", Stop: "stopSequences"})
 
 	body, err := json.Marshal(map[string]any{
 		"schemaVersion": "messages-v1",
@@ -93,47 +294,73 @@ Prompt: %s`, os.Getenv("GOOS"), shell, prompt)
 				map[string]any{"text": fullPrompt},
 			}},
 		},
-		"inferenceConfig": map[string]any{
-			"maxTokens": 200,
-		},
+		"inferenceConfig": inferenceConfig,
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal prompt: %w", err)
+		return nil, fmt.Errorf("failed to marshal prompt: %w", err)
 	}
+	return body, nil
+}
 
-	output, err := c.InvokeModel(ctx, &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String(c.Model),
-		ContentType: aws.String("application/json"),
-		Body:        body,
-		Accept:      aws.String("application/json"),
-	})
+// GenerateCommand implements the BedrockModel interface for NovaLiteModel.
+func (c *NovaLiteModel) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	body, err := c.body(logger, prompt, env)
 	if err != nil {
-		var ae *types.AccessDeniedException
-		if errors.As(err, &ae) {
-			return "", fmt.Errorf("access denied to Bedrock API. Please check your AWS credentials and permissions: %w", err)
-		}
-		return "", fmt.Errorf("failed to invoke Bedrock model: %w", err)
+		return Response{}, err
+	}
+
+	output, usage, err := invokeBedrock(ctx, c.InvokeModel, c.Model, body)
+	if err != nil {
+		return Response{}, err
 	}
 
 	var response novaLiteResponse
-	if err := json.Unmarshal(output.Body, &response); err != nil {
-		return "", fmt.Errorf("failed to unmarshal Bedrock response: %w", err)
+	if err := json.Unmarshal(output, &response); err != nil {
+		return Response{}, fmt.Errorf("failed to unmarshal Bedrock response: %w", err)
 	}
 
 	if len(response.Output.Message.Content) == 0 {
-		return "", fmt.Errorf("bedrock response did not contain any content")
+		return Response{}, providerError(ctx, ErrEmptyResponse, errBedrockNoContent)
 	}
 
 	text := response.Output.Message.Content[0].Text
 
 	logger.Debug("bedrock response", "response", text)
-	return text, nil
+	return withUsage(parseResponseOrText(text), usage), nil
+}
+
+// StreamCommand implements the BedrockModel interface for NovaLiteModel.
+func (c *NovaLiteModel) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	body, err := c.body(logger, prompt, env)
+	if err != nil {
+		return Response{}, err
+	}
+
+	content := newJSONFieldStream("command", onToken)
+	text, usage, err := streamBedrock(ctx, c.InvokeModelWithResponseStream, c.Model, body, func(b []byte) (string, error) {
+		var chunk novaLiteChunk
+		err := json.Unmarshal(b, &chunk)
+		return chunk.ContentBlockDelta.Delta.Text, err
+	}, content.Write)
+	if err != nil {
+		return Response{}, err
+	}
+
+	if text == "" {
+		return Response{}, providerError(ctx, ErrEmptyResponse, errBedrockNoContent)
+	}
+
+	logger.Debug("bedrock response", "response", text)
+	return withUsage(parseResponseOrText(text), usage), nil
 }
 
 // TitanLiteModel represents the amazon.titan-text-lite-v1 model.
 type TitanLiteModel struct {
-	InvokeModel func(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error)
-	Model       string
+	InvokeModel                   BedrockInvokeModelFunc
+	InvokeModelWithResponseStream BedrockInvokeModelStreamFunc
+	Model                         string
+	Templates                     *prompt.Templates
+	Params                        GenerationParams
 }
 
 type titanLiteResponse struct {
@@ -142,61 +369,88 @@ type titanLiteResponse struct {
 	} `json:"results"`
 }
 
-// GenerateCommand implements the BedrockModel interface for TitanLiteModel.
-// GenerateCommand implements the BedrockModel interface for TitanLiteModel.
-func (c *TitanLiteModel) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt, shell string) (string, error) {
-	fullPrompt := fmt.Sprintf(`System: You are a helpful assistant that generates shell commands. The user will provide a prompt and you will generate a single shell command that can be executed on a %s machine in a %s shell. The command should be reasonable and not destructive. Return only the command, with no explanation or other text.
+type titanLiteChunk struct {
+	OutputText string `json:"outputText"`
+}
 
-User: list all files in the current directory
-Assistant: ls -l
+func (c *TitanLiteModel) body(logger *slog.Logger, prompt string, env environment.Environment) ([]byte, error) {
+	fullPrompt, err := renderPrompt(logger, c.Templates, "bedrock-titan-lite", prompt, env)
+	if err != nil {
+		return nil, err
+	}
 
-User: %s
-Assistant:`, os.Getenv("GOOS"), shell, prompt)
-	logger.Debug("bedrock prompt", "prompt", fullPrompt)
+	config := map[string]any{"maxTokenCount": bedrockMaxTokens}
+	c.Params.set(config, paramNames{Temperature: "temperature", TopP: "topP", MaxTokens: "maxTokenCount", Stop: "stopSequences"})
 
 	body, err := json.Marshal(map[string]any{
-		"inputText": fullPrompt,
-		"textGenerationConfig": map[string]any{
-			"maxTokenCount": 200,
-		},
+		"inputText":            fullPrompt,
+		"textGenerationConfig": config,
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal prompt: %w", err)
+		return nil, fmt.Errorf("failed to marshal prompt: %w", err)
 	}
+	return body, nil
+}
 
-	output, err := c.InvokeModel(ctx, &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String(c.Model),
-		ContentType: aws.String("application/json"),
-		Body:        body,
-		Accept:      aws.String("application/json"),
-	})
+// GenerateCommand implements the BedrockModel interface for TitanLiteModel.
+func (c *TitanLiteModel) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	body, err := c.body(logger, prompt, env)
 	if err != nil {
-		var ae *types.AccessDeniedException
-		if errors.As(err, &ae) {
-			return "", fmt.Errorf("access denied to Bedrock API. Please check your AWS credentials and permissions: %w", err)
-		}
-		return "", fmt.Errorf("failed to invoke Bedrock model: %w", err)
+		return Response{}, err
+	}
+
+	output, usage, err := invokeBedrock(ctx, c.InvokeModel, c.Model, body)
+	if err != nil {
+		return Response{}, err
 	}
 
 	var response titanLiteResponse
-	if err := json.Unmarshal(output.Body, &response); err != nil {
-		return "", fmt.Errorf("failed to unmarshal Bedrock response: %w", err)
+	if err := json.Unmarshal(output, &response); err != nil {
+		return Response{}, fmt.Errorf("failed to unmarshal Bedrock response: %w", err)
 	}
 
 	if len(response.Results) == 0 {
-		return "", fmt.Errorf("bedrock response did not contain any results")
+		return Response{}, fmt.Errorf("bedrock response did not contain any results")
 	}
 
 	text := response.Results[0].OutputText
 
 	logger.Debug("bedrock response", "response", text)
-	return text, nil
+	return withUsage(parseResponseOrText(text), usage), nil
+}
+
+// StreamCommand implements the BedrockModel interface for TitanLiteModel.
+func (c *TitanLiteModel) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	body, err := c.body(logger, prompt, env)
+	if err != nil {
+		return Response{}, err
+	}
+
+	content := newJSONFieldStream("command", onToken)
+	text, usage, err := streamBedrock(ctx, c.InvokeModelWithResponseStream, c.Model, body, func(b []byte) (string, error) {
+		var chunk titanLiteChunk
+		err := json.Unmarshal(b, &chunk)
+		return chunk.OutputText, err
+	}, content.Write)
+	if err != nil {
+		return Response{}, err
+	}
+
+	if text == "" {
+		return Response{}, fmt.Errorf("bedrock response did not contain any results")
+	}
+
+	logger.Debug("bedrock response", "response", text)
+	return withUsage(parseResponseOrText(text), usage), nil
 }
 
 // OpenAIGPTOSSModel represents the openai.gpt-oss-120b-1:0 model.
 type OpenAIGPTOSSModel struct {
-	InvokeModel func(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error)
-	Model       string
+	InvokeModel                   BedrockInvokeModelFunc
+	InvokeModelWithResponseStream BedrockInvokeModelStreamFunc
+	Model                         string
+	Templates                     *prompt.Templates
+	Params                        GenerationParams
 }
 
 type openAIChatResponse struct {
@@ -208,18 +462,29 @@ type openAIChatResponse struct {
 	} `json:"choices"`
 }
 
-// GenerateCommand implements the BedrockModel interface for OpenAIGPTOSSModel.
-func (c *OpenAIGPTOSSModel) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt, shell string) (string, error) {
-	fullPrompt := fmt.Sprintf(`Given the following prompt, generate a single shell command. The command should be able to be executed on a %s machine in a %s shell. The command should be reasonable and not destructive. Return only the command, with no explanation or other text.
+type openAIChatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
 
-Prompt: %s`, os.Getenv("GOOS"), shell, prompt)
-	logger.Debug("bedrock prompt", "prompt", fullPrompt)
+func (c *OpenAIGPTOSSModel) body(logger *slog.Logger, prompt string, env environment.Environment) ([]byte, error) {
+	system, err := renderPrompt(logger, c.Templates, "bedrock-gpt-oss-system", prompt, env)
+	if err != nil {
+		return nil, err
+	}
+	fullPrompt, err := renderPrompt(logger, c.Templates, "bedrock-gpt-oss", prompt, env)
+	if err != nil {
+		return nil, err
+	}
 
-	body, err := json.Marshal(map[string]any{
+	request := map[string]any{
 		"messages": []any{
 			map[string]any{
 				"role":    "system",
-				"content": "You are a shell command generator. Return only the final shell command. Do not include any explanations, chain-of-thought, or tags such as <reasoning>. Do not wrap the command in quotes or backticks.",
+				"content": system,
 			},
 			map[string]any{
 				"role":    "user",
@@ -227,7 +492,7 @@ Prompt: %s`, os.Getenv("GOOS"), shell, prompt)
 			},
 			map[string]any{
 				"role":    "assistant",
-				"content": "ls -A",
+				"content": `{"command": "ls -A", "explanation": "Lists all files in the current directory, including hidden ones.", "risk": "low", "requires_sudo": false}`,
 			},
 			map[string]any{
 				"role":    "user",
@@ -235,62 +500,98 @@ Prompt: %s`, os.Getenv("GOOS"), shell, prompt)
 			},
 		},
 		"temperature":           0,
-		"max_completion_tokens": 200,
-	})
+		"max_completion_tokens": bedrockMaxTokens,
+	}
+	c.Params.set(request, paramNames{Temperature: "temperature", TopP: "top_p", MaxTokens: "max_completion_tokens", Stop: "stop"})
+
+	body, err := json.Marshal(request)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal prompt: %w", err)
+		return nil, fmt.Errorf("failed to marshal prompt: %w", err)
 	}
+	return body, nil
+}
 
-	output, err := c.InvokeModel(ctx, &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String(c.Model),
-		ContentType: aws.String("application/json"),
-		Body:        body,
-		Accept:      aws.String("application/json"),
-	})
+// GenerateCommand implements the BedrockModel interface for OpenAIGPTOSSModel.
+func (c *OpenAIGPTOSSModel) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	body, err := c.body(logger, prompt, env)
 	if err != nil {
-		var ae *types.AccessDeniedException
-		if errors.As(err, &ae) {
-			return "", fmt.Errorf("access denied to Bedrock API. Please check your AWS credentials and permissions: %w", err)
-		}
-		return "", fmt.Errorf("failed to invoke Bedrock model: %w", err)
+		return Response{}, err
+	}
+
+	output, usage, err := invokeBedrock(ctx, c.InvokeModel, c.Model, body)
+	if err != nil {
+		return Response{}, err
 	}
 
 	var response openAIChatResponse
-	if err := json.Unmarshal(output.Body, &response); err != nil {
-		return "", fmt.Errorf("failed to unmarshal Bedrock response: %w", err)
+	if err := json.Unmarshal(output, &response); err != nil {
+		return Response{}, fmt.Errorf("failed to unmarshal Bedrock response: %w", err)
 	}
 
 	if len(response.Choices) == 0 {
-		return "", fmt.Errorf("bedrock response did not contain any choices")
+		return Response{}, fmt.Errorf("bedrock response did not contain any choices")
 	}
 
 	text := response.Choices[0].Message.Content
 
 	logger.Debug("bedrock response", "response", text)
-	return strings.TrimSpace(regexp.MustCompile("(?s)<reasoning>.*?</reasoning>").ReplaceAllString(text, "")), nil
+	return withUsage(parseResponseOrText(text), usage), nil
+}
+
+// StreamCommand implements the BedrockModel interface for OpenAIGPTOSSModel.
+// Streamed tokens may include reasoning tags; they are only stripped from the
+// returned command.
+func (c *OpenAIGPTOSSModel) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	body, err := c.body(logger, prompt, env)
+	if err != nil {
+		return Response{}, err
+	}
+
+	content := newJSONFieldStream("command", onToken)
+	text, usage, err := streamBedrock(ctx, c.InvokeModelWithResponseStream, c.Model, body, func(b []byte) (string, error) {
+		var chunk openAIChatChunk
+		if err := json.Unmarshal(b, &chunk); err != nil || len(chunk.Choices) == 0 {
+			return "", err
+		}
+		return chunk.Choices[0].Delta.Content, nil
+	}, content.Write)
+	if err != nil {
+		return Response{}, err
+	}
+
+	if text == "" {
+		return Response{}, fmt.Errorf("bedrock response did not contain any choices")
+	}
+
+	logger.Debug("bedrock response", "response", text)
+	return withUsage(parseResponseOrText(text), usage), nil
 }
 
 // AnthropicSonnet4Model represents the anthropic.claude-sonnet-4-20250514-v1:0 model.
+// It returns structured output by forcing a call to the shell_command tool.
 type AnthropicSonnet4Model struct {
-	InvokeModel func(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error)
-	Model       string
+	InvokeModel                   BedrockInvokeModelFunc
+	InvokeModelWithResponseStream BedrockInvokeModelStreamFunc
+	Model                         string
+	Templates                     *prompt.Templates
+	Params                        GenerationParams
 }
 
 type anthropicMessageResponse struct {
 	Content []struct {
-		Type string `json:"type"`
-		Text string `json:"text"`
+		Type  string          `json:"type"`
+		Text  string          `json:"text"`
+		Input json.RawMessage `json:"input"`
 	} `json:"content"`
 }
 
-// GenerateCommand implements the BedrockModel interface for AnthropicSonnet4Model.
-func (c *AnthropicSonnet4Model) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt, shell string) (string, error) {
-	fullPrompt := fmt.Sprintf(`Given the following prompt, generate a single shell command. The command should be able to be executed on a %s machine in a %s shell. The command should be reasonable and not destructive. Return only the command, with no explanation or other text.
-
-Prompt: %s`, os.Getenv("GOOS"), shell, prompt)
-	logger.Debug("bedrock prompt", "prompt", fullPrompt)
+func (c *AnthropicSonnet4Model) body(logger *slog.Logger, prompt string, env environment.Environment) ([]byte, error) {
+	fullPrompt, err := renderPrompt(logger, c.Templates, "bedrock-claude-sonnet-4", prompt, env)
+	if err != nil {
+		return nil, err
+	}
 
-	body, err := json.Marshal(map[string]any{
+	request := map[string]any{
 		"anthropic_version": "bedrock-2023-05-31",
 		"messages": []any{
 			map[string]any{
@@ -300,37 +601,76 @@ Prompt: %s`, os.Getenv("GOOS"), shell, prompt)
 				},
 			},
 		},
-		"max_tokens": 200,
-	})
+		"max_tokens": bedrockMaxTokens,
+	}
+	anthropicResponseTool(request)
+	c.Params.set(request, paramNames{Temperature: "temperature", TopP: "top_p", MaxTokens: "max_tokens", Stop: "stop_sequences"})
+
+	body, err := json.Marshal(request)
 	if err != nil {
-		return "", fmt.Errorf("failed to marshal prompt: %w", err)
+		return nil, fmt.Errorf("failed to marshal prompt: %w", err)
 	}
+	return body, nil
+}
 
-	output, err := c.InvokeModel(ctx, &bedrockruntime.InvokeModelInput{
-		ModelId:     aws.String(c.Model),
-		ContentType: aws.String("application/json"),
-		Body:        body,
-		Accept:      aws.String("application/json"),
-	})
+// GenerateCommand implements the BedrockModel interface for AnthropicSonnet4Model.
+func (c *AnthropicSonnet4Model) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	body, err := c.body(logger, prompt, env)
 	if err != nil {
-		var ae *types.AccessDeniedException
-		if errors.As(err, &ae) {
-			return "", fmt.Errorf("access denied to Bedrock API. Please check your AWS credentials and permissions: %w", err)
-		}
-		return "", fmt.Errorf("failed to invoke Bedrock model: %w", err)
+		return Response{}, err
+	}
+
+	output, usage, err := invokeBedrock(ctx, c.InvokeModel, c.Model, body)
+	if err != nil {
+		return Response{}, err
 	}
 
 	var response anthropicMessageResponse
-	if err := json.Unmarshal(output.Body, &response); err != nil {
-		return "", fmt.Errorf("failed to unmarshal Bedrock response: %w", err)
+	if err := json.Unmarshal(output, &response); err != nil {
+		return Response{}, fmt.Errorf("failed to unmarshal Bedrock response: %w", err)
+	}
+
+	for _, content := range response.Content {
+		if content.Type == "tool_use" {
+			logger.Debug("bedrock response", "response", string(content.Input))
+			response, err := parseResponse(string(content.Input))
+			return withUsage(response, usage), err
+		}
 	}
 
 	if len(response.Content) == 0 {
-		return "", fmt.Errorf("bedrock response did not contain any content")
+		return Response{}, providerError(ctx, ErrEmptyResponse, errBedrockNoContent)
 	}
 
 	text := response.Content[0].Text
 
 	logger.Debug("bedrock response", "response", text)
-	return text, nil
+	return withUsage(parseResponseOrText(text), usage), nil
+}
+
+// StreamCommand implements the BedrockModel interface for AnthropicSonnet4Model.
+func (c *AnthropicSonnet4Model) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	body, err := c.body(logger, prompt, env)
+	if err != nil {
+		return Response{}, err
+	}
+
+	content := newJSONFieldStream("command", onToken)
+	text, usage, err := streamBedrock(ctx, c.InvokeModelWithResponseStream, c.Model, body, func(b []byte) (string, error) {
+		var chunk anthropicMessageChunk
+		if err := json.Unmarshal(b, &chunk); err != nil || chunk.Type != "content_block_delta" {
+			return "", err
+		}
+		return chunk.Delta.Text + chunk.Delta.PartialJSON, nil
+	}, content.Write)
+	if err != nil {
+		return Response{}, err
+	}
+
+	if text == "" {
+		return Response{}, providerError(ctx, ErrEmptyResponse, errBedrockNoContent)
+	}
+
+	logger.Debug("bedrock response", "response", text)
+	return withUsage(parseResponseOrText(text), usage), nil
 }
@@ -0,0 +1,231 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm/prompt"
+)
+
+// BedrockConverseFunc sends a conversation to a Bedrock model through the
+// Converse API.
+type BedrockConverseFunc func(ctx context.Context, params *bedrockruntime.ConverseInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.ConverseOutput, error)
+
+// BedrockConverseStreamFunc sends a conversation to a Bedrock model through
+// the ConverseStream API and returns a reader for the streamed events.
+type BedrockConverseStreamFunc func(ctx context.Context, params *bedrockruntime.ConverseStreamInput) (bedrockruntime.ConverseStreamOutputReader, error)
+
+// ConverseModel is a BedrockModel for any model that supports the Bedrock
+// Converse API. Bedrock translates the request into the model's native
+// format, so no per-model code is needed. The model is made to answer by
+// calling a tool whose input schema is the response schema, and a text
+// answer is only used when it does not. Models that reject the forced tool
+// choice, such as Llama, Mistral Small and Cohere Command, are asked again
+// without the tool and answer with text.
+type ConverseModel struct {
+	Converse       BedrockConverseFunc
+	ConverseStream BedrockConverseStreamFunc
+	Model          string
+	Templates      *prompt.Templates
+	Params         GenerationParams
+}
+
+func (c *ConverseModel) messages(logger *slog.Logger, prompt string, env environment.Environment) ([]types.Message, error) {
+	fullPrompt, err := renderPrompt(logger, c.Templates, "bedrock-converse", prompt, env)
+	if err != nil {
+		return nil, err
+	}
+
+	return []types.Message{
+		{
+			Role:    types.ConversationRoleUser,
+			Content: []types.ContentBlock{&types.ContentBlockMemberText{Value: fullPrompt}},
+		},
+	}, nil
+}
+
+func (c *ConverseModel) inferenceConfig() *types.InferenceConfiguration {
+	return &types.InferenceConfiguration{
+		MaxTokens:     aws.Int32(int32(c.Params.maxTokens(bedrockMaxTokens))),
+		Temperature:   c.Params.Temperature,
+		TopP:          c.Params.TopP,
+		StopSequences: c.Params.Stop,
+	}
+}
+
+// converseToolConfig makes the model answer by calling the response tool.
+func converseToolConfig() *types.ToolConfiguration {
+	var schema map[string]any
+	if err := json.Unmarshal([]byte(responseSchema), &schema); err != nil {
+		panic(err)
+	}
+	return &types.ToolConfiguration{
+		Tools: []types.Tool{
+			&types.ToolMemberToolSpec{Value: types.ToolSpecification{
+				Name:        aws.String(responseToolName),
+				Description: aws.String(responseToolDescription),
+				InputSchema: &types.ToolInputSchemaMemberJson{Value: document.NewLazyDocument(schema)},
+			}},
+		},
+		ToolChoice: &types.ToolChoiceMemberTool{Value: types.SpecificToolChoice{Name: aws.String(responseToolName)}},
+	}
+}
+
+// toolsRejected reports whether Bedrock rejected a request, which it does
+// when the model does not support tool use or a forced tool choice.
+func toolsRejected(err error) bool {
+	var validation *types.ValidationException
+	return errors.As(err, &validation)
+}
+
+// converseStopError returns ErrContentFiltered when the model stopped because
+// its answer was filtered or a guardrail intervened.
+func converseStopError(ctx context.Context, reason types.StopReason) error {
+	switch reason {
+	case types.StopReasonContentFiltered, types.StopReasonGuardrailIntervened:
+		return providerError(ctx, ErrContentFiltered, fmt.Errorf("bedrock stopped the response: %s", reason))
+	}
+	return nil
+}
+
+// GenerateCommand implements the BedrockModel interface for ConverseModel.
+func (c *ConverseModel) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	messages, err := c.messages(logger, prompt, env)
+	if err != nil {
+		return Response{}, err
+	}
+
+	input := &bedrockruntime.ConverseInput{
+		ModelId:         aws.String(c.Model),
+		Messages:        messages,
+		InferenceConfig: c.inferenceConfig(),
+		ToolConfig:      converseToolConfig(),
+	}
+	output, err := c.Converse(ctx, input)
+	if toolsRejected(err) {
+		logger.Debug("bedrock rejected the response tool, asking without it", "error", err)
+		input.ToolConfig = nil
+		output, err = c.Converse(ctx, input)
+	}
+	if err != nil {
+		return Response{}, bedrockInvokeError(ctx, err)
+	}
+
+	if err := converseStopError(ctx, output.StopReason); err != nil {
+		return Response{}, err
+	}
+
+	message, ok := output.Output.(*types.ConverseOutputMemberMessage)
+	if !ok {
+		return Response{}, providerError(ctx, ErrEmptyResponse, errBedrockNoContent)
+	}
+
+	var text strings.Builder
+	for _, block := range message.Value.Content {
+		switch b := block.(type) {
+		case *types.ContentBlockMemberToolUse:
+			input, err := b.Value.Input.MarshalSmithyDocument()
+			if err != nil {
+				return Response{}, fmt.Errorf("failed to read the Bedrock tool use: %w", err)
+			}
+			logger.Debug("bedrock response", "response", string(input))
+			response, err := parseResponse(string(input))
+			response.Usage = converseUsage(output.Usage)
+			return response, err
+		case *types.ContentBlockMemberText:
+			text.WriteString(b.Value)
+		}
+	}
+	if text.Len() == 0 {
+		return Response{}, providerError(ctx, ErrEmptyResponse, errBedrockNoContent)
+	}
+
+	logger.Debug("bedrock response", "response", text.String())
+	response := parseResponseOrText(text.String())
+	response.Usage = converseUsage(output.Usage)
+	return response, nil
+}
+
+// StreamCommand implements the BedrockModel interface for ConverseModel.
+func (c *ConverseModel) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	messages, err := c.messages(logger, prompt, env)
+	if err != nil {
+		return Response{}, err
+	}
+
+	input := &bedrockruntime.ConverseStreamInput{
+		ModelId:         aws.String(c.Model),
+		Messages:        messages,
+		InferenceConfig: c.inferenceConfig(),
+		ToolConfig:      converseToolConfig(),
+	}
+	stream, err := c.ConverseStream(ctx, input)
+	if toolsRejected(err) {
+		logger.Debug("bedrock rejected the response tool, asking without it", "error", err)
+		input.ToolConfig = nil
+		stream, err = c.ConverseStream(ctx, input)
+	}
+	if err != nil {
+		return Response{}, bedrockInvokeError(ctx, err)
+	}
+	defer stream.Close()
+
+	content := newJSONFieldStream("command", onToken)
+	var (
+		stopReason types.StopReason
+		usage      Usage
+	)
+	for event := range stream.Events() {
+		switch e := event.(type) {
+		case *types.ConverseStreamOutputMemberContentBlockDelta:
+			// The answer is the input of the tool use, or the text of a
+			// model that did not use it. Reasoning is not part of it.
+			switch d := e.Value.Delta.(type) {
+			case *types.ContentBlockDeltaMemberToolUse:
+				content.Write(aws.ToString(d.Value.Input))
+			case *types.ContentBlockDeltaMemberText:
+				content.Write(d.Value)
+			}
+		case *types.ConverseStreamOutputMemberMessageStop:
+			stopReason = e.Value.StopReason
+		case *types.ConverseStreamOutputMemberMetadata:
+			usage = converseUsage(e.Value.Usage)
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return Response{}, bedrockInvokeError(ctx, err)
+	}
+	if err := converseStopError(ctx, stopReason); err != nil {
+		return Response{}, err
+	}
+
+	text := content.String()
+	if text == "" {
+		return Response{}, providerError(ctx, ErrEmptyResponse, errBedrockNoContent)
+	}
+
+	logger.Debug("bedrock response", "response", text)
+	response := parseResponseOrText(text)
+	response.Usage = usage
+	return response, nil
+}
+
+func converseUsage(usage *types.TokenUsage) Usage {
+	if usage == nil {
+		return Usage{}
+	}
+	return Usage{
+		InputTokens:  int(aws.ToInt32(usage.InputTokens)),
+		OutputTokens: int(aws.ToInt32(usage.OutputTokens)),
+	}
+}
@@ -0,0 +1,351 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/document"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/llm"
+)
+
+type fakeConverseStream struct {
+	events chan types.ConverseStreamOutput
+	err    error
+}
+
+func newFakeConverseStream(events ...types.ConverseStreamOutput) *fakeConverseStream {
+	ch := make(chan types.ConverseStreamOutput, len(events))
+	for _, event := range events {
+		ch <- event
+	}
+	close(ch)
+	return &fakeConverseStream{events: ch}
+}
+
+func (s *fakeConverseStream) Events() <-chan types.ConverseStreamOutput { return s.events }
+func (s *fakeConverseStream) Close() error                              { return nil }
+func (s *fakeConverseStream) Err() error                                { return s.err }
+
+func converseDelta(delta types.ContentBlockDelta) types.ConverseStreamOutput {
+	return &types.ConverseStreamOutputMemberContentBlockDelta{
+		Value: types.ContentBlockDeltaEvent{Delta: delta},
+	}
+}
+
+var _ = Describe("ConverseModel", func() {
+	var (
+		model  *llm.ConverseModel
+		logger *slog.Logger
+		input  *bedrockruntime.ConverseInput
+		output *bedrockruntime.ConverseOutput
+		err    error
+	)
+
+	BeforeEach(func() {
+		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+		err = nil
+		output = &bedrockruntime.ConverseOutput{
+			Output: &types.ConverseOutputMemberMessage{Value: types.Message{
+				Role: types.ConversationRoleAssistant,
+				Content: []types.ContentBlock{
+					&types.ContentBlockMemberReasoningContent{},
+					&types.ContentBlockMemberText{Value: `{"command": "ls -l", "explanation": "Lists files.", "risk": "low", "requires_sudo": false}`},
+				},
+			}},
+		}
+		model = &llm.ConverseModel{
+			Converse: func(ctx context.Context, params *bedrockruntime.ConverseInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.ConverseOutput, error) {
+				input = params
+				return output, err
+			},
+			Model: "mistral.mistral-large-2407-v1:0",
+		}
+	})
+
+	Describe("GenerateCommand", func() {
+		Context("the request", func() {
+			var sent string
+
+			JustBeforeEach(func() {
+				_, err := model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(err).ToNot(HaveOccurred())
+				sent = input.Messages[0].Content[0].(*types.ContentBlockMemberText).Value
+			})
+
+			It("is sent to the configured model", func() {
+				Expect(*input.ModelId).To(Equal("mistral.mistral-large-2407-v1:0"))
+			})
+
+			It("has one message", func() {
+				Expect(input.Messages).To(HaveLen(1))
+			})
+
+			It("is sent as the user", func() {
+				Expect(input.Messages[0].Role).To(Equal(types.ConversationRoleUser))
+			})
+
+			It("contains the prompt", func() {
+				Expect(sent).To(ContainSubstring("list files"))
+			})
+
+			It("contains the shell", func() {
+				Expect(sent).To(ContainSubstring("bash"))
+			})
+		})
+
+		It("asks for a short answer", func() {
+			_, err := model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+			Expect(input.InferenceConfig, err).To(Equal(&types.InferenceConfiguration{MaxTokens: aws.Int32(200)}))
+		})
+
+		When("generation params are set", func() {
+			BeforeEach(func() {
+				model.Params = llm.GenerationParams{Temperature: aws.Float32(0), TopP: aws.Float32(0.9), MaxTokens: 4000, Stop: []string{"END"}}
+			})
+
+			It("sets them on the inference config", func() {
+				_, err := model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(input.InferenceConfig, err).To(Equal(&types.InferenceConfiguration{Temperature: aws.Float32(0), TopP: aws.Float32(0.9), MaxTokens: aws.Int32(4000), StopSequences: []string{"END"}}))
+			})
+		})
+
+		It("forces the model to use the response tool", func() {
+			_, err := model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+			Expect(input.ToolConfig.ToolChoice, err).To(Equal(&types.ToolChoiceMemberTool{Value: types.SpecificToolChoice{Name: aws.String("shell_command")}}))
+		})
+
+		It("gives the tool the response schema", func() {
+			model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+			schema, err := input.ToolConfig.Tools[0].(*types.ToolMemberToolSpec).Value.InputSchema.(*types.ToolInputSchemaMemberJson).Value.MarshalSmithyDocument()
+			Expect(string(schema), err).To(ContainSubstring(`"required":["command","explanation","risk","requires_sudo"]`))
+		})
+
+		When("the model uses the tool", func() {
+			BeforeEach(func() {
+				output.Output = &types.ConverseOutputMemberMessage{Value: types.Message{
+					Content: []types.ContentBlock{&types.ContentBlockMemberToolUse{Value: types.ToolUseBlock{
+						Name:  aws.String("shell_command"),
+						Input: document.NewLazyDocument(map[string]any{"command": "ls -l", "explanation": "Lists files.", "risk": "low", "requires_sudo": false}),
+					}}},
+				}}
+			})
+
+			It("returns the response from the tool input", func() {
+				Expect(model.GenerateCommand(context.Background(), logger, "list files", testEnv)).To(Equal(llm.Response{Command: "ls -l", Explanation: "Lists files.", Risk: llm.RiskLow}))
+			})
+		})
+
+		It("returns the response from the text content", func() {
+			response, err := model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+			Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Explanation: "Lists files.", Risk: llm.RiskLow}))
+		})
+
+		When("Bedrock reports usage", func() {
+			BeforeEach(func() {
+				output.Usage = &types.TokenUsage{InputTokens: aws.Int32(330), OutputTokens: aws.Int32(21)}
+			})
+
+			It("returns the token usage", func() {
+				response, err := model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(response.Usage, err).To(Equal(llm.Usage{InputTokens: 330, OutputTokens: 21}))
+			})
+		})
+
+		When("the model answers with the bare command", func() {
+			BeforeEach(func() {
+				output.Output = &types.ConverseOutputMemberMessage{Value: types.Message{
+					Content: []types.ContentBlock{&types.ContentBlockMemberText{Value: "ls -l\n"}},
+				}}
+			})
+
+			It("uses the text as the command", func() {
+				Expect(model.GenerateCommand(context.Background(), logger, "list files", testEnv)).To(Equal(llm.Response{Command: "ls -l"}))
+			})
+		})
+
+		When("the response has no text", func() {
+			BeforeEach(func() {
+				output.Output = &types.ConverseOutputMemberMessage{}
+			})
+
+			It("returns an error", func() {
+				_, err := model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(err).To(MatchError(llm.ErrEmptyResponse))
+			})
+		})
+
+		When("the request is throttled", func() {
+			BeforeEach(func() {
+				err = &types.ThrottlingException{Message: new(string)}
+			})
+
+			It("returns a rate limited error", func() {
+				_, err := model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(err).To(MatchError(llm.ErrRateLimited))
+			})
+		})
+
+		When("a guardrail intervenes", func() {
+			BeforeEach(func() {
+				output.StopReason = types.StopReasonGuardrailIntervened
+			})
+
+			It("returns a content filtered error", func() {
+				_, err := model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(err).To(MatchError(llm.ErrContentFiltered))
+			})
+		})
+
+		When("access is denied", func() {
+			BeforeEach(func() {
+				err = &types.AccessDeniedException{Message: new(string)}
+			})
+
+			It("returns an access denied error", func() {
+				_, err := model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(err).To(MatchError(ContainSubstring("access denied to Bedrock API")))
+			})
+		})
+
+		When("the model does not support a forced tool choice", func() {
+			var (
+				calls    int
+				response llm.Response
+			)
+
+			BeforeEach(func() {
+				calls = 0
+				model.Converse = func(ctx context.Context, params *bedrockruntime.ConverseInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.ConverseOutput, error) {
+					calls++
+					input = params
+					if params.ToolConfig != nil {
+						return nil, &types.ValidationException{Message: aws.String("This model doesn't support the toolConfig.toolChoice.tool field.")}
+					}
+					return output, nil
+				}
+			})
+
+			JustBeforeEach(func() {
+				response, err = model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+			})
+
+			It("returns the response from the text content", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Explanation: "Lists files.", Risk: llm.RiskLow}))
+			})
+
+			It("asks again", func() {
+				Expect(calls).To(Equal(2))
+			})
+
+			It("asks again without the tool", func() {
+				Expect(input.ToolConfig).To(BeNil())
+			})
+		})
+
+		When("the request is rejected without the tool too", func() {
+			BeforeEach(func() {
+				err = &types.ValidationException{Message: aws.String("Malformed input request")}
+			})
+
+			It("returns the error", func() {
+				_, err := model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(err).To(MatchError(ContainSubstring("Malformed input request")))
+			})
+		})
+	})
+
+	Describe("StreamCommand", func() {
+		var (
+			stream *fakeConverseStream
+			tokens []string
+		)
+
+		BeforeEach(func() {
+			tokens = nil
+			stream = newFakeConverseStream(
+				&types.ConverseStreamOutputMemberMessageStart{},
+				converseDelta(&types.ContentBlockDeltaMemberReasoningContent{Value: &types.ReasoningContentBlockDeltaMemberText{Value: "thinking"}}),
+				converseDelta(&types.ContentBlockDeltaMemberText{Value: `{"command": "ls`}),
+				converseDelta(&types.ContentBlockDeltaMemberText{Value: ` -l", "risk": "low"}`}),
+				&types.ConverseStreamOutputMemberMessageStop{},
+			)
+			model.ConverseStream = func(ctx context.Context, params *bedrockruntime.ConverseStreamInput) (bedrockruntime.ConverseStreamOutputReader, error) {
+				return stream, nil
+			}
+		})
+
+		Context("with text deltas", func() {
+			var response llm.Response
+
+			JustBeforeEach(func() {
+				response, err = model.StreamCommand(context.Background(), logger, "list files", testEnv, func(token string) {
+					tokens = append(tokens, token)
+				})
+			})
+
+			It("streams the command from them", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Risk: llm.RiskLow}))
+			})
+
+			It("passes the command to the token func as it arrives", func() {
+				Expect(tokens).To(Equal([]string{"ls", " -l"}))
+			})
+		})
+
+		When("the model uses the tool", func() {
+			BeforeEach(func() {
+				stream = newFakeConverseStream(
+					converseDelta(&types.ContentBlockDeltaMemberToolUse{Value: types.ToolUseBlockDelta{Input: aws.String(`{"command": "ls`)}}),
+					converseDelta(&types.ContentBlockDeltaMemberToolUse{Value: types.ToolUseBlockDelta{Input: aws.String(` -l", "risk": "low"}`)}}),
+					&types.ConverseStreamOutputMemberMessageStop{Value: types.MessageStopEvent{StopReason: types.StopReasonToolUse}},
+				)
+			})
+
+			It("streams the command from the tool input", func() {
+				Expect(model.StreamCommand(context.Background(), logger, "list files", testEnv, func(string) {})).To(Equal(llm.Response{Command: "ls -l", Risk: llm.RiskLow}))
+			})
+		})
+
+		When("the model does not support a forced tool choice", func() {
+			var sent *bedrockruntime.ConverseStreamInput
+
+			BeforeEach(func() {
+				model.ConverseStream = func(ctx context.Context, params *bedrockruntime.ConverseStreamInput) (bedrockruntime.ConverseStreamOutputReader, error) {
+					sent = params
+					if params.ToolConfig != nil {
+						return nil, &types.ValidationException{Message: aws.String("This model doesn't support tool use.")}
+					}
+					return stream, nil
+				}
+			})
+
+			It("streams the command from the text", func() {
+				Expect(model.StreamCommand(context.Background(), logger, "list files", testEnv, func(string) {})).To(Equal(llm.Response{Command: "ls -l", Risk: llm.RiskLow}))
+			})
+
+			It("asks again without the tool", func() {
+				model.StreamCommand(context.Background(), logger, "list files", testEnv, func(string) {})
+				Expect(sent.ToolConfig).To(BeNil())
+			})
+		})
+
+		When("the stream fails", func() {
+			BeforeEach(func() {
+				stream.err = errors.New("stream error")
+			})
+
+			It("returns an error", func() {
+				_, err := model.StreamCommand(context.Background(), logger, "list files", testEnv, func(string) {})
+				Expect(err).To(MatchError("failed to invoke Bedrock model: stream error"))
+			})
+		})
+	})
+})
@@ -3,17 +3,38 @@ package llm_test
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"log/slog"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
+	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/zombor/gen/environment"
 	"github.com/zombor/gen/llm"
 )
 
+type fakeBedrockStream struct {
+	events chan types.ResponseStream
+	err    error
+}
+
+func newFakeBedrockStream(chunks ...string) *fakeBedrockStream {
+	events := make(chan types.ResponseStream, len(chunks))
+	for _, chunk := range chunks {
+		events <- &types.ResponseStreamMemberChunk{Value: types.PayloadPart{Bytes: []byte(chunk)}}
+	}
+	close(events)
+	return &fakeBedrockStream{events: events}
+}
+
+func (s *fakeBedrockStream) Events() <-chan types.ResponseStream { return s.events }
+func (s *fakeBedrockStream) Close() error                        { return nil }
+func (s *fakeBedrockStream) Err() error                          { return s.err }
+
 var _ = Describe("Bedrock Models", func() {
 	var (
 		mockInvokeModel func(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error)
@@ -28,16 +49,16 @@ var _ = Describe("Bedrock Models", func() {
 		var (
 			model            *llm.NovaLiteModel
 			prompt           string
-			shell            string
+			env              environment.Environment
 			expectedResponse string
-			response         string
+			response         llm.Response
 			err              error
 		)
 
 		BeforeEach(func() {
 			prompt = "Hello, Nova!"
-			shell = "bash"
-			expectedResponse = "Nova says hi!"
+			env = environment.Environment{OS: "linux", Shell: "bash"}
+			expectedResponse = `{"command": "echo hi", "explanation": "Nova says hi!", "risk": "low", "requires_sudo": false}`
 		})
 
 		JustBeforeEach(func() {
@@ -45,7 +66,7 @@ var _ = Describe("Bedrock Models", func() {
 				InvokeModel: mockInvokeModel,
 				Model:       "amazon.nova-lite-v1:0",
 			}
-			response, err = model.GenerateCommand(context.Background(), logger, prompt, shell)
+			response, err = model.GenerateCommand(context.Background(), logger, prompt, env)
 		})
 
 		Context("when the API call is successful", func() {
@@ -73,7 +94,7 @@ var _ = Describe("Bedrock Models", func() {
 			})
 
 			It("returns the expected response", func() {
-				Expect(response).To(Equal(expectedResponse))
+				Expect(response).To(Equal(llm.Response{Command: "echo hi", Explanation: "Nova says hi!", Risk: llm.RiskLow}))
 			})
 
 			It("does not return an error", func() {
@@ -86,16 +107,16 @@ var _ = Describe("Bedrock Models", func() {
 		var (
 			model            *llm.TitanLiteModel
 			prompt           string
-			shell            string
+			env              environment.Environment
 			expectedResponse string
-			response         string
+			response         llm.Response
 			err              error
 		)
 
 		BeforeEach(func() {
 			prompt = "Hello, Titan!"
-			shell = "zsh"
-			expectedResponse = "Titan says hi!"
+			env = environment.Environment{OS: "linux", Shell: "zsh"}
+			expectedResponse = "echo hi"
 		})
 
 		JustBeforeEach(func() {
@@ -103,7 +124,7 @@ var _ = Describe("Bedrock Models", func() {
 				InvokeModel: mockInvokeModel,
 				Model:       "amazon.titan-text-lite-v1",
 			}
-			response, err = model.GenerateCommand(context.Background(), logger, prompt, shell)
+			response, err = model.GenerateCommand(context.Background(), logger, prompt, env)
 		})
 
 		Context("when the API call is successful", func() {
@@ -124,8 +145,8 @@ var _ = Describe("Bedrock Models", func() {
 				}
 			})
 
-			It("returns the expected response", func() {
-				Expect(response).To(Equal(expectedResponse))
+			It("uses the whole text as the command when it is not json", func() {
+				Expect(response).To(Equal(llm.Response{Command: expectedResponse}))
 			})
 
 			It("does not return an error", func() {
@@ -134,25 +155,301 @@ var _ = Describe("Bedrock Models", func() {
 		})
 	})
 
+	Describe("AnthropicSonnet4Model", func() {
+		var (
+			reqBody  map[string]any
+			response llm.Response
+			err      error
+		)
+
+		JustBeforeEach(func() {
+			model := &llm.AnthropicSonnet4Model{
+				InvokeModel: mockInvokeModel,
+				Model:       "anthropic.claude-sonnet-4-20250514-v1:0",
+			}
+			response, err = model.GenerateCommand(context.Background(), logger, "list files", testEnv)
+		})
+
+		Context("when the model calls the tool", func() {
+			BeforeEach(func() {
+				mockInvokeModel = func(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error) {
+					_ = json.Unmarshal(params.Body, &reqBody)
+					return &bedrockruntime.InvokeModelOutput{
+						Body: []byte(`{"content":[{"type":"tool_use","name":"shell_command","input":{"command":"ls -l","explanation":"Lists files.","risk":"low","requires_sudo":false}}]}`),
+					}, nil
+				}
+			})
+
+			It("forces the shell_command tool", func() {
+				Expect(reqBody["tool_choice"]).To(Equal(map[string]any{"type": "tool", "name": "shell_command"}))
+			})
+
+			It("returns the tool input", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Explanation: "Lists files.", Risk: llm.RiskLow}))
+			})
+		})
+
+		Context("when the model answers with text", func() {
+			BeforeEach(func() {
+				mockInvokeModel = func(ctx context.Context, params *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error) {
+					return &bedrockruntime.InvokeModelOutput{
+						Body: []byte(`{"content":[{"type":"text","text":"ls -l"}]}`),
+					}, nil
+				}
+			})
+
+			It("uses the text as the command", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l"}))
+			})
+		})
+	})
+
+	Describe("StreamCommand", func() {
+		var (
+			model     llm.BedrockModel
+			stream    *fakeBedrockStream
+			invokeErr error
+			tokens    []string
+			command   llm.Response
+			err       error
+		)
+
+		BeforeEach(func() {
+			invokeErr = nil
+			tokens = nil
+		})
+
+		JustBeforeEach(func() {
+			command, err = model.StreamCommand(context.Background(), logger, "list files", testEnv, func(token string) {
+				tokens = append(tokens, token)
+			})
+		})
+
+		invokeStream := func(ctx context.Context, params *bedrockruntime.InvokeModelWithResponseStreamInput) (bedrockruntime.ResponseStreamReader, error) {
+			if invokeErr != nil {
+				return nil, invokeErr
+			}
+			return stream, nil
+		}
+
+		Context("with NovaLiteModel", func() {
+			BeforeEach(func() {
+				stream = newFakeBedrockStream(
+					`{"messageStart":{"role":"assistant"}}`,
+					`{"contentBlockDelta":{"delta":{"text":"{\"command\": \"ls"}}}`,
+					`{"contentBlockDelta":{"delta":{"text":" -l\", \"risk\": \"low\"}"}}}`,
+				)
+				model = &llm.NovaLiteModel{InvokeModelWithResponseStream: invokeStream, Model: "amazon.nova-lite-v1:0"}
+			})
+
+			It("returns the full response", func() {
+				Expect(command, err).To(Equal(llm.Response{Command: "ls -l", Risk: llm.RiskLow}))
+			})
+
+			It("passes the command to the token func as it is decoded", func() {
+				Expect(tokens).To(Equal([]string{"ls", " -l"}))
+			})
+		})
+
+		Context("when Bedrock reports invocation metrics", func() {
+			BeforeEach(func() {
+				stream = newFakeBedrockStream(
+					`{"contentBlockDelta":{"delta":{"text":"{\"command\": \"ls -l\"}"}}}`,
+					`{"messageStop":{"stopReason":"end_turn"},"amazon-bedrock-invocationMetrics":{"inputTokenCount":310,"outputTokenCount":12}}`,
+				)
+				model = &llm.NovaLiteModel{InvokeModelWithResponseStream: invokeStream, Model: "amazon.nova-lite-v1:0"}
+			})
+
+			It("returns the token usage", func() {
+				Expect(command.Usage, err).To(Equal(llm.Usage{InputTokens: 310, OutputTokens: 12}))
+			})
+		})
+
+		Context("with TitanLiteModel", func() {
+			BeforeEach(func() {
+				stream = newFakeBedrockStream(`{"outputText":"ls"}`, `{"outputText":" -l"}`)
+				model = &llm.TitanLiteModel{InvokeModelWithResponseStream: invokeStream, Model: "amazon.titan-text-lite-v1"}
+			})
+
+			It("returns the full command", func() {
+				Expect(command, err).To(Equal(llm.Response{Command: "ls -l"}))
+			})
+		})
+
+		Context("with OpenAIGPTOSSModel", func() {
+			BeforeEach(func() {
+				stream = newFakeBedrockStream(
+					`{"choices":[{"delta":{"content":"<reasoning>list</reasoning>"}}]}`,
+					`{"choices":[]}`,
+					`{"choices":[{"delta":{"content":"ls -l"}}]}`,
+				)
+				model = &llm.OpenAIGPTOSSModel{InvokeModelWithResponseStream: invokeStream, Model: "openai.gpt-oss-120b-1:0"}
+			})
+
+			It("returns the command without reasoning", func() {
+				Expect(command, err).To(Equal(llm.Response{Command: "ls -l"}))
+			})
+		})
+
+		Context("with AnthropicSonnet4Model", func() {
+			BeforeEach(func() {
+				stream = newFakeBedrockStream(
+					`{"type":"message_start"}`,
+					`{"type":"content_block_delta","delta":{"type":"input_json_delta","partial_json":"{\"command\": \"ls"}}`,
+					`{"type":"content_block_delta","delta":{"type":"input_json_delta","partial_json":" -l\", \"requires_sudo\": true}"}}`,
+				)
+				model = &llm.AnthropicSonnet4Model{InvokeModelWithResponseStream: invokeStream, Model: "anthropic.claude-sonnet-4-20250514-v1:0"}
+			})
+
+			It("returns the tool input", func() {
+				Expect(command, err).To(Equal(llm.Response{Command: "ls -l", RequiresSudo: true}))
+			})
+		})
+
+		Context("when the chunk cannot be decoded", func() {
+			BeforeEach(func() {
+				stream = newFakeBedrockStream(`not json`)
+				model = &llm.NovaLiteModel{InvokeModelWithResponseStream: invokeStream, Model: "amazon.nova-lite-v1:0"}
+			})
+
+			It("returns an error", func() {
+				Expect(command, err).Error().To(MatchError(ContainSubstring("failed to unmarshal Bedrock response chunk")))
+			})
+		})
+
+		Context("when the stream is empty", func() {
+			BeforeEach(func() {
+				stream = newFakeBedrockStream()
+				model = &llm.NovaLiteModel{InvokeModelWithResponseStream: invokeStream, Model: "amazon.nova-lite-v1:0"}
+			})
+
+			It("returns an error", func() {
+				Expect(command, err).Error().To(MatchError(llm.ErrEmptyResponse))
+			})
+		})
+
+		Context("when access is denied", func() {
+			BeforeEach(func() {
+				invokeErr = &types.AccessDeniedException{}
+				model = &llm.NovaLiteModel{InvokeModelWithResponseStream: invokeStream, Model: "amazon.nova-lite-v1:0"}
+			})
+
+			It("returns an access denied error", func() {
+				Expect(command, err).Error().To(MatchError(ContainSubstring("access denied to Bedrock API")))
+			})
+		})
+
+		Context("when the stream fails", func() {
+			BeforeEach(func() {
+				stream = newFakeBedrockStream()
+				stream.err = errors.New("stream error")
+				model = &llm.NovaLiteModel{InvokeModelWithResponseStream: invokeStream, Model: "amazon.nova-lite-v1:0"}
+			})
+
+			It("returns an error", func() {
+				Expect(command, err).Error().To(MatchError("failed to invoke Bedrock model: stream error"))
+			})
+		})
+	})
+
+	Describe("generation params", func() {
+		var (
+			body   map[string]any
+			params llm.GenerationParams
+		)
+
+		BeforeEach(func() {
+			body = nil
+			params = llm.GenerationParams{}
+			mockInvokeModel = func(ctx context.Context, input *bedrockruntime.InvokeModelInput, optFns ...func(*bedrockruntime.Options)) (*bedrockruntime.InvokeModelOutput, error) {
+				Expect(json.Unmarshal(input.Body, &body)).To(Succeed())
+				return &bedrockruntime.InvokeModelOutput{Body: []byte(`{}`)}, nil
+			}
+		})
+
+		request := func(newModel func(llm.BedrockInvokeModelFunc, llm.GenerationParams) llm.BedrockModel) map[string]any {
+			_, _ = newModel(mockInvokeModel, params).GenerateCommand(context.Background(), logger, "list files", testEnv)
+			return body
+		}
+
+		set := func() {
+			temperature, topP := float32(0.5), float32(0.9)
+			params = llm.GenerationParams{Temperature: &temperature, TopP: &topP, MaxTokens: 4000, Stop: []string{"END"}}
+		}
+
+		nova := func(invoke llm.BedrockInvokeModelFunc, params llm.GenerationParams) llm.BedrockModel {
+			return &llm.NovaLiteModel{InvokeModel: invoke, Params: params}
+		}
+		titan := func(invoke llm.BedrockInvokeModelFunc, params llm.GenerationParams) llm.BedrockModel {
+			return &llm.TitanLiteModel{InvokeModel: invoke, Params: params}
+		}
+		gptOSS := func(invoke llm.BedrockInvokeModelFunc, params llm.GenerationParams) llm.BedrockModel {
+			return &llm.OpenAIGPTOSSModel{InvokeModel: invoke, Params: params}
+		}
+		sonnet4 := func(invoke llm.BedrockInvokeModelFunc, params llm.GenerationParams) llm.BedrockModel {
+			return &llm.AnthropicSonnet4Model{InvokeModel: invoke, Params: params}
+		}
+
+		DescribeTable("the request body",
+			func(setup func(), newModel func(llm.BedrockInvokeModelFunc, llm.GenerationParams) llm.BedrockModel, key string, expected map[string]any) {
+				setup()
+				body := request(newModel)
+				if key != "" {
+					body, _ = body[key].(map[string]any)
+				}
+				got := map[string]any{}
+				for name := range expected {
+					got[name] = body[name]
+				}
+				Expect(got).To(Equal(expected))
+			},
+			Entry("Nova defaults", func() {}, nova, "inferenceConfig", map[string]any{"maxTokens": 200.0}),
+			Entry("Nova params", set, nova, "inferenceConfig", map[string]any{"maxTokens": 4000.0, "temperature": 0.5, "topP": 0.9, "stopSequences": []any{"END"}}),
+			Entry("Titan defaults", func() {}, titan, "textGenerationConfig", map[string]any{"maxTokenCount": 200.0}),
+			Entry("Titan params", set, titan, "textGenerationConfig", map[string]any{"maxTokenCount": 4000.0, "temperature": 0.5, "topP": 0.9, "stopSequences": []any{"END"}}),
+			Entry("GPT-OSS defaults", func() {}, gptOSS, "", map[string]any{"max_completion_tokens": 200.0, "temperature": 0.0}),
+			Entry("GPT-OSS params", set, gptOSS, "", map[string]any{"max_completion_tokens": 4000.0, "temperature": 0.5, "top_p": 0.9, "stop": []any{"END"}}),
+			Entry("Sonnet 4 defaults", func() {}, sonnet4, "", map[string]any{"max_tokens": 200.0, "temperature": nil}),
+			Entry("Sonnet 4 params", set, sonnet4, "", map[string]any{"max_tokens": 4000.0, "temperature": 0.5, "top_p": 0.9, "stop_sequences": []any{"END"}}),
+		)
+	})
+
 	Describe("NewBedrock", func() {
-		Context("with a supported model", func() {
+		Context("with the converse API", func() {
+			It("returns a ConverseModel for any model", func() {
+				model, err := llm.NewBedrock(context.Background(), "meta.llama3-1-70b-instruct-v1:0", "us-east-1", "", llm.BedrockAPIConverse, nil, llm.GenerationParams{})
+				Expect(model, err).To(BeAssignableToTypeOf(&llm.ConverseModel{}))
+			})
+
+			It("uses the inference profile as the model ID", func() {
+				model, err := llm.NewBedrock(context.Background(), "anthropic.claude-sonnet-4-20250514-v1:0", "us-east-1", "us.anthropic.claude-sonnet-4-20250514-v1:0", llm.BedrockAPIConverse, nil, llm.GenerationParams{})
+				Expect(model, err).To(HaveField("Model", "us.anthropic.claude-sonnet-4-20250514-v1:0"))
+			})
+		})
+
+		Context("with the invoke API and a supported model", func() {
 			It("returns a NovaLiteModel for amazon.nova-lite-v1:0", func() {
-				model, err := llm.NewBedrock(context.Background(), "amazon.nova-lite-v1:0", "us-east-1", "")
-				Expect(err).ToNot(HaveOccurred())
-				Expect(model).To(BeAssignableToTypeOf(&llm.NovaLiteModel{}))
+				model, err := llm.NewBedrock(context.Background(), "amazon.nova-lite-v1:0", "us-east-1", "", llm.BedrockAPIInvoke, nil, llm.GenerationParams{})
+				Expect(model, err).To(BeAssignableToTypeOf(&llm.NovaLiteModel{}))
 			})
 
 			It("returns a TitanLiteModel for amazon.titan-text-lite-v1", func() {
-				model, err := llm.NewBedrock(context.Background(), "amazon.titan-text-lite-v1", "us-east-1", "")
-				Expect(err).ToNot(HaveOccurred())
-				Expect(model).To(BeAssignableToTypeOf(&llm.TitanLiteModel{}))
+				model, err := llm.NewBedrock(context.Background(), "amazon.titan-text-lite-v1", "us-east-1", "", llm.BedrockAPIInvoke, nil, llm.GenerationParams{})
+				Expect(model, err).To(BeAssignableToTypeOf(&llm.TitanLiteModel{}))
+			})
+		})
+
+		Context("with the invoke API and an unsupported model", func() {
+			It("returns an error", func() {
+				_, err := llm.NewBedrock(context.Background(), "unsupported-model", "us-east-1", "", llm.BedrockAPIInvoke, nil, llm.GenerationParams{})
+				Expect(err).To(MatchError("unsupported Bedrock model for the invoke API: unsupported-model (use the converse API instead)"))
 			})
 		})
 
-		Context("with an unsupported model", func() {
+		Context("with an unknown API", func() {
 			It("returns an error", func() {
-				_, err := llm.NewBedrock(context.Background(), "unsupported-model", "us-east-1", "")
-				Expect(err).To(MatchError("unsupported Bedrock model: unsupported-model"))
+				_, err := llm.NewBedrock(context.Background(), "amazon.nova-lite-v1:0", "us-east-1", "", "rest", nil, llm.GenerationParams{})
+				Expect(err).To(MatchError("unsupported Bedrock API: rest (expected converse or invoke)"))
 			})
 		})
 	})
@@ -0,0 +1,55 @@
+package llm
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/zombor/gen/environment"
+)
+
+// BudgetChecker decides whether a provider may be called.
+type BudgetChecker interface {
+	// CheckBudget returns a *ProviderError of kind ErrBudgetExceeded when
+	// provider has used up one of its budgets.
+	CheckBudget(provider string) error
+}
+
+// BudgetProvider is an LLMProvider that refuses to call the wrapped provider
+// once its budget is used up. In a FallbackProvider the next provider is
+// tried instead.
+type BudgetProvider struct {
+	LLMProvider
+	Checker  BudgetChecker
+	Provider string
+}
+
+// NewBudgetProvider wraps provider, checking the budget of the named provider
+// with checker before every request.
+func NewBudgetProvider(provider LLMProvider, checker BudgetChecker, name string) *BudgetProvider {
+	return &BudgetProvider{
+		LLMProvider: provider,
+		Checker:     checker,
+		Provider:    name,
+	}
+}
+
+// GenerateCommand implements LLMProvider.
+func (p *BudgetProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	return p.StreamCommand(ctx, logger, prompt, env, discardTokens)
+}
+
+// StreamCommand implements StreamingLLMProvider.
+func (p *BudgetProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	if err := p.Checker.CheckBudget(p.Provider); err != nil {
+		return Response{}, err
+	}
+	return StreamCommand(ctx, logger, p.LLMProvider, prompt, env, onToken)
+}
+
+// GenerateCommands implements MultiLLMProvider.
+func (p *BudgetProvider) GenerateCommands(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, n int) ([]Candidate, error) {
+	if err := p.Checker.CheckBudget(p.Provider); err != nil {
+		return nil, err
+	}
+	return GenerateCommands(ctx, logger, p.LLMProvider, prompt, env, n)
+}
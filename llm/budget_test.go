@@ -0,0 +1,192 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/llm"
+)
+
+// fakeChecker refuses the providers in over.
+type fakeChecker struct {
+	over map[string]bool
+}
+
+func (c *fakeChecker) CheckBudget(provider string) error {
+	if c.over[provider] {
+		return &llm.ProviderError{Kind: llm.ErrBudgetExceeded, Provider: provider, Err: errors.New(provider + " has used its daily budget")}
+	}
+	return nil
+}
+
+// checkerFunc is a BudgetChecker made of a func.
+type checkerFunc func(provider string) error
+
+func (f checkerFunc) CheckBudget(provider string) error { return f(provider) }
+
+var _ = Describe("BudgetProvider", func() {
+	var (
+		counting *countingProvider
+		checker  *fakeChecker
+		provider *llm.BudgetProvider
+		logger   *slog.Logger
+	)
+
+	BeforeEach(func() {
+		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+		counting = &countingProvider{command: "ls -l"}
+		checker = &fakeChecker{over: map[string]bool{}}
+		provider = llm.NewBudgetProvider(counting, checker, "bedrock")
+	})
+
+	Describe("GenerateCommand", func() {
+		var (
+			response llm.Response
+			err      error
+		)
+
+		JustBeforeEach(func() {
+			response, err = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+		})
+
+		When("the provider is within its budget", func() {
+			It("asks the provider", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Risk: llm.RiskLow}))
+			})
+		})
+
+		When("the provider is over its budget", func() {
+			BeforeEach(func() {
+				checker.over["bedrock"] = true
+			})
+
+			It("returns the budget error", func() {
+				Expect(response, err).Error().To(MatchError(llm.ErrBudgetExceeded))
+			})
+
+			It("does not ask the provider", func() {
+				Expect(counting.calls).To(Equal(0))
+			})
+		})
+	})
+
+	Describe("GenerateCommands", func() {
+		var (
+			candidates []llm.Candidate
+			err        error
+		)
+
+		JustBeforeEach(func() {
+			candidates, err = provider.GenerateCommands(context.Background(), logger, "list files", testEnv, 2)
+		})
+
+		When("the provider is within its budget", func() {
+			It("asks the provider", func() {
+				Expect(candidates, err).To(Equal([]llm.Candidate{{Response: llm.Response{Command: "ls -l", Risk: llm.RiskLow}, Votes: 2}}))
+			})
+		})
+
+		When("the provider is over its budget", func() {
+			BeforeEach(func() {
+				checker.over["bedrock"] = true
+			})
+
+			It("returns the budget error", func() {
+				Expect(candidates, err).Error().To(MatchError(llm.ErrBudgetExceeded))
+			})
+		})
+	})
+
+	When("it is part of a fallback chain", func() {
+		var (
+			response llm.Response
+			err      error
+		)
+
+		BeforeEach(func() {
+			checker.over["bedrock"] = true
+		})
+
+		JustBeforeEach(func() {
+			fallback := llm.NewFallbackProvider(0,
+				llm.NamedProvider{Name: "bedrock", LLMProvider: provider},
+				llm.NamedProvider{Name: "ollama", LLMProvider: &countingProvider{command: "ls -la"}},
+			)
+			response, err = fallback.GenerateCommand(context.Background(), logger, "list files", testEnv)
+		})
+
+		It("falls through to the next provider", func() {
+			Expect(response, err).To(Equal(llm.Response{Command: "ls -la", Risk: llm.RiskLow, Provider: "ollama"}))
+		})
+	})
+})
+
+var _ = Describe("BudgetProvider in a RetryProvider", func() {
+	var (
+		flaky    *flakyProvider
+		checks   int
+		allowed  int
+		response llm.Response
+		err      error
+	)
+
+	BeforeEach(func() {
+		flaky = &flakyProvider{errs: []error{rateLimited(0)}}
+		checks, allowed = 0, 2
+	})
+
+	JustBeforeEach(func() {
+		checker := checkerFunc(func(provider string) error {
+			checks++
+			if checks > allowed {
+				return &llm.ProviderError{Kind: llm.ErrBudgetExceeded, Provider: provider, Err: errors.New(provider + " has used its daily budget")}
+			}
+			return nil
+		})
+		provider := llm.NewRetryProvider(llm.NewBudgetProvider(flaky, checker, "bedrock"), llm.RetryPolicy{Retries: 2})
+		response, err = provider.GenerateCommand(context.Background(), slog.New(slog.NewJSONHandler(ioutil.Discard, nil)), "list files", testEnv)
+	})
+
+	It("checks the budget before every attempt", func() {
+		Expect(checks).To(Equal(2))
+	})
+
+	When("the budget runs out between attempts", func() {
+		BeforeEach(func() {
+			allowed = 1
+		})
+
+		It("returns the budget error", func() {
+			Expect(response, err).Error().To(MatchError(llm.ErrBudgetExceeded))
+		})
+
+		It("does not retry the provider", func() {
+			Expect(flaky.calls).To(Equal(1))
+		})
+	})
+})
+
+var _ = Describe("UsageRecorders", func() {
+	var (
+		first, second *fakeRecorder
+		err           error
+	)
+
+	BeforeEach(func() {
+		first = &fakeRecorder{}
+		second = &fakeRecorder{}
+	})
+
+	JustBeforeEach(func() {
+		err = llm.UsageRecorders{first, second}.RecordUsage(llm.UsageRecord{Provider: "bedrock"})
+	})
+
+	It("passes the record to every recorder", func() {
+		Expect(second.records, err).To(ConsistOf(HaveField("Provider", "bedrock")))
+	})
+})
@@ -0,0 +1,217 @@
+package llm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zombor/gen/environment"
+)
+
+// Cache stores JSON encodable values by key.
+type Cache interface {
+	// Get decodes the value stored under key into v and reports whether
+	// there was one.
+	Get(key string, v any) bool
+	Put(key string, v any) error
+}
+
+// DiskCache is a Cache that stores each value in a JSON file in Dir. Keys
+// are used as file names, so they must be safe to use as one. Entries older
+// than TTL are treated as missing; a TTL of zero keeps entries forever.
+type DiskCache struct {
+	Dir string
+	TTL time.Duration
+	Now func() time.Time
+}
+
+// cacheEntry is the content of a DiskCache file.
+type cacheEntry struct {
+	Created time.Time       `json:"created"`
+	Value   json.RawMessage `json:"value"`
+}
+
+// NewDiskCache creates a DiskCache in dir.
+func NewDiskCache(dir string, ttl time.Duration) *DiskCache {
+	return &DiskCache{
+		Dir: dir,
+		TTL: ttl,
+		Now: time.Now,
+	}
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.Dir, key+".json")
+}
+
+// Get implements Cache. Expired entries are removed.
+func (c *DiskCache) Get(key string, v any) bool {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return false
+	}
+	if c.TTL > 0 && c.Now().Sub(entry.Created) > c.TTL {
+		os.Remove(c.path(key))
+		return false
+	}
+
+	return json.Unmarshal(entry.Value, v) == nil
+}
+
+// Put implements Cache. The file is replaced atomically so that concurrent
+// readers never see a partial entry.
+func (c *DiskCache) Put(key string, v any) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(cacheEntry{Created: c.Now(), Value: value})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(c.Dir, 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(c.Dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path(key))
+}
+
+// Clear removes every entry. Other files in Dir are left alone.
+func (c *DiskCache) Clear() error {
+	entries, err := os.ReadDir(c.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !(strings.HasSuffix(name, ".json") || strings.HasSuffix(name, ".tmp")) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(c.Dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CacheKey identifies what generates the responses of a CacheProvider.
+type CacheKey struct {
+	Provider string
+	Model    string
+	// Templates is the version of the prompt templates.
+	Templates string
+	Params    GenerationParams
+}
+
+// CacheProvider is an LLMProvider that answers repeated prompts from Cache.
+// Responses are cached by Key, the prompt, the shell and the fingerprint of
+// the environment. Errors and empty commands are not cached.
+type CacheProvider struct {
+	LLMProvider
+	Cache Cache
+	Key   CacheKey
+}
+
+// NewCacheProvider wraps provider with cache.
+func NewCacheProvider(provider LLMProvider, cache Cache, key CacheKey) *CacheProvider {
+	return &CacheProvider{
+		LLMProvider: provider,
+		Cache:       cache,
+		Key:         key,
+	}
+}
+
+// key returns the cache key of a request for n commands.
+func (p *CacheProvider) key(prompt string, env environment.Environment, n int) string {
+	data, _ := json.Marshal(struct {
+		CacheKey
+		Shell       string
+		Environment string
+		Prompt      string
+		N           int
+	}{p.Key, env.Shell, env.Fingerprint(), prompt, n})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateCommand implements LLMProvider.
+func (p *CacheProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	return p.StreamCommand(ctx, logger, prompt, env, discardTokens)
+}
+
+// StreamCommand implements StreamingLLMProvider. A cached command is passed
+// to onToken in one piece.
+func (p *CacheProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	key := p.key(prompt, env, 1)
+
+	var response Response
+	if p.Cache.Get(key, &response) {
+		logger.Debug("cache hit", "key", key)
+		response.Cached = true
+		onToken(response.Command)
+		return response, nil
+	}
+
+	response, err := StreamCommand(ctx, logger, p.LLMProvider, prompt, env, onToken)
+	if err != nil || strings.TrimSpace(response.Command) == "" {
+		return response, err
+	}
+
+	if err := p.Cache.Put(key, response); err != nil {
+		logger.Warn("failed to cache response", "error", err)
+	}
+	return response, nil
+}
+
+// GenerateCommands implements MultiLLMProvider.
+func (p *CacheProvider) GenerateCommands(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, n int) ([]Candidate, error) {
+	key := p.key(prompt, env, n)
+
+	var candidates []Candidate
+	if p.Cache.Get(key, &candidates) {
+		logger.Debug("cache hit", "key", key)
+		for i := range candidates {
+			candidates[i].Cached = true
+		}
+		return candidates, nil
+	}
+
+	candidates, err := GenerateCommands(ctx, logger, p.LLMProvider, prompt, env, n)
+	if err != nil {
+		return candidates, err
+	}
+
+	if err := p.Cache.Put(key, candidates); err != nil {
+		logger.Warn("failed to cache candidates", "error", err)
+	}
+	return candidates, nil
+}
@@ -0,0 +1,249 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm"
+)
+
+// countingProvider answers with command and counts its calls.
+type countingProvider struct {
+	command string
+	err     error
+	calls   int
+	mu      sync.Mutex
+}
+
+func (p *countingProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (llm.Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls++
+	return llm.Response{Command: p.command, Risk: llm.RiskLow}, p.err
+}
+
+var _ = Describe("CacheProvider", func() {
+	var (
+		counting *countingProvider
+		cache    *llm.DiskCache
+		provider *llm.CacheProvider
+		logger   *slog.Logger
+		now      time.Time
+		ctx      context.Context
+
+		// warm is called before the request under test.
+		warm func()
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+		now = time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+		counting = &countingProvider{command: "ls -l"}
+		cache = llm.NewDiskCache(GinkgoT().TempDir(), time.Hour)
+		cache.Now = func() time.Time { return now }
+		provider = llm.NewCacheProvider(counting, cache, llm.CacheKey{Provider: "ollama", Model: "llama3", Templates: "v1"})
+		warm = func() {
+			_, _ = provider.GenerateCommand(ctx, logger, "list files", testEnv)
+		}
+	})
+
+	Describe("GenerateCommand", func() {
+		var (
+			prompt   string
+			env      environment.Environment
+			response llm.Response
+			err      error
+		)
+
+		BeforeEach(func() {
+			prompt = "list files"
+			env = testEnv
+		})
+
+		JustBeforeEach(func() {
+			warm()
+			response, err = provider.GenerateCommand(ctx, logger, prompt, env)
+		})
+
+		When("the prompt was asked before", func() {
+			It("returns the cached response", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Risk: llm.RiskLow, Cached: true}))
+			})
+
+			It("does not ask the provider again", func() {
+				Expect(counting.calls).To(Equal(1))
+			})
+		})
+
+		When("the prompt is different", func() {
+			BeforeEach(func() {
+				prompt = "show disk usage"
+			})
+
+			It("asks the provider", func() {
+				Expect(counting.calls).To(Equal(2))
+			})
+		})
+
+		When("the shell is different", func() {
+			BeforeEach(func() {
+				env = environment.Environment{OS: "linux", Shell: "fish"}
+			})
+
+			It("asks the provider", func() {
+				Expect(counting.calls).To(Equal(2))
+			})
+		})
+
+		When("the templates changed", func() {
+			BeforeEach(func() {
+				warm = func() {
+					_, _ = provider.GenerateCommand(ctx, logger, "list files", testEnv)
+					provider.Key.Templates = "v2"
+				}
+			})
+
+			It("asks the provider", func() {
+				Expect(counting.calls).To(Equal(2))
+			})
+		})
+
+		When("the generation params changed", func() {
+			BeforeEach(func() {
+				warm = func() {
+					_, _ = provider.GenerateCommand(ctx, logger, "list files", testEnv)
+					provider.Key.Params.MaxTokens = 4000
+				}
+			})
+
+			It("asks the provider", func() {
+				Expect(counting.calls).To(Equal(2))
+			})
+		})
+
+		When("the entry has expired", func() {
+			BeforeEach(func() {
+				warm = func() {
+					_, _ = provider.GenerateCommand(ctx, logger, "list files", testEnv)
+					now = now.Add(2 * time.Hour)
+				}
+			})
+
+			It("returns a fresh response", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Risk: llm.RiskLow}))
+			})
+		})
+
+		When("the earlier request failed", func() {
+			BeforeEach(func() {
+				warm = func() {
+					counting.err = errors.New("connection refused")
+					_, _ = provider.GenerateCommand(ctx, logger, "list files", testEnv)
+					counting.err = nil
+				}
+			})
+
+			It("returns a fresh response", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Risk: llm.RiskLow}))
+			})
+		})
+	})
+
+	Describe("StreamCommand", func() {
+		var tokens []string
+
+		JustBeforeEach(func() {
+			warm()
+			tokens = nil
+			_, _ = provider.StreamCommand(ctx, logger, "list files", testEnv, func(token string) {
+				tokens = append(tokens, token)
+			})
+		})
+
+		It("passes a cached command to the token func in one piece", func() {
+			Expect(tokens).To(Equal([]string{"ls -l"}))
+		})
+	})
+
+	Describe("GenerateCommands", func() {
+		var (
+			candidates []llm.Candidate
+			err        error
+		)
+
+		JustBeforeEach(func() {
+			warm()
+			candidates, err = provider.GenerateCommands(ctx, logger, "list files", testEnv, 2)
+		})
+
+		When("only a single command was cached", func() {
+			It("asks the provider", func() {
+				Expect(candidates, err).To(Equal([]llm.Candidate{{Response: llm.Response{Command: "ls -l", Risk: llm.RiskLow}, Votes: 2}}))
+			})
+		})
+
+		When("the candidates were cached", func() {
+			BeforeEach(func() {
+				warm = func() {
+					_, _ = provider.GenerateCommands(ctx, logger, "list files", testEnv, 2)
+				}
+			})
+
+			It("returns the cached candidates", func() {
+				Expect(candidates, err).To(Equal([]llm.Candidate{{Response: llm.Response{Command: "ls -l", Risk: llm.RiskLow, Cached: true}, Votes: 2}}))
+			})
+		})
+	})
+})
+
+var _ = Describe("DiskCache", func() {
+	var (
+		dir   string
+		other string
+		cache *llm.DiskCache
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		other = filepath.Join(dir, "notes.txt")
+		Expect(os.WriteFile(other, []byte("keep"), 0o600)).To(Succeed())
+		cache = llm.NewDiskCache(dir, 0)
+		Expect(cache.Put("key", "value")).To(Succeed())
+	})
+
+	Describe("Clear", func() {
+		JustBeforeEach(func() {
+			Expect(cache.Clear()).To(Succeed())
+		})
+
+		It("removes the entries", func() {
+			var value string
+			Expect(cache.Get("key", &value)).To(BeFalse())
+		})
+
+		It("leaves other files alone", func() {
+			Expect(other).To(BeAnExistingFile())
+		})
+
+		When("the directory does not exist", func() {
+			BeforeEach(func() {
+				cache.Dir = filepath.Join(dir, "missing")
+			})
+
+			It("succeeds", func() {
+				Expect(cache.Dir).ToNot(BeAnExistingFile())
+			})
+		})
+	})
+})
@@ -0,0 +1,98 @@
+package llm
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/zombor/gen/environment"
+)
+
+// Candidate is one of several alternative commands generated for a prompt.
+type Candidate struct {
+	Response
+	// Votes is the number of generations that produced this command.
+	Votes int
+}
+
+// MultiLLMProvider is an LLMProvider that can generate several alternative
+// commands in a single request.
+type MultiLLMProvider interface {
+	LLMProvider
+	GenerateCommands(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, n int) ([]Candidate, error)
+}
+
+// GenerateCommands asks provider for n alternative commands and returns them
+// ranked with the most frequently generated command first. Providers that do
+// not implement MultiLLMProvider are called n times in parallel. Individual
+// failures are ignored as long as at least one call succeeds.
+func GenerateCommands(ctx context.Context, logger *slog.Logger, provider LLMProvider, prompt string, env environment.Environment, n int) ([]Candidate, error) {
+	if mp, ok := provider.(MultiLLMProvider); ok {
+		return mp.GenerateCommands(ctx, logger, prompt, env, n)
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		responses []Response
+		firstErr  error
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := provider.GenerateCommand(ctx, logger, prompt, env)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			responses = append(responses, response)
+		}()
+	}
+	wg.Wait()
+
+	if len(responses) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+
+	return rankCandidates(responses, Usage{})
+}
+
+// rankCandidates merges responses with identical commands and orders them by
+// the number of times they were generated. Ties keep their original order.
+// The usage of all responses plus usage, the usage of the request that
+// generated them, is reported on the first candidate.
+func rankCandidates(responses []Response, usage Usage) ([]Candidate, error) {
+	var candidates []Candidate
+	index := map[string]int{}
+	for _, response := range responses {
+		usage = usage.Add(response.Usage)
+		response.Usage = Usage{}
+		response.Command = strings.TrimSpace(response.Command)
+		if response.Command == "" {
+			continue
+		}
+		if i, ok := index[response.Command]; ok {
+			candidates[i].Votes++
+			continue
+		}
+		index[response.Command] = len(candidates)
+		candidates = append(candidates, Candidate{Response: response, Votes: 1})
+	}
+
+	if len(candidates) == 0 {
+		return nil, emptyResponse()
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Votes > candidates[j].Votes
+	})
+	candidates[0].Usage = usage
+	return candidates, nil
+}
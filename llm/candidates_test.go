@@ -0,0 +1,138 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm"
+)
+
+// sequenceProvider returns the configured results in order, one per call.
+type sequenceProvider struct {
+	mu       sync.Mutex
+	commands []string
+	errs     []error
+}
+
+func candidate(command string, votes int) llm.Candidate {
+	return llm.Candidate{Response: llm.Response{Command: command}, Votes: votes}
+}
+
+func (p *sequenceProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (llm.Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	command, err := p.commands[0], p.errs[0]
+	p.commands, p.errs = p.commands[1:], p.errs[1:]
+	return llm.Response{Command: command}, err
+}
+
+var _ = Describe("GenerateCommands", func() {
+	var (
+		provider   llm.LLMProvider
+		logger     *slog.Logger
+		candidates []llm.Candidate
+		err        error
+	)
+
+	BeforeEach(func() {
+		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+	})
+
+	JustBeforeEach(func() {
+		candidates, err = llm.GenerateCommands(context.Background(), logger, provider, "list files", testEnv, 3)
+	})
+
+	Context("when the provider only generates single commands", func() {
+		BeforeEach(func() {
+			provider = &sequenceProvider{
+				commands: []string{"ls", "ls -l", " ls -l "},
+				errs:     []error{nil, nil, nil},
+			}
+		})
+
+		It("ranks the most frequently generated command first", func() {
+			Expect(candidates, err).To(Equal([]llm.Candidate{
+				candidate("ls -l", 2),
+				candidate("ls", 1),
+			}))
+		})
+	})
+
+	Context("when some of the calls fail", func() {
+		BeforeEach(func() {
+			provider = &sequenceProvider{
+				commands: []string{"", "ls", ""},
+				errs:     []error{errors.New("boom"), nil, errors.New("boom")},
+			}
+		})
+
+		It("returns the successful candidates", func() {
+			Expect(candidates, err).To(Equal([]llm.Candidate{candidate("ls", 1)}))
+		})
+	})
+
+	Context("when every call fails", func() {
+		BeforeEach(func() {
+			provider = &sequenceProvider{
+				commands: []string{"", "", ""},
+				errs:     []error{errors.New("boom"), errors.New("boom"), errors.New("boom")},
+			}
+		})
+
+		It("returns the error", func() {
+			Expect(candidates, err).Error().To(MatchError("boom"))
+		})
+	})
+
+	Context("when every command is empty", func() {
+		BeforeEach(func() {
+			provider = &sequenceProvider{
+				commands: []string{"", " ", ""},
+				errs:     []error{nil, nil, nil},
+			}
+		})
+
+		It("returns a 'no command generated' error", func() {
+			Expect(candidates, err).Error().To(MatchError("no command generated"))
+		})
+	})
+
+	Context("when the provider generates candidates natively", func() {
+		var requested int
+
+		BeforeEach(func() {
+			provider = &llm.OpenAIProvider{
+				CreateChatCompletion: func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+					requested = req.N
+					return openai.ChatCompletionResponse{
+						Choices: []openai.ChatCompletionChoice{
+							{Message: openai.ChatCompletionMessage{Content: `{"command": "ls"}`}},
+							{Message: openai.ChatCompletionMessage{Content: `{"command": "ls -a"}`}},
+							{Message: openai.ChatCompletionMessage{Content: "not json"}},
+							{Message: openai.ChatCompletionMessage{Content: `{"command": "ls -a"}`}},
+						},
+					}, nil
+				},
+			}
+		})
+
+		It("asks for all candidates in one request", func() {
+			Expect(requested).To(Equal(3))
+		})
+
+		It("ranks the candidates", func() {
+			Expect(candidates, err).To(Equal([]llm.Candidate{
+				candidate("ls -a", 2),
+				candidate("ls", 1),
+			}))
+		})
+	})
+})
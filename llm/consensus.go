@@ -0,0 +1,111 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zombor/gen/environment"
+)
+
+// Consensus holds the answers of several providers to the same prompt.
+type Consensus struct {
+	// Answers holds the answer of every provider that returned a command,
+	// in the order the providers are configured. Response.Provider names
+	// the provider.
+	Answers []Response
+	// Normalized holds the normalized command of each answer.
+	Normalized []string
+}
+
+// Agreed reports whether at least two providers answered and all of their
+// commands are the same once normalized.
+func (c Consensus) Agreed() bool {
+	if len(c.Normalized) < 2 {
+		return false
+	}
+	for _, command := range c.Normalized[1:] {
+		if command != c.Normalized[0] {
+			return false
+		}
+	}
+	return true
+}
+
+// ConsensusProvider asks all of its providers for a command at once so that
+// their answers can be compared.
+type ConsensusProvider struct {
+	Providers []NamedProvider
+	// Timeout bounds each provider's attempt. Zero means no timeout.
+	Timeout time.Duration
+	// Normalize rewrites a command into a canonical form, so that commands
+	// that differ only in formatting agree.
+	Normalize func(command string) string
+}
+
+// NewConsensusProvider creates a ConsensusProvider that compares the answers
+// of providers after normalizing them with normalize.
+func NewConsensusProvider(timeout time.Duration, normalize func(string) string, providers ...NamedProvider) *ConsensusProvider {
+	return &ConsensusProvider{
+		Providers: providers,
+		Timeout:   timeout,
+		Normalize: normalize,
+	}
+}
+
+// Ask asks every provider for a command. Providers that fail or return an
+// empty command are left out of the consensus; it is an error only when all
+// of them fail.
+func (p *ConsensusProvider) Ask(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Consensus, error) {
+	if len(p.Providers) == 0 {
+		return Consensus{}, fmt.Errorf("no providers configured")
+	}
+
+	var (
+		wg        sync.WaitGroup
+		responses = make([]Response, len(p.Providers))
+		errs      = make([]error, len(p.Providers))
+	)
+	for i, provider := range p.Providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = attemptWithin(ctx, p.Timeout, provider, func(ctx context.Context, provider NamedProvider) error {
+				response, err := provider.GenerateCommand(ctx, logger, prompt, env)
+				if err != nil {
+					return err
+				}
+				if strings.TrimSpace(response.Command) == "" {
+					return emptyResponse()
+				}
+				response.Provider = provider.Name
+				responses[i] = response
+				return nil
+			})
+		}()
+	}
+	wg.Wait()
+
+	var (
+		consensus Consensus
+		failed    []error
+	)
+	for i, provider := range p.Providers {
+		if errs[i] != nil {
+			logger.Warn("provider failed", "provider", provider.Name, "error", errs[i])
+			failed = append(failed, fmt.Errorf("%s: %w", provider.Name, errs[i]))
+			continue
+		}
+		consensus.Answers = append(consensus.Answers, responses[i])
+		consensus.Normalized = append(consensus.Normalized, p.Normalize(responses[i].Command))
+	}
+
+	if len(consensus.Answers) == 0 {
+		return Consensus{}, providersFailed(failed)
+	}
+	logger.Debug("consensus", "normalized", consensus.Normalized, "agreed", consensus.Agreed())
+	return consensus, nil
+}
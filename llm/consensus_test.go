@@ -0,0 +1,101 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/llm"
+)
+
+var _ = Describe("ConsensusProvider", func() {
+	var (
+		first, second *fakeProvider
+		provider      *llm.ConsensusProvider
+		logger        *slog.Logger
+
+		consensus llm.Consensus
+		err       error
+	)
+
+	BeforeEach(func() {
+		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+		first = &fakeProvider{command: "ls  -l"}
+		second = &fakeProvider{command: "ls -l"}
+		provider = llm.NewConsensusProvider(0, func(command string) string { return strings.Join(strings.Fields(command), " ") },
+			llm.NamedProvider{Name: "ollama", LLMProvider: first},
+			llm.NamedProvider{Name: "bedrock", LLMProvider: second},
+		)
+	})
+
+	JustBeforeEach(func() {
+		consensus, err = provider.Ask(context.Background(), logger, "list files", testEnv)
+	})
+
+	It("returns every answer in provider order", func() {
+		Expect(consensus, err).To(HaveField("Answers", []llm.Response{
+			{Command: "ls  -l", Provider: "ollama"},
+			{Command: "ls -l", Provider: "bedrock"},
+		}))
+	})
+
+	It("normalizes the answers", func() {
+		Expect(consensus, err).To(HaveField("Normalized", []string{"ls -l", "ls -l"}))
+	})
+
+	When("a provider fails", func() {
+		BeforeEach(func() {
+			first.err = errors.New("connection refused")
+		})
+
+		It("leaves it out", func() {
+			Expect(consensus, err).To(HaveField("Answers", []llm.Response{{Command: "ls -l", Provider: "bedrock"}}))
+		})
+	})
+
+	When("a provider returns an empty command", func() {
+		BeforeEach(func() {
+			first.command = " "
+		})
+
+		It("leaves it out", func() {
+			Expect(consensus, err).To(HaveField("Normalized", []string{"ls -l"}))
+		})
+	})
+
+	When("every provider fails", func() {
+		BeforeEach(func() {
+			first.err = errors.New("connection refused")
+			second.err = errors.New("403 Forbidden")
+		})
+
+		It("returns every provider's error", func() {
+			Expect(consensus, err).Error().To(MatchError(And(
+				ContainSubstring("all providers failed"),
+				ContainSubstring("ollama: connection refused"),
+				ContainSubstring("bedrock: 403 Forbidden"),
+			)))
+		})
+	})
+})
+
+var _ = Describe("Consensus", func() {
+	Describe("Agreed", func() {
+		It("is true when all normalized commands are the same", func() {
+			Expect(llm.Consensus{Normalized: []string{"ls -l", "ls -l"}}.Agreed()).To(BeTrue())
+		})
+
+		It("is false when a command differs", func() {
+			Expect(llm.Consensus{Normalized: []string{"ls -l", "ls -l", "ls -a"}}.Agreed()).To(BeFalse())
+		})
+
+		It("is false with a single answer", func() {
+			Expect(llm.Consensus{Normalized: []string{"ls -l"}}.Agreed()).To(BeFalse())
+		})
+	})
+})
@@ -0,0 +1,161 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Errors returned by providers, wrapped in a *ProviderError. Use errors.Is to
+// check for them.
+var (
+	ErrAuth            = errors.New("authentication failed")
+	ErrRateLimited     = errors.New("rate limited")
+	ErrQuotaExceeded   = errors.New("quota exceeded")
+	ErrModelNotFound   = errors.New("model not found")
+	ErrContentFiltered = errors.New("content filtered")
+	ErrEmptyResponse   = errors.New("no command generated")
+	ErrTimeout         = errors.New("timed out")
+	ErrBudgetExceeded  = errors.New("budget exceeded")
+)
+
+// ProviderError is a provider or SDK error classified as one of the Err*
+// sentinel errors.
+type ProviderError struct {
+	// Kind is one of the Err* sentinel errors.
+	Kind error
+	// Provider is the name of the provider that failed, when known.
+	Provider string
+	// RetryAfter is how long the provider asked to wait before retrying.
+	RetryAfter time.Duration
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *ProviderError) Error() string {
+	if e.Err == nil {
+		return e.Kind.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+}
+
+// Unwrap returns both the sentinel and the underlying error so that errors.Is
+// and errors.As match either.
+func (e *ProviderError) Unwrap() []error {
+	if e.Err == nil {
+		return []error{e.Kind}
+	}
+	return []error{e.Kind, e.Err}
+}
+
+// emptyResponse returns the error for a reply without a command.
+func emptyResponse() error {
+	return &ProviderError{Kind: ErrEmptyResponse}
+}
+
+// providerError classifies err as kind. The Retry-After header recorded for
+// the request in ctx, if any, is attached.
+func providerError(ctx context.Context, kind, err error) error {
+	return &ProviderError{
+		Kind:       kind,
+		RetryAfter: retryAfterFromContext(ctx),
+		Err:        err,
+	}
+}
+
+// statusError classifies an HTTP status code. It returns err unchanged when
+// the status code does not map to a sentinel error.
+func statusError(ctx context.Context, statusCode int, err error) error {
+	switch statusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return providerError(ctx, ErrAuth, err)
+	case http.StatusNotFound:
+		return providerError(ctx, ErrModelNotFound, err)
+	case http.StatusTooManyRequests:
+		return providerError(ctx, ErrRateLimited, err)
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		return providerError(ctx, ErrTimeout, err)
+	}
+	return err
+}
+
+// contextError classifies errors caused by ctx expiring. It returns err
+// unchanged otherwise.
+func contextError(ctx context.Context, err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return providerError(ctx, ErrTimeout, err)
+	}
+	return err
+}
+
+// retryAfterKey is the context key of the *retryAfter recorded for a request.
+type retryAfterKey struct{}
+
+// retryAfter holds the Retry-After header of the last response to a request.
+type retryAfter struct {
+	mu    sync.Mutex
+	delay time.Duration
+}
+
+// withRetryAfter returns a context in which RetryAfterTransport records the
+// Retry-After header of responses.
+func withRetryAfter(ctx context.Context) context.Context {
+	return context.WithValue(ctx, retryAfterKey{}, &retryAfter{})
+}
+
+func retryAfterFromContext(ctx context.Context) time.Duration {
+	if ctx == nil {
+		return 0
+	}
+	r, ok := ctx.Value(retryAfterKey{}).(*retryAfter)
+	if !ok {
+		return 0
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.delay
+}
+
+// parseRetryAfter parses a Retry-After header, given either in seconds or as
+// an HTTP date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// RetryAfterTransport records the Retry-After header of responses so that
+// providers whose SDK does not expose response headers can honor it.
+type RetryAfterTransport struct {
+	Base http.RoundTripper
+}
+
+// NewHTTPClient returns an HTTP client for provider SDKs that records the
+// Retry-After header of responses.
+func NewHTTPClient() *http.Client {
+	return &http.Client{Transport: &RetryAfterTransport{Base: http.DefaultTransport}}
+}
+
+func (t *RetryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	if r, ok := req.Context().Value(retryAfterKey{}).(*retryAfter); ok {
+		r.mu.Lock()
+		r.delay = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+		r.mu.Unlock()
+	}
+	return resp, nil
+}
@@ -0,0 +1,170 @@
+package llm
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// reasoningPattern matches the reasoning some models emit before their
+	// answer. A block that is never closed runs to the end of the text.
+	reasoningPattern = regexp.MustCompile(`(?s)<(think|thinking|reasoning)>.*?(</(think|thinking|reasoning)>|\z)`)
+	// danglingReasoningPattern matches the end of a reasoning block whose
+	// opening tag was part of the prompt.
+	danglingReasoningPattern = regexp.MustCompile(`(?s)\A.*?</(think|thinking|reasoning)>`)
+
+	fencePattern      = regexp.MustCompile("(?s)```[^\n]*\n(.*?)(```|\\z)")
+	promptPattern     = regexp.MustCompile(`^(\$|%|[\w.-]+@[\w.-]+:\S*\$)\s+`)
+	listPattern       = regexp.MustCompile(`^(\d+[.)]|[-*])\s+`)
+	inlineCodePattern = regexp.MustCompile("`([^`]+)`")
+	capitalizedWord   = regexp.MustCompile(`^[A-Z][a-z']*[,:!.?]?$`)
+	// leadInPattern matches the end of a lowercase lead-in to a command,
+	// such as "then run:".
+	leadInPattern = regexp.MustCompile(`(?i)\b(run|use|try|type|execute|enter|command|commands|following|this|these|instead|example|below)\s*:$`)
+	// scriptPattern matches lines that only make sense as part of a script,
+	// which must not be chained with &&.
+	scriptPattern = regexp.MustCompile(`(\\$|<<|^(if|then|else|elif|fi|for|while|until|do|done|case|esac)\b|(^|\s)[{}]$)`)
+)
+
+// stripReasoning removes the reasoning blocks of thinking models from text.
+func stripReasoning(text string) string {
+	if loc := danglingReasoningPattern.FindStringIndex(text); loc != nil && !strings.Contains(text[:loc[1]], "<think") && !strings.Contains(text[:loc[1]], "<reasoning") {
+		text = text[loc[1]:]
+	}
+	return reasoningPattern.ReplaceAllString(text, "")
+}
+
+// ExtractCommand extracts the shell command from a model's reply, which may
+// be the bare command or the command dressed up for a chat window. It
+// removes reasoning blocks, takes the contents of Markdown code fences,
+// strips `$ ` prompts, surrounding backticks and quotes, and drops prose.
+// Several commands are chained with && unless they form a script.
+func ExtractCommand(text string) string {
+	text = stripReasoning(text)
+
+	var blocks []string
+	for _, match := range fencePattern.FindAllStringSubmatch(text, -1) {
+		blocks = append(blocks, match[1])
+	}
+	if len(blocks) == 0 {
+		blocks = []string{text}
+	}
+
+	var commands []string
+	for _, block := range blocks {
+		commands = append(commands, extractLines(block)...)
+	}
+
+	for _, command := range commands {
+		if scriptPattern.MatchString(strings.TrimSpace(command)) {
+			return strings.Join(commands, "\n")
+		}
+	}
+	for i, command := range commands {
+		commands[i] = strings.TrimSpace(command)
+	}
+	return strings.Join(commands, " && ")
+}
+
+// extractLines returns the command lines of a block of text. They keep
+// their indentation, less the indentation they all share, in case they are
+// joined into a script.
+func extractLines(block string) []string {
+	lines := strings.Split(strings.ReplaceAll(block, "\r\n", "\n"), "\n")
+
+	// In a transcript of a terminal session, the lines without a prompt
+	// are output.
+	transcript := false
+	for _, line := range lines {
+		if promptPattern.MatchString(strings.TrimSpace(line)) {
+			transcript = true
+		}
+	}
+
+	var commands []string
+	continued := false
+	for _, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		line = strings.TrimSpace(line)
+		if continued {
+			commands = append(commands, indent+line)
+			continued = strings.HasSuffix(line, `\`)
+			continue
+		}
+
+		if transcript && !promptPattern.MatchString(line) {
+			continue
+		}
+		line = listPattern.ReplaceAllString(promptPattern.ReplaceAllString(line, ""), "")
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if isProse(line) || isProse(strings.TrimSpace(inlineCodePattern.ReplaceAllString(line, ""))) {
+			// Prose may still mention the command in inline code.
+			for _, match := range inlineCodePattern.FindAllStringSubmatch(line, -1) {
+				if code := unquote(match[1]); code != "" && !isProse(code) {
+					commands = append(commands, code)
+				}
+			}
+			continue
+		}
+
+		line = unquote(line)
+		if line == "" {
+			continue
+		}
+		commands = append(commands, indent+line)
+		continued = strings.HasSuffix(line, `\`)
+	}
+	return dedent(commands)
+}
+
+// dedent removes the indentation that all lines share.
+func dedent(lines []string) []string {
+	var shared string
+	for i, line := range lines {
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if i == 0 {
+			shared = indent
+		}
+		for !strings.HasPrefix(indent, shared) {
+			shared = shared[:len(shared)-1]
+		}
+	}
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, shared)
+	}
+	return lines
+}
+
+// unquote removes backticks or quotes wrapped around a whole command.
+func unquote(line string) string {
+	for {
+		line = strings.TrimSpace(line)
+		if len(line) < 2 {
+			return line
+		}
+		quote := line[0]
+		if !strings.ContainsRune("`'\"", rune(quote)) || line[len(line)-1] != quote || strings.IndexByte(line[1:len(line)-1], quote) >= 0 {
+			return line
+		}
+		line = line[1 : len(line)-1]
+	}
+}
+
+// isProse reports whether line reads like a sentence rather than a command:
+// a capitalized sentence ending with punctuation, or a lead-in such as "then
+// run:". Other lines ending with a colon, such as echo Done:, are commands.
+func isProse(line string) bool {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return false
+	}
+	last := line[len(line)-1:]
+	if capitalizedWord.MatchString(words[0]) && strings.ContainsAny(last, ".!?:") {
+		return true
+	}
+	return len(words) > 1 && leadInPattern.MatchString(line) && !strings.ContainsAny(line, "@=$|/")
+}
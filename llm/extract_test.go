@@ -0,0 +1,69 @@
+package llm_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/llm"
+)
+
+// extractCase is a model reply from testdata/extract and the command that
+// should be extracted from it. Each file holds a "-- reply --" section
+// followed by a "-- command --" section.
+type extractCase struct {
+	name    string
+	reply   string
+	command string
+}
+
+func loadExtractCases() []extractCase {
+	paths, err := filepath.Glob(filepath.Join("testdata", "extract", "*.txt"))
+	if err != nil {
+		panic(err)
+	}
+
+	var cases []extractCase
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			panic(err)
+		}
+
+		c := extractCase{name: strings.TrimSuffix(filepath.Base(path), ".txt")}
+		section := ""
+		for _, line := range strings.SplitAfter(string(data), "\n") {
+			switch strings.TrimRight(line, "\r\n") {
+			case "-- reply --", "-- command --":
+				section = strings.TrimRight(line, "\r\n")
+				continue
+			}
+			switch section {
+			case "-- reply --":
+				c.reply += line
+			case "-- command --":
+				c.command += line
+			}
+		}
+		c.command = strings.TrimSuffix(c.command, "\n")
+		cases = append(cases, c)
+	}
+	return cases
+}
+
+var _ = Describe("ExtractCommand", func() {
+	var entries []TableEntry
+	for _, c := range loadExtractCases() {
+		entries = append(entries, Entry(c.name, c.reply, c.command))
+	}
+
+	DescribeTable("extracts the command from real model replies",
+		func(reply, command string) {
+			Expect(llm.ExtractCommand(reply)).To(Equal(command))
+		},
+		entries,
+	)
+})
@@ -0,0 +1,137 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/zombor/gen/environment"
+)
+
+// NamedProvider is an LLMProvider along with the name it was configured as.
+type NamedProvider struct {
+	Name string
+	LLMProvider
+}
+
+// FallbackProvider is an LLMProvider that tries each of its providers in
+// order until one of them returns a command. A provider that errors, takes
+// longer than Timeout, or returns an empty command is skipped. The name of the
+// provider that answered is recorded in Response.Provider.
+type FallbackProvider struct {
+	Providers []NamedProvider
+	// Timeout bounds each provider's attempt. Zero means no timeout.
+	Timeout time.Duration
+}
+
+// NewFallbackProvider creates a FallbackProvider that tries providers in order.
+func NewFallbackProvider(timeout time.Duration, providers ...NamedProvider) *FallbackProvider {
+	return &FallbackProvider{
+		Providers: providers,
+		Timeout:   timeout,
+	}
+}
+
+// GenerateCommand implements LLMProvider.
+func (p *FallbackProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	return p.StreamCommand(ctx, logger, prompt, env, discardTokens)
+}
+
+// StreamCommand implements StreamingLLMProvider. Tokens already passed to
+// onToken by a provider that then fails are not retracted, so the returned
+// Response is authoritative.
+func (p *FallbackProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	var response Response
+	err := p.try(ctx, logger, func(ctx context.Context, provider NamedProvider) error {
+		r, err := StreamCommand(ctx, logger, provider.LLMProvider, prompt, env, onToken)
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(r.Command) == "" {
+			return emptyResponse()
+		}
+		r.Provider = provider.Name
+		response = r
+		return nil
+	})
+	return response, err
+}
+
+// GenerateCommands implements MultiLLMProvider.
+func (p *FallbackProvider) GenerateCommands(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, n int) ([]Candidate, error) {
+	var candidates []Candidate
+	err := p.try(ctx, logger, func(ctx context.Context, provider NamedProvider) error {
+		c, err := GenerateCommands(ctx, logger, provider.LLMProvider, prompt, env, n)
+		if err != nil {
+			return err
+		}
+		for i := range c {
+			c[i].Provider = provider.Name
+		}
+		candidates = c
+		return nil
+	})
+	return candidates, err
+}
+
+// try calls attempt with each provider until one succeeds.
+func (p *FallbackProvider) try(ctx context.Context, logger *slog.Logger, attempt func(context.Context, NamedProvider) error) error {
+	if len(p.Providers) == 0 {
+		return fmt.Errorf("no providers configured")
+	}
+
+	var errs []error
+	for _, provider := range p.Providers {
+		err := attemptWithin(ctx, p.Timeout, provider, attempt)
+		if err == nil {
+			logger.Debug("provider answered", "provider", provider.Name)
+			return nil
+		}
+		// Stop when the caller gave up rather than the provider failing.
+		if ctx.Err() != nil {
+			return err
+		}
+
+		logger.Warn("provider failed, trying the next one", "provider", provider.Name, "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", provider.Name, err))
+	}
+
+	return providersFailed(errs)
+}
+
+// providersFailed combines the errors of every provider that was asked.
+func providersFailed(errs []error) error {
+	if len(errs) == 1 {
+		return errs[0]
+	}
+	return fmt.Errorf("all providers failed:\n%w", errors.Join(errs...))
+}
+
+// attemptWithin calls attempt with provider, giving up after timeout unless
+// it is zero. A provider error is attributed to provider.
+func attemptWithin(ctx context.Context, timeout time.Duration, provider NamedProvider, attempt func(context.Context, NamedProvider) error) error {
+	err := attemptWithTimeout(ctx, timeout, provider, attempt)
+	var providerErr *ProviderError
+	if errors.As(err, &providerErr) && providerErr.Provider == "" {
+		providerErr.Provider = provider.Name
+	}
+	return err
+}
+
+func attemptWithTimeout(ctx context.Context, timeout time.Duration, provider NamedProvider, attempt func(context.Context, NamedProvider) error) error {
+	if timeout <= 0 {
+		return attempt(ctx, provider)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	err := attempt(ctx, provider)
+	if err != nil && !errors.Is(err, ErrTimeout) && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return &ProviderError{Kind: ErrTimeout, Err: fmt.Errorf("no answer after %s: %w", timeout, err)}
+	}
+	return err
+}
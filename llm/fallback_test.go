@@ -0,0 +1,183 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm"
+)
+
+// blockingProvider waits for its context to be done.
+type blockingProvider struct{}
+
+func (blockingProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (llm.Response, error) {
+	<-ctx.Done()
+	return llm.Response{}, ctx.Err()
+}
+
+// streamingProvider streams its tokens and returns them joined as the command.
+type streamingProvider struct {
+	tokens []string
+}
+
+func (p *streamingProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (llm.Response, error) {
+	return p.StreamCommand(ctx, logger, prompt, env, func(string) {})
+}
+
+func (p *streamingProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken llm.TokenFunc) (llm.Response, error) {
+	var command string
+	for _, token := range p.tokens {
+		onToken(token)
+		command += token
+	}
+	return llm.Response{Command: command}, nil
+}
+
+var _ = Describe("FallbackProvider", func() {
+	var (
+		provider *llm.FallbackProvider
+		logger   *slog.Logger
+	)
+
+	BeforeEach(func() {
+		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+	})
+
+	Describe("GenerateCommand", func() {
+		It("returns the first provider's answer", func() {
+			provider = llm.NewFallbackProvider(0,
+				llm.NamedProvider{Name: "ollama", LLMProvider: &fakeProvider{command: "ls"}},
+				llm.NamedProvider{Name: "openai", LLMProvider: &fakeProvider{command: "ls -l"}},
+			)
+
+			response, err := provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+			Expect(response, err).To(Equal(llm.Response{Command: "ls", Provider: "ollama"}))
+		})
+
+		It("tries the next provider when one errors", func() {
+			provider = llm.NewFallbackProvider(0,
+				llm.NamedProvider{Name: "ollama", LLMProvider: &fakeProvider{err: errors.New("connection refused")}},
+				llm.NamedProvider{Name: "openai", LLMProvider: &fakeProvider{command: "ls -l"}},
+			)
+
+			response, err := provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+			Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Provider: "openai"}))
+		})
+
+		It("tries the next provider when one returns an empty command", func() {
+			provider = llm.NewFallbackProvider(0,
+				llm.NamedProvider{Name: "ollama", LLMProvider: &fakeProvider{command: "  "}},
+				llm.NamedProvider{Name: "openai", LLMProvider: &fakeProvider{command: "ls -l"}},
+			)
+
+			response, err := provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+			Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Provider: "openai"}))
+		})
+
+		It("tries the next provider when one times out", func() {
+			provider = llm.NewFallbackProvider(10*time.Millisecond,
+				llm.NamedProvider{Name: "bedrock", LLMProvider: blockingProvider{}},
+				llm.NamedProvider{Name: "openai", LLMProvider: &fakeProvider{command: "ls -l"}},
+			)
+
+			response, err := provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+			Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Provider: "openai"}))
+		})
+
+		When("all providers fail", func() {
+			var err error
+
+			BeforeEach(func() {
+				provider = llm.NewFallbackProvider(10*time.Millisecond,
+					llm.NamedProvider{Name: "ollama", LLMProvider: &fakeProvider{err: errors.New("connection refused")}},
+					llm.NamedProvider{Name: "bedrock", LLMProvider: blockingProvider{}},
+				)
+			})
+
+			JustBeforeEach(func() {
+				_, err = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+			})
+
+			It("says all providers failed", func() {
+				Expect(err).To(MatchError(ContainSubstring("all providers failed")))
+			})
+
+			It("returns the error of a provider that failed", func() {
+				Expect(err).To(MatchError(ContainSubstring("ollama: connection refused")))
+			})
+
+			It("returns the time out of a provider that timed out", func() {
+				Expect(err).To(MatchError(ContainSubstring("bedrock: timed out")))
+			})
+
+			It("wraps the errors", func() {
+				Expect(err).To(MatchError(context.DeadlineExceeded))
+			})
+		})
+
+		It("stops when the caller's context is canceled", func() {
+			second := &fakeProvider{command: "ls -l"}
+			provider = llm.NewFallbackProvider(0,
+				llm.NamedProvider{Name: "bedrock", LLMProvider: blockingProvider{}},
+				llm.NamedProvider{Name: "openai", LLMProvider: second},
+			)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			_, err := provider.GenerateCommand(ctx, logger, "list files", testEnv)
+			Expect(err).To(MatchError(context.Canceled))
+		})
+	})
+
+	Describe("StreamCommand", func() {
+		var (
+			response llm.Response
+			tokens   []string
+			err      error
+		)
+
+		BeforeEach(func() {
+			tokens = nil
+			provider = llm.NewFallbackProvider(0,
+				llm.NamedProvider{Name: "ollama", LLMProvider: &fakeProvider{err: errors.New("connection refused")}},
+				llm.NamedProvider{Name: "openai", LLMProvider: &streamingProvider{tokens: []string{"ls", " -l"}}},
+			)
+		})
+
+		JustBeforeEach(func() {
+			response, err = provider.StreamCommand(context.Background(), logger, "list files", testEnv, func(token string) {
+				tokens = append(tokens, token)
+			})
+		})
+
+		It("returns the response of the provider that answers", func() {
+			Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Provider: "openai"}))
+		})
+
+		It("streams tokens from the provider that answers", func() {
+			Expect(tokens).To(Equal([]string{"ls", " -l"}))
+		})
+	})
+
+	Describe("GenerateCommands", func() {
+		It("records the provider on each candidate", func() {
+			provider = llm.NewFallbackProvider(0,
+				llm.NamedProvider{Name: "ollama", LLMProvider: &fakeProvider{err: errors.New("connection refused")}},
+				llm.NamedProvider{Name: "openai", LLMProvider: &fakeProvider{command: "ls"}},
+			)
+
+			candidates, err := provider.GenerateCommands(context.Background(), logger, "list files", testEnv, 2)
+			Expect(candidates, err).To(Equal([]llm.Candidate{
+				{Response: llm.Response{Command: "ls", Provider: "openai"}, Votes: 2},
+			}))
+		})
+	})
+})
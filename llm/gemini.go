@@ -2,37 +2,220 @@ package llm
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"log/slog"
-	"os"
 
 	"github.com/google/generative-ai-go/genai"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm/prompt"
 )
 
+// GeminiResponseIterator is the subset of *genai.GenerateContentResponseIterator
+// used by GeminiProvider.
+type GeminiResponseIterator interface {
+	Next() (*genai.GenerateContentResponse, error)
+}
+
 // GeminiProvider is an implementation of LLMProvider for Google's Gemini.
 type GeminiProvider struct {
-	GenerateContent func(context.Context, ...genai.Part) (*genai.GenerateContentResponse, error)
+	GenerateContent       func(context.Context, ...genai.Part) (*genai.GenerateContentResponse, error)
+	GenerateContentStream func(context.Context, ...genai.Part) GeminiResponseIterator
+	// GenerateCandidates is like GenerateContent but asks for n candidates.
+	GenerateCandidates func(ctx context.Context, n int32, parts ...genai.Part) (*genai.GenerateContentResponse, error)
+	Templates          *prompt.Templates
+}
+
+// geminiResponseSchema mirrors responseSchema in the form the Gemini SDK expects.
+var geminiResponseSchema = &genai.Schema{
+	Type: genai.TypeObject,
+	Properties: map[string]*genai.Schema{
+		"command":       {Type: genai.TypeString, Description: "The shell command."},
+		"explanation":   {Type: genai.TypeString, Description: "One short sentence explaining what the command does."},
+		"risk":          {Type: genai.TypeString, Enum: []string{"low", "medium", "high"}, Description: "How likely the command is to lose data or change the system."},
+		"requires_sudo": {Type: genai.TypeBoolean, Description: "Whether the command needs root privileges."},
+	},
+	Required: []string{"command", "explanation", "risk", "requires_sudo"},
+}
+
+// NewGeminiProvider creates a GeminiProvider for model, configuring it to
+// respond with JSON matching Response and to generate with params.
+func NewGeminiProvider(model *genai.GenerativeModel, templates *prompt.Templates, params GenerationParams) *GeminiProvider {
+	model.ResponseMIMEType = "application/json"
+	model.ResponseSchema = geminiResponseSchema
+	model.Temperature = params.Temperature
+	model.TopP = params.TopP
+	if params.MaxTokens > 0 {
+		model.SetMaxOutputTokens(int32(params.MaxTokens))
+	}
+	model.StopSequences = params.Stop
+
+	return &GeminiProvider{
+		GenerateContent: model.GenerateContent,
+		GenerateContentStream: func(ctx context.Context, parts ...genai.Part) GeminiResponseIterator {
+			return model.GenerateContentStream(ctx, parts...)
+		},
+		GenerateCandidates: func(ctx context.Context, n int32, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+			m := *model
+			m.SetCandidateCount(n)
+			return m.GenerateContent(ctx, parts...)
+		},
+		Templates: templates,
+	}
 }
 
 // GenerateCommand generates a command using the Gemini LLM.
-func (p *GeminiProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt, shell string) (string, error) {
-	fullPrompt := fmt.Sprintf("Given the following prompt, generate a single shell command. The command should be able to be executed on a %s machine in a %s shell. The command should be reasonable and not destructive. Return only the command, with no explanation or other text.\n\nPrompt: %s", os.Getenv("GOOS"), shell, prompt)
-	logger.Debug("gemini prompt", "prompt", fullPrompt)
+func (p *GeminiProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	fullPrompt, err := renderPrompt(logger, p.Templates, "gemini", prompt, env)
+	if err != nil {
+		return Response{}, err
+	}
 
 	resp, err := p.GenerateContent(ctx, genai.Text(fullPrompt))
 
 	if err != nil {
-		return "", err
+		return Response{}, geminiError(ctx, err)
+	}
+
+	if txt := geminiText(resp); txt != "" {
+		logger.Debug("gemini response", "response", txt)
+		response, err := parseResponse(txt)
+		response.Usage = geminiUsage(resp)
+		return response, err
+	}
+
+	return Response{}, emptyResponse()
+}
+
+// GenerateCommands generates n alternative commands in a single Gemini request.
+func (p *GeminiProvider) GenerateCommands(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, n int) ([]Candidate, error) {
+	fullPrompt, err := renderPrompt(logger, p.Templates, "gemini", prompt, env)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := p.GenerateCandidates(ctx, int32(n), genai.Text(fullPrompt))
+	if err != nil {
+		return nil, geminiError(ctx, err)
+	}
+
+	var responses []Response
+	for _, candidate := range resp.Candidates {
+		txt := geminiCandidateText(candidate)
+		logger.Debug("gemini response", "response", txt)
+		if response, err := parseResponse(txt); err == nil {
+			responses = append(responses, response)
+		}
+	}
+
+	return rankCandidates(responses, geminiUsage(resp))
+}
+
+// StreamCommand generates a command using the Gemini LLM, passing the command
+// to onToken as it is decoded from the streamed JSON response.
+func (p *GeminiProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	fullPrompt, err := renderPrompt(logger, p.Templates, "gemini", prompt, env)
+	if err != nil {
+		return Response{}, err
 	}
 
-	if len(resp.Candidates) > 0 {
-		for _, part := range resp.Candidates[0].Content.Parts {
-			if txt, ok := part.(genai.Text); ok {
-				logger.Debug("gemini response", "response", string(txt))
-				return string(txt), nil
-			}
+	iter := p.GenerateContentStream(ctx, genai.Text(fullPrompt))
+
+	content := newJSONFieldStream("command", onToken)
+	var usage Usage
+	for {
+		resp, err := iter.Next()
+		if errors.Is(err, iterator.Done) {
+			break
+		}
+		if err != nil {
+			return Response{}, geminiError(ctx, err)
+		}
+		content.Write(geminiText(resp))
+		// Each chunk reports the usage of the request so far.
+		if resp.UsageMetadata != nil {
+			usage = geminiUsage(resp)
 		}
 	}
 
-	return "", fmt.Errorf("no command generated")
+	if content.String() == "" {
+		return Response{}, emptyResponse()
+	}
+
+	logger.Debug("gemini response", "response", content.String())
+	response, err := parseResponse(content.String())
+	response.Usage = usage
+	return response, err
+}
+
+func geminiUsage(resp *genai.GenerateContentResponse) Usage {
+	if resp.UsageMetadata == nil {
+		return Usage{}
+	}
+	return Usage{
+		InputTokens:  int(resp.UsageMetadata.PromptTokenCount),
+		OutputTokens: int(resp.UsageMetadata.CandidatesTokenCount),
+	}
+}
+
+// geminiError maps Gemini errors onto the sentinel errors.
+func geminiError(ctx context.Context, err error) error {
+	var blocked *genai.BlockedError
+	if errors.As(err, &blocked) {
+		return providerError(ctx, ErrContentFiltered, err)
+	}
+
+	var apiErr *apierror.APIError
+	if !errors.As(err, &apiErr) {
+		return contextError(ctx, err)
+	}
+
+	details := apiErr.Details()
+	switch apiErr.GRPCStatus().Code() {
+	case codes.Unauthenticated, codes.PermissionDenied:
+		return providerError(ctx, ErrAuth, err)
+	case codes.InvalidArgument:
+		if apiErr.Reason() == "API_KEY_INVALID" {
+			return providerError(ctx, ErrAuth, err)
+		}
+	case codes.NotFound:
+		return providerError(ctx, ErrModelNotFound, err)
+	case codes.ResourceExhausted:
+		// Per-minute limits come with a retry delay, daily quotas do not.
+		if details.RetryInfo != nil {
+			return &ProviderError{Kind: ErrRateLimited, RetryAfter: details.RetryInfo.GetRetryDelay().AsDuration(), Err: err}
+		}
+		if details.QuotaFailure != nil {
+			return providerError(ctx, ErrQuotaExceeded, err)
+		}
+		return providerError(ctx, ErrRateLimited, err)
+	case codes.DeadlineExceeded:
+		return providerError(ctx, ErrTimeout, err)
+	}
+
+	return statusError(ctx, apiErr.HTTPCode(), err)
+}
+
+// geminiText returns the first text part of the first candidate in resp.
+func geminiText(resp *genai.GenerateContentResponse) string {
+	if len(resp.Candidates) == 0 {
+		return ""
+	}
+	return geminiCandidateText(resp.Candidates[0])
+}
+
+// geminiCandidateText returns the first text part of candidate.
+func geminiCandidateText(candidate *genai.Candidate) string {
+	if candidate.Content == nil {
+		return ""
+	}
+	for _, part := range candidate.Content.Parts {
+		if txt, ok := part.(genai.Text); ok {
+			return string(txt)
+		}
+	}
+	return ""
 }
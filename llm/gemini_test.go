@@ -9,10 +9,36 @@ import (
 	"github.com/google/generative-ai-go/genai"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	"google.golang.org/api/iterator"
 
 	"github.com/zombor/gen/llm"
 )
 
+type fakeGeminiIterator struct {
+	responses []*genai.GenerateContentResponse
+	err       error
+}
+
+func (i *fakeGeminiIterator) Next() (*genai.GenerateContentResponse, error) {
+	if len(i.responses) == 0 {
+		if i.err != nil {
+			return nil, i.err
+		}
+		return nil, iterator.Done
+	}
+	resp := i.responses[0]
+	i.responses = i.responses[1:]
+	return resp, nil
+}
+
+func geminiTextResponse(text string) *genai.GenerateContentResponse {
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{
+			{Content: &genai.Content{Parts: []genai.Part{genai.Text(text)}}},
+		},
+	}
+}
+
 var _ = Describe("GeminiProvider", func() {
 	var (
 		generateContentFunc func(context.Context, ...genai.Part) (*genai.GenerateContentResponse, error)
@@ -20,7 +46,7 @@ var _ = Describe("GeminiProvider", func() {
 		mockError           error
 		logger              *slog.Logger
 
-		command string
+		command llm.Response
 		err     error
 	)
 
@@ -31,7 +57,7 @@ var _ = Describe("GeminiProvider", func() {
 				{
 					Content: &genai.Content{
 						Parts: []genai.Part{
-							genai.Text("ls -l"),
+							genai.Text(`{"command": "ls -l", "explanation": "Lists files.", "risk": "low", "requires_sudo": false}`),
 						},
 					},
 				},
@@ -48,7 +74,7 @@ var _ = Describe("GeminiProvider", func() {
 	JustBeforeEach(func() {
 		command, err = (&llm.GeminiProvider{
 			GenerateContent: generateContentFunc,
-		}).GenerateCommand(context.Background(), logger, "list files", "bash")
+		}).GenerateCommand(context.Background(), logger, "list files", testEnv)
 	})
 
 	Context("GenerateContent", func() {
@@ -57,7 +83,17 @@ var _ = Describe("GeminiProvider", func() {
 	Context("GenerateCommand", func() {
 		When("command generation is successful", func() {
 			It("should return the generated command and no error", func() {
-				Expect(command, err).To(Equal("ls -l"))
+				Expect(command, err).To(Equal(llm.Response{Command: "ls -l", Explanation: "Lists files.", Risk: llm.RiskLow}))
+			})
+		})
+
+		When("the response reports usage", func() {
+			BeforeEach(func() {
+				mockResponse.UsageMetadata = &genai.UsageMetadata{PromptTokenCount: 180, CandidatesTokenCount: 30}
+			})
+
+			It("should return the token usage", func() {
+				Expect(command.Usage, err).To(Equal(llm.Usage{InputTokens: 180, OutputTokens: 30}))
 			})
 		})
 
@@ -72,6 +108,17 @@ var _ = Describe("GeminiProvider", func() {
 			})
 		})
 
+		When("the prompt is blocked", func() {
+			BeforeEach(func() {
+				mockResponse = nil
+				mockError = &genai.BlockedError{PromptFeedback: &genai.PromptFeedback{BlockReason: genai.BlockReasonSafety}}
+			})
+
+			It("should return a content filtered error", func() {
+				Expect(command, err).Error().To(MatchError(llm.ErrContentFiltered))
+			})
+		})
+
 		When("no command is generated", func() {
 			BeforeEach(func() {
 				mockResponse = &genai.GenerateContentResponse{}
@@ -79,8 +126,152 @@ var _ = Describe("GeminiProvider", func() {
 			})
 
 			It("should return a 'no command generated' error and empty command", func() {
-				Expect(command, err).Error().To(Equal(errors.New("no command generated")))
+				Expect(command, err).Error().To(MatchError(llm.ErrEmptyResponse))
+			})
+		})
+	})
+
+	Context("StreamCommand", func() {
+		var (
+			iter   *fakeGeminiIterator
+			tokens []string
+		)
+
+		BeforeEach(func() {
+			iter = &fakeGeminiIterator{
+				responses: []*genai.GenerateContentResponse{
+					geminiTextResponse(`{"command": "ls`),
+					{},
+					geminiTextResponse(` -l"}`),
+				},
+			}
+			tokens = nil
+		})
+
+		JustBeforeEach(func() {
+			command, err = (&llm.GeminiProvider{
+				GenerateContentStream: func(context.Context, ...genai.Part) llm.GeminiResponseIterator {
+					return iter
+				},
+			}).StreamCommand(context.Background(), logger, "list files", testEnv, func(token string) {
+				tokens = append(tokens, token)
+			})
+		})
+
+		When("the stream is successful", func() {
+			It("should return the full command", func() {
+				Expect(command, err).To(Equal(llm.Response{Command: "ls -l"}))
+			})
+
+			It("should pass the command to the token func as it is decoded", func() {
+				Expect(tokens).To(Equal([]string{"ls", " -l"}))
+			})
+		})
+
+		When("the stream fails", func() {
+			BeforeEach(func() {
+				iter.err = errors.New("API error")
+			})
+
+			It("should return the error", func() {
+				Expect(command, err).Error().To(MatchError("API error"))
+			})
+		})
+
+		When("no command is generated", func() {
+			BeforeEach(func() {
+				iter.responses = nil
 			})
+
+			It("should return a 'no command generated' error", func() {
+				Expect(command, err).Error().To(MatchError("no command generated"))
+			})
+		})
+	})
+
+	Context("GenerateCommands", func() {
+		var (
+			requested  int32
+			candidates []llm.Candidate
+		)
+
+		BeforeEach(func() {
+			mockResponse = &genai.GenerateContentResponse{
+				Candidates: []*genai.Candidate{
+					{Content: &genai.Content{Parts: []genai.Part{genai.Text(`{"command": "ls -l"}`)}}},
+					{},
+					{Content: &genai.Content{Parts: []genai.Part{genai.Text(`{"command": "ls -la"}`)}}},
+				},
+			}
+		})
+
+		JustBeforeEach(func() {
+			candidates, err = (&llm.GeminiProvider{
+				GenerateCandidates: func(ctx context.Context, n int32, parts ...genai.Part) (*genai.GenerateContentResponse, error) {
+					requested = n
+					return mockResponse, mockError
+				},
+			}).GenerateCommands(context.Background(), logger, "list files", testEnv, 3)
+		})
+
+		When("candidate generation is successful", func() {
+			It("should ask for n candidates", func() {
+				Expect(requested).To(Equal(int32(3)))
+			})
+
+			It("should return every candidate with text", func() {
+				Expect(candidates, err).To(Equal([]llm.Candidate{
+					{Response: llm.Response{Command: "ls -l"}, Votes: 1},
+					{Response: llm.Response{Command: "ls -la"}, Votes: 1},
+				}))
+			})
+		})
+
+		When("candidate generation fails", func() {
+			BeforeEach(func() {
+				mockError = errors.New("API error")
+			})
+
+			It("should return the error", func() {
+				Expect(candidates, err).Error().To(MatchError("API error"))
+			})
+		})
+	})
+})
+
+var _ = Describe("NewGeminiProvider", func() {
+	var (
+		model  *genai.GenerativeModel
+		params llm.GenerationParams
+	)
+
+	BeforeEach(func() {
+		model = &genai.GenerativeModel{}
+		params = llm.GenerationParams{}
+	})
+
+	JustBeforeEach(func() {
+		llm.NewGeminiProvider(model, nil, params)
+	})
+
+	It("asks for a json response", func() {
+		Expect(model.ResponseMIMEType).To(Equal("application/json"))
+	})
+
+	It("leaves the generation config to the API", func() {
+		Expect(model.GenerationConfig).To(Equal(genai.GenerationConfig{ResponseMIMEType: "application/json", ResponseSchema: model.ResponseSchema}))
+	})
+
+	Context("with generation params", func() {
+		var temperature, topP float32
+
+		BeforeEach(func() {
+			temperature, topP = 0, 0.9
+			params = llm.GenerationParams{Temperature: &temperature, TopP: &topP, MaxTokens: 8000, Stop: []string{"END"}}
+		})
+
+		It("sets them on the model", func() {
+			Expect([]any{model.Temperature, model.TopP, *model.MaxOutputTokens, model.StopSequences}).To(Equal([]any{&temperature, &topP, int32(8000), []string{"END"}}))
 		})
 	})
 })
@@ -3,9 +3,61 @@ package llm
 import (
 	"context"
 	"log/slog"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm/prompt"
 )
 
 // LLMProvider defines the interface for a language model provider.
 type LLMProvider interface {
-	GenerateCommand(ctx context.Context, logger *slog.Logger, prompt, shell string) (string, error)
+	GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error)
+}
+
+// TokenFunc receives pieces of the command as they are generated.
+type TokenFunc func(token string)
+
+// StreamingLLMProvider is an LLMProvider that can deliver the command
+// incrementally while the model is still generating it.
+type StreamingLLMProvider interface {
+	LLMProvider
+	StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error)
+}
+
+// StreamCommand streams a command from provider if it supports streaming.
+// Otherwise it falls back to GenerateCommand and delivers the whole command
+// as a single token. Only the command itself is streamed.
+func StreamCommand(ctx context.Context, logger *slog.Logger, provider LLMProvider, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	if sp, ok := provider.(StreamingLLMProvider); ok {
+		return sp.StreamCommand(ctx, logger, prompt, env, onToken)
+	}
+
+	response, err := provider.GenerateCommand(ctx, logger, prompt, env)
+	if err != nil {
+		return Response{}, err
+	}
+	onToken(response.Command)
+	return response, nil
+}
+
+// renderPrompt renders the prompt template for the named provider. The
+// built-in templates are used when templates is nil.
+func renderPrompt(logger *slog.Logger, templates *prompt.Templates, name, userPrompt string, env environment.Environment) (string, error) {
+	if templates == nil {
+		templates = prompt.Default()
+	}
+
+	fullPrompt, err := templates.Render(name, prompt.Data{
+		Environment: env,
+		Prompt:      userPrompt,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	logger.Debug(name+" prompt", "prompt", fullPrompt)
+	return fullPrompt, nil
 }
+
+// discardTokens is a TokenFunc used when a caller is not interested in
+// the streamed output.
+func discardTokens(string) {}
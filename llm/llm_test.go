@@ -0,0 +1,88 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+
+	"github.com/ollama/ollama/api"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm"
+)
+
+// testEnv is the environment passed to providers in tests.
+var testEnv = environment.Environment{OS: "linux", Shell: "bash"}
+
+type fakeProvider struct {
+	command string
+	err     error
+}
+
+func (p *fakeProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (llm.Response, error) {
+	return llm.Response{Command: p.command}, p.err
+}
+
+var _ = Describe("StreamCommand", func() {
+	var (
+		provider llm.LLMProvider
+		logger   *slog.Logger
+		tokens   []string
+		command  llm.Response
+		err      error
+	)
+
+	BeforeEach(func() {
+		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+		tokens = nil
+	})
+
+	JustBeforeEach(func() {
+		command, err = llm.StreamCommand(context.Background(), logger, provider, "list files", testEnv, func(token string) {
+			tokens = append(tokens, token)
+		})
+	})
+
+	Context("when the provider does not support streaming", func() {
+		BeforeEach(func() {
+			provider = &fakeProvider{command: "ls -l"}
+		})
+
+		It("returns the generated command", func() {
+			Expect(command, err).To(Equal(llm.Response{Command: "ls -l"}))
+		})
+
+		It("passes the whole command as a single token", func() {
+			Expect(tokens).To(Equal([]string{"ls -l"}))
+		})
+	})
+
+	Context("when the provider does not support streaming and fails", func() {
+		BeforeEach(func() {
+			provider = &fakeProvider{err: errors.New("provider error")}
+		})
+
+		It("returns the error", func() {
+			Expect(command, err).Error().To(MatchError("provider error"))
+		})
+	})
+
+	Context("when the provider supports streaming", func() {
+		BeforeEach(func() {
+			provider = &llm.OllamaProvider{
+				Generate: func(ctx context.Context, req *api.GenerateRequest, fn api.GenerateResponseFunc) error {
+					_ = fn(api.GenerateResponse{Response: `{"command": "ls`})
+					_ = fn(api.GenerateResponse{Response: ` -l"}`})
+					return nil
+				},
+			}
+		})
+
+		It("streams the command", func() {
+			Expect(tokens).To(Equal([]string{"ls", " -l"}))
+		})
+	})
+})
@@ -3,69 +3,86 @@ package llm
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
 
 	"github.com/ollama/ollama/api"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm/prompt"
 )
 
 // OllamaProvider is an implementation of LLMProvider for Ollama.
 type OllamaProvider struct {
-	Generate func(context.Context, *api.GenerateRequest, api.GenerateResponseFunc) error
-	Model    string
+	Generate  func(context.Context, *api.GenerateRequest, api.GenerateResponseFunc) error
+	Model     string
+	Templates *prompt.Templates
+	Params    GenerationParams
 }
 
-func NewOllamaProvider(client *api.Client, model string) *OllamaProvider {
+func NewOllamaProvider(client *api.Client, model string, templates *prompt.Templates, params GenerationParams) *OllamaProvider {
 	return &OllamaProvider{
-		Generate: client.Generate,
-		Model:    model,
+		Generate:  client.Generate,
+		Model:     model,
+		Templates: templates,
+		Params:    params,
+	}
+}
+
+// ollamaError maps Ollama errors onto the sentinel errors.
+func ollamaError(ctx context.Context, err error) error {
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) {
+		return statusError(ctx, statusErr.StatusCode, err)
 	}
+	return contextError(ctx, err)
 }
 
 // GenerateCommand generates a command using the Ollama LLM.
-func (p *OllamaProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt, shell string) (string, error) {
-	fullPrompt := fmt.Sprintf(`Given the following prompt, generate a single shell command. The command should be able to be executed on a %s machine in a %s shell. The command should be reasonable and not destructive. Return the command in a json object with a single key "command".
+func (p *OllamaProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	return p.StreamCommand(ctx, logger, prompt, env, discardTokens)
+}
 
-Prompt: %s`, os.Getenv("GOOS"), shell, prompt)
-	logger.Debug("ollama prompt", "prompt", fullPrompt)
+// StreamCommand generates a command using the Ollama LLM, passing the command
+// to onToken as it is decoded from the streamed JSON response.
+func (p *OllamaProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	fullPrompt, err := renderPrompt(logger, p.Templates, "ollama", prompt, env)
+	if err != nil {
+		return Response{}, err
+	}
 
 	req := &api.GenerateRequest{
 		Model:  p.Model,
-		Format: json.RawMessage(`"json"`),
+		Format: json.RawMessage(responseSchema),
 		Prompt: fullPrompt,
 	}
-
-	var response string
-	respCh := make(chan *api.GenerateResponse)
-	errCh := make(chan error, 1)
-	go func() {
-		defer close(respCh)
-		defer close(errCh)
-		err := p.Generate(ctx, req, func(r api.GenerateResponse) error {
-			respCh <- &r
-			return nil
-		})
-		if err != nil {
-			errCh <- err
-		}
-	}()
-
-	for resp := range respCh {
-		response += resp.Response
+	options := map[string]any{}
+	p.Params.set(options, paramNames{Temperature: "temperature", TopP: "top_p", MaxTokens: "num_predict", Stop: "stop", Seed: "seed"})
+	if len(options) > 0 {
+		req.Options = options
 	}
 
-	if err := <-errCh; err != nil {
-		return "", err
+	ctx = withRetryAfter(ctx)
+	stream := newJSONFieldStream("command", onToken)
+	var usage Usage
+	err = p.Generate(ctx, req, func(r api.GenerateResponse) error {
+		stream.Write(r.Response)
+		if r.Done {
+			usage = Usage{InputTokens: r.PromptEvalCount, OutputTokens: r.EvalCount}
+		}
+		return nil
+	})
+	if err != nil {
+		return Response{}, ollamaError(ctx, err)
 	}
 
-	var command struct {
-		Command string `json:"command"`
-	}
-	if err := json.Unmarshal([]byte(response), &command); err != nil {
-		return "", fmt.Errorf("failed to unmarshal response from ollama: %w", err)
+	logger.Debug("ollama response", "response", stream.String())
+	response, err := parseResponse(stream.String())
+	response.Usage = usage
+	if err != nil {
+		return response, fmt.Errorf("failed to unmarshal response from ollama: %w", err)
 	}
 
-	logger.Debug("ollama response", "response", command.Command)
-	return command.Command, nil
+	return response, nil
 }
@@ -5,12 +5,16 @@ import (
 	"errors"
 	"io/ioutil"
 	"log/slog"
+	"net/http"
+	"testing/fstest"
 
 	"github.com/ollama/ollama/api"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 
+	"github.com/zombor/gen/environment"
 	"github.com/zombor/gen/llm"
+	"github.com/zombor/gen/llm/prompt"
 )
 
 var _ = Describe("OllamaProvider", func() {
@@ -20,12 +24,16 @@ var _ = Describe("OllamaProvider", func() {
 		mockResponse string
 		mockError    error
 		logger       *slog.Logger
+		templates    *prompt.Templates
+		params       llm.GenerationParams
 	)
 
 	BeforeEach(func() {
 		// Default mock values for successful command generation
-		mockResponse = `{"command": "echo hello"}`
+		mockResponse = `{"command": "echo hello", "explanation": "Prints hello.", "risk": "low", "requires_sudo": false}`
 		mockError = nil
+		templates = nil
+		params = llm.GenerationParams{}
 		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
 
 		generateFunc = func(ctx context.Context, req *api.GenerateRequest, fn api.GenerateResponseFunc) error {
@@ -36,16 +44,99 @@ var _ = Describe("OllamaProvider", func() {
 
 	JustBeforeEach(func() {
 		provider = &llm.OllamaProvider{
-			Generate: generateFunc,
-			Model:    "test-model",
+			Generate:  generateFunc,
+			Model:     "test-model",
+			Templates: templates,
+			Params:    params,
 		}
 	})
 
 	Context("GenerateCommand", func() {
 		When("command generation is successful", func() {
 			It("should return the generated command and no error", func() {
-				command, err := provider.GenerateCommand(context.Background(), logger, "say hello", "bash")
-				Expect([]interface{}{command, err}).To(ConsistOf("echo hello", nil))
+				command, err := provider.GenerateCommand(context.Background(), logger, "say hello", testEnv)
+				Expect(command, err).To(Equal(llm.Response{Command: "echo hello", Explanation: "Prints hello.", Risk: llm.RiskLow}))
+			})
+		})
+
+		When("generation params are set", func() {
+			var options map[string]any
+
+			BeforeEach(func() {
+				temperature, topP, seed := float32(0), float32(0.9), 42
+				params = llm.GenerationParams{Temperature: &temperature, TopP: &topP, MaxTokens: 2000, Stop: []string{"\n\n"}, Seed: &seed}
+				generateFunc = func(ctx context.Context, req *api.GenerateRequest, fn api.GenerateResponseFunc) error {
+					options = req.Options
+					return fn(api.GenerateResponse{Response: mockResponse})
+				}
+			})
+
+			It("should pass them as model options", func() {
+				_, err := provider.GenerateCommand(context.Background(), logger, "say hello", testEnv)
+				Expect(options, err).To(Equal(map[string]any{"temperature": float32(0), "top_p": float32(0.9), "num_predict": 2000, "stop": []string{"\n\n"}, "seed": 42}))
+			})
+		})
+
+		When("no generation params are set", func() {
+			var options map[string]any
+
+			BeforeEach(func() {
+				generateFunc = func(ctx context.Context, req *api.GenerateRequest, fn api.GenerateResponseFunc) error {
+					options = req.Options
+					return fn(api.GenerateResponse{Response: mockResponse})
+				}
+			})
+
+			It("should leave the model options alone", func() {
+				_, err := provider.GenerateCommand(context.Background(), logger, "say hello", testEnv)
+				Expect(options, err).To(BeNil())
+			})
+		})
+
+		When("a thinking model reasons before answering", func() {
+			BeforeEach(func() {
+				mockResponse = "<think>Maybe {\"command\": \"ls\"}?</think>\n" + `{"command": "` + "```bash\\necho hello\\n```" + `", "explanation": "Prints hello.", "risk": "low", "requires_sudo": false}`
+			})
+
+			It("should return the extracted command", func() {
+				command, err := provider.GenerateCommand(context.Background(), logger, "say hello", testEnv)
+				Expect(command, err).To(Equal(llm.Response{Command: "echo hello", Explanation: "Prints hello.", Risk: llm.RiskLow}))
+			})
+		})
+
+		When("the final response reports token counts", func() {
+			BeforeEach(func() {
+				generateFunc = func(ctx context.Context, req *api.GenerateRequest, fn api.GenerateResponseFunc) error {
+					_ = fn(api.GenerateResponse{Response: mockResponse})
+					return fn(api.GenerateResponse{Done: true, Metrics: api.Metrics{PromptEvalCount: 210, EvalCount: 24}})
+				}
+			})
+
+			It("should return the token usage", func() {
+				command, err := provider.GenerateCommand(context.Background(), logger, "say hello", testEnv)
+				Expect(command.Usage, err).To(Equal(llm.Usage{InputTokens: 210, OutputTokens: 24}))
+			})
+		})
+
+		When("an ollama prompt template is configured", func() {
+			var sent string
+
+			BeforeEach(func() {
+				var err error
+				templates, err = prompt.Load(fstest.MapFS{
+					"ollama.tmpl": {Data: []byte("{{.Shell}}: {{.Prompt}}")},
+				})
+				Expect(err).ToNot(HaveOccurred())
+
+				generateFunc = func(ctx context.Context, req *api.GenerateRequest, fn api.GenerateResponseFunc) error {
+					sent = req.Prompt
+					return fn(api.GenerateResponse{Response: mockResponse})
+				}
+			})
+
+			It("should send the rendered template", func() {
+				_, err := provider.GenerateCommand(context.Background(), logger, "say hello", environment.Environment{OS: "linux", Shell: "zsh"})
+				Expect(sent, err).To(Equal("zsh: say hello"))
 			})
 		})
 
@@ -56,8 +147,20 @@ var _ = Describe("OllamaProvider", func() {
 			})
 
 			It("should return an ollama error and empty command", func() {
-				command, err := provider.GenerateCommand(context.Background(), logger, "say hello", "bash")
-				Expect([]interface{}{command, err}).To(ConsistOf("", errors.New("ollama error")))
+				command, err := provider.GenerateCommand(context.Background(), logger, "say hello", testEnv)
+				Expect(command, err).Error().To(MatchError("ollama error"))
+			})
+		})
+
+		When("the model is not installed", func() {
+			BeforeEach(func() {
+				mockResponse = ""
+				mockError = api.StatusError{StatusCode: http.StatusNotFound, ErrorMessage: `model "llama3" not found, try pulling it first`}
+			})
+
+			It("should return a model not found error", func() {
+				_, err := provider.GenerateCommand(context.Background(), logger, "say hello", testEnv)
+				Expect(err).To(MatchError(llm.ErrModelNotFound))
 			})
 		})
 
@@ -66,11 +169,81 @@ var _ = Describe("OllamaProvider", func() {
 				mockResponse = "invalid json"
 			})
 
-			It("should return an unmarshal error and empty command", func() {
-				command, err := provider.GenerateCommand(context.Background(), logger, "say hello", "bash")
-				Expect(command).To(BeEmpty())
+			It("should return an unmarshal error", func() {
+				_, err := provider.GenerateCommand(context.Background(), logger, "say hello", testEnv)
 				Expect(err).To(HaveOccurred())
 			})
+
+			It("should return an empty command", func() {
+				command, _ := provider.GenerateCommand(context.Background(), logger, "say hello", testEnv)
+				Expect(command).To(BeZero())
+			})
+		})
+	})
+
+	Context("StreamCommand", func() {
+		var (
+			chunks  []string
+			tokens  []string
+			command llm.Response
+			err     error
+		)
+
+		BeforeEach(func() {
+			chunks = []string{`{"comm`, `and": "echo \"hi`, `\" \u00`, `e9"}`}
+			tokens = nil
+			generateFunc = func(ctx context.Context, req *api.GenerateRequest, fn api.GenerateResponseFunc) error {
+				for _, chunk := range chunks {
+					_ = fn(api.GenerateResponse{Response: chunk})
+				}
+				return mockError
+			}
+		})
+
+		JustBeforeEach(func() {
+			command, err = provider.StreamCommand(context.Background(), logger, "say hi", testEnv, func(token string) {
+				tokens = append(tokens, token)
+			})
+		})
+
+		When("the stream is successful", func() {
+			It("should return the decoded command", func() {
+				Expect(command, err).To(Equal(llm.Response{Command: `echo "hi" é`}))
+			})
+
+			It("should pass the decoded command to the token func as it arrives", func() {
+				Expect(tokens).To(Equal([]string{`echo "hi`, `" `, `é`}))
+			})
+		})
+
+		When("the command contains an escaped emoji", func() {
+			BeforeEach(func() {
+				chunks = []string{`{"command": "echo \ud83d`, `\ude`, `00"}`}
+			})
+
+			It("should pass the emoji to the token func once both halves arrive", func() {
+				Expect(tokens).To(Equal([]string{"echo ", "😀"}))
+			})
+		})
+
+		When("the command contains a lone surrogate", func() {
+			BeforeEach(func() {
+				chunks = []string{`{"command": "echo \ud83d!`, `!"}`}
+			})
+
+			It("should pass a replacement character to the token func", func() {
+				Expect(tokens).To(Equal([]string{"echo \ufffd!", "!"}))
+			})
+		})
+
+		When("generate fails", func() {
+			BeforeEach(func() {
+				mockError = errors.New("ollama error")
+			})
+
+			It("should return the ollama error", func() {
+				Expect(command, err).Error().To(MatchError("ollama error"))
+			})
 		})
 	})
 })
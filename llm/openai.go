@@ -2,46 +2,315 @@ package llm
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
+	"errors"
+	"io"
 	"log/slog"
-	"os"
+	"math"
+	"net/http"
+	"strings"
 
 	openai "github.com/sashabaranov/go-openai"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm/prompt"
 )
 
+// OpenAIChatStream is the subset of *openai.ChatCompletionStream used by
+// OpenAIProvider.
+type OpenAIChatStream interface {
+	Recv() (openai.ChatCompletionStreamResponse, error)
+	Close() error
+}
+
 // OpenAIProvider is an implementation of LLMProvider for OpenAI.
 type OpenAIProvider struct {
-	CreateChatCompletion func(context.Context, openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
-	Model                string
+	CreateChatCompletion       func(context.Context, openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+	CreateChatCompletionStream func(context.Context, openai.ChatCompletionRequest) (OpenAIChatStream, error)
+	Model                      string
+	Templates                  *prompt.Templates
+	Params                     GenerationParams
+	Compatibility              OpenAICompatibility
 }
 
-// GenerateCommand generates a command using the OpenAI LLM.
-func (p *OpenAIProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt, shell string) (string, error) {
-	fullPrompt := fmt.Sprintf(`Given the following prompt, generate a single shell command. The command should be able to be executed on a %s machine in a %s shell. The command should be reasonable and not destructive. Return only the command, with no explanation or other text.
-
-Prompt: %s`, os.Getenv("GOOS"), shell, prompt)
-	logger.Debug("openai prompt", "prompt", fullPrompt)
-
-	resp, err := p.CreateChatCompletion(
-		ctx,
-		openai.ChatCompletionRequest{
-			Model: p.Model,
-			Messages: []openai.ChatCompletionMessage{
-				{
-					Role:    openai.ChatMessageRoleUser,
-					Content: fullPrompt,
-				},
+// OpenAICompatibility turns off the parts of the OpenAI API that an
+// OpenAI-compatible server may not support. The zero value uses all of
+// them.
+type OpenAICompatibility struct {
+	// ResponseFormat is the response_format asked for: a JSON schema, by
+	// default, a JSON object, or text, in which case no response_format is
+	// sent and the command may be answered in plain text.
+	ResponseFormat openai.ChatCompletionResponseFormatType
+	// NotStrict asks for the JSON schema without strict adherence to it.
+	NotStrict bool
+	// MaxTokens sends the longest answer as max_tokens, which servers that
+	// predate max_completion_tokens understand, instead.
+	MaxTokens bool
+	// NoStreamUsage does not ask for the token usage at the end of a
+	// stream.
+	NoStreamUsage bool
+}
+
+// OpenAIClientConfig returns the client configuration for the OpenAI API or,
+// when baseURL is set, an OpenAI-compatible server such as vLLM, llama.cpp,
+// LM Studio or OpenRouter. headers are added to every request.
+func OpenAIClientConfig(apiKey, baseURL, organization string, headers map[string]string) openai.ClientConfig {
+	config := openai.DefaultConfig(apiKey)
+	if baseURL != "" {
+		config.BaseURL = strings.TrimRight(baseURL, "/")
+	}
+	config.OrgID = organization
+
+	var transport http.RoundTripper = http.DefaultTransport
+	if len(headers) > 0 {
+		transport = &headerTransport{headers: headers, base: transport}
+	}
+	config.HTTPClient = &http.Client{Transport: &RetryAfterTransport{Base: transport}}
+	return config
+}
+
+// headerTransport adds a fixed set of headers to every request.
+type headerTransport struct {
+	headers map[string]string
+	base    http.RoundTripper
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for name, value := range t.headers {
+		req.Header.Set(name, value)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// openAIError maps go-openai errors onto the sentinel errors.
+func openAIError(ctx context.Context, err error) error {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		code, _ := apiErr.Code.(string)
+		switch {
+		case code == "insufficient_quota":
+			return providerError(ctx, ErrQuotaExceeded, err)
+		case code == "model_not_found", code == "DeploymentNotFound":
+			return providerError(ctx, ErrModelNotFound, err)
+		case code == "content_filter", apiErr.InnerError != nil && apiErr.InnerError.Code == "ResponsibleAIPolicyViolation":
+			return providerError(ctx, ErrContentFiltered, err)
+		}
+		return statusError(ctx, apiErr.HTTPStatusCode, err)
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return statusError(ctx, reqErr.HTTPStatusCode, err)
+	}
+
+	return contextError(ctx, err)
+}
+
+// openAIFilterError returns ErrContentFiltered when the model refused to
+// answer or its answer was filtered.
+func openAIFilterError(ctx context.Context, finishReason openai.FinishReason, refusal string) error {
+	switch {
+	case refusal != "":
+		return providerError(ctx, ErrContentFiltered, errors.New(refusal))
+	case finishReason == openai.FinishReasonContentFilter:
+		return providerError(ctx, ErrContentFiltered, errors.New("the response was filtered by the provider"))
+	}
+	return nil
+}
+
+func NewOpenAIProvider(client *openai.Client, model string, templates *prompt.Templates, params GenerationParams) *OpenAIProvider {
+	return &OpenAIProvider{
+		CreateChatCompletion: client.CreateChatCompletion,
+		CreateChatCompletionStream: func(ctx context.Context, req openai.ChatCompletionRequest) (OpenAIChatStream, error) {
+			stream, err := client.CreateChatCompletionStream(ctx, req)
+			if err != nil {
+				return nil, err
+			}
+			return stream, nil
+		},
+		Model:     model,
+		Templates: templates,
+		Params:    params,
+	}
+}
+
+func (p *OpenAIProvider) request(logger *slog.Logger, prompt string, env environment.Environment) (openai.ChatCompletionRequest, error) {
+	fullPrompt, err := renderPrompt(logger, p.Templates, "openai", prompt, env)
+	if err != nil {
+		return openai.ChatCompletionRequest{}, err
+	}
+
+	req := openai.ChatCompletionRequest{
+		Model: p.Model,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: fullPrompt,
 			},
 		},
-	)
+		Stop: p.Params.Stop,
+		Seed: p.Params.Seed,
+	}
+	switch p.Compatibility.ResponseFormat {
+	case "", openai.ChatCompletionResponseFormatTypeJSONSchema:
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{
+			Type: openai.ChatCompletionResponseFormatTypeJSONSchema,
+			JSONSchema: &openai.ChatCompletionResponseFormatJSONSchema{
+				Name:        responseToolName,
+				Description: responseToolDescription,
+				Schema:      json.RawMessage(responseSchema),
+				Strict:      !p.Compatibility.NotStrict,
+			},
+		}
+	case openai.ChatCompletionResponseFormatTypeJSONObject:
+		req.ResponseFormat = &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}
+	}
+	if p.Compatibility.MaxTokens {
+		req.MaxTokens = p.Params.MaxTokens
+	} else {
+		req.MaxCompletionTokens = p.Params.MaxTokens
+	}
+	// The client tags Temperature and TopP with omitempty, so a zero would
+	// be left out of the request and the API would use its default of 1
+	// instead. The smallest float32 above zero is sent in its place: it
+	// makes sampling as greedy as zero does.
+	if p.Params.Temperature != nil {
+		req.Temperature = *p.Params.Temperature
+		if req.Temperature == 0 {
+			req.Temperature = math.SmallestNonzeroFloat32
+		}
+	}
+	if p.Params.TopP != nil {
+		req.TopP = *p.Params.TopP
+		if req.TopP == 0 {
+			req.TopP = math.SmallestNonzeroFloat32
+		}
+	}
+	return req, nil
+}
+
+// parse decodes the content of an answer. Without a response_format, a model
+// may answer with the bare command.
+func (p *OpenAIProvider) parse(content string) (Response, error) {
+	if p.Compatibility.ResponseFormat == openai.ChatCompletionResponseFormatTypeText {
+		return parseResponseOrText(content), nil
+	}
+	return parseResponse(content)
+}
+
+// GenerateCommand generates a command using the OpenAI LLM.
+func (p *OpenAIProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	req, err := p.request(logger, prompt, env)
 	if err != nil {
-		return "", err
+		return Response{}, err
+	}
+
+	ctx = withRetryAfter(ctx)
+	resp, err := p.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return Response{}, openAIError(ctx, err)
 	}
 
 	if len(resp.Choices) > 0 {
-		logger.Debug("openai response", "response", resp.Choices[0].Message.Content)
-		return resp.Choices[0].Message.Content, nil
+		choice := resp.Choices[0]
+		logger.Debug("openai response", "response", choice.Message.Content)
+		if err := openAIFilterError(ctx, choice.FinishReason, choice.Message.Refusal); err != nil {
+			return Response{}, err
+		}
+		response, err := p.parse(choice.Message.Content)
+		response.Usage = openAIUsage(&resp.Usage)
+		return response, err
+	}
+
+	return Response{}, emptyResponse()
+}
+
+// GenerateCommands generates n alternative commands in a single OpenAI request.
+func (p *OpenAIProvider) GenerateCommands(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, n int) ([]Candidate, error) {
+	req, err := p.request(logger, prompt, env)
+	if err != nil {
+		return nil, err
+	}
+	req.N = n
+
+	ctx = withRetryAfter(ctx)
+	resp, err := p.CreateChatCompletion(ctx, req)
+	if err != nil {
+		return nil, openAIError(ctx, err)
+	}
+
+	var responses []Response
+	for _, choice := range resp.Choices {
+		logger.Debug("openai response", "response", choice.Message.Content)
+		if response, err := p.parse(choice.Message.Content); err == nil {
+			responses = append(responses, response)
+		}
 	}
 
-	return "", fmt.Errorf("no command generated")
+	return rankCandidates(responses, openAIUsage(&resp.Usage))
+}
+
+// StreamCommand generates a command using the OpenAI LLM, passing the command
+// to onToken as it is decoded from the streamed JSON response.
+func (p *OpenAIProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	req, err := p.request(logger, prompt, env)
+	if err != nil {
+		return Response{}, err
+	}
+
+	if !p.Compatibility.NoStreamUsage {
+		req.StreamOptions = &openai.StreamOptions{IncludeUsage: true}
+	}
+
+	ctx = withRetryAfter(ctx)
+	stream, err := p.CreateChatCompletionStream(ctx, req)
+	if err != nil {
+		return Response{}, openAIError(ctx, err)
+	}
+	defer stream.Close()
+
+	content := newJSONFieldStream("command", onToken)
+	var (
+		refusal      strings.Builder
+		finishReason openai.FinishReason
+		usage        Usage
+	)
+	for {
+		resp, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return Response{}, openAIError(ctx, err)
+		}
+		if resp.Usage != nil {
+			usage = openAIUsage(resp.Usage)
+		}
+		if len(resp.Choices) > 0 {
+			choice := resp.Choices[0]
+			content.Write(choice.Delta.Content)
+			refusal.WriteString(choice.Delta.Refusal)
+			if choice.FinishReason != "" {
+				finishReason = choice.FinishReason
+			}
+		}
+	}
+
+	if err := openAIFilterError(ctx, finishReason, refusal.String()); err != nil {
+		return Response{}, err
+	}
+
+	if content.String() == "" {
+		return Response{}, emptyResponse()
+	}
+
+	logger.Debug("openai response", "response", content.String())
+	response, err := p.parse(content.String())
+	response.Usage = usage
+	return response, err
+}
+
+func openAIUsage(usage *openai.Usage) Usage {
+	return Usage{InputTokens: usage.PromptTokens, OutputTokens: usage.CompletionTokens}
 }
@@ -2,9 +2,16 @@ package llm_test
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"time"
 
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -14,10 +21,43 @@ import (
 	"github.com/zombor/gen/llm"
 )
 
+type fakeOpenAIChatStream struct {
+	responses []openai.ChatCompletionStreamResponse
+	err       error
+	closed    bool
+}
+
+func (s *fakeOpenAIChatStream) Recv() (openai.ChatCompletionStreamResponse, error) {
+	if len(s.responses) == 0 {
+		if s.err != nil {
+			return openai.ChatCompletionStreamResponse{}, s.err
+		}
+		return openai.ChatCompletionStreamResponse{}, io.EOF
+	}
+	resp := s.responses[0]
+	s.responses = s.responses[1:]
+	return resp, nil
+}
+
+func (s *fakeOpenAIChatStream) Close() error {
+	s.closed = true
+	return nil
+}
+
+func openAIDelta(content string) openai.ChatCompletionStreamResponse {
+	return openai.ChatCompletionStreamResponse{
+		Choices: []openai.ChatCompletionStreamChoice{
+			{Delta: openai.ChatCompletionStreamChoiceDelta{Content: content}},
+		},
+	}
+}
+
 var _ = Describe("OpenAIProvider", func() {
 	var (
 		provider                 *llm.OpenAIProvider
 		mockCreateChatCompletion func(context.Context, openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error)
+		params                   llm.GenerationParams
+		compatibility            llm.OpenAICompatibility
 		logger                   *slog.Logger
 	)
 
@@ -27,13 +67,15 @@ var _ = Describe("OpenAIProvider", func() {
 					Choices: []openai.ChatCompletionChoice{
 						{
 							Message: openai.ChatCompletionMessage{
-								Content: "ls -l",
+								Content: `{"command": "ls -l", "explanation": "Lists files.", "risk": "low", "requires_sudo": false}`,
 							},
 						},
 					},
 				},
 				nil
 		}
+		params = llm.GenerationParams{}
+		compatibility = llm.OpenAICompatibility{}
 		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
 	})
 
@@ -41,18 +83,148 @@ var _ = Describe("OpenAIProvider", func() {
 		provider = &llm.OpenAIProvider{
 			CreateChatCompletion: mockCreateChatCompletion,
 			Model:                "gpt-3.5-turbo",
+			Params:               params,
+			Compatibility:        compatibility,
 		}
 	})
 
 	Describe("GenerateCommand", func() {
 		Context("when the OpenAI API call is successful", func() {
 			It("returns the generated command", func() {
-				command, err := provider.GenerateCommand(context.Background(), logger, "list files", "bash")
-				Expect(command).To(Equal("ls -l"))
+				command, err := provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(command).To(Equal(llm.Response{Command: "ls -l", Explanation: "Lists files.", Risk: llm.RiskLow}))
 				Expect(err).ToNot(HaveOccurred())
 			})
 		})
 
+		Context("when the request is sent", func() {
+			var request openai.ChatCompletionRequest
+
+			BeforeEach(func() {
+				mockCreateChatCompletion = func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+					request = req
+					return openai.ChatCompletionResponse{}, nil
+				}
+			})
+
+			It("asks for a json schema response", func() {
+				_, _ = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(request.ResponseFormat.Type).To(Equal(openai.ChatCompletionResponseFormatTypeJSONSchema))
+			})
+
+			It("asks for the schema to be followed strictly", func() {
+				_, _ = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(request.ResponseFormat.JSONSchema.Strict).To(BeTrue())
+			})
+
+			Context("for a server without strict schemas", func() {
+				BeforeEach(func() {
+					compatibility = llm.OpenAICompatibility{NotStrict: true}
+				})
+
+				It("does not ask for the schema to be followed strictly", func() {
+					_, _ = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+					Expect(request.ResponseFormat.JSONSchema.Strict).To(BeFalse())
+				})
+			})
+
+			Context("for a server without json schemas", func() {
+				BeforeEach(func() {
+					compatibility = llm.OpenAICompatibility{ResponseFormat: openai.ChatCompletionResponseFormatTypeJSONObject}
+				})
+
+				It("asks for a json object", func() {
+					_, _ = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+					Expect(request.ResponseFormat).To(Equal(&openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject}))
+				})
+			})
+
+			Context("for a server without response formats", func() {
+				BeforeEach(func() {
+					compatibility = llm.OpenAICompatibility{ResponseFormat: openai.ChatCompletionResponseFormatTypeText}
+				})
+
+				It("does not send one", func() {
+					_, _ = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+					Expect(request.ResponseFormat).To(BeNil())
+				})
+			})
+
+			Context("for a server that predates max_completion_tokens", func() {
+				BeforeEach(func() {
+					params = llm.GenerationParams{MaxTokens: 4000}
+					compatibility = llm.OpenAICompatibility{MaxTokens: true}
+				})
+
+				It("sends the longest answer as max_tokens", func() {
+					_, _ = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+					Expect([]int{request.MaxTokens, request.MaxCompletionTokens}).To(Equal([]int{4000, 0}))
+				})
+			})
+
+			It("leaves the generation params to the API", func() {
+				_, _ = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect([]any{request.Temperature, request.TopP, request.MaxCompletionTokens, request.Stop, request.Seed}).To(Equal([]any{float32(0), float32(0), 0, []string(nil), (*int)(nil)}))
+			})
+
+			Context("with generation params", func() {
+				var seed int
+
+				BeforeEach(func() {
+					temperature, topP := float32(0.2), float32(0.9)
+					seed = 42
+					params = llm.GenerationParams{Temperature: &temperature, TopP: &topP, MaxTokens: 4000, Stop: []string{"END"}, Seed: &seed}
+				})
+
+				It("sets them on the request", func() {
+					_, _ = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+					Expect([]any{request.Temperature, request.TopP, request.MaxCompletionTokens, request.Stop, request.Seed}).To(Equal([]any{float32(0.2), float32(0.9), 4000, []string{"END"}, &seed}))
+				})
+			})
+
+			Context("with a temperature of zero", func() {
+				BeforeEach(func() {
+					temperature := float32(0)
+					params = llm.GenerationParams{Temperature: &temperature, TopP: &temperature}
+				})
+
+				It("sends a temperature that is not omitted from the request", func() {
+					_, _ = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+					Expect([]float32{request.Temperature, request.TopP}).To(Equal([]float32{math.SmallestNonzeroFloat32, math.SmallestNonzeroFloat32}))
+				})
+			})
+		})
+
+		Context("when a server without response formats answers with the bare command", func() {
+			BeforeEach(func() {
+				compatibility = llm.OpenAICompatibility{ResponseFormat: openai.ChatCompletionResponseFormatTypeText}
+				mockCreateChatCompletion = func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+					return openai.ChatCompletionResponse{Choices: []openai.ChatCompletionChoice{{Message: openai.ChatCompletionMessage{Content: "ls -l\n"}}}}, nil
+				}
+			})
+
+			It("uses it as the command", func() {
+				Expect(provider.GenerateCommand(context.Background(), logger, "list files", testEnv)).To(Equal(llm.Response{Command: "ls -l"}))
+			})
+		})
+
+		Context("when the response is not json", func() {
+			BeforeEach(func() {
+				mockCreateChatCompletion = func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+					return openai.ChatCompletionResponse{
+						Choices: []openai.ChatCompletionChoice{
+							{Message: openai.ChatCompletionMessage{Content: "ls -l"}},
+						},
+					}, nil
+				}
+			})
+
+			It("returns an error", func() {
+				command, err := provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(command, err).Error().To(HaveOccurred())
+			})
+		})
+
 		Context("when the OpenAI API call returns an error", func() {
 			BeforeEach(func() {
 				mockCreateChatCompletion = func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
@@ -61,12 +233,60 @@ var _ = Describe("OpenAIProvider", func() {
 			})
 
 			It("returns an error", func() {
-				command, err := provider.GenerateCommand(context.Background(), logger, "list files", "bash")
-				Expect(command).To(BeEmpty())
+				command, err := provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(command).To(BeZero())
 				Expect(err).To(MatchError("API error"))
 			})
 		})
 
+		Context("when the API reports usage", func() {
+			BeforeEach(func() {
+				mockCreateChatCompletion = func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+					return openai.ChatCompletionResponse{
+						Choices: []openai.ChatCompletionChoice{
+							{Message: openai.ChatCompletionMessage{Content: `{"command": "ls -l"}`}},
+						},
+						Usage: openai.Usage{PromptTokens: 200, CompletionTokens: 35},
+					}, nil
+				}
+			})
+
+			It("returns the token usage", func() {
+				command, err := provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(command.Usage, err).To(Equal(llm.Usage{InputTokens: 200, OutputTokens: 35}))
+			})
+		})
+
+		Context("when the account is out of quota", func() {
+			BeforeEach(func() {
+				mockCreateChatCompletion = func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+					return openai.ChatCompletionResponse{}, &openai.APIError{Code: "insufficient_quota", HTTPStatusCode: http.StatusTooManyRequests}
+				}
+			})
+
+			It("returns a quota error", func() {
+				_, err := provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(err).To(MatchError(llm.ErrQuotaExceeded))
+			})
+		})
+
+		Context("when the model refuses", func() {
+			BeforeEach(func() {
+				mockCreateChatCompletion = func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+					return openai.ChatCompletionResponse{
+						Choices: []openai.ChatCompletionChoice{
+							{Message: openai.ChatCompletionMessage{Refusal: "I can't help with that."}},
+						},
+					}, nil
+				}
+			})
+
+			It("returns a content filtered error", func() {
+				_, err := provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(err).To(MatchError(llm.ErrContentFiltered))
+			})
+		})
+
 		Context("when no command is generated", func() {
 			BeforeEach(func() {
 				mockCreateChatCompletion = func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
@@ -76,10 +296,284 @@ var _ = Describe("OpenAIProvider", func() {
 			})
 
 			It("returns an error", func() {
-				command, err := provider.GenerateCommand(context.Background(), logger, "list files", "bash")
-				Expect(command).To(BeEmpty())
+				command, err := provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+				Expect(command).To(BeZero())
 				Expect(err).To(MatchError("no command generated"))
 			})
 		})
 	})
+
+	Describe("StreamCommand", func() {
+		var (
+			stream    *fakeOpenAIChatStream
+			streamErr error
+			request   openai.ChatCompletionRequest
+			tokens    []string
+			command   llm.Response
+			err       error
+		)
+
+		BeforeEach(func() {
+			stream = &fakeOpenAIChatStream{
+				responses: []openai.ChatCompletionStreamResponse{
+					openAIDelta(`{"command": "ls`),
+					{},
+					openAIDelta(` -l", "explanation": "Lists files.",`),
+					openAIDelta(` "risk": "low", "requires_sudo": false}`),
+				},
+			}
+			streamErr = nil
+			tokens = nil
+		})
+
+		JustBeforeEach(func() {
+			provider.CreateChatCompletionStream = func(ctx context.Context, req openai.ChatCompletionRequest) (llm.OpenAIChatStream, error) {
+				request = req
+				return stream, streamErr
+			}
+			command, err = provider.StreamCommand(context.Background(), logger, "list files", testEnv, func(token string) {
+				tokens = append(tokens, token)
+			})
+		})
+
+		Context("when the stream is successful", func() {
+			It("returns the full response", func() {
+				Expect(command, err).To(Equal(llm.Response{Command: "ls -l", Explanation: "Lists files.", Risk: llm.RiskLow}))
+			})
+
+			It("passes the command to the token func as it is decoded", func() {
+				Expect(tokens).To(Equal([]string{"ls", " -l"}))
+			})
+
+			It("closes the stream", func() {
+				Expect(stream.closed).To(BeTrue())
+			})
+
+			It("asks for the token usage", func() {
+				Expect(request.StreamOptions).To(Equal(&openai.StreamOptions{IncludeUsage: true}))
+			})
+		})
+
+		Context("for a server without stream usage", func() {
+			BeforeEach(func() {
+				compatibility = llm.OpenAICompatibility{NoStreamUsage: true}
+			})
+
+			It("does not ask for the token usage", func() {
+				Expect(request.StreamOptions).To(BeNil())
+			})
+		})
+
+		Context("when the stream cannot be created", func() {
+			BeforeEach(func() {
+				streamErr = errors.New("API error")
+			})
+
+			It("returns an error", func() {
+				Expect(command, err).Error().To(MatchError("API error"))
+			})
+		})
+
+		Context("when the last chunk reports usage", func() {
+			BeforeEach(func() {
+				stream.responses = append(stream.responses, openai.ChatCompletionStreamResponse{
+					Usage: &openai.Usage{PromptTokens: 200, CompletionTokens: 35},
+				})
+			})
+
+			It("returns the token usage", func() {
+				Expect(command.Usage, err).To(Equal(llm.Usage{InputTokens: 200, OutputTokens: 35}))
+			})
+		})
+
+		Context("when the stream fails part way", func() {
+			BeforeEach(func() {
+				stream.err = errors.New("stream error")
+			})
+
+			It("returns an error", func() {
+				Expect(command, err).Error().To(MatchError("stream error"))
+			})
+		})
+
+		Context("when no command is generated", func() {
+			BeforeEach(func() {
+				stream.responses = nil
+			})
+
+			It("returns an error", func() {
+				Expect(command, err).Error().To(MatchError("no command generated"))
+			})
+		})
+	})
+
+	Describe("GenerateCommands", func() {
+		Context("when the OpenAI API call returns an error", func() {
+			BeforeEach(func() {
+				mockCreateChatCompletion = func(ctx context.Context, req openai.ChatCompletionRequest) (openai.ChatCompletionResponse, error) {
+					return openai.ChatCompletionResponse{}, errors.New("API error")
+				}
+			})
+
+			It("returns an error", func() {
+				candidates, err := provider.GenerateCommands(context.Background(), logger, "list files", testEnv, 2)
+				Expect(candidates, err).Error().To(MatchError("API error"))
+			})
+		})
+	})
+})
+
+var _ = Describe("OpenAIProvider with an OpenAI-compatible server", func() {
+	const reply = `{"command": "ls -l", "explanation": "Lists files.", "risk": "low", "requires_sudo": false}`
+
+	var (
+		server   *httptest.Server
+		requests []*http.Request
+		bodies   []map[string]any
+		provider *llm.OpenAIProvider
+		logger   *slog.Logger
+	)
+
+	BeforeEach(func() {
+		requests, bodies = nil, nil
+		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]any
+			Expect(json.NewDecoder(r.Body).Decode(&body)).To(Succeed())
+			requests = append(requests, r)
+			bodies = append(bodies, body)
+
+			if body["stream"] == true {
+				w.Header().Set("Content-Type", "text/event-stream")
+				for _, chunk := range []string{reply[:15], reply[15:]} {
+					data, _ := json.Marshal(map[string]any{
+						"choices": []any{map[string]any{"index": 0, "delta": map[string]any{"content": chunk}}},
+					})
+					fmt.Fprintf(w, "data: %s\n\n", data)
+				}
+				fmt.Fprint(w, "data: [DONE]\n\n")
+				return
+			}
+
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(map[string]any{
+				"choices": []any{map[string]any{"index": 0, "message": map[string]any{"role": "assistant", "content": reply}}},
+			})
+		}))
+
+		config := llm.OpenAIClientConfig("test-key", server.URL+"/v1/", "org-123", map[string]string{"X-Title": "gen"})
+		provider = llm.NewOpenAIProvider(openai.NewClientWithConfig(config), "local-model", nil, llm.GenerationParams{})
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	Describe("GenerateCommand", func() {
+		var (
+			command llm.Response
+			err     error
+		)
+
+		JustBeforeEach(func() {
+			command, err = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+		})
+
+		It("returns the command from the server", func() {
+			Expect(command, err).To(Equal(llm.Response{Command: "ls -l", Explanation: "Lists files.", Risk: llm.RiskLow}))
+		})
+
+		It("sends one request", func() {
+			Expect(requests).To(HaveLen(1))
+		})
+
+		It("sends the chat completion to the base URL", func() {
+			Expect(requests[0].URL.Path).To(Equal("/v1/chat/completions"))
+		})
+
+		It("authorizes with the api key", func() {
+			Expect(requests[0].Header.Get("Authorization")).To(Equal("Bearer test-key"))
+		})
+
+		It("sends the organization", func() {
+			Expect(requests[0].Header.Get("OpenAI-Organization")).To(Equal("org-123"))
+		})
+
+		It("sends the configured headers", func() {
+			Expect(requests[0].Header.Get("X-Title")).To(Equal("gen"))
+		})
+
+		It("asks for the configured model", func() {
+			Expect(bodies[0]["model"]).To(Equal("local-model"))
+		})
+	})
+
+	Describe("StreamCommand", func() {
+		var (
+			command llm.Response
+			tokens  []string
+			err     error
+		)
+
+		BeforeEach(func() {
+			tokens = nil
+		})
+
+		JustBeforeEach(func() {
+			command, err = provider.StreamCommand(context.Background(), logger, "list files", testEnv, func(token string) {
+				tokens = append(tokens, token)
+			})
+		})
+
+		It("streams the command from the server", func() {
+			Expect(command, err).To(Equal(llm.Response{Command: "ls -l", Explanation: "Lists files.", Risk: llm.RiskLow}))
+		})
+
+		It("passes the command to the token func as it arrives", func() {
+			Expect(tokens).To(Equal([]string{"ls", " -l"}))
+		})
+
+		It("sends the configured headers", func() {
+			Expect(requests[0].Header.Get("X-Title")).To(Equal("gen"))
+		})
+	})
+})
+
+var _ = Describe("OpenAIProvider when the server rate limits", func() {
+	var (
+		server *httptest.Server
+		err    error
+	)
+
+	BeforeEach(func() {
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Retry-After", "7")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprint(w, `{"error": {"message": "Rate limit reached", "type": "requests", "code": "rate_limit_exceeded"}}`)
+		}))
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	JustBeforeEach(func() {
+		config := llm.OpenAIClientConfig("test-key", server.URL+"/v1", "", nil)
+		provider := llm.NewOpenAIProvider(openai.NewClientWithConfig(config), "gpt-4o", nil, llm.GenerationParams{})
+		_, err = provider.GenerateCommand(context.Background(), slog.New(slog.NewJSONHandler(ioutil.Discard, nil)), "list files", testEnv)
+	})
+
+	It("returns a rate limited error", func() {
+		Expect(err).To(MatchError(llm.ErrRateLimited))
+	})
+
+	It("records the Retry-After", func() {
+		Expect(err).To(WithTransform(func(err error) time.Duration {
+			var providerErr *llm.ProviderError
+			errors.As(err, &providerErr)
+			return providerErr.RetryAfter
+		}, Equal(7*time.Second)))
+	})
 })
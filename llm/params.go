@@ -0,0 +1,74 @@
+package llm
+
+// GenerationParams tune how a model samples its answer. Unset fields leave the
+// provider's default in place. Providers ignore the parameters their API does
+// not support: only OpenAI and Ollama take a Seed.
+type GenerationParams struct {
+	// Temperature controls the randomness of the answer. Zero, together with
+	// a Seed, gives the most repeatable answers.
+	Temperature *float32
+	// TopP limits sampling to the most likely tokens whose probabilities add
+	// up to TopP.
+	TopP *float32
+	// MaxTokens bounds the length of the answer. Reasoning models count their
+	// reasoning against it.
+	MaxTokens int
+	// Stop lists sequences that end the answer.
+	Stop []string
+	// Seed makes sampling deterministic on the providers that support it.
+	Seed *int
+}
+
+// maxTokens returns MaxTokens, or def when it is not set.
+func (p GenerationParams) maxTokens(def int) int {
+	if p.MaxTokens > 0 {
+		return p.MaxTokens
+	}
+	return def
+}
+
+// paramNames names the parameters of GenerationParams in a model's JSON
+// request. A name left empty means the model does not support the parameter.
+type paramNames struct {
+	Temperature, TopP, MaxTokens, Stop, Seed string
+}
+
+// set adds the parameters that are set to options under their names.
+func (p GenerationParams) set(options map[string]any, names paramNames) {
+	if p.Temperature != nil && names.Temperature != "" {
+		options[names.Temperature] = *p.Temperature
+	}
+	if p.TopP != nil && names.TopP != "" {
+		options[names.TopP] = *p.TopP
+	}
+	if p.MaxTokens > 0 && names.MaxTokens != "" {
+		options[names.MaxTokens] = p.MaxTokens
+	}
+	if len(p.Stop) > 0 && names.Stop != "" {
+		options[names.Stop] = p.Stop
+	}
+	if p.Seed != nil && names.Seed != "" {
+		options[names.Seed] = *p.Seed
+	}
+}
+
+// With returns p with the parameters that are set in override in place of
+// its own.
+func (p GenerationParams) With(override GenerationParams) GenerationParams {
+	if override.Temperature != nil {
+		p.Temperature = override.Temperature
+	}
+	if override.TopP != nil {
+		p.TopP = override.TopP
+	}
+	if override.MaxTokens != 0 {
+		p.MaxTokens = override.MaxTokens
+	}
+	if len(override.Stop) > 0 {
+		p.Stop = override.Stop
+	}
+	if override.Seed != nil {
+		p.Seed = override.Seed
+	}
+	return p
+}
@@ -0,0 +1,44 @@
+package llm_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/llm"
+)
+
+var _ = Describe("GenerationParams", func() {
+	Describe("With", func() {
+		var (
+			low, high float32
+			seed      int
+			params    llm.GenerationParams
+			override  llm.GenerationParams
+			merged    llm.GenerationParams
+		)
+
+		BeforeEach(func() {
+			low, high, seed = 0.2, 0.9, 42
+			params = llm.GenerationParams{Temperature: &high, TopP: &high, MaxTokens: 4000, Stop: []string{"END"}}
+			override = llm.GenerationParams{}
+		})
+
+		JustBeforeEach(func() {
+			merged = params.With(override)
+		})
+
+		It("keeps the parameters the override does not set", func() {
+			Expect(merged).To(Equal(params))
+		})
+
+		When("the override sets every parameter", func() {
+			BeforeEach(func() {
+				override = llm.GenerationParams{Temperature: &low, TopP: &low, MaxTokens: 500, Stop: []string{"\n"}, Seed: &seed}
+			})
+
+			It("uses the override's", func() {
+				Expect(merged).To(Equal(override))
+			})
+		})
+	})
+})
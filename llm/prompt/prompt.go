@@ -0,0 +1,135 @@
+// Package prompt builds the prompts sent to language models from
+// text/template templates.
+//
+// Every provider looks up a template by name. A template named after the
+// provider is used when one exists, otherwise the "default" template is used.
+// Built-in templates can be overridden, and new provider-specific templates
+// added, by placing <name>.tmpl files in the user's prompts directory.
+package prompt
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/zombor/gen/environment"
+)
+
+// DefaultName is the name of the template used when a provider has no
+// template of its own.
+const DefaultName = "default"
+
+//go:embed templates/*.tmpl
+var builtin embed.FS
+
+var builtinTemplates = func() map[string]*template.Template {
+	templates, err := parseDir(builtin, "templates")
+	if err != nil {
+		panic(err)
+	}
+	return templates
+}()
+
+// Data is the information available to prompt templates. The fields of
+// the embedded Environment, such as {{.OS}} and {{.Shell}}, and its
+// {{.Summary}} are available directly.
+type Data struct {
+	environment.Environment
+	// Prompt is the user's request.
+	Prompt string
+}
+
+// Templates renders prompts from the built-in templates and any user
+// overrides.
+type Templates struct {
+	overrides map[string]*template.Template
+	defaults  map[string]*template.Template
+}
+
+// Default returns the built-in templates.
+func Default() *Templates {
+	return &Templates{defaults: builtinTemplates}
+}
+
+// Load returns the built-in templates overridden by the *.tmpl files in fsys.
+// A missing directory is not an error.
+func Load(fsys fs.FS) (*Templates, error) {
+	t := Default()
+
+	overrides, err := parseDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	t.overrides = overrides
+
+	return t, nil
+}
+
+// Render renders the template for name with data. See the package
+// documentation for how the template is chosen.
+func (t *Templates) Render(name string, data Data) (string, error) {
+	tmpl := t.lookup(name)
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render %s prompt: %w", name, err)
+	}
+	return strings.TrimRight(b.String(), " \t\r\n"), nil
+}
+
+// Version identifies the built-in and overriding templates. It changes
+// whenever any template is edited, added or removed.
+func (t *Templates) Version() string {
+	h := sha256.New()
+	for _, set := range []struct {
+		kind      string
+		templates map[string]*template.Template
+	}{{"default", t.defaults}, {"override", t.overrides}} {
+		for _, name := range slices.Sorted(maps.Keys(set.templates)) {
+			fmt.Fprintf(h, "%s/%s\n%s\x00", set.kind, name, set.templates[name].Tree.Root.String())
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+func (t *Templates) lookup(name string) *template.Template {
+	for _, n := range []string{name, DefaultName} {
+		if tmpl, ok := t.overrides[n]; ok {
+			return tmpl
+		}
+		if tmpl, ok := t.defaults[n]; ok {
+			return tmpl
+		}
+	}
+	panic("prompt: missing built-in default template")
+}
+
+func parseDir(fsys fs.FS, dir string) (map[string]*template.Template, error) {
+	files, err := fs.Glob(fsys, path.Join(dir, "*.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+
+	templates := map[string]*template.Template{}
+	for _, file := range files {
+		text, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt template %s: %w", file, err)
+		}
+		name := strings.TrimSuffix(path.Base(file), ".tmpl")
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse prompt template %s: %w", file, err)
+		}
+		templates[name] = tmpl
+	}
+	return templates, nil
+}
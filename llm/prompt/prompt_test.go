@@ -0,0 +1,183 @@
+package prompt_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing/fstest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm/prompt"
+)
+
+var _ = Describe("Templates", func() {
+	var (
+		data     prompt.Data
+		provider string
+		text     string
+		err      error
+	)
+
+	BeforeEach(func() {
+		text, err = "", nil
+		data = prompt.Data{
+			Environment: environment.Environment{OS: "linux", Shell: "bash", Coreutils: environment.CoreutilsGNU},
+			Prompt:      "list files",
+		}
+	})
+
+	Context("Default", func() {
+		JustBeforeEach(func() {
+			text, err = prompt.Default().Render(provider, data)
+		})
+
+		When("the provider has no template", func() {
+			BeforeEach(func() {
+				provider = "openai"
+			})
+
+			It("starts with the instructions", func() {
+				Expect(text, err).To(HavePrefix("Given the following prompt, generate a single shell command."))
+			})
+
+			It("describes the machine", func() {
+				Expect(text, err).To(ContainSubstring("executed on a linux machine in a bash shell"))
+			})
+
+			It("describes the coreutils", func() {
+				Expect(text, err).To(ContainSubstring("Coreutils: GNU"))
+			})
+
+			It("ends with the prompt", func() {
+				Expect(text, err).To(HaveSuffix("Prompt: list files"))
+			})
+		})
+
+		When("the provider has a template", func() {
+			BeforeEach(func() {
+				provider = "bedrock-titan-lite"
+			})
+
+			It("starts with the system prompt", func() {
+				Expect(text, err).To(HavePrefix("System: "))
+			})
+
+			It("ends with the user prompt and the assistant turn", func() {
+				Expect(text, err).To(HaveSuffix("User: list files\nAssistant:"))
+			})
+		})
+	})
+
+	Context("Load", func() {
+		var (
+			files     fstest.MapFS
+			templates *prompt.Templates
+			loadErr   error
+		)
+
+		BeforeEach(func() {
+			provider = "openai"
+			files = fstest.MapFS{}
+		})
+
+		JustBeforeEach(func() {
+			templates, loadErr = prompt.Load(files)
+			if loadErr == nil {
+				text, err = templates.Render(provider, data)
+			}
+		})
+
+		When("a provider override exists", func() {
+			BeforeEach(func() {
+				files["openai.tmpl"] = &fstest.MapFile{Data: []byte("{{.Shell}} on {{.OS}}: {{.Prompt}}\n")}
+			})
+
+			It("prefers it", func() {
+				Expect(text, err).To(Equal("bash on linux: list files"))
+			})
+		})
+
+		When("the default is overridden", func() {
+			BeforeEach(func() {
+				files["default.tmpl"] = &fstest.MapFile{Data: []byte("custom: {{.Prompt}}")}
+				provider = "gemini"
+			})
+
+			It("uses it for providers without a template", func() {
+				Expect(text, err).To(Equal("custom: list files"))
+			})
+
+			When("the provider has a built-in template", func() {
+				BeforeEach(func() {
+					provider = "bedrock-titan-lite"
+				})
+
+				It("keeps the built-in template", func() {
+					Expect(text, err).To(HavePrefix("System: "))
+				})
+			})
+		})
+
+		When("a file does not have the .tmpl extension", func() {
+			BeforeEach(func() {
+				files["openai.txt"] = &fstest.MapFile{Data: []byte("custom")}
+			})
+
+			It("ignores it", func() {
+				Expect(text, err).To(HavePrefix("Given the following prompt"))
+			})
+		})
+
+		When("a template does not parse", func() {
+			BeforeEach(func() {
+				files["openai.tmpl"] = &fstest.MapFile{Data: []byte("{{.Prompt")}
+			})
+
+			It("returns an error", func() {
+				Expect(loadErr).To(MatchError(ContainSubstring("failed to parse prompt template openai.tmpl")))
+			})
+		})
+
+		When("a template uses an unknown field", func() {
+			BeforeEach(func() {
+				files["openai.tmpl"] = &fstest.MapFile{Data: []byte("{{.Missing}}")}
+			})
+
+			It("returns an error from Render", func() {
+				Expect(err).To(MatchError(ContainSubstring("failed to render openai prompt")))
+			})
+		})
+
+		When("a template is overridden", func() {
+			BeforeEach(func() {
+				files["openai.tmpl"] = &fstest.MapFile{Data: []byte("{{.Prompt}}")}
+			})
+
+			It("changes the version", func() {
+				Expect(templates.Version()).ToNot(Equal(prompt.Default().Version()))
+			})
+		})
+	})
+
+	Context("Load from a directory that does not exist", func() {
+		JustBeforeEach(func() {
+			var templates *prompt.Templates
+			templates, err = prompt.Load(os.DirFS(filepath.Join(GinkgoT().TempDir(), "missing")))
+			if err == nil {
+				text, err = templates.Render("openai", data)
+			}
+		})
+
+		It("uses the built-in templates", func() {
+			Expect(text, err).To(HavePrefix("Given the following prompt"))
+		})
+	})
+
+	Context("Version", func() {
+		It("is the same for the same templates", func() {
+			Expect(prompt.Default().Version()).To(Equal(prompt.Default().Version()))
+		})
+	})
+})
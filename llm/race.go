@@ -0,0 +1,180 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zombor/gen/environment"
+)
+
+// RaceProvider is an LLMProvider that asks all of its providers at once and
+// answers with the first command returned. The requests still in flight are
+// then cancelled. With a Grace period it waits that much longer for the other
+// providers and answers with the best command received, as scored by
+// Quality. The name of the provider that won is recorded in
+// Response.Provider.
+type RaceProvider struct {
+	Providers []NamedProvider
+	// Timeout bounds each provider's attempt. Zero means no timeout.
+	Timeout time.Duration
+	// Grace is how long to wait for better answers after the first one.
+	Grace time.Duration
+	// Quality scores an answer, higher is better. Ties go to the answer
+	// that came first.
+	Quality func(Response) int
+	After   func(time.Duration) <-chan time.Time
+}
+
+// NewRaceProvider creates a RaceProvider that races providers.
+func NewRaceProvider(timeout, grace time.Duration, providers ...NamedProvider) *RaceProvider {
+	return &RaceProvider{
+		Providers: providers,
+		Timeout:   timeout,
+		Grace:     grace,
+		Quality:   AnswerQuality,
+		After:     time.After,
+	}
+}
+
+// AnswerQuality prefers answers that the model considers safe, that do not
+// need sudo and that come with an explanation.
+func AnswerQuality(response Response) int {
+	score := 0
+	switch response.Risk {
+	case RiskLow:
+		score += 2
+	case RiskMedium:
+		score++
+	}
+	if !response.RequiresSudo {
+		score++
+	}
+	if strings.TrimSpace(response.Explanation) != "" {
+		score++
+	}
+	return score
+}
+
+// GenerateCommand implements LLMProvider.
+func (p *RaceProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	return p.StreamCommand(ctx, logger, prompt, env, discardTokens)
+}
+
+// StreamCommand implements StreamingLLMProvider. The providers' tokens
+// cannot be shown before the race is decided, so the winning command is
+// passed to onToken in one piece.
+func (p *RaceProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	candidates, err := p.race(ctx, logger, func(ctx context.Context, provider NamedProvider) ([]Candidate, error) {
+		response, err := StreamCommand(ctx, logger, provider.LLMProvider, prompt, env, discardTokens)
+		if err != nil {
+			return nil, err
+		}
+		return []Candidate{{Response: response}}, nil
+	})
+	if err != nil {
+		return Response{}, err
+	}
+
+	onToken(candidates[0].Command)
+	return candidates[0].Response, nil
+}
+
+// GenerateCommands implements MultiLLMProvider. The candidates of a single
+// provider win together, scored by the first of them.
+func (p *RaceProvider) GenerateCommands(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, n int) ([]Candidate, error) {
+	return p.race(ctx, logger, func(ctx context.Context, provider NamedProvider) ([]Candidate, error) {
+		return GenerateCommands(ctx, logger, provider.LLMProvider, prompt, env, n)
+	})
+}
+
+// raceResult is the answer or error of one provider in a race.
+type raceResult struct {
+	provider   NamedProvider
+	candidates []Candidate
+	err        error
+}
+
+// race calls attempt with every provider at once and returns the winning
+// candidates.
+func (p *RaceProvider) race(ctx context.Context, logger *slog.Logger, attempt func(context.Context, NamedProvider) ([]Candidate, error)) ([]Candidate, error) {
+	if len(p.Providers) == 0 {
+		return nil, fmt.Errorf("no providers configured")
+	}
+
+	raceCtx, cancel := context.WithCancel(ctx)
+	// The channel has room for every result so that the losers never block.
+	results := make(chan raceResult, len(p.Providers))
+	var wg sync.WaitGroup
+	for _, provider := range p.Providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var candidates []Candidate
+			err := attemptWithin(raceCtx, p.Timeout, provider, func(ctx context.Context, provider NamedProvider) error {
+				c, err := attempt(ctx, provider)
+				if err != nil {
+					return err
+				}
+				if len(c) == 0 || strings.TrimSpace(c[0].Command) == "" {
+					return emptyResponse()
+				}
+				candidates = c
+				return nil
+			})
+			results <- raceResult{provider: provider, candidates: candidates, err: err}
+		}()
+	}
+	// Cancel the requests still in flight and wait for them to stop, so
+	// that their usage is recorded.
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	var (
+		winner *raceResult
+		errs   []error
+		grace  <-chan time.Time
+	)
+wait:
+	for pending := len(p.Providers); pending > 0; pending-- {
+		select {
+		case result := <-results:
+			if result.err != nil {
+				// Stop when the caller gave up rather than the provider failing.
+				if ctx.Err() != nil {
+					return nil, result.err
+				}
+				logger.Warn("provider failed", "provider", result.provider.Name, "error", result.err)
+				errs = append(errs, fmt.Errorf("%s: %w", result.provider.Name, result.err))
+				continue
+			}
+
+			logger.Debug("provider answered", "provider", result.provider.Name)
+			if winner == nil || p.Quality(result.candidates[0].Response) > p.Quality(winner.candidates[0].Response) {
+				winner = &result
+			}
+			if p.Grace <= 0 {
+				break wait
+			}
+			if grace == nil {
+				grace = p.After(p.Grace)
+			}
+		case <-grace:
+			break wait
+		}
+	}
+
+	if winner == nil {
+		return nil, providersFailed(errs)
+	}
+	logger.Debug("provider won the race", "provider", winner.provider.Name)
+	for i := range winner.candidates {
+		winner.candidates[i].Provider = winner.provider.Name
+	}
+	return winner.candidates, nil
+}
@@ -0,0 +1,219 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm"
+)
+
+// gatedProvider answers with response once release is closed.
+type gatedProvider struct {
+	response llm.Response
+	release  chan struct{}
+	canceled atomic.Bool
+}
+
+func newGatedProvider(response llm.Response) *gatedProvider {
+	return &gatedProvider{response: response, release: make(chan struct{})}
+}
+
+func (p *gatedProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (llm.Response, error) {
+	select {
+	case <-p.release:
+		return p.response, nil
+	case <-ctx.Done():
+		p.canceled.Store(true)
+		return llm.Response{}, ctx.Err()
+	}
+}
+
+var _ = Describe("RaceProvider", func() {
+	var (
+		fast     *fakeProvider
+		slow     *gatedProvider
+		provider *llm.RaceProvider
+		logger   *slog.Logger
+		ctx      context.Context
+	)
+
+	BeforeEach(func() {
+		ctx = context.Background()
+		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+		fast = &fakeProvider{command: "ls"}
+		slow = newGatedProvider(llm.Response{Command: "ls -l", Risk: llm.RiskLow, Explanation: "Lists files."})
+		provider = llm.NewRaceProvider(0, 0,
+			llm.NamedProvider{Name: "bedrock", LLMProvider: slow},
+			llm.NamedProvider{Name: "ollama", LLMProvider: fast},
+		)
+	})
+
+	Describe("GenerateCommand", func() {
+		var (
+			response llm.Response
+			err      error
+		)
+
+		JustBeforeEach(func() {
+			response, err = provider.GenerateCommand(ctx, logger, "list files", testEnv)
+		})
+
+		It("returns the first answer", func() {
+			Expect(response, err).To(Equal(llm.Response{Command: "ls", Provider: "ollama"}))
+		})
+
+		It("cancels the requests still in flight", func() {
+			Expect(slow.canceled.Load()).To(BeTrue())
+		})
+
+		When("the fastest provider fails", func() {
+			BeforeEach(func() {
+				fast.err = errors.New("connection refused")
+				close(slow.release)
+			})
+
+			It("waits for the next answer", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Risk: llm.RiskLow, Explanation: "Lists files.", Provider: "bedrock"}))
+			})
+		})
+
+		When("the fastest provider returns an empty command", func() {
+			BeforeEach(func() {
+				fast.command = " "
+				close(slow.release)
+			})
+
+			It("waits for the next answer", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Risk: llm.RiskLow, Explanation: "Lists files.", Provider: "bedrock"}))
+			})
+		})
+
+		When("every provider fails", func() {
+			BeforeEach(func() {
+				fast.err = errors.New("connection refused")
+				provider.Timeout = 10 * time.Millisecond
+			})
+
+			It("returns every provider's error", func() {
+				Expect(response, err).Error().To(MatchError(And(
+					ContainSubstring("all providers failed"),
+					ContainSubstring("ollama: connection refused"),
+					ContainSubstring("bedrock: timed out"),
+				)))
+			})
+		})
+
+		When("the caller's context is canceled", func() {
+			BeforeEach(func() {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithCancel(ctx)
+				cancel()
+				provider.Providers[1].LLMProvider = newGatedProvider(llm.Response{Command: "ls"})
+			})
+
+			It("returns the caller's error", func() {
+				Expect(response, err).Error().To(MatchError(context.Canceled))
+			})
+		})
+
+		When("there is a grace period", func() {
+			var expire chan time.Time
+
+			BeforeEach(func() {
+				expire = make(chan time.Time, 1)
+				provider.Grace = time.Second
+				// The slow provider answers during the grace period.
+				provider.After = func(d time.Duration) <-chan time.Time {
+					close(slow.release)
+					return expire
+				}
+			})
+
+			It("returns the best answer", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Risk: llm.RiskLow, Explanation: "Lists files.", Provider: "bedrock"}))
+			})
+
+			When("no better answer arrives in time", func() {
+				BeforeEach(func() {
+					provider.After = func(d time.Duration) <-chan time.Time {
+						expire <- time.Now()
+						return expire
+					}
+				})
+
+				It("returns the first answer", func() {
+					Expect(response, err).To(Equal(llm.Response{Command: "ls", Provider: "ollama"}))
+				})
+
+				It("cancels the requests still in flight", func() {
+					Expect(slow.canceled.Load()).To(BeTrue())
+				})
+			})
+		})
+	})
+
+	Describe("StreamCommand", func() {
+		var tokens []string
+
+		BeforeEach(func() {
+			provider = llm.NewRaceProvider(0, 0,
+				llm.NamedProvider{Name: "bedrock", LLMProvider: slow},
+				llm.NamedProvider{Name: "openai", LLMProvider: &streamingProvider{tokens: []string{"ls", " -a"}}},
+			)
+		})
+
+		JustBeforeEach(func() {
+			tokens = nil
+			_, _ = provider.StreamCommand(ctx, logger, "list files", testEnv, func(token string) {
+				tokens = append(tokens, token)
+			})
+		})
+
+		It("passes the winning command to the token func in one piece", func() {
+			Expect(tokens).To(Equal([]string{"ls -a"}))
+		})
+	})
+
+	Describe("GenerateCommands", func() {
+		var (
+			candidates []llm.Candidate
+			err        error
+		)
+
+		JustBeforeEach(func() {
+			candidates, err = provider.GenerateCommands(ctx, logger, "list files", testEnv, 2)
+		})
+
+		It("records the provider on each candidate", func() {
+			Expect(candidates, err).To(Equal([]llm.Candidate{
+				{Response: llm.Response{Command: "ls", Provider: "ollama"}, Votes: 2},
+			}))
+		})
+	})
+})
+
+var _ = Describe("AnswerQuality", func() {
+	It("prefers low risk commands", func() {
+		Expect(llm.AnswerQuality(llm.Response{Risk: llm.RiskLow})).To(BeNumerically(">", llm.AnswerQuality(llm.Response{Risk: llm.RiskMedium})))
+	})
+
+	It("prefers medium over high risk commands", func() {
+		Expect(llm.AnswerQuality(llm.Response{Risk: llm.RiskMedium})).To(BeNumerically(">", llm.AnswerQuality(llm.Response{Risk: llm.RiskHigh})))
+	})
+
+	It("prefers commands that do not need sudo", func() {
+		Expect(llm.AnswerQuality(llm.Response{})).To(BeNumerically(">", llm.AnswerQuality(llm.Response{RequiresSudo: true})))
+	})
+
+	It("prefers commands with an explanation", func() {
+		Expect(llm.AnswerQuality(llm.Response{Explanation: "Lists files."})).To(BeNumerically(">", llm.AnswerQuality(llm.Response{})))
+	})
+})
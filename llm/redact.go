@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/zombor/gen/environment"
+)
+
+// Redactor replaces the secrets in text with placeholders and puts them
+// back.
+type Redactor interface {
+	Redact(text string) string
+	Restore(text string) string
+}
+
+// RedactProvider is an LLMProvider that replaces the secrets in the prompt
+// and the environment with placeholders before the wrapped provider sees
+// them, and puts them back in the commands it returns. Only placeholders
+// reach the provider, the cache and the debug log.
+type RedactProvider struct {
+	LLMProvider
+	// NewRedactor creates the Redactor of a request.
+	NewRedactor func() Redactor
+}
+
+// NewRedactProvider wraps provider, hiding secrets with a new Redactor for
+// every request.
+func NewRedactProvider(provider LLMProvider, newRedactor func() Redactor) *RedactProvider {
+	return &RedactProvider{
+		LLMProvider: provider,
+		NewRedactor: newRedactor,
+	}
+}
+
+// GenerateCommand implements LLMProvider.
+func (p *RedactProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	return p.StreamCommand(ctx, logger, prompt, env, discardTokens)
+}
+
+// StreamCommand implements StreamingLLMProvider. A placeholder split across
+// tokens is streamed as it is; the returned response is always restored.
+func (p *RedactProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	r := p.NewRedactor()
+	prompt, env = redact(r, prompt, env)
+	response, err := StreamCommand(ctx, logger, p.LLMProvider, prompt, env, func(token string) {
+		onToken(r.Restore(token))
+	})
+	if err != nil {
+		return Response{}, err
+	}
+	return restore(r, response), nil
+}
+
+// GenerateCommands implements MultiLLMProvider.
+func (p *RedactProvider) GenerateCommands(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, n int) ([]Candidate, error) {
+	r := p.NewRedactor()
+	prompt, env = redact(r, prompt, env)
+	candidates, err := GenerateCommands(ctx, logger, p.LLMProvider, prompt, env, n)
+	if err != nil {
+		return nil, err
+	}
+	for i := range candidates {
+		candidates[i].Response = restore(r, candidates[i].Response)
+	}
+	return candidates, nil
+}
+
+// redact hides the secrets in the prompt and in the working directory, the
+// only part of the environment the user controls.
+func redact(r Redactor, prompt string, env environment.Environment) (string, environment.Environment) {
+	env.Cwd = r.Redact(env.Cwd)
+	return r.Redact(prompt), env
+}
+
+// restore puts the secrets back in a response.
+func restore(r Redactor, response Response) Response {
+	response.Command = r.Restore(response.Command)
+	response.Explanation = r.Restore(response.Explanation)
+	return response
+}
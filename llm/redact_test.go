@@ -0,0 +1,141 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm"
+)
+
+// fakeRedactor hides the word hunter2.
+type fakeRedactor struct{}
+
+func (fakeRedactor) Redact(text string) string {
+	return strings.ReplaceAll(text, "hunter2", "__SECRET_1__")
+}
+
+func (fakeRedactor) Restore(text string) string {
+	return strings.ReplaceAll(text, "__SECRET_1__", "hunter2")
+}
+
+// envRecordingProvider keeps the prompt and environment it was asked with
+// and answers with its command.
+type envRecordingProvider struct {
+	command string
+	err     error
+	prompt  string
+	env     environment.Environment
+}
+
+func (p *envRecordingProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (llm.Response, error) {
+	p.prompt, p.env = prompt, env
+	return llm.Response{Command: p.command, Explanation: "Logs in with __SECRET_1__."}, p.err
+}
+
+var _ = Describe("RedactProvider", func() {
+	var (
+		wrapped  *envRecordingProvider
+		provider *llm.RedactProvider
+		logger   *slog.Logger
+		env      environment.Environment
+	)
+
+	BeforeEach(func() {
+		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+		wrapped = &envRecordingProvider{command: "mysql -u root -p__SECRET_1__"}
+		provider = llm.NewRedactProvider(wrapped, func() llm.Redactor { return fakeRedactor{} })
+		env = environment.Environment{OS: "linux", Shell: "bash", Cwd: "/srv/hunter2"}
+	})
+
+	Describe("GenerateCommand", func() {
+		var (
+			response llm.Response
+			err      error
+		)
+
+		JustBeforeEach(func() {
+			response, err = provider.GenerateCommand(context.Background(), logger, "log in to mysql with password hunter2", env)
+		})
+
+		It("hides the secrets in the prompt", func() {
+			Expect(wrapped.prompt).To(Equal("log in to mysql with password __SECRET_1__"))
+		})
+
+		It("hides the secrets in the working directory", func() {
+			Expect(wrapped.env).To(Equal(environment.Environment{OS: "linux", Shell: "bash", Cwd: "/srv/__SECRET_1__"}))
+		})
+
+		It("puts the secrets back in the response", func() {
+			Expect(response, err).To(Equal(llm.Response{Command: "mysql -u root -phunter2", Explanation: "Logs in with hunter2."}))
+		})
+
+		When("the provider fails", func() {
+			BeforeEach(func() {
+				wrapped.err = errors.New("connection refused")
+			})
+
+			It("returns the error", func() {
+				Expect(response, err).Error().To(MatchError("connection refused"))
+			})
+		})
+	})
+
+	Describe("StreamCommand", func() {
+		var (
+			tokens   []string
+			response llm.Response
+			err      error
+		)
+
+		BeforeEach(func() {
+			tokens = nil
+			provider.LLMProvider = &streamingProvider{tokens: []string{"mysql ", "-p__SECRET_1__"}}
+		})
+
+		JustBeforeEach(func() {
+			response, err = provider.StreamCommand(context.Background(), logger, "log in with hunter2", env, func(token string) {
+				tokens = append(tokens, token)
+			})
+		})
+
+		It("puts the secrets back in the tokens", func() {
+			Expect(tokens).To(Equal([]string{"mysql ", "-phunter2"}))
+		})
+
+		It("puts the secrets back in the response", func() {
+			Expect(response, err).To(Equal(llm.Response{Command: "mysql -phunter2"}))
+		})
+	})
+
+	Describe("GenerateCommands", func() {
+		var (
+			candidates []llm.Candidate
+			err        error
+		)
+
+		JustBeforeEach(func() {
+			candidates, err = provider.GenerateCommands(context.Background(), logger, "log in to mysql with password hunter2", env, 2)
+		})
+
+		It("puts the secrets back in the candidates", func() {
+			Expect(candidates, err).To(Equal([]llm.Candidate{{Response: llm.Response{Command: "mysql -u root -phunter2", Explanation: "Logs in with hunter2."}, Votes: 2}}))
+		})
+
+		When("the provider fails", func() {
+			BeforeEach(func() {
+				wrapped.err = errors.New("connection refused")
+			})
+
+			It("returns the error", func() {
+				Expect(candidates, err).Error().To(MatchError("connection refused"))
+			})
+		})
+	})
+})
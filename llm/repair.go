@@ -0,0 +1,103 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/zombor/gen/environment"
+)
+
+// ValidateFunc returns the syntax error the shell at path would fail to run
+// command with, if any.
+type ValidateFunc func(path, command string) error
+
+// RepairProvider is an LLMProvider that parses the commands it returns and
+// sends a command the shell would reject back to the provider along with the
+// parser's error, until it is fixed or Repairs is used up. A command that is
+// still invalid after that is returned as it is, so the user can fix it.
+type RepairProvider struct {
+	LLMProvider
+	Validate ValidateFunc
+	// Repairs is the number of times a command is sent back to be fixed.
+	Repairs int
+}
+
+// NewRepairProvider wraps provider, checking its commands with validate.
+func NewRepairProvider(provider LLMProvider, validate ValidateFunc, repairs int) *RepairProvider {
+	return &RepairProvider{
+		LLMProvider: provider,
+		Validate:    validate,
+		Repairs:     repairs,
+	}
+}
+
+// GenerateCommand implements LLMProvider.
+func (p *RepairProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	return p.StreamCommand(ctx, logger, prompt, env, discardTokens)
+}
+
+// StreamCommand implements StreamingLLMProvider. Only the first command is
+// streamed; a repaired command replaces it in the returned response.
+func (p *RepairProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	response, err := StreamCommand(ctx, logger, p.LLMProvider, prompt, env, onToken)
+	if err != nil {
+		return Response{}, err
+	}
+	return p.repair(ctx, logger, prompt, env, response), nil
+}
+
+// GenerateCommands implements MultiLLMProvider. Each invalid candidate is
+// repaired on its own.
+func (p *RepairProvider) GenerateCommands(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, n int) ([]Candidate, error) {
+	candidates, err := GenerateCommands(ctx, logger, p.LLMProvider, prompt, env, n)
+	if err != nil {
+		return nil, err
+	}
+	for i := range candidates {
+		candidates[i].Response = p.repair(ctx, logger, prompt, env, candidates[i].Response)
+	}
+	return candidates, nil
+}
+
+// repair asks the provider to fix the command of response until it parses.
+// The usage of every attempt is added up in the returned response.
+func (p *RepairProvider) repair(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, response Response) Response {
+	for i := 0; ; i++ {
+		err := p.Validate(env.Shell, response.Command)
+		if err == nil {
+			return response
+		}
+		if i >= p.Repairs {
+			logger.Warn("command is not valid shell syntax", "command", response.Command, "error", err)
+			return response
+		}
+
+		logger.Warn("command is not valid shell syntax, asking for a fix", "command", response.Command, "error", err, "attempt", i+1)
+		fixed, ferr := p.LLMProvider.GenerateCommand(ctx, logger, repairPrompt(prompt, response.Command, err), env)
+		if ferr != nil {
+			logger.Warn("failed to repair command", "error", ferr)
+			return response
+		}
+		fixed.Usage = response.Usage.Add(fixed.Usage)
+		fixed.Repairs = response.Repairs + 1
+		response = fixed
+	}
+}
+
+// repairPrompt asks for a corrected version of command, which the shell
+// parser rejected with err.
+func repairPrompt(prompt, command string, err error) string {
+	return fmt.Sprintf("%s\n\nYour previous command is not valid shell syntax:\n\n%s\n\nThe shell parser reported: %v\n\nReturn a corrected command that does the same thing.", prompt, command, err)
+}
+
+// FixPrompt asks for a corrected version of command, which failed with
+// exitCode and printed stderr when the user ran it.
+func FixPrompt(prompt, command string, exitCode int, stderr string) string {
+	output := "It printed nothing on stderr."
+	if stderr = strings.TrimSpace(stderr); stderr != "" {
+		output = "It printed:\n\n" + stderr
+	}
+	return fmt.Sprintf("%s\n\nYour previous command failed with exit status %d:\n\n%s\n\n%s\n\nReturn a corrected command that does the same thing, for example without options this system does not support.", prompt, exitCode, command, output)
+}
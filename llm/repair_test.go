@@ -0,0 +1,210 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"strings"
+	"sync"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm"
+)
+
+// answeringProvider answers with its commands in order and keeps the prompts
+// it was asked.
+type answeringProvider struct {
+	mu       sync.Mutex
+	commands []string
+	err      error
+	prompts  []string
+}
+
+func (p *answeringProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (llm.Response, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.prompts = append(p.prompts, prompt)
+	if len(p.prompts) > 1 && p.err != nil {
+		return llm.Response{}, p.err
+	}
+	command := p.commands[0]
+	if len(p.commands) > 1 {
+		p.commands = p.commands[1:]
+	}
+	return llm.Response{Command: command, Usage: llm.Usage{InputTokens: 10, OutputTokens: 2}}, nil
+}
+
+// validatePipes rejects commands that end in a pipe.
+func validatePipes(path, command string) error {
+	if strings.HasSuffix(command, "|") {
+		return errors.New("1:7: | must be followed by a statement")
+	}
+	return nil
+}
+
+var _ = Describe("RepairProvider", func() {
+	var (
+		answering *answeringProvider
+		wrapped   llm.LLMProvider
+		repairs   int
+		logger    *slog.Logger
+	)
+
+	BeforeEach(func() {
+		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+		answering = &answeringProvider{commands: []string{"ls -l"}}
+		wrapped = answering
+		repairs = 2
+	})
+
+	Describe("GenerateCommand", func() {
+		var (
+			response llm.Response
+			err      error
+		)
+
+		JustBeforeEach(func() {
+			response, err = llm.NewRepairProvider(wrapped, validatePipes, repairs).GenerateCommand(context.Background(), logger, "list files", testEnv)
+		})
+
+		When("the command is valid", func() {
+			It("returns it", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l", Usage: llm.Usage{InputTokens: 10, OutputTokens: 2}}))
+			})
+
+			It("asks the provider once", func() {
+				Expect(answering.prompts).To(HaveLen(1))
+			})
+		})
+
+		When("the command is invalid", func() {
+			BeforeEach(func() {
+				answering.commands = []string{"ls -l |", "ls -l | less"}
+			})
+
+			It("returns the repaired command", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l | less", Usage: llm.Usage{InputTokens: 20, OutputTokens: 4}, Repairs: 1}))
+			})
+
+			It("sends the parser error back to the provider", func() {
+				Expect(answering.prompts[1]).To(Equal("list files\n\nYour previous command is not valid shell syntax:\n\nls -l |\n\nThe shell parser reported: 1:7: | must be followed by a statement\n\nReturn a corrected command that does the same thing."))
+			})
+		})
+
+		When("the command cannot be repaired", func() {
+			BeforeEach(func() {
+				answering.commands = []string{"ls -l |"}
+			})
+
+			It("returns the last command", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l |", Usage: llm.Usage{InputTokens: 30, OutputTokens: 6}, Repairs: 2}))
+			})
+
+			It("stops after the repairs", func() {
+				Expect(answering.prompts).To(HaveLen(3))
+			})
+		})
+
+		When("repairs are disabled", func() {
+			BeforeEach(func() {
+				answering.commands = []string{"ls -l |"}
+				repairs = 0
+			})
+
+			It("returns the command as it is", func() {
+				Expect(response.Command).To(Equal("ls -l |"))
+			})
+		})
+
+		When("the repair fails", func() {
+			BeforeEach(func() {
+				answering.commands = []string{"ls -l |"}
+				answering.err = errors.New("connection refused")
+			})
+
+			It("returns the first command", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l |", Usage: llm.Usage{InputTokens: 10, OutputTokens: 2}}))
+			})
+		})
+
+		When("the provider fails", func() {
+			BeforeEach(func() {
+				wrapped = &countingProvider{err: errors.New("connection refused")}
+			})
+
+			It("returns the error", func() {
+				Expect(response, err).Error().To(MatchError("connection refused"))
+			})
+		})
+	})
+
+	Describe("StreamCommand", func() {
+		var (
+			tokens   []string
+			response llm.Response
+			err      error
+		)
+
+		BeforeEach(func() {
+			tokens = nil
+			wrapped = &streamingProvider{tokens: []string{"ls -l ", "|"}}
+		})
+
+		JustBeforeEach(func() {
+			response, err = llm.NewRepairProvider(wrapped, validatePipes, repairs).StreamCommand(context.Background(), logger, "list files", testEnv, func(token string) {
+				tokens = append(tokens, token)
+			})
+		})
+
+		It("streams the first command", func() {
+			Expect(tokens).To(Equal([]string{"ls -l ", "|"}))
+		})
+
+		It("returns the command, still invalid after the repairs", func() {
+			Expect(response, err).To(Equal(llm.Response{Command: "ls -l |", Repairs: 2}))
+		})
+	})
+
+	Describe("GenerateCommands", func() {
+		var (
+			candidates []llm.Candidate
+			err        error
+		)
+
+		BeforeEach(func() {
+			answering.commands = []string{"ls -l |", "ls -l |", "ls -la"}
+		})
+
+		JustBeforeEach(func() {
+			candidates, err = llm.NewRepairProvider(wrapped, validatePipes, repairs).GenerateCommands(context.Background(), logger, "list files", testEnv, 2)
+		})
+
+		It("repairs the invalid candidates", func() {
+			Expect(candidates, err).To(Equal([]llm.Candidate{{Response: llm.Response{Command: "ls -la", Usage: llm.Usage{InputTokens: 30, OutputTokens: 6}, Repairs: 1}, Votes: 2}}))
+		})
+
+		When("the provider fails", func() {
+			BeforeEach(func() {
+				wrapped = &countingProvider{err: errors.New("connection refused")}
+			})
+
+			It("returns the error", func() {
+				Expect(candidates, err).Error().To(MatchError("connection refused"))
+			})
+		})
+	})
+})
+
+var _ = Describe("FixPrompt", func() {
+	It("includes the command, exit status and error", func() {
+		Expect(llm.FixPrompt("list files by size", "ls --sort=size", 1, "ls: unrecognized option '--sort=size'\n")).To(Equal("list files by size\n\nYour previous command failed with exit status 1:\n\nls --sort=size\n\nIt printed:\n\nls: unrecognized option '--sort=size'\n\nReturn a corrected command that does the same thing, for example without options this system does not support."))
+	})
+
+	It("says when there was no error output", func() {
+		Expect(llm.FixPrompt("find large files", "find / -size +1G | grep -q x", 1, "")).To(ContainSubstring("It printed nothing on stderr."))
+	})
+})
@@ -0,0 +1,85 @@
+package llm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Risk is the model's assessment of how dangerous a command is.
+type Risk string
+
+const (
+	RiskLow    Risk = "low"
+	RiskMedium Risk = "medium"
+	RiskHigh   Risk = "high"
+)
+
+// Response is the structured answer returned by every provider.
+type Response struct {
+	Command      string `json:"command"`
+	Explanation  string `json:"explanation"`
+	Risk         Risk   `json:"risk"`
+	RequiresSudo bool   `json:"requires_sudo"`
+	// Provider is the name of the provider that generated the response when
+	// several are configured.
+	Provider string `json:"-"`
+	// Cached reports whether the response was read from the cache rather
+	// than generated.
+	Cached bool `json:"-"`
+	// Usage is the number of tokens spent generating the response.
+	Usage Usage `json:"-"`
+	// Repairs is the number of times the command was sent back to the
+	// provider because it was not valid shell syntax.
+	Repairs int `json:"-"`
+}
+
+// responseToolName is the name of the tool used by providers that return
+// structured output through tool calling.
+const responseToolName = "shell_command"
+
+// responseToolDescription describes the structured output tool to the model.
+const responseToolDescription = "Return the generated shell command along with an explanation and risk assessment."
+
+// responseSchema is the JSON schema of Response.
+const responseSchema = `{
+	"type": "object",
+	"properties": {
+		"command": {"type": "string", "description": "The shell command."},
+		"explanation": {"type": "string", "description": "One short sentence explaining what the command does."},
+		"risk": {"type": "string", "enum": ["low", "medium", "high"], "description": "How likely the command is to lose data or change the system."},
+		"requires_sudo": {"type": "boolean", "description": "Whether the command needs root privileges."}
+	},
+	"required": ["command", "explanation", "risk", "requires_sudo"],
+	"additionalProperties": false
+}`
+
+// parseResponse decodes a JSON Response from text, ignoring reasoning and
+// anything else surrounding the outermost JSON object. The command is
+// cleaned up with ExtractCommand.
+func parseResponse(text string) (Response, error) {
+	var response Response
+	text = stripReasoning(text)
+	start, end := strings.Index(text, "{"), strings.LastIndex(text, "}")
+	if start < 0 || end < start {
+		return response, fmt.Errorf("response did not contain a json object")
+	}
+	if err := json.Unmarshal([]byte(text[start:end+1]), &response); err != nil {
+		return response, err
+	}
+	response.Command = ExtractCommand(response.Command)
+	if response.Command == "" {
+		return response, emptyResponse()
+	}
+	return response, nil
+}
+
+// parseResponseOrText decodes a JSON Response from text. Models without a
+// native JSON mode sometimes ignore the instructions and answer in plain
+// text, in which case the command is extracted from the whole text.
+func parseResponseOrText(text string) Response {
+	if response, err := parseResponse(text); err == nil {
+		return response
+	}
+	return Response{Command: ExtractCommand(text)}
+}
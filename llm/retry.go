@@ -0,0 +1,132 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"time"
+
+	"github.com/zombor/gen/environment"
+)
+
+// RetryPolicy controls how RetryProvider retries failed requests.
+type RetryPolicy struct {
+	// Retries is the number of retries after the first attempt.
+	Retries int
+	// BaseDelay is the delay before the first retry. It doubles after each
+	// retry.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between attempts. A Retry-After longer than
+	// MaxDelay is not waited for.
+	MaxDelay time.Duration
+}
+
+// retryable reports whether a request that failed with err may succeed if it
+// is sent again.
+func retryable(err error) bool {
+	return errors.Is(err, ErrRateLimited) || errors.Is(err, ErrTimeout)
+}
+
+// RetryProvider is an LLMProvider that retries rate limited and timed out
+// requests with jittered exponential backoff, honoring the Retry-After the
+// provider asked for.
+type RetryProvider struct {
+	LLMProvider
+	Policy RetryPolicy
+	// Sleep waits for d or until ctx is done.
+	Sleep func(ctx context.Context, d time.Duration) error
+	// Jitter returns a random delay between d/2 and d.
+	Jitter func(d time.Duration) time.Duration
+}
+
+// NewRetryProvider wraps provider with policy.
+func NewRetryProvider(provider LLMProvider, policy RetryPolicy) *RetryProvider {
+	return &RetryProvider{
+		LLMProvider: provider,
+		Policy:      policy,
+		Sleep:       sleep,
+		Jitter: func(d time.Duration) time.Duration {
+			return d/2 + rand.N(d/2+1)
+		},
+	}
+}
+
+// GenerateCommand implements LLMProvider.
+func (p *RetryProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	var response Response
+	err := p.retry(ctx, logger, func() (bool, error) {
+		var err error
+		response, err = p.LLMProvider.GenerateCommand(ctx, logger, prompt, env)
+		return true, err
+	})
+	return response, err
+}
+
+// StreamCommand implements StreamingLLMProvider. A request is only retried if
+// it failed before any token was passed to onToken.
+func (p *RetryProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	var response Response
+	err := p.retry(ctx, logger, func() (bool, error) {
+		streamed := false
+		var err error
+		response, err = StreamCommand(ctx, logger, p.LLMProvider, prompt, env, func(token string) {
+			streamed = true
+			onToken(token)
+		})
+		return !streamed, err
+	})
+	return response, err
+}
+
+// GenerateCommands implements MultiLLMProvider.
+func (p *RetryProvider) GenerateCommands(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, n int) ([]Candidate, error) {
+	var candidates []Candidate
+	err := p.retry(ctx, logger, func() (bool, error) {
+		var err error
+		candidates, err = GenerateCommands(ctx, logger, p.LLMProvider, prompt, env, n)
+		return true, err
+	})
+	return candidates, err
+}
+
+// retry calls attempt until it succeeds, fails with an error that is not
+// retryable, or the policy's retries are used up. attempt reports whether it
+// is safe to retry.
+func (p *RetryProvider) retry(ctx context.Context, logger *slog.Logger, attempt func() (bool, error)) error {
+	delay := p.Policy.BaseDelay
+	for i := 0; ; i++ {
+		safe, err := attempt()
+		if err == nil || !safe || !retryable(err) || i >= p.Policy.Retries || ctx.Err() != nil {
+			return err
+		}
+
+		wait := p.Jitter(delay)
+		var providerErr *ProviderError
+		if errors.As(err, &providerErr) && providerErr.RetryAfter > 0 {
+			if providerErr.RetryAfter > p.Policy.MaxDelay {
+				return err
+			}
+			wait = providerErr.RetryAfter
+		}
+
+		logger.Warn("request failed, retrying", "error", err, "attempt", i+1, "delay", wait)
+		if err := p.Sleep(ctx, wait); err != nil {
+			return err
+		}
+
+		delay = min(delay*2, p.Policy.MaxDelay)
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,180 @@
+package llm_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm"
+)
+
+// flakyProvider fails with each of errs in turn before answering.
+type flakyProvider struct {
+	errs   []error
+	tokens []string
+	calls  int
+}
+
+func (p *flakyProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (llm.Response, error) {
+	return p.StreamCommand(ctx, logger, prompt, env, func(string) {})
+}
+
+func (p *flakyProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken llm.TokenFunc) (llm.Response, error) {
+	p.calls++
+	for _, token := range p.tokens {
+		onToken(token)
+	}
+	if len(p.errs) > 0 {
+		err := p.errs[0]
+		p.errs = p.errs[1:]
+		return llm.Response{}, err
+	}
+	return llm.Response{Command: "ls -l"}, nil
+}
+
+func rateLimited(retryAfter time.Duration) error {
+	return fmt.Errorf("openai: %w", &llm.ProviderError{Kind: llm.ErrRateLimited, RetryAfter: retryAfter, Err: errors.New("429 Too Many Requests")})
+}
+
+var _ = Describe("RetryProvider", func() {
+	var (
+		flaky    *flakyProvider
+		provider *llm.RetryProvider
+		logger   *slog.Logger
+		slept    []time.Duration
+
+		response llm.Response
+		err      error
+	)
+
+	BeforeEach(func() {
+		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+		slept = nil
+		flaky = &flakyProvider{}
+		provider = llm.NewRetryProvider(flaky, llm.RetryPolicy{Retries: 2, BaseDelay: time.Second, MaxDelay: 3 * time.Second})
+		provider.Sleep = func(ctx context.Context, d time.Duration) error {
+			slept = append(slept, d)
+			return nil
+		}
+		provider.Jitter = func(d time.Duration) time.Duration { return d }
+	})
+
+	Describe("GenerateCommand", func() {
+		JustBeforeEach(func() {
+			response, err = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+		})
+
+		When("the provider is rate limited", func() {
+			BeforeEach(func() {
+				flaky.errs = []error{rateLimited(0), rateLimited(0)}
+			})
+
+			It("returns the answer of the last attempt", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l"}))
+			})
+
+			It("backs off exponentially", func() {
+				Expect(slept).To(Equal([]time.Duration{time.Second, 2 * time.Second}))
+			})
+		})
+
+		When("the request times out", func() {
+			BeforeEach(func() {
+				flaky.errs = []error{&llm.ProviderError{Kind: llm.ErrTimeout, Err: context.DeadlineExceeded}}
+			})
+
+			It("retries it", func() {
+				Expect(flaky.calls).To(Equal(2))
+			})
+		})
+
+		When("the provider asks to retry after a delay", func() {
+			BeforeEach(func() {
+				flaky.errs = []error{rateLimited(2500 * time.Millisecond)}
+			})
+
+			It("waits for that delay", func() {
+				Expect(slept).To(Equal([]time.Duration{2500 * time.Millisecond}))
+			})
+		})
+
+		When("the Retry-After is longer than the max delay", func() {
+			BeforeEach(func() {
+				flaky.errs = []error{rateLimited(time.Minute)}
+			})
+
+			It("returns the error", func() {
+				Expect(response, err).Error().To(MatchError(llm.ErrRateLimited))
+			})
+
+			It("does not retry", func() {
+				Expect(flaky.calls).To(Equal(1))
+			})
+		})
+
+		When("every retry fails", func() {
+			BeforeEach(func() {
+				flaky.errs = []error{rateLimited(0), rateLimited(0), rateLimited(0)}
+			})
+
+			It("returns the error", func() {
+				Expect(response, err).Error().To(MatchError(llm.ErrRateLimited))
+			})
+
+			It("stops after the configured retries", func() {
+				Expect(flaky.calls).To(Equal(3))
+			})
+		})
+
+		When("the error will not go away", func() {
+			BeforeEach(func() {
+				flaky.errs = []error{&llm.ProviderError{Kind: llm.ErrAuth, Err: errors.New("401 Unauthorized")}}
+			})
+
+			It("returns the error", func() {
+				Expect(response, err).Error().To(MatchError(llm.ErrAuth))
+			})
+
+			It("does not retry", func() {
+				Expect(flaky.calls).To(Equal(1))
+			})
+		})
+	})
+
+	Describe("StreamCommand", func() {
+		BeforeEach(func() {
+			flaky.errs = []error{rateLimited(0)}
+		})
+
+		JustBeforeEach(func() {
+			response, err = provider.StreamCommand(context.Background(), logger, "list files", testEnv, func(string) {})
+		})
+
+		When("the stream fails before any token", func() {
+			It("returns the answer of the retry", func() {
+				Expect(response, err).To(Equal(llm.Response{Command: "ls -l"}))
+			})
+		})
+
+		When("the stream already passed tokens on", func() {
+			BeforeEach(func() {
+				flaky.tokens = []string{"ls"}
+			})
+
+			It("returns the error", func() {
+				Expect(response, err).Error().To(MatchError(llm.ErrRateLimited))
+			})
+
+			It("does not retry", func() {
+				Expect(flaky.calls).To(Equal(1))
+			})
+		})
+	})
+})
@@ -0,0 +1,121 @@
+package llm
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// jsonFieldStream forwards the value of a single string field of a JSON
+// object to a TokenFunc while the object is still being streamed.
+type jsonFieldStream struct {
+	field   string
+	onToken TokenFunc
+	raw     strings.Builder
+	sent    int
+}
+
+func newJSONFieldStream(field string, onToken TokenFunc) *jsonFieldStream {
+	return &jsonFieldStream{field: field, onToken: onToken}
+}
+
+// Write appends a chunk of the raw JSON and emits any newly decoded
+// characters of the field value.
+func (s *jsonFieldStream) Write(chunk string) {
+	s.raw.WriteString(chunk)
+	value := partialJSONString(s.raw.String(), s.field)
+	if len(value) > s.sent {
+		s.onToken(value[s.sent:])
+		s.sent = len(value)
+	}
+}
+
+// String returns the raw JSON received so far.
+func (s *jsonFieldStream) String() string {
+	return s.raw.String()
+}
+
+// partialJSONString decodes as much of the string value of key as is
+// present in raw, which may be a truncated JSON object.
+func partialJSONString(raw, key string) string {
+	idx := strings.Index(raw, strconv.Quote(key))
+	if idx < 0 {
+		return ""
+	}
+	rest := strings.TrimLeft(raw[idx+len(key)+2:], " \t\r\n")
+	if !strings.HasPrefix(rest, ":") {
+		return ""
+	}
+	rest = strings.TrimLeft(rest[1:], " \t\r\n")
+	if !strings.HasPrefix(rest, `"`) {
+		return ""
+	}
+	rest = rest[1:]
+
+	var value strings.Builder
+	for i := 0; i < len(rest); i++ {
+		c := rest[i]
+		switch {
+		case c == '"':
+			return value.String()
+		case c != '\\':
+			value.WriteByte(c)
+			continue
+		case i+1 >= len(rest):
+			return value.String()
+		}
+
+		i++
+		switch rest[i] {
+		case 'n':
+			value.WriteByte('\n')
+		case 't':
+			value.WriteByte('\t')
+		case 'r':
+			value.WriteByte('\r')
+		case 'b':
+			value.WriteByte('\b')
+		case 'f':
+			value.WriteByte('\f')
+		case 'u':
+			if i+5 > len(rest) {
+				return value.String()
+			}
+			r, err := strconv.ParseUint(rest[i+1:i+5], 16, 32)
+			if err != nil {
+				return value.String()
+			}
+			i += 4
+			if !utf16.IsSurrogate(rune(r)) {
+				value.WriteRune(rune(r))
+				continue
+			}
+			// Characters outside the BMP, such as emoji, are escaped as a
+			// pair of surrogates that are decoded together.
+			next := rest[i+1:]
+			switch {
+			case len(next) < 6 && strings.HasPrefix(`\u`, next[:min(len(next), 2)]):
+				// The other half has not arrived yet.
+				return value.String()
+			case strings.HasPrefix(next, `\u`):
+				low, err := strconv.ParseUint(next[2:6], 16, 32)
+				if decoded := utf16.DecodeRune(rune(r), rune(low)); err == nil && decoded != utf8.RuneError {
+					value.WriteRune(decoded)
+					i += 6
+					continue
+				}
+			}
+			value.WriteRune(utf8.RuneError)
+		default:
+			value.WriteByte(rest[i])
+		}
+	}
+
+	// Don't emit a partially received multi-byte character.
+	s := value.String()
+	for len(s) > 0 && !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
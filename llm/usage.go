@@ -0,0 +1,131 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/zombor/gen/environment"
+)
+
+// Usage is the number of tokens a request consumed, as reported by the
+// provider.
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// Add returns the sum of u and other.
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		InputTokens:  u.InputTokens + other.InputTokens,
+		OutputTokens: u.OutputTokens + other.OutputTokens,
+	}
+}
+
+// UsageRecord describes one request made to a provider.
+type UsageRecord struct {
+	Time     time.Time `json:"time"`
+	Provider string    `json:"provider"`
+	Model    string    `json:"model"`
+	Usage
+	// Latency is how long the request took, including streaming.
+	Latency time.Duration `json:"latency_ns"`
+	// Error is set when the request failed.
+	Error string `json:"error,omitempty"`
+}
+
+// UsageRecorder stores usage records.
+type UsageRecorder interface {
+	RecordUsage(record UsageRecord) error
+}
+
+// UsageRecorders is a UsageRecorder that passes each record to all of its
+// recorders.
+type UsageRecorders []UsageRecorder
+
+// RecordUsage implements UsageRecorder.
+func (r UsageRecorders) RecordUsage(record UsageRecord) error {
+	var errs []error
+	for _, recorder := range r {
+		errs = append(errs, recorder.RecordUsage(record))
+	}
+	return errors.Join(errs...)
+}
+
+// UsageProvider is an LLMProvider that records the usage and latency of
+// every request made to the wrapped provider.
+type UsageProvider struct {
+	LLMProvider
+	Recorder UsageRecorder
+	Provider string
+	Model    string
+	Now      func() time.Time
+}
+
+// NewUsageProvider wraps provider, recording its requests with recorder
+// under the given provider and model names.
+func NewUsageProvider(provider LLMProvider, recorder UsageRecorder, name, model string) *UsageProvider {
+	return &UsageProvider{
+		LLMProvider: provider,
+		Recorder:    recorder,
+		Provider:    name,
+		Model:       model,
+		Now:         time.Now,
+	}
+}
+
+// GenerateCommand implements LLMProvider.
+func (p *UsageProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (Response, error) {
+	return p.StreamCommand(ctx, logger, prompt, env, discardTokens)
+}
+
+// StreamCommand implements StreamingLLMProvider.
+func (p *UsageProvider) StreamCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, onToken TokenFunc) (Response, error) {
+	start := p.Now()
+	response, err := StreamCommand(ctx, logger, p.LLMProvider, prompt, env, onToken)
+	p.record(logger, start, response.Usage, err)
+	return response, err
+}
+
+// GenerateCommands implements MultiLLMProvider. A provider that generates
+// the candidates in one request is recorded once. Otherwise each of the n
+// requests is recorded on its own.
+func (p *UsageProvider) GenerateCommands(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, n int) ([]Candidate, error) {
+	if _, ok := p.LLMProvider.(MultiLLMProvider); !ok {
+		// Hiding GenerateCommands makes GenerateCommands call GenerateCommand.
+		return GenerateCommands(ctx, logger, struct{ LLMProvider }{p}, prompt, env, n)
+	}
+
+	start := p.Now()
+	candidates, err := GenerateCommands(ctx, logger, p.LLMProvider, prompt, env, n)
+
+	var usage Usage
+	for _, candidate := range candidates {
+		usage = usage.Add(candidate.Usage)
+	}
+	p.record(logger, start, usage, err)
+	return candidates, err
+}
+
+// record stores a request that started at start. Failing to store it is
+// logged rather than failing the request.
+func (p *UsageProvider) record(logger *slog.Logger, start time.Time, usage Usage, err error) {
+	record := UsageRecord{
+		Time:     start,
+		Provider: p.Provider,
+		Model:    p.Model,
+		Usage:    usage,
+		Latency:  p.Now().Sub(start),
+	}
+	if err != nil {
+		record.Error = err.Error()
+	} else if usage == (Usage{}) {
+		logger.Warn("the provider reported no token usage, so token and dollar budgets do not count the request", "provider", p.Provider, "model", p.Model)
+	}
+
+	if err := p.Recorder.RecordUsage(record); err != nil {
+		logger.Warn("failed to record usage", "error", err)
+	}
+}
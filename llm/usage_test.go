@@ -0,0 +1,144 @@
+package llm_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log/slog"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/environment"
+	"github.com/zombor/gen/llm"
+)
+
+// usageReportingProvider answers with a fixed usage.
+type usageReportingProvider struct {
+	usage llm.Usage
+	err   error
+}
+
+func (p *usageReportingProvider) GenerateCommand(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment) (llm.Response, error) {
+	return llm.Response{Command: "ls -l", Usage: p.usage}, p.err
+}
+
+// multiUsageProvider generates n candidates in one request with a fixed
+// usage.
+type multiUsageProvider struct {
+	usageReportingProvider
+	usage llm.Usage
+}
+
+func (p *multiUsageProvider) GenerateCommands(ctx context.Context, logger *slog.Logger, prompt string, env environment.Environment, n int) ([]llm.Candidate, error) {
+	return []llm.Candidate{{Response: llm.Response{Command: "ls -l", Usage: p.usage}, Votes: n}}, nil
+}
+
+// fakeRecorder keeps the records it is given.
+type fakeRecorder struct {
+	mu      sync.Mutex
+	records []llm.UsageRecord
+}
+
+func (r *fakeRecorder) RecordUsage(record llm.UsageRecord) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.records = append(r.records, record)
+	return nil
+}
+
+var _ = Describe("UsageProvider", func() {
+	var (
+		wrapped  *usageReportingProvider
+		recorder *fakeRecorder
+		provider *llm.UsageProvider
+		logger   *slog.Logger
+		start    time.Time
+	)
+
+	BeforeEach(func() {
+		logger = slog.New(slog.NewJSONHandler(ioutil.Discard, nil))
+		wrapped = &usageReportingProvider{usage: llm.Usage{InputTokens: 120, OutputTokens: 15}}
+		recorder = &fakeRecorder{}
+		provider = llm.NewUsageProvider(wrapped, recorder, "bedrock", "amazon.nova-lite-v1:0")
+
+		// Each call to Now is 250ms after the previous one.
+		start = time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC)
+		var mu sync.Mutex
+		now := start
+		provider.Now = func() time.Time {
+			mu.Lock()
+			defer mu.Unlock()
+			t := now
+			now = now.Add(250 * time.Millisecond)
+			return t
+		}
+	})
+
+	Describe("GenerateCommand", func() {
+		JustBeforeEach(func() {
+			_, _ = provider.GenerateCommand(context.Background(), logger, "list files", testEnv)
+		})
+
+		It("records the usage and latency of the request", func() {
+			Expect(recorder.records).To(Equal([]llm.UsageRecord{{
+				Time:     start,
+				Provider: "bedrock",
+				Model:    "amazon.nova-lite-v1:0",
+				Usage:    llm.Usage{InputTokens: 120, OutputTokens: 15},
+				Latency:  250 * time.Millisecond,
+			}}))
+		})
+
+		When("the request fails", func() {
+			BeforeEach(func() {
+				wrapped.err = errors.New("throttled")
+			})
+
+			It("records the error", func() {
+				Expect(recorder.records).To(ConsistOf(HaveField("Error", "throttled")))
+			})
+		})
+
+		When("the provider reports no usage", func() {
+			var logs *bytes.Buffer
+
+			BeforeEach(func() {
+				logs = &bytes.Buffer{}
+				logger = slog.New(slog.NewTextHandler(logs, nil))
+				wrapped.usage = llm.Usage{}
+			})
+
+			It("logs a warning", func() {
+				Expect(logs.String()).To(ContainSubstring("level=WARN msg=\"the provider reported no token usage"))
+			})
+		})
+	})
+
+	Describe("GenerateCommands", func() {
+		JustBeforeEach(func() {
+			_, _ = provider.GenerateCommands(context.Background(), logger, "list files", testEnv, 3)
+		})
+
+		It("records each request", func() {
+			Expect(recorder.records).To(HaveEach(HaveField("Usage", llm.Usage{InputTokens: 120, OutputTokens: 15})))
+		})
+
+		It("records as many requests as candidates", func() {
+			Expect(recorder.records).To(HaveLen(3))
+		})
+
+		When("the provider generates the candidates in one request", func() {
+			BeforeEach(func() {
+				provider.LLMProvider = &multiUsageProvider{usage: llm.Usage{InputTokens: 120, OutputTokens: 45}}
+			})
+
+			It("records the request once", func() {
+				Expect(recorder.records).To(ConsistOf(HaveField("Usage", llm.Usage{InputTokens: 120, OutputTokens: 45})))
+			})
+		})
+	})
+})
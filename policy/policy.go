@@ -0,0 +1,370 @@
+// Package policy enforces an organization's rules on the commands gen
+// executes.
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/zombor/gen/shell"
+)
+
+// SystemPath is the policy every user of the machine is held to. It is
+// always read, whatever the configuration.
+const SystemPath = "/etc/gen/policy"
+
+// Action is what a rule does with the commands it matches.
+type Action string
+
+const (
+	// Allow lets matching commands run. Once a policy file has allow rules,
+	// commands that match none of them are denied.
+	Allow Action = "allow"
+	// Confirm makes the user type the confirmation phrase.
+	Confirm Action = "confirm"
+	// Deny stops matching commands from running.
+	Deny Action = "deny"
+)
+
+// Rule is one line of a policy file.
+type Rule struct {
+	Action Action
+	// Kind is what the rule matches: binary, args, path, category or risk.
+	Kind   string
+	Values []string
+	// Source is the file and line the rule was read from.
+	Source string
+
+	args *regexp.Regexp
+	risk shell.Risk
+}
+
+// String formats the rule as it is written in the policy file.
+func (r Rule) String() string {
+	return strings.Join(append([]string{string(r.Action), r.Kind}, r.Values...), " ")
+}
+
+// File is a parsed policy file.
+type File struct {
+	Path  string
+	Rules []Rule
+}
+
+// Policy is the set of policy files the commands are checked against.
+type Policy struct {
+	Files []File
+	// Dir is the directory relative paths in commands are resolved against.
+	Dir string
+	// Home is the directory ~ stands for.
+	Home string
+}
+
+// Decision is the verdict of a policy on a command.
+type Decision struct {
+	Action Action
+	// Rule is the rule that fired. It is nil when no rule applied, or when
+	// the command was denied for matching no allow rule.
+	Rule *Rule
+	// Reason explains the decision.
+	Reason string
+}
+
+// String describes the decision and the rule that fired.
+func (d Decision) String() string {
+	if d.Rule == nil {
+		return d.Reason
+	}
+	return fmt.Sprintf("%s (rule %q at %s)", d.Reason, d.Rule.String(), d.Rule.Source)
+}
+
+// categories are the named groups of binaries a category rule can match.
+// The rules of shell.Assess, such as rm-recursive, are categories too.
+var categories = map[string][]string{
+	"network": {"curl", "wget", "nc", "ncat", "netcat", "socat", "ssh", "scp", "sftp", "rsync", "telnet", "ftp", "tftp", "nmap", "aria2c", "http", "https"},
+	"sudo":    {"sudo", "doas", "su", "pkexec", "runuser"},
+}
+
+// riskRules lists the rules of shell.Assess, which are categories as well.
+var riskRules = []string{"rm-recursive", "disk-write", "mkfs", "recursive-permissions", "pipe-to-shell", "force-push", "sql-drop", "fork-bomb"}
+
+// Load reads the policy files at paths. Missing files are skipped, so a
+// machine without a policy allows everything.
+func Load(dir, home string, paths ...string) (*Policy, error) {
+	policy := &Policy{Dir: dir, Home: home}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy: %w", err)
+		}
+		file, err := Parse(p, data)
+		if err != nil {
+			return nil, err
+		}
+		policy.Files = append(policy.Files, file)
+	}
+	return policy, nil
+}
+
+// Parse parses a policy file: one "action kind value..." rule per line.
+// Blank lines and lines starting with # are ignored. The kinds are
+//
+//	binary <glob>...      the command, e.g. "deny binary nc ncat"
+//	args <glob> <regexp>  the arguments of a command, joined with spaces
+//	path <glob>...        a path in the arguments, or anything under it
+//	category <name>...    network tools, sudo, or a rule of the risk check
+//	risk <level>          commands the risk check rates at least level
+func Parse(name string, data []byte) (File, error) {
+	file := File{Path: name}
+	for i, line := range strings.Split(string(data), "\n") {
+		if comment := strings.Index(line, "#"); comment >= 0 {
+			line = line[:comment]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		rule, err := parseRule(fields)
+		if err != nil {
+			return File{}, fmt.Errorf("%s:%d: %w", name, i+1, err)
+		}
+		rule.Source = fmt.Sprintf("%s:%d", name, i+1)
+		file.Rules = append(file.Rules, rule)
+	}
+	return file, nil
+}
+
+func parseRule(fields []string) (Rule, error) {
+	if len(fields) < 3 {
+		return Rule{}, fmt.Errorf("expected action, kind and value, got %q", strings.Join(fields, " "))
+	}
+	rule := Rule{Action: Action(fields[0]), Kind: fields[1], Values: fields[2:]}
+	switch rule.Action {
+	case Allow, Confirm, Deny:
+	default:
+		return Rule{}, fmt.Errorf("unknown action %q, expected allow, confirm or deny", fields[0])
+	}
+
+	switch rule.Kind {
+	case "binary", "path":
+		for _, glob := range rule.Values {
+			if _, err := path.Match(glob, ""); err != nil {
+				return Rule{}, fmt.Errorf("invalid pattern %q", glob)
+			}
+		}
+	case "args":
+		if len(rule.Values) < 2 {
+			return Rule{}, fmt.Errorf("expected a binary and a pattern, e.g. \"deny args rm .*--no-preserve-root\"")
+		}
+		// The pattern may contain spaces.
+		rule.Values = []string{rule.Values[0], strings.Join(rule.Values[1:], " ")}
+		if _, err := path.Match(rule.Values[0], ""); err != nil {
+			return Rule{}, fmt.Errorf("invalid pattern %q", rule.Values[0])
+		}
+		args, err := regexp.Compile(rule.Values[1])
+		if err != nil {
+			return Rule{}, fmt.Errorf("invalid pattern %q: %w", rule.Values[1], err)
+		}
+		rule.args = args
+	case "category":
+		for _, name := range rule.Values {
+			if _, ok := categories[name]; !ok && !slices.Contains(riskRules, name) {
+				return Rule{}, fmt.Errorf("unknown category %q, expected network, sudo or one of %s", name, strings.Join(riskRules, ", "))
+			}
+		}
+	case "risk":
+		risk, ok := map[string]shell.Risk{"medium": shell.RiskMedium, "high": shell.RiskHigh}[rule.Values[0]]
+		if !ok || len(rule.Values) > 1 {
+			return Rule{}, fmt.Errorf("invalid risk %q, expected medium or high", strings.Join(rule.Values, " "))
+		}
+		rule.risk = risk
+	default:
+		return Rule{}, fmt.Errorf("unknown kind %q, expected binary, args, path, category or risk", fields[1])
+	}
+	return rule, nil
+}
+
+// Check decides whether command may run. A deny rule in any file wins over
+// everything else, then the allow lists of the files, then confirm rules.
+// Every file with allow rules is an allow list of its own, so a user's
+// policy cannot allow what the system policy leaves out. A command that
+// does not parse is denied, since the commands it runs cannot be checked.
+func (p *Policy) Check(command string) Decision {
+	if p == nil || len(p.Files) == 0 {
+		return Decision{Action: Allow}
+	}
+
+	commands, err := shell.Commands(command)
+	if err != nil {
+		return Decision{Action: Deny, Reason: fmt.Sprintf("the command could not be parsed, so the policy cannot be checked: %v", err)}
+	}
+	assessment := shell.Assess(command)
+
+	var confirm *Decision
+	for _, file := range p.Files {
+		for i := range file.Rules {
+			rule := &file.Rules[i]
+			if rule.Action == Allow {
+				continue
+			}
+			if reason, ok := p.match(rule, commands, assessment); ok {
+				decision := Decision{Action: rule.Action, Rule: rule, Reason: reason}
+				if rule.Action == Deny {
+					return decision
+				}
+				if confirm == nil {
+					confirm = &decision
+				}
+			}
+		}
+	}
+
+	for _, file := range p.Files {
+		if decision, ok := p.allowed(file, commands); !ok {
+			return decision
+		}
+	}
+
+	if confirm != nil {
+		return *confirm
+	}
+	return Decision{Action: Allow}
+}
+
+// allowed checks each command against the allow rules of file, if it has
+// any.
+func (p *Policy) allowed(file File, commands []shell.Command) (Decision, bool) {
+	var allows []*Rule
+	for i := range file.Rules {
+		if file.Rules[i].Action == Allow {
+			allows = append(allows, &file.Rules[i])
+		}
+	}
+	if len(allows) == 0 {
+		return Decision{}, true
+	}
+
+	for _, c := range commands {
+		// Wrappers run commands of their own.
+		for _, name := range append(append([]string{}, c.Wrappers...), c.Name) {
+			allowed := false
+			for _, rule := range allows {
+				if _, ok := p.match(rule, []shell.Command{{Name: name, Args: c.Args}}, shell.Assessment{}); ok {
+					allowed = true
+					break
+				}
+			}
+			if !allowed {
+				return Decision{Action: Deny, Reason: fmt.Sprintf("%s is not allowed by %s", name, file.Path)}, false
+			}
+		}
+	}
+	return Decision{}, true
+}
+
+// match reports whether rule matches one of commands, and why.
+func (p *Policy) match(rule *Rule, commands []shell.Command, assessment shell.Assessment) (string, bool) {
+	switch rule.Kind {
+	case "risk":
+		if assessment.Risk >= rule.risk {
+			return fmt.Sprintf("the command is %s risk", assessment.Risk), true
+		}
+		return "", false
+	case "category":
+		for _, finding := range assessment.Findings {
+			if slices.Contains(rule.Values, finding.Rule) {
+				return finding.Reason, true
+			}
+		}
+	}
+
+	for _, c := range commands {
+		switch rule.Kind {
+		case "binary":
+			for _, name := range append(append([]string{}, c.Wrappers...), c.Name) {
+				if matchAny(rule.Values, path.Base(name)) || matchAny(rule.Values, name) {
+					return fmt.Sprintf("%s is a restricted binary", name), true
+				}
+			}
+		case "args":
+			if (matchAny(rule.Values[:1], path.Base(c.Name)) || matchAny(rule.Values[:1], c.Name)) && rule.args.MatchString(strings.Join(c.Args, " ")) {
+				return fmt.Sprintf("the arguments of %s are restricted", c.Name), true
+			}
+		case "path":
+			var globs []string
+			for _, glob := range rule.Values {
+				globs = append(globs, p.expandHome(glob))
+			}
+			for _, arg := range append(append([]string{}, c.Args...), c.Redirects...) {
+				if resolved := p.resolve(arg); resolved != "" && matchTree(globs, resolved) {
+					return fmt.Sprintf("%s is a restricted path", resolved), true
+				}
+			}
+		case "category":
+			for _, category := range rule.Values {
+				for _, name := range append(append([]string{}, c.Wrappers...), c.Name) {
+					if slices.Contains(categories[category], path.Base(name)) {
+						return fmt.Sprintf("%s is in the %s category", name, category), true
+					}
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+func matchAny(globs []string, name string) bool {
+	for _, glob := range globs {
+		if ok, _ := path.Match(glob, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTree reports whether p, or a directory p is in, matches one of globs.
+func matchTree(globs []string, p string) bool {
+	for ; ; p = path.Dir(p) {
+		if matchAny(globs, p) {
+			return true
+		}
+		if p == "/" || p == "." {
+			return false
+		}
+	}
+}
+
+// resolve returns the absolute path an argument would name, or "" for
+// options and URLs. Relative arguments are resolved against Dir.
+func (p *Policy) resolve(arg string) string {
+	if _, value, ok := strings.Cut(arg, "="); ok {
+		arg = value
+	}
+	if arg == "" || strings.HasPrefix(arg, "-") || strings.Contains(arg, "://") {
+		return ""
+	}
+	arg = p.expandHome(arg)
+	if !path.IsAbs(arg) {
+		arg = path.Join(p.Dir, arg)
+	}
+	return path.Clean(arg)
+}
+
+// expandHome replaces a leading ~ or $HOME with Home.
+func (p *Policy) expandHome(s string) string {
+	switch {
+	case s == "~" || strings.HasPrefix(s, "~/"):
+		return p.Home + s[1:]
+	case s == "$HOME" || strings.HasPrefix(s, "$HOME/"):
+		return p.Home + s[len("$HOME"):]
+	}
+	return s
+}
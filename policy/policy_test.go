@@ -0,0 +1,266 @@
+package policy_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/policy"
+)
+
+var _ = Describe("Policy", func() {
+	var (
+		files    []string
+		p        *policy.Policy
+		command  string
+		decision policy.Decision
+	)
+
+	BeforeEach(func() {
+		files = nil
+		command = "ls -l"
+	})
+
+	JustBeforeEach(func() {
+		p = &policy.Policy{Dir: "/home/me/src", Home: "/home/me"}
+		for i, data := range files {
+			file, err := policy.Parse(filepath.Join("policy", string(rune('a'+i))), []byte(data))
+			Expect(err).ToNot(HaveOccurred())
+			p.Files = append(p.Files, file)
+		}
+		decision = p.Check(command)
+	})
+
+	Context("without rules", func() {
+		It("allows the command", func() {
+			Expect(decision).To(Equal(policy.Decision{Action: policy.Allow}))
+		})
+	})
+
+	Context("with a deny rule", func() {
+		BeforeEach(func() {
+			files = []string{"# Jump host policy\n\ndeny category network # no outbound traffic\n"}
+			command = "cd /tmp && curl -s https://example.com"
+		})
+
+		It("denies a matching command", func() {
+			Expect(decision.Action).To(Equal(policy.Deny))
+		})
+
+		It("reports the rule that fired", func() {
+			Expect(decision.String()).To(Equal(`curl is in the network category (rule "deny category network" at policy/a:3)`))
+		})
+	})
+
+	DescribeTable("matches commands",
+		func(rule, command string, action policy.Action) {
+			file, err := policy.Parse("policy", []byte(rule))
+			Expect(err).ToNot(HaveOccurred())
+			p := &policy.Policy{Files: []policy.File{file}, Dir: "/home/me/src", Home: "/home/me"}
+			Expect(p.Check(command).Action).To(Equal(action))
+		},
+		Entry("a binary", "deny binary nc ncat", "nc -l 8080", policy.Deny),
+		Entry("a binary by glob", "deny binary mkfs.*", "mkfs.ext4 /dev/sdb1", policy.Deny),
+		Entry("a binary by path", "deny binary nc", "/usr/bin/nc -l 8080", policy.Deny),
+		Entry("a binary run through a wrapper", "deny binary nc", "sudo nc -l 8080", policy.Deny),
+		Entry("a binary in sh -c", "deny binary nc", `sh -c "nc -l 8080"`, policy.Deny),
+		Entry("a binary in bash -lc", "deny binary rm", `bash -lc 'rm -rf /'`, policy.Deny),
+		Entry("a binary in sh -ec", "deny binary curl", `sh -ec 'curl x'`, policy.Deny),
+		Entry("a binary in sudo sh -xc", "deny binary rm", `sudo sh -xc 'rm x'`, policy.Deny),
+		Entry("a binary in sh -c run by find", "deny binary rm", `find . -exec sh -c 'rm x' +`, policy.Deny),
+		Entry("a network command run by timeout", "deny category network", "timeout 5 curl example.com", policy.Deny),
+		Entry("a network command run by watch", "deny category network", "watch curl example.com", policy.Deny),
+		Entry("a network command run by setsid", "deny category network", "setsid curl example.com", policy.Deny),
+		Entry("a network command run by stdbuf", "deny category network", "stdbuf -o0 curl example.com", policy.Deny),
+		Entry("a binary in a command substitution", "deny binary whoami", "echo $(whoami)", policy.Deny),
+		Entry("a binary run by find", "deny binary rm", `find . -exec rm {} \;`, policy.Deny),
+		Entry("a binary on the next line", "deny binary rm", "echo hi\nrm x", policy.Deny),
+		Entry("a binary after a list", "deny binary rm", "echo hi; rm x", policy.Deny),
+		Entry("a binary in the background", "deny binary rm", "true & rm x", policy.Deny),
+		Entry("a binary in a subshell", "deny binary rm", "(rm x)", policy.Deny),
+		Entry("a binary in a block", "deny binary rm", "{ echo hi; rm x; }", policy.Deny),
+		Entry("a binary in an if", "deny binary rm", "if true; then rm x; fi", policy.Deny),
+		Entry("a binary in a loop", "deny binary rm", "for f in *.log; do rm \"$f\"; done", policy.Deny),
+		Entry("a binary in a function", "deny binary rm", "clean() { rm x; }; clean", policy.Deny),
+		Entry("a binary in eval", "deny binary rm", `eval "rm x"`, policy.Deny),
+		Entry("a command that does not parse", "deny binary rm", "echo 'hi", policy.Deny),
+		Entry("another binary", "deny binary nc", "ls -l", policy.Allow),
+		Entry("arguments", "deny args kubectl delete .*--all", "kubectl delete pods --all", policy.Deny),
+		Entry("other arguments", "deny args kubectl delete .*--all", "kubectl get pods --all-namespaces", policy.Allow),
+		Entry("a path", "deny path /etc", "vi /etc/ssh/sshd_config", policy.Deny),
+		Entry("a path by glob", "deny path /home/*/.ssh", "cat ~/.ssh/id_rsa", policy.Deny),
+		Entry("a relative path", "deny path /home/me/src/secrets", "cat secrets/token", policy.Deny),
+		Entry("a path that escapes with ..", "deny path /etc", "cat ../../../etc/shadow", policy.Deny),
+		Entry("a path in an option", "deny path /dev", "dd if=x of=/dev/sda", policy.Deny),
+		Entry("a path in a redirection", "deny path /etc", "echo x >/etc/motd", policy.Deny),
+		Entry("a path in a redirection of a block", "deny path /etc", "{ echo x; } >> /etc/motd", policy.Deny),
+		Entry("a path under $HOME", "deny path /home/me/.aws", "cat $HOME/.aws/credentials", policy.Deny),
+		Entry("a path under ~ in the rule", "deny path ~/.ssh", "cat /home/me/.ssh/id_rsa", policy.Deny),
+		Entry("another path", "deny path /etc", "cat /var/log/syslog", policy.Allow),
+		Entry("a URL", "deny path /example.com", "curl https://example.com/", policy.Allow),
+		Entry("sudo", "deny category sudo", "sudo systemctl restart nginx", policy.Deny),
+		Entry("su", "deny category sudo", "su -", policy.Deny),
+		Entry("a rule of the risk check", "confirm category force-push", "git push -f", policy.Confirm),
+		Entry("a risk level", "confirm risk medium", "rm -r build", policy.Confirm),
+		Entry("a lower risk level", "confirm risk high", "rm -r build", policy.Allow),
+	)
+
+	Context("with a command that does not parse", func() {
+		BeforeEach(func() {
+			files = []string{"confirm binary kubectl"}
+			command = "echo 'hi"
+		})
+
+		It("explains why it is denied", func() {
+			Expect(decision.String()).To(HavePrefix("the command could not be parsed, so the policy cannot be checked: "))
+		})
+	})
+
+	Context("with a confirm rule", func() {
+		BeforeEach(func() {
+			files = []string{"confirm binary kubectl terraform"}
+			command = "terraform apply"
+		})
+
+		It("asks for confirmation", func() {
+			Expect(decision.String()).To(Equal(`terraform is a restricted binary (rule "confirm binary kubectl terraform" at policy/a:1)`))
+		})
+	})
+
+	Context("with confirm and deny rules", func() {
+		BeforeEach(func() {
+			files = []string{"confirm binary kubectl", "deny args kubectl delete"}
+			command = "kubectl delete pod web"
+		})
+
+		It("denies the command", func() {
+			Expect(decision.Action).To(Equal(policy.Deny))
+		})
+	})
+
+	Context("with an allow list", func() {
+		BeforeEach(func() {
+			files = []string{"allow binary ls cat grep ssh\nconfirm binary ssh"}
+		})
+
+		Context("and an allowed command", func() {
+			BeforeEach(func() {
+				command = "ls -l | grep go"
+			})
+
+			It("allows it", func() {
+				Expect(decision).To(Equal(policy.Decision{Action: policy.Allow}))
+			})
+		})
+
+		Context("and an allowed command with a confirm rule", func() {
+			BeforeEach(func() {
+				command = "ssh db1"
+			})
+
+			It("asks for confirmation", func() {
+				Expect(decision.Action).To(Equal(policy.Confirm))
+			})
+		})
+
+		Context("and a command that is not allowed", func() {
+			BeforeEach(func() {
+				command = "ls -l | xargs rm"
+			})
+
+			It("denies it", func() {
+				Expect(decision).To(Equal(policy.Decision{Action: policy.Deny, Reason: "xargs is not allowed by policy/a"}))
+			})
+		})
+
+		Context("and a user policy that allows more", func() {
+			BeforeEach(func() {
+				files = append(files, "allow binary rm")
+				command = "rm notes.txt"
+			})
+
+			It("still denies the command", func() {
+				Expect(decision).To(Equal(policy.Decision{Action: policy.Deny, Reason: "rm is not allowed by policy/a"}))
+			})
+		})
+	})
+
+	Context("without a policy", func() {
+		It("allows everything", func() {
+			Expect((*policy.Policy)(nil).Check("rm -rf /")).To(Equal(policy.Decision{Action: policy.Allow}))
+		})
+	})
+})
+
+var _ = Describe("Parse", func() {
+	DescribeTable("rejects invalid rules",
+		func(data, message string) {
+			Expect(policy.Parse("policy", []byte(data))).Error().To(MatchError(message))
+		},
+		Entry("a short rule", "deny nc", `policy:1: expected action, kind and value, got "deny nc"`),
+		Entry("an unknown action", "block binary nc", `policy:1: unknown action "block", expected allow, confirm or deny`),
+		Entry("an unknown kind", "deny program nc", `policy:1: unknown kind "program", expected binary, args, path, category or risk`),
+		Entry("a bad glob", "deny binary [nc", `policy:1: invalid pattern "[nc"`),
+		Entry("a bad path glob", "deny path /etc/[", `policy:1: invalid pattern "/etc/["`),
+		Entry("args without a pattern", "deny args rm", `policy:1: expected a binary and a pattern, e.g. "deny args rm .*--no-preserve-root"`),
+		Entry("args with a bad binary", "deny args [rm x", `policy:1: invalid pattern "[rm"`),
+		Entry("args with a bad pattern", "deny args rm (", "policy:1: invalid pattern \"(\": error parsing regexp: missing closing ): `(`"),
+		Entry("an unknown category", "\ndeny category games", `policy:2: unknown category "games", expected network, sudo or one of rm-recursive, disk-write, mkfs, recursive-permissions, pipe-to-shell, force-push, sql-drop, fork-bomb`),
+		Entry("an unknown risk", "confirm risk low", `policy:1: invalid risk "low", expected medium or high`),
+	)
+
+	It("keeps spaces in argument patterns", func() {
+		file, err := policy.Parse("policy", []byte("deny args git push .* --force"))
+		Expect(file.Rules[0].Values, err).To(Equal([]string{"git", "push .* --force"}))
+	})
+})
+
+var _ = Describe("Load", func() {
+	var (
+		dir   string
+		paths []string
+		p     *policy.Policy
+		err   error
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "system"), []byte("deny category sudo\n"), 0o644)).To(Succeed())
+		paths = []string{filepath.Join(dir, "system"), filepath.Join(dir, "missing")}
+	})
+
+	JustBeforeEach(func() {
+		p, err = policy.Load("/work", "/home/me", paths...)
+	})
+
+	It("reads the files that exist", func() {
+		Expect(p, err).To(Equal(&policy.Policy{
+			Files: []policy.File{{Path: filepath.Join(dir, "system"), Rules: []policy.Rule{{Action: policy.Deny, Kind: "category", Values: []string{"sudo"}, Source: filepath.Join(dir, "system") + ":1"}}}},
+			Dir:   "/work",
+			Home:  "/home/me",
+		}))
+	})
+
+	Context("when a file is invalid", func() {
+		BeforeEach(func() {
+			Expect(os.WriteFile(filepath.Join(dir, "user"), []byte("deny everything\n"), 0o644)).To(Succeed())
+			paths = append(paths, filepath.Join(dir, "user"))
+		})
+
+		It("returns an error", func() {
+			Expect(p, err).Error().To(MatchError(filepath.Join(dir, "user") + `:1: expected action, kind and value, got "deny everything"`))
+		})
+	})
+
+	Context("when a file cannot be read", func() {
+		BeforeEach(func() {
+			paths = []string{dir}
+		})
+
+		It("returns an error", func() {
+			Expect(p, err).Error().To(MatchError(ContainSubstring("failed to read policy")))
+		})
+	})
+})
@@ -0,0 +1,70 @@
+// Package preview runs commands in a throwaway sandbox to show what they
+// would change before they run for real.
+package preview
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// ErrUnsupported is returned by Run where no sandbox is available.
+var ErrUnsupported = errors.New("preview is only supported on Linux")
+
+// ChangeKind is what happened to a file.
+type ChangeKind string
+
+const (
+	Created  ChangeKind = "created"
+	Modified ChangeKind = "modified"
+	Deleted  ChangeKind = "deleted"
+)
+
+// Change is a file the command created, modified or deleted. Directories
+// end in a slash; their contents are not listed.
+type Change struct {
+	Kind ChangeKind
+	Path string
+}
+
+// Result is what a command did in the sandbox.
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Changes  []Change
+}
+
+// Diff compares the upper directory of an overlay with its lower directory
+// and returns the changes it holds. A character device in upper is a
+// whiteout, the overlay's record of a deleted file. A directory that was
+// deleted and created again is compared file by file, so the files it lost
+// are not reported.
+func Diff(lower, upper fs.FS) ([]Change, error) {
+	var changes []Change
+	err := fs.WalkDir(upper, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+
+		before, statErr := fs.Stat(lower, name)
+		existed := statErr == nil
+		switch {
+		case d.Type()&fs.ModeCharDevice != 0:
+			if existed && before.IsDir() {
+				name += "/"
+			}
+			changes = append(changes, Change{Kind: Deleted, Path: name})
+		case d.IsDir() && existed && before.IsDir():
+			// Only some of its files changed.
+		case d.IsDir():
+			changes = append(changes, Change{Kind: Created, Path: name + "/"})
+			return fs.SkipDir
+		case existed:
+			changes = append(changes, Change{Kind: Modified, Path: name})
+		default:
+			changes = append(changes, Change{Kind: Created, Path: name})
+		}
+		return nil
+	})
+	return changes, err
+}
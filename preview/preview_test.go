@@ -0,0 +1,70 @@
+package preview_test
+
+import (
+	"io/fs"
+	"testing/fstest"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/preview"
+)
+
+// whiteout is how an overlay records a deleted file.
+var whiteout = &fstest.MapFile{Mode: fs.ModeDevice | fs.ModeCharDevice}
+
+var _ = Describe("Diff", func() {
+	var (
+		lower   fstest.MapFS
+		upper   fstest.MapFS
+		changes []preview.Change
+		err     error
+	)
+
+	BeforeEach(func() {
+		lower = fstest.MapFS{
+			"README.md":      {Data: []byte("# app")},
+			"src/main.go":    {Data: []byte("package main")},
+			"src/old.go":     {Data: []byte("package main")},
+			"build/app":      {Data: []byte("binary")},
+			"build/app.sym":  {Data: []byte("symbols")},
+			"docs/index.md":  {Data: []byte("docs")},
+			"notes/todo.txt": {Data: []byte("todo")},
+		}
+		upper = fstest.MapFS{}
+	})
+
+	JustBeforeEach(func() {
+		changes, err = preview.Diff(lower, upper)
+	})
+
+	When("nothing changed", func() {
+		It("returns no changes", func() {
+			Expect(changes, err).To(BeEmpty())
+		})
+	})
+
+	When("files changed", func() {
+		BeforeEach(func() {
+			upper["README.md"] = &fstest.MapFile{Data: []byte("# app\n")}
+			upper["src"] = &fstest.MapFile{Mode: fs.ModeDir}
+			upper["src/new.go"] = &fstest.MapFile{Data: []byte("package main")}
+			upper["src/old.go"] = whiteout
+			upper["build"] = whiteout
+			upper["dist/app.tar.gz"] = &fstest.MapFile{Data: []byte("archive")}
+			upper["dist/checksums"] = &fstest.MapFile{Data: []byte("sums")}
+			upper["notes"] = &fstest.MapFile{Data: []byte("no longer a directory")}
+		})
+
+		It("returns them in order", func() {
+			Expect(changes, err).To(Equal([]preview.Change{
+				{Kind: preview.Modified, Path: "README.md"},
+				{Kind: preview.Deleted, Path: "build/"},
+				{Kind: preview.Created, Path: "dist/"},
+				{Kind: preview.Modified, Path: "notes"},
+				{Kind: preview.Created, Path: "src/new.go"},
+				{Kind: preview.Deleted, Path: "src/old.go"},
+			}))
+		})
+	})
+})
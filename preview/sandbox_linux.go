@@ -0,0 +1,226 @@
+package preview
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// hiddenDirs are where the sockets of services such as docker, D-Bus and
+// the ssh agent usually live. Connecting to a socket is not a write, so a
+// read-only mount does not stop it; an empty tmpfs is mounted over these
+// directories instead.
+var hiddenDirs = []string{"/tmp", "/var/tmp", "/run", "/var/run", "/dev/shm"}
+
+// socketVariables name sockets, possibly outside hiddenDirs, whose
+// directories are hidden too.
+var socketVariables = []string{"XDG_RUNTIME_DIR", "SSH_AUTH_SOCK", "DOCKER_HOST", "DBUS_SESSION_BUS_ADDRESS"}
+
+// lockedOptions are the mount options a user namespace may not drop when
+// it remounts a mount of its parent, so they are kept.
+var lockedOptions = map[string]bool{"nosuid": true, "nodev": true, "noexec": true, "noatime": true, "nodiratime": true, "relatime": true, "strictatime": true}
+
+// mount is a line of /proc/self/mountinfo.
+type mount struct {
+	point   string
+	options []string
+}
+
+// Run runs command with shell in a sandbox where dir is a copy-on-write
+// overlay, the rest of the file system is read-only, the directories of
+// service sockets are empty, and there is no network, and returns its
+// output and the changes it made to dir. The command runs as root in its
+// own user namespace, which maps to the current user, and its own PID
+// namespace, so it cannot see or signal other processes.
+func Run(shell, dir, command string) (Result, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return Result{}, err
+	}
+	mounts, err := readMounts("/proc/self/mountinfo")
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to read the mounts: %w", err)
+	}
+	tmp, err := os.MkdirTemp("", "gen-preview-")
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to create the sandbox: %w", err)
+	}
+	defer os.RemoveAll(tmp)
+	upper, work := filepath.Join(tmp, "upper"), filepath.Join(tmp, "work")
+	for _, d := range []string{upper, work} {
+		if err := os.Mkdir(d, 0o700); err != nil {
+			return Result{}, fmt.Errorf("failed to create the sandbox: %w", err)
+		}
+	}
+
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return Result{}, err
+	}
+	defer ready.Close()
+
+	var stdout, stderr bytes.Buffer
+	script := setup(dir, upper, work, mounts, socketDirs(os.Getenv))
+	cmd := exec.Command("/bin/sh", "-c", script, shell, command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.ExtraFiles = []*os.File{readyWriter}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS | syscall.CLONE_NEWNET | syscall.CLONE_NEWPID,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+	}
+	if err := cmd.Start(); err != nil {
+		readyWriter.Close()
+		return Result{}, fmt.Errorf("failed to start the sandbox: %w", err)
+	}
+	readyWriter.Close()
+	line, _ := bufio.NewReader(ready).ReadString('\n')
+	err = cmd.Wait()
+	if line != "ok\n" {
+		return Result{}, fmt.Errorf("failed to set up the sandbox: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	result := Result{Stdout: stdout.String(), Stderr: stderr.String()}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		result.ExitCode = exitErr.ExitCode()
+	} else if err != nil {
+		return Result{}, err
+	}
+
+	result.Changes, err = Diff(os.DirFS(dir), os.DirFS(upper))
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to compare the sandbox: %w", err)
+	}
+	return result, nil
+}
+
+// setup returns the script that prepares the sandbox, running as root in
+// the new namespaces, before it runs the command, its $1, with the shell
+// in $0. It mounts an overlay over dir, so that writes go to upper, makes
+// every other mount read-only, mounts an empty tmpfs over the hidden
+// directories that exist and a /proc of the new PID namespace. Any failure
+// stops it. It writes "ok" to fd 3 once the sandbox is ready, which tells
+// its own failures apart from the command's.
+func setup(dir, upper, work string, mounts []mount, hidden []string) string {
+	var b strings.Builder
+	b.WriteString("set -e\n")
+	fmt.Fprintf(&b, "mount -t overlay overlay -o %s %s\n", quote("lowerdir="+dir+",upperdir="+upper+",workdir="+work), quote(dir))
+	// The overlay is reached through fd 4 when a hidden directory covers
+	// it.
+	fmt.Fprintf(&b, "exec 4<%s\n", quote(dir))
+	for _, m := range mounts {
+		if m.point == dir || within(m.point, dir) {
+			continue
+		}
+		fmt.Fprintf(&b, "mount -o %s %s\n", quote(strings.Join(append([]string{"remount", "bind", "ro"}, m.options...), ",")), quote(m.point))
+	}
+
+	var covered bool
+	var inside []string
+	for _, h := range hidden {
+		if info, err := os.Lstat(h); err != nil || !info.IsDir() {
+			continue
+		}
+		if within(h, dir) {
+			inside = append(inside, h)
+			continue
+		}
+		fmt.Fprintf(&b, "mount -t tmpfs tmpfs %s\n", quote(h))
+		covered = covered || h == dir || within(dir, h)
+	}
+	if covered {
+		fmt.Fprintf(&b, "mkdir -p %s\nmount --no-canonicalize --bind /proc/self/fd/4 %s\n", quote(dir), quote(dir))
+	}
+	for _, h := range inside {
+		fmt.Fprintf(&b, "mount -t tmpfs tmpfs %s\n", quote(h))
+	}
+	b.WriteString("exec 4<&-\n")
+	b.WriteString("mount -t proc proc /proc\n")
+	fmt.Fprintf(&b, "cd %s\n", quote(dir))
+	b.WriteString("echo ok >&3\nexec 3>&-\n")
+	b.WriteString(`exec "$0" -c "$1"` + "\n")
+	return b.String()
+}
+
+// socketDirs returns hiddenDirs and the directories of the sockets named by
+// the socketVariables.
+func socketDirs(getenv func(string) string) []string {
+	dirs := append([]string{}, hiddenDirs...)
+	for _, name := range socketVariables {
+		value := getenv(name)
+		// DBUS_SESSION_BUS_ADDRESS is unix:path=/run/user/1000/bus,guid=...
+		// and DOCKER_HOST is unix:///run/docker.sock.
+		if _, path, ok := strings.Cut(value, "path="); ok {
+			value, _, _ = strings.Cut(path, ",")
+		}
+		value = strings.TrimPrefix(value, "unix://")
+		if !filepath.IsAbs(value) {
+			continue
+		}
+		if name != "XDG_RUNTIME_DIR" {
+			value = filepath.Dir(value)
+		}
+		dirs = append(dirs, filepath.Clean(value))
+	}
+	return dirs
+}
+
+// readMounts returns the mount points in a mountinfo file, with the options
+// that must be kept when they are remounted.
+func readMounts(path string) ([]mount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mounts []mount
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 6 {
+			return nil, fmt.Errorf("invalid mountinfo line %q", line)
+		}
+		m := mount{point: unescapeMountinfo(fields[4])}
+		for _, option := range strings.Split(fields[5], ",") {
+			if lockedOptions[option] {
+				m.options = append(m.options, option)
+			}
+		}
+		mounts = append(mounts, m)
+	}
+	return mounts, nil
+}
+
+// unescapeMountinfo decodes the octal escapes of spaces, tabs, newlines
+// and backslashes in a mountinfo path, e.g. \040 for a space.
+func unescapeMountinfo(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// within reports whether path is under dir.
+func within(path, dir string) bool {
+	return strings.HasPrefix(path, strings.TrimSuffix(dir, "/")+"/")
+}
+
+// quote quotes s for the shell.
+func quote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,98 @@
+package preview_test
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/preview"
+)
+
+var _ = Describe("Run", func() {
+	var (
+		dir     string
+		command string
+		result  preview.Result
+		err     error
+	)
+
+	BeforeEach(func() {
+		dir = GinkgoT().TempDir()
+		Expect(os.WriteFile(filepath.Join(dir, "a"), []byte("a\n"), 0o644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(dir, "b"), []byte("b\n"), 0o644)).To(Succeed())
+		command = "echo done; echo c > c; echo b >> b; rm a"
+	})
+
+	JustBeforeEach(func() {
+		result, err = preview.Run("/bin/sh", dir, command)
+		if errors.Is(err, os.ErrPermission) {
+			Skip("user namespaces are not available: " + err.Error())
+		}
+	})
+
+	It("returns the output and the changes", func() {
+		Expect(result, err).To(Equal(preview.Result{
+			Stdout: "done\n",
+			Changes: []preview.Change{
+				{Kind: preview.Deleted, Path: "a"},
+				{Kind: preview.Modified, Path: "b"},
+				{Kind: preview.Created, Path: "c"},
+			},
+		}))
+	})
+
+	It("leaves the directory alone", func() {
+		Expect(os.ReadDir(dir)).To(HaveLen(2))
+	})
+
+	When("the command writes outside the directory", func() {
+		BeforeEach(func() {
+			home, err := os.UserHomeDir()
+			Expect(err).NotTo(HaveOccurred())
+			command = "touch " + filepath.Join(home, ".gen-preview-outside")
+		})
+
+		It("fails", func() {
+			Expect(result.ExitCode, err).To(Equal(1))
+		})
+	})
+
+	When("the command signals a process outside the sandbox", func() {
+		BeforeEach(func() {
+			command = "kill -0 " + strconv.Itoa(os.Getpid())
+		})
+
+		It("fails", func() {
+			Expect(result.ExitCode, err).To(Equal(1))
+		})
+	})
+
+	When("the command looks for a socket outside the directory", func() {
+		BeforeEach(func() {
+			socket := filepath.Join(filepath.Dir(dir), "service.sock")
+			listener, err := net.Listen("unix", socket)
+			Expect(err).NotTo(HaveOccurred())
+			DeferCleanup(listener.Close)
+			command = "test -S " + socket
+		})
+
+		It("does not find it", func() {
+			Expect(result.ExitCode, err).To(Equal(1))
+		})
+	})
+
+	When("the command fails", func() {
+		BeforeEach(func() {
+			command = "echo oops >&2; exit 3"
+		})
+
+		It("returns its exit code and stderr", func() {
+			Expect(result, err).To(Equal(preview.Result{Stderr: "oops\n", ExitCode: 3}))
+		})
+	})
+})
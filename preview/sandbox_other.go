@@ -0,0 +1,8 @@
+//go:build !linux
+
+package preview
+
+// Run is not supported outside Linux.
+func Run(shell, dir, command string) (Result, error) {
+	return Result{}, ErrUnsupported
+}
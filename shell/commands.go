@@ -0,0 +1,386 @@
+package shell
+
+import (
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// wrapper is a command that runs the command that follows its options and
+// operands.
+type wrapper struct {
+	// options lists the short options that take an argument.
+	options string
+	// operands is the number of operands before the command, such as the
+	// duration of timeout.
+	operands int
+}
+
+// wrappers are the commands that run another command.
+var wrappers = map[string]wrapper{
+	"builtin": {},
+	"chroot":  {operands: 1},
+	"command": {},
+	"doas":    {options: "uC"},
+	"env":     {options: "uCS"},
+	"exec":    {options: "a"},
+	"flock":   {options: "wEc", operands: 1},
+	"ionice":  {options: "cnp"},
+	"nice":    {options: "n"},
+	"nohup":   {},
+	"setsid":  {},
+	"stdbuf":  {options: "ioe"},
+	"sudo":    {options: "ugCDhpT"},
+	"time":    {},
+	"timeout": {options: "sk", operands: 1},
+	"watch":   {options: "n"},
+	"xargs":   {options: "EILPdns"},
+}
+
+// simpleCommand is a command without control operators.
+type simpleCommand struct {
+	// wrappers are the wrappers, such as sudo, that run the command.
+	wrappers []string
+	// name is the command run, after wrappers such as sudo.
+	name string
+	// args are the arguments of name after quote removal. Expansions are
+	// kept as written.
+	args []string
+	// redirects are the files the command's input and output are
+	// redirected to or from.
+	redirects []string
+	// piped reports whether the command reads the output of the previous
+	// one.
+	piped bool
+}
+
+// parse parses command as bash, which accepts the syntax of the other
+// shells the parser knows.
+func parse(command string) (*syntax.File, error) {
+	return syntax.NewParser(syntax.Variant(syntax.LangBash)).Parse(strings.NewReader(command), "")
+}
+
+// simpleCommands parses command and returns the simple commands it runs,
+// wherever they are: in lists and pipelines, on separate lines, in the
+// background, in subshells, blocks, conditionals, loops and functions, and
+// in command and process substitutions. The commands of a substitution come
+// before the command whose arguments contain it.
+func simpleCommands(command string) ([]simpleCommand, error) {
+	file, err := parse(command)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		commands  []simpleCommand
+		piped     = map[*syntax.CallExpr]bool{}
+		redirects = map[*syntax.CallExpr][]string{}
+		visit     func(node syntax.Node) bool
+	)
+	visit = func(node syntax.Node) bool {
+		switch n := node.(type) {
+		case *syntax.BinaryCmd:
+			if n.Op == syntax.Pipe || n.Op == syntax.PipeAll {
+				if call := firstCall(n.Y); call != nil {
+					piped[call] = true
+				}
+			}
+		case *syntax.Stmt:
+			// A redirection of a block or subshell applies to every
+			// command in it.
+			for _, r := range n.Redirs {
+				if r.Word == nil || r.Op == syntax.Hdoc || r.Op == syntax.DashHdoc || r.Op == syntax.WordHdoc || r.Op == syntax.DplIn || r.Op == syntax.DplOut {
+					continue
+				}
+				target := wordValue(command, r.Word.Parts, false)
+				syntax.Walk(n.Cmd, func(node syntax.Node) bool {
+					if call, ok := node.(*syntax.CallExpr); ok {
+						redirects[call] = append(redirects[call], target)
+					}
+					return true
+				})
+			}
+		case *syntax.CallExpr:
+			for _, assign := range n.Assigns {
+				syntax.Walk(assign, visit)
+			}
+			var args []string
+			for _, word := range n.Args {
+				syntax.Walk(word, visit)
+				args = append(args, wordValue(command, word.Parts, false))
+			}
+			if c, ok := newSimpleCommand(args); ok {
+				c.piped = piped[n]
+				c.redirects = redirects[n]
+				commands = append(commands, c)
+			}
+			return false
+		}
+		return true
+	}
+	syntax.Walk(file, visit)
+	return commands, nil
+}
+
+// firstCall returns the first simple command of a command, which is the one
+// that reads its input in a pipeline.
+func firstCall(node syntax.Node) *syntax.CallExpr {
+	var first *syntax.CallExpr
+	syntax.Walk(node, func(node syntax.Node) bool {
+		if call, ok := node.(*syntax.CallExpr); ok && first == nil {
+			first = call
+		}
+		return first == nil
+	})
+	return first
+}
+
+// wordValue returns a word after quote removal, keeping expansions such as
+// $HOME and $(date) as they are written in command.
+func wordValue(command string, parts []syntax.WordPart, quoted bool) string {
+	var b strings.Builder
+	for _, part := range parts {
+		switch p := part.(type) {
+		case *syntax.Lit:
+			b.WriteString(unescape(p.Value, quoted))
+		case *syntax.SglQuoted:
+			b.WriteString(p.Value)
+		case *syntax.DblQuoted:
+			b.WriteString(wordValue(command, p.Parts, true))
+		default:
+			b.WriteString(command[p.Pos().Offset():p.End().Offset()])
+		}
+	}
+	return b.String()
+}
+
+// unescape removes the backslashes of a literal. In double quotes, only
+// the characters the shell treats specially there are escaped.
+func unescape(s string, quoted bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && (!quoted || strings.IndexByte("$`\"\\\n", s[i+1]) >= 0) {
+			i++
+			if s[i] == '\n' {
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// newSimpleCommand skips the wrappers of a command and their options and
+// operands, and reports whether a command is left. The command of flock -c
+// is run by sh -c.
+func newSimpleCommand(words []string) (simpleCommand, bool) {
+	var used []string
+	i := 0
+	for i < len(words) {
+		name := words[i]
+		w, ok := wrappers[name]
+		if !ok {
+			break
+		}
+		used = append(used, name)
+		// Options may follow the operands, as in flock file -c script.
+		operands := w.operands
+		for i++; i < len(words); i++ {
+			word := words[i]
+			if name == "flock" && (word == "-c" || word == "--command") && i+1 < len(words) {
+				return simpleCommand{wrappers: used, name: "sh", args: []string{"-c", words[i+1]}}, true
+			}
+			if strings.HasPrefix(word, "-") {
+				if len(word) == 2 && strings.ContainsRune(w.options, rune(word[1])) {
+					i++
+				}
+				continue
+			}
+			if operands == 0 {
+				break
+			}
+			operands--
+		}
+		// env takes assignments before the command.
+		for i < len(words) && assignment.MatchString(words[i]) {
+			i++
+		}
+	}
+	switch {
+	case i < len(words):
+		c := simpleCommand{wrappers: used, name: words[i]}
+		if i+1 < len(words) {
+			c.args = words[i+1:]
+		}
+		return c, true
+	case len(used) > 0:
+		// The last wrapper is run on its own, e.g. sudo -i.
+		c := simpleCommand{name: used[len(used)-1]}
+		if len(used) > 1 {
+			c.wrappers = used[:len(used)-1]
+		}
+		return c, true
+	}
+	return simpleCommand{}, false
+}
+
+// flagged reports whether one of the options before -- is one of the short
+// options or long names.
+func (c simpleCommand) flagged(short string, long ...string) bool {
+	for _, arg := range c.args {
+		switch {
+		case arg == "--":
+			return false
+		case strings.HasPrefix(arg, "--"):
+			for _, name := range long {
+				if arg == name || strings.HasPrefix(arg, name+"=") {
+					return true
+				}
+			}
+		case shortFlags.MatchString(arg) && strings.ContainsAny(arg[1:], short):
+			return true
+		}
+	}
+	return false
+}
+
+// operands returns the arguments that are not options.
+func (c simpleCommand) operands() []string {
+	var operands []string
+	options := true
+	for _, arg := range c.args {
+		switch {
+		case options && arg == "--":
+			options = false
+		case options && strings.HasPrefix(arg, "-"):
+		default:
+			operands = append(operands, arg)
+		}
+	}
+	return operands
+}
+
+// Command is one of the simple commands a command line runs.
+type Command struct {
+	// Wrappers are the commands, such as sudo or xargs, that run Name.
+	Wrappers []string
+	// Name is the command that is run, as written.
+	Name string
+	// Args are the arguments of Name after quote removal.
+	Args []string
+	// Redirects are the files the input and output of the command are
+	// redirected to or from.
+	Redirects []string
+}
+
+// Commands parses command and returns the simple commands it runs,
+// including the commands of sh -c, eval, find -exec, subshells, blocks,
+// conditionals, loops, functions and command substitutions. Like Assess, it
+// only reads the command, so it cannot see commands that are built at run
+// time. It returns the syntax error of a command that does not parse.
+func Commands(command string) ([]Command, error) {
+	all, err := commands(command)
+	if err != nil {
+		return nil, err
+	}
+
+	var commands []Command
+	for _, c := range all {
+		commands = append(commands, Command{Wrappers: c.wrappers, Name: c.name, Args: c.args, Redirects: c.redirects})
+	}
+	return commands, nil
+}
+
+// commands returns the simple commands of command, each followed by the
+// commands it runs itself.
+func commands(command string) ([]simpleCommand, error) {
+	simple, err := simpleCommands(command)
+	if err != nil {
+		return nil, err
+	}
+
+	var all []simpleCommand
+	for _, c := range simple {
+		nested, err := c.nested()
+		if err != nil {
+			return nil, err
+		}
+		all = append(append(all, c), nested...)
+	}
+	return all, nil
+}
+
+// nested returns the commands a shell, eval or find runs, and the commands
+// those run in turn.
+func (c simpleCommand) nested() ([]simpleCommand, error) {
+	switch {
+	case shells[c.name]:
+		if script, ok := shellScript(c.args); ok {
+			return commands(script)
+		}
+	case c.name == "eval":
+		return commands(strings.Join(c.args, " "))
+	case c.name == "find":
+		var all []simpleCommand
+		for _, words := range findCommands(c.args) {
+			run, _ := newSimpleCommand(words)
+			nested, err := run.nested()
+			if err != nil {
+				return nil, err
+			}
+			all = append(append(all, run), nested...)
+		}
+		return all, nil
+	}
+	return nil, nil
+}
+
+// shellScript returns the script of a shell run with -c, which may be
+// grouped with other options, as in bash -lc or sh -ec.
+func shellScript(args []string) (string, bool) {
+	script := false
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			i++
+		case arg == "--rcfile" || arg == "--init-file":
+			i++
+			continue
+		case strings.HasPrefix(arg, "--"):
+			continue
+		case len(arg) > 1 && (arg[0] == '-' || arg[0] == '+'):
+			// -o and -O take the name of an option, as in -euo pipefail.
+			i += strings.Count(arg[1:], "o") + strings.Count(arg[1:], "O")
+			script = script || arg[0] == '-' && strings.Contains(arg[1:], "c")
+			continue
+		}
+		if script && i < len(args) {
+			return args[i], true
+		}
+		return "", false
+	}
+	return "", false
+}
+
+// findCommands returns the words of the commands find runs with -exec and
+// its relatives.
+func findCommands(args []string) [][]string {
+	var commands [][]string
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "-exec", "-execdir", "-ok", "-okdir":
+		default:
+			continue
+		}
+		end := i + 1
+		for end < len(args) && args[end] != ";" && args[end] != "+" {
+			end++
+		}
+		if end > i+1 {
+			commands = append(commands, args[i+1:end])
+		}
+		i = end
+	}
+	return commands
+}
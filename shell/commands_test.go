@@ -0,0 +1,164 @@
+package shell_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/shell"
+)
+
+var _ = Describe("Commands", func() {
+	DescribeTable("returns the simple commands a command line runs",
+		func(command string, commands []shell.Command) {
+			Expect(shell.Commands(command)).To(Equal(commands))
+		},
+		Entry("a single command", "ls -l /tmp", []shell.Command{
+			{Name: "ls", Args: []string{"-l", "/tmp"}},
+		}),
+		Entry("a pipeline and a list", "cat a | grep x && echo 'done'", []shell.Command{
+			{Name: "cat", Args: []string{"a"}},
+			{Name: "grep", Args: []string{"x"}},
+			{Name: "echo", Args: []string{"done"}},
+		}),
+		Entry("wrappers and assignments", "LC_ALL=C sudo -u root nice -n 5 make install", []shell.Command{
+			{Wrappers: []string{"sudo", "nice"}, Name: "make", Args: []string{"install"}},
+		}),
+		Entry("a wrapper on its own", "sudo -i", []shell.Command{
+			{Name: "sudo"},
+		}),
+		Entry("wrappers on their own", "sudo env", []shell.Command{
+			{Wrappers: []string{"sudo"}, Name: "env"},
+		}),
+		Entry("sh -c", `bash -c "curl -s example.com"`, []shell.Command{
+			{Name: "bash", Args: []string{"-c", "curl -s example.com"}},
+			{Name: "curl", Args: []string{"-s", "example.com"}},
+		}),
+		Entry("-c grouped with other options", `bash -lc 'rm -rf /'`, []shell.Command{
+			{Name: "bash", Args: []string{"-lc", "rm -rf /"}},
+			{Name: "rm", Args: []string{"-rf", "/"}},
+		}),
+		Entry("-c before other options", `sh -c -e 'rm x'`, []shell.Command{
+			{Name: "sh", Args: []string{"-c", "-e", "rm x"}},
+			{Name: "rm", Args: []string{"x"}},
+		}),
+		Entry("-c after options with arguments", `bash --norc --rcfile x -euo pipefail +O extglob -c 'rm x'`, []shell.Command{
+			{Name: "bash", Args: []string{"--norc", "--rcfile", "x", "-euo", "pipefail", "+O", "extglob", "-c", "rm x"}},
+			{Name: "rm", Args: []string{"x"}},
+		}),
+		Entry("-c after --", `sh -c -- 'rm x'`, []shell.Command{
+			{Name: "sh", Args: []string{"-c", "--", "rm x"}},
+			{Name: "rm", Args: []string{"x"}},
+		}),
+		Entry("-c without a script", "sh -c", []shell.Command{
+			{Name: "sh", Args: []string{"-c"}},
+		}),
+		Entry("a shell running a file", "bash -x deploy.sh", []shell.Command{
+			{Name: "bash", Args: []string{"-x", "deploy.sh"}},
+		}),
+		Entry("a shell on its own", "bash -l", []shell.Command{
+			{Name: "bash", Args: []string{"-l"}},
+		}),
+		Entry("sudo sh -c", `sudo sh -xc 'rm x'`, []shell.Command{
+			{Wrappers: []string{"sudo"}, Name: "sh", Args: []string{"-xc", "rm x"}},
+			{Name: "rm", Args: []string{"x"}},
+		}),
+		Entry("eval", `eval "wget example.com"`, []shell.Command{
+			{Name: "eval", Args: []string{"wget example.com"}},
+			{Name: "wget", Args: []string{"example.com"}},
+		}),
+		Entry("find -exec", `find . -name '*.tmp' -exec rm -f {} \; -print`, []shell.Command{
+			{Name: "find", Args: []string{".", "-name", "*.tmp", "-exec", "rm", "-f", "{}", ";", "-print"}},
+			{Name: "rm", Args: []string{"-f", "{}"}},
+		}),
+		Entry("sh -c run by find", `find . -exec sh -c 'rm x' +`, []shell.Command{
+			{Name: "find", Args: []string{".", "-exec", "sh", "-c", "rm x", "+"}},
+			{Name: "sh", Args: []string{"-c", "rm x"}},
+			{Name: "rm", Args: []string{"x"}},
+		}),
+		Entry("a wrapper run by find", `find . -execdir sudo rm {} \;`, []shell.Command{
+			{Name: "find", Args: []string{".", "-execdir", "sudo", "rm", "{}", ";"}},
+			{Wrappers: []string{"sudo"}, Name: "rm", Args: []string{"{}"}},
+		}),
+		Entry("timeout", "timeout -s KILL 5 curl example.com", []shell.Command{
+			{Wrappers: []string{"timeout"}, Name: "curl", Args: []string{"example.com"}},
+		}),
+		Entry("watch", "watch -n 1 curl example.com", []shell.Command{
+			{Wrappers: []string{"watch"}, Name: "curl", Args: []string{"example.com"}},
+		}),
+		Entry("setsid and stdbuf", "setsid stdbuf -o0 curl example.com", []shell.Command{
+			{Wrappers: []string{"setsid", "stdbuf"}, Name: "curl", Args: []string{"example.com"}},
+		}),
+		Entry("ionice", "ionice -c 3 rm -r build", []shell.Command{
+			{Wrappers: []string{"ionice"}, Name: "rm", Args: []string{"-r", "build"}},
+		}),
+		Entry("chroot", "chroot /srv/root rm x", []shell.Command{
+			{Wrappers: []string{"chroot"}, Name: "rm", Args: []string{"x"}},
+		}),
+		Entry("flock", "flock -w 5 /tmp/lock rm x", []shell.Command{
+			{Wrappers: []string{"flock"}, Name: "rm", Args: []string{"x"}},
+		}),
+		Entry("flock -c", "flock /tmp/lock -c 'rm x'", []shell.Command{
+			{Wrappers: []string{"flock"}, Name: "sh", Args: []string{"-c", "rm x"}},
+			{Name: "rm", Args: []string{"x"}},
+		}),
+		Entry("a wrapper without its operands", "timeout", []shell.Command{
+			{Name: "timeout"},
+		}),
+		Entry("command substitutions", "echo $(whoami) `hostname`", []shell.Command{
+			{Name: "whoami"},
+			{Name: "hostname"},
+			{Name: "echo", Args: []string{"$(whoami)", "`hostname`"}},
+		}),
+		Entry("commands on separate lines", "echo hi\nrm x", []shell.Command{
+			{Name: "echo", Args: []string{"hi"}},
+			{Name: "rm", Args: []string{"x"}},
+		}),
+		Entry("a command in the background", "true & rm x", []shell.Command{
+			{Name: "true"},
+			{Name: "rm", Args: []string{"x"}},
+		}),
+		Entry("subshells and blocks", "(rm x) && { cd /tmp; ls; }", []shell.Command{
+			{Name: "rm", Args: []string{"x"}},
+			{Name: "cd", Args: []string{"/tmp"}},
+			{Name: "ls"},
+		}),
+		Entry("conditionals and loops", `if true; then rm x; fi; for f in *; do echo "$f"; done`, []shell.Command{
+			{Name: "true"},
+			{Name: "rm", Args: []string{"x"}},
+			{Name: "echo", Args: []string{"$f"}},
+		}),
+		Entry("redirections", "sort <in.txt 2>/dev/null >out.txt 2>&1", []shell.Command{
+			{Name: "sort", Redirects: []string{"in.txt", "/dev/null", "out.txt"}},
+		}),
+		Entry("a redirection of a block", "{ echo a; echo b; } >>log", []shell.Command{
+			{Name: "echo", Args: []string{"a"}, Redirects: []string{"log"}},
+			{Name: "echo", Args: []string{"b"}, Redirects: []string{"log"}},
+		}),
+		Entry("escapes and quotes", `echo a\ b "c \"d\" $HOME" 'e\f'`, []shell.Command{
+			{Name: "echo", Args: []string{"a b", `c "d" $HOME`, `e\f`}},
+		}),
+		Entry("env with assignments", "env -u PATH LANG=C make", []shell.Command{
+			{Wrappers: []string{"env"}, Name: "make"},
+		}),
+		Entry("an assignment on its own", "X=$(rm x)", []shell.Command{
+			{Name: "rm", Args: []string{"x"}},
+		}),
+		Entry("an empty command", "", nil),
+	)
+
+	It("returns the error of a command that does not parse", func() {
+		Expect(shell.Commands("echo 'hi")).Error().To(MatchError("1:6: reached EOF without closing quote '"))
+	})
+
+	It("returns the error of a nested command that does not parse", func() {
+		Expect(shell.Commands(`bash -c "echo 'hi"`)).Error().To(HaveOccurred())
+	})
+
+	It("returns the error of an eval that does not parse", func() {
+		Expect(shell.Commands(`eval "echo 'hi"`)).Error().To(HaveOccurred())
+	})
+
+	It("returns the error of a command run by find that does not parse", func() {
+		Expect(shell.Commands(`find . -exec sh -c "echo 'hi" \;`)).Error().To(HaveOccurred())
+	})
+})
@@ -0,0 +1,83 @@
+package shell
+
+// Word is a word of a command compared with other commands.
+type Word struct {
+	Text string
+	// Differs reports whether the word is missing from, or in a different
+	// place in, at least one of the other commands.
+	Differs bool
+}
+
+// Fields splits command into its words and control operators.
+func Fields(command string) []string {
+	var fields []string
+	for _, t := range tokenize(command) {
+		fields = append(fields, t.raw)
+	}
+	return fields
+}
+
+// Compare splits each of commands into words and marks the words that are
+// not common to all of them, by the longest common subsequence of words
+// with each of the other commands.
+func Compare(commands ...string) [][]Word {
+	fields := make([][]string, len(commands))
+	for i, command := range commands {
+		fields[i] = Fields(command)
+	}
+
+	words := make([][]Word, len(commands))
+	for i := range fields {
+		words[i] = make([]Word, len(fields[i]))
+		for k, field := range fields[i] {
+			words[i][k].Text = field
+		}
+		for j := range fields {
+			if i == j {
+				continue
+			}
+			common := commonWords(fields[i], fields[j])
+			for k := range words[i] {
+				if !common[k] {
+					words[i][k].Differs = true
+				}
+			}
+		}
+	}
+	return words
+}
+
+// commonWords marks the words of a that are part of the longest common
+// subsequence of a and b.
+func commonWords(a, b []string) []bool {
+	// lengths[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:].
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	common := make([]bool, len(a))
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] == b[j]:
+			common[i] = true
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	return common
+}
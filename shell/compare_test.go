@@ -0,0 +1,74 @@
+package shell_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/shell"
+)
+
+var _ = Describe("Fields", func() {
+	It("splits a command into words and operators", func() {
+		Expect(shell.Fields(`grep -r 'a b' .|wc -l`)).To(Equal([]string{"grep", "-r", "'a b'", ".", "|", "wc", "-l"}))
+	})
+})
+
+var _ = Describe("Compare", func() {
+	var (
+		commands []string
+		words    [][]shell.Word
+	)
+
+	JustBeforeEach(func() {
+		words = shell.Compare(commands...)
+	})
+
+	When("the commands are the same", func() {
+		BeforeEach(func() {
+			commands = []string{"ls -l", "ls -l"}
+		})
+
+		It("marks no differences", func() {
+			Expect(words).To(Equal([][]shell.Word{
+				{{Text: "ls"}, {Text: "-l"}},
+				{{Text: "ls"}, {Text: "-l"}},
+			}))
+		})
+	})
+
+	When("the commands differ", func() {
+		BeforeEach(func() {
+			commands = []string{"find . -name '*.log' -delete", "find . -name '*.log' -print"}
+		})
+
+		It("marks the words that differ", func() {
+			Expect(words).To(Equal([][]shell.Word{
+				{{Text: "find"}, {Text: "."}, {Text: "-name"}, {Text: "'*.log'"}, {Text: "-delete", Differs: true}},
+				{{Text: "find"}, {Text: "."}, {Text: "-name"}, {Text: "'*.log'"}, {Text: "-print", Differs: true}},
+			}))
+		})
+	})
+
+	When("a command has an extra word", func() {
+		BeforeEach(func() {
+			commands = []string{"rm -r build", "rm build"}
+		})
+
+		It("marks the extra word", func() {
+			Expect(words).To(Equal([][]shell.Word{
+				{{Text: "rm"}, {Text: "-r", Differs: true}, {Text: "build"}},
+				{{Text: "rm"}, {Text: "build"}},
+			}))
+		})
+	})
+
+	When("there are more than two commands", func() {
+		BeforeEach(func() {
+			commands = []string{"du -h .", "du -h .", "du -s ."}
+		})
+
+		It("marks words missing from any of the others", func() {
+			Expect(words[0]).To(Equal([]shell.Word{{Text: "du"}, {Text: "-h", Differs: true}, {Text: "."}}))
+		})
+	})
+})
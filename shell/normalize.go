@@ -0,0 +1,251 @@
+// Package shell works with the text of generated shell commands.
+package shell
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// token is a word or control operator of a command line.
+type token struct {
+	// raw is the token as written.
+	raw string
+	// value is the word after quote removal.
+	value string
+	// literal reports whether the word expands to exactly value, i.e.
+	// every character the shell would expand is quoted.
+	literal bool
+	// operator reports whether the token is a control operator.
+	operator bool
+}
+
+// operators are the control operators that separate commands, longest
+// first.
+var operators = []string{"&&", "||", "|", ";"}
+
+// tokenize splits command into words and control operators the way the shell
+// does, without expanding anything. Unterminated quotes run to the end of
+// the command.
+func tokenize(command string) []token {
+	var (
+		tokens  []token
+		current *token
+	)
+	word := func() *token {
+		if current == nil {
+			current = &token{literal: true}
+		}
+		return current
+	}
+	flush := func() {
+		if current != nil {
+			tokens = append(tokens, *current)
+			current = nil
+		}
+	}
+
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+		case c == '\'':
+			end := strings.IndexByte(command[i+1:], '\'')
+			if end < 0 {
+				end = len(command) - i - 1
+			}
+			t := word()
+			t.raw += command[i:min(i+end+2, len(command))]
+			t.value += command[i+1 : i+1+end]
+			i += end + 1
+		case c == '"':
+			t := word()
+			t.raw += `"`
+			for i++; i < len(command) && command[i] != '"'; i++ {
+				t.raw += string(command[i])
+				switch {
+				case command[i] == '\\' && i+1 < len(command) && strings.ContainsRune("$`\"\\\n", rune(command[i+1])):
+					i++
+					t.raw += string(command[i])
+					t.value += string(command[i])
+				case command[i] == '$' || command[i] == '`':
+					t.literal = false
+					t.value += string(command[i])
+				default:
+					t.value += string(command[i])
+				}
+			}
+			if i < len(command) {
+				t.raw += `"`
+			}
+		case c == '\\' && i+1 < len(command):
+			t := word()
+			t.raw += command[i : i+2]
+			t.value += command[i+1 : i+2]
+			i++
+		default:
+			if op := operatorAt(command[i:]); op != "" {
+				flush()
+				tokens = append(tokens, token{raw: op, value: op, operator: true})
+				i += len(op) - 1
+				continue
+			}
+			t := word()
+			if strings.IndexByte("$`*?[{~<>()&!", c) >= 0 {
+				t.literal = false
+			}
+			t.raw += string(c)
+			t.value += string(c)
+		}
+	}
+	flush()
+	return tokens
+}
+
+func operatorAt(s string) string {
+	for _, op := range operators {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+// safeWord matches words that need no quoting.
+var safeWord = regexp.MustCompile(`^[A-Za-z0-9_./:=,+@%^-]+$`)
+
+// quote returns the canonical spelling of a literal word: bare when it is
+// safe, single quoted otherwise.
+func quote(value string) string {
+	if safeWord.MatchString(value) {
+		return value
+	}
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// argFlags lists, for commands whose short options are well known, the
+// options that take an argument. The other short options of these commands
+// are switches, which can be reordered and combined without changing the
+// command.
+var argFlags = map[string]string{
+	"cat":   "",
+	"chmod": "",
+	"chown": "",
+	"cp":    "St",
+	"df":    "Btx",
+	"du":    "dBt",
+	"egrep": "efmABCdD",
+	"fgrep": "efmABCdD",
+	"grep":  "efmABCdD",
+	"head":  "nc",
+	"ln":    "St",
+	"ls":    "ITw",
+	"mkdir": "m",
+	"mv":    "St",
+	"rm":    "",
+	"rmdir": "",
+	"sort":  "kStoT",
+	"tail":  "ncs",
+	"uniq":  "fsw",
+	"wc":    "",
+}
+
+var (
+	shortFlags = regexp.MustCompile(`^-[A-Za-z0-9]+$`)
+	assignment = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=`)
+)
+
+// Normalize rewrites command into a canonical form, so that commands that
+// differ only in formatting compare equal. It collapses whitespace, spells
+// literal words with the least quoting, and sorts and combines the switches
+// of well known commands, e.g. `ls -l  -a "/tmp"` becomes `ls -al /tmp`.
+// The result is meant for comparison and is not guaranteed to run.
+func Normalize(command string) string {
+	tokens := tokenize(strings.TrimSpace(command))
+	for len(tokens) > 0 && tokens[len(tokens)-1].raw == ";" {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	var words []string
+	for start := 0; start < len(tokens); {
+		end := start
+		for end < len(tokens) && !tokens[end].operator {
+			end++
+		}
+		words = append(words, normalizeSimpleCommand(tokens[start:end])...)
+		if end < len(tokens) {
+			words = append(words, tokens[end].raw)
+		}
+		start = end + 1
+	}
+	return strings.Join(words, " ")
+}
+
+// normalizeSimpleCommand normalizes the words of a command without control
+// operators.
+func normalizeSimpleCommand(tokens []token) []string {
+	words := make([]string, len(tokens))
+	for i, t := range tokens {
+		words[i] = t.raw
+		if t.literal {
+			words[i] = quote(t.value)
+		}
+	}
+
+	// Find the command name, skipping variable assignments and sudo.
+	name := 0
+	for name < len(tokens) && (assignment.MatchString(tokens[name].raw) || (tokens[name].value == "sudo" && name+1 < len(tokens))) {
+		name++
+	}
+	if name == len(tokens) || !tokens[name].literal {
+		return words
+	}
+	args, known := argFlags[tokens[name].value]
+	if !known {
+		return words
+	}
+
+	normalized := append([]string{}, words[:name+1]...)
+	var switches []byte
+	flushSwitches := func() {
+		if len(switches) == 0 {
+			return
+		}
+		sort.Slice(switches, func(i, j int) bool { return switches[i] < switches[j] })
+		var unique []byte
+		for i, s := range switches {
+			if i == 0 || s != switches[i-1] {
+				unique = append(unique, s)
+			}
+		}
+		normalized = append(normalized, "-"+string(unique))
+		switches = nil
+	}
+
+	for i := name + 1; i < len(tokens); i++ {
+		value := tokens[i].value
+		if !tokens[i].literal || !shortFlags.MatchString(value) {
+			flushSwitches()
+			if value == "--" {
+				return append(normalized, words[i:]...)
+			}
+			normalized = append(normalized, words[i])
+			continue
+		}
+		if strings.ContainsAny(value[1:], args) {
+			// The option's argument is the rest of the word or the next
+			// word, so neither may move.
+			flushSwitches()
+			normalized = append(normalized, words[i])
+			if strings.IndexAny(value[1:], args) == len(value)-2 && i+1 < len(tokens) {
+				i++
+				normalized = append(normalized, words[i])
+			}
+			continue
+		}
+		switches = append(switches, value[1:]...)
+	}
+	flushSwitches()
+	return normalized
+}
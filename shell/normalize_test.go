@@ -0,0 +1,38 @@
+package shell_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/shell"
+)
+
+var _ = Describe("Normalize", func() {
+	DescribeTable("rewrites equivalent commands to the same form",
+		func(command, normalized string) {
+			Expect(shell.Normalize(command)).To(Equal(normalized))
+		},
+		Entry("collapses whitespace", "  ls\t -l   /tmp ", "ls -l /tmp"),
+		Entry("spaces control operators", "ls|wc -l&&echo done;", "ls | wc -l && echo done"),
+		Entry("keeps operators in quotes", `echo 'a|b'`, "echo 'a|b'"),
+		Entry("unquotes safe words", `cat "notes.txt" 'todo.md'`, "cat notes.txt todo.md"),
+		Entry("single quotes words with spaces", `cat "my notes.txt"`, "cat 'my notes.txt'"),
+		Entry("single quotes escaped words", `find . -name \*.go`, "find . -name '*.go'"),
+		Entry("escapes single quotes", `echo "it's"`, `echo 'it'\''s'`),
+		Entry("keeps escapes in double quotes", `echo "say \"hi\""`, `echo 'say "hi"'`),
+		Entry("keeps expansions as written", `echo "$HOME" ~/src *.go`, `echo "$HOME" ~/src *.go`),
+		Entry("keeps unterminated quotes", `echo 'oops`, `echo oops`),
+		Entry("keeps unterminated double quotes", `echo "oops`, `echo oops`),
+		Entry("keeps redirections", "ls 2>&1 >/dev/null", "ls 2>&1 >/dev/null"),
+		Entry("sorts and combines switches", "ls -l -a -h /tmp", "ls -ahl /tmp"),
+		Entry("drops repeated switches", "ls -la -l", "ls -al"),
+		Entry("finds the command after sudo and assignments", "LC_ALL=C sudo rm -r -f build", "LC_ALL=C sudo rm -fr build"),
+		Entry("keeps options with an argument in place", "grep -n -A 3 -i -r TODO .", "grep -n -A 3 -ir TODO ."),
+		Entry("keeps an argument attached to its option", "grep -A3 -v -i x", "grep -A3 -iv x"),
+		Entry("keeps operands in place", "ls -l /tmp -a", "ls -l /tmp -a"),
+		Entry("stops at --", "rm -f -- -r -i", "rm -f -- -r -i"),
+		Entry("leaves unknown commands alone", "tar -x -z -f a.tgz", "tar -x -z -f a.tgz"),
+		Entry("leaves computed commands alone", "$EDITOR -b -a", "$EDITOR -b -a"),
+		Entry("normalizes each command of a pipeline", "du -s -h * | sort -r -h", "du -hs * | sort -hr"),
+	)
+})
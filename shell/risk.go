@@ -0,0 +1,221 @@
+package shell
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Risk is how much damage a command can do.
+type Risk int
+
+const (
+	RiskLow Risk = iota
+	RiskMedium
+	RiskHigh
+)
+
+func (r Risk) String() string {
+	switch r {
+	case RiskMedium:
+		return "medium"
+	case RiskHigh:
+		return "high"
+	}
+	return "low"
+}
+
+// Finding is a risky pattern found in a command.
+type Finding struct {
+	// Rule names the pattern, e.g. "rm-recursive".
+	Rule   string
+	Risk   Risk
+	Reason string
+}
+
+// Assessment is the result of analyzing a command.
+type Assessment struct {
+	// Risk is the highest risk of the findings, or RiskLow.
+	Risk     Risk
+	Findings []Finding
+}
+
+func (a *Assessment) add(rule string, risk Risk, format string, args ...any) {
+	a.Findings = append(a.Findings, Finding{Rule: rule, Risk: risk, Reason: fmt.Sprintf(format, args...)})
+	a.Risk = max(a.Risk, risk)
+}
+
+var (
+	// forkBomb matches functions that pipe a call of themselves into
+	// another one in the background, e.g. :(){ :|:& };:
+	forkBomb = regexp.MustCompile(`([\w:.]+)\s*\(\)\s*\{\s*([\w:.]+)\s*\|\s*([\w:.]+)\s*&`)
+	// fetchAndRun matches shells running a downloaded script through
+	// process or command substitution, e.g. bash <(curl -s url).
+	fetchAndRun = regexp.MustCompile(`\b(ba|z|da|k)?sh\b[^|;&]*(<\(|\$\(|` + "`" + `)\s*(curl|wget)\b`)
+	// deviceRedirect matches output redirected onto a disk.
+	deviceRedirect = regexp.MustCompile(`>\s*(/dev/(sd|hd|vd|xvd|nvme|mmcblk|disk|md|dm-)\S*)`)
+	sqlDrop        = regexp.MustCompile(`(?i)\b(drop\s+(table|database|schema)|truncate\s+(table\s+)?\w)`)
+	mkfsCommand    = regexp.MustCompile(`^(mkfs(\..+)?|mke2fs|mkswap|wipefs)$`)
+	// broadPath matches paths whose recursive removal loses a home
+	// directory, the working directory or a top level directory.
+	broadPath = regexp.MustCompile(`^(/[^/]*|~[^/]*|\$HOME|\$\{HOME\}|\.|\.\.|\*|\.\*)/?\*?$`)
+	// rootPath matches / and the top level directories.
+	rootPath = regexp.MustCompile(`^/[^/]*/?\*?$`)
+	// variablePath matches paths that start at / when the variable is
+	// empty, e.g. "$DIR/".
+	variablePath = regexp.MustCompile(`^\$\{?(\w+)\}?/`)
+)
+
+var (
+	shells     = map[string]bool{"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true, "fish": true}
+	fetchers   = map[string]bool{"curl": true, "wget": true, "fetch": true}
+	sqlClients = map[string]bool{"psql": true, "mysql": true, "mariadb": true, "sqlite3": true, "sqlcmd": true, "clickhouse-client": true}
+	safeDevice = map[string]bool{"/dev/null": true, "/dev/zero": true, "/dev/stdout": true, "/dev/stderr": true, "/dev/tty": true}
+	// findEverything are the options and actions of find that do not
+	// narrow down the files it finds.
+	findEverything = map[string]bool{"-H": true, "-L": true, "-P": true, "-delete": true, "-depth": true, "-maxdepth": true, "-mindepth": true, "-mount": true, "-xdev": true}
+)
+
+// Assess looks for patterns that destroy data or compromise the system in
+// command, such as rm -rf on a broad path, dd onto a disk, or a downloaded
+// script piped into a shell. It parses the command, so the patterns are
+// found wherever the commands are, and a command that does not parse is
+// high risk. It only reads the command, so a command that builds its
+// arguments at run time may hide its risk.
+func Assess(command string) Assessment {
+	var a Assessment
+	assess(&a, command)
+	return a
+}
+
+func assess(a *Assessment, command string) {
+	if m := forkBomb.FindStringSubmatch(command); m != nil && m[1] == m[2] && m[2] == m[3] {
+		a.add("fork-bomb", RiskHigh, "%s is a fork bomb that exhausts the system's processes", m[1])
+	}
+	if fetchAndRun.MatchString(command) {
+		a.add("pipe-to-shell", RiskHigh, "runs a downloaded script without showing it")
+	}
+	if m := deviceRedirect.FindStringSubmatch(command); m != nil {
+		a.add("disk-write", RiskHigh, "writes directly to %s, overwriting its filesystem", m[1])
+	}
+
+	commands, err := commands(command)
+	if err != nil {
+		a.add("unparsed", RiskHigh, "the command could not be parsed, so its risk is unknown: %v", err)
+		return
+	}
+	fetched := false
+	for _, c := range commands {
+		switch {
+		case c.name == "rm":
+			assessRemove(a, c)
+		case c.name == "find":
+			assessFind(a, c)
+		case c.name == "dd":
+			for _, operand := range c.operands() {
+				if device, ok := strings.CutPrefix(operand, "of="); ok && strings.HasPrefix(device, "/dev/") && !safeDevice[device] {
+					a.add("disk-write", RiskHigh, "dd writes directly to %s, overwriting its filesystem", device)
+				}
+			}
+		case mkfsCommand.MatchString(c.name):
+			a.add("mkfs", RiskHigh, "%s erases a filesystem", c.name)
+		case c.name == "chmod" || c.name == "chown" || c.name == "chgrp":
+			if c.flagged("R", "--recursive") {
+				for _, operand := range c.operands() {
+					if rootPath.MatchString(operand) {
+						a.add("recursive-permissions", RiskHigh, "%s -R changes everything under %s", c.name, operand)
+					}
+				}
+			}
+		case c.name == "git":
+			assessPush(a, c)
+		case sqlClients[c.name]:
+			if sqlDrop.MatchString(command) {
+				a.add("sql-drop", RiskHigh, "%s drops or empties a table or database", c.name)
+			}
+		case shells[c.name]:
+			if c.piped && fetched {
+				a.add("pipe-to-shell", RiskHigh, "pipes a downloaded script into %s without showing it", c.name)
+			}
+		}
+		fetched = fetched || fetchers[c.name]
+	}
+}
+
+// assessRemove assesses an rm command.
+func assessRemove(a *Assessment, c simpleCommand) {
+	if !c.flagged("rR", "--recursive") {
+		return
+	}
+	if c.flagged("", "--no-preserve-root") {
+		a.add("rm-recursive", RiskHigh, "rm --no-preserve-root can delete the whole filesystem")
+		return
+	}
+	for _, operand := range c.operands() {
+		if broadPath.MatchString(operand) {
+			a.add("rm-recursive", RiskHigh, "rm -r deletes everything under %s", operand)
+			return
+		}
+		if m := variablePath.FindStringSubmatch(operand); m != nil && m[1] != "HOME" {
+			a.add("rm-recursive", RiskHigh, "rm -r deletes from / if $%s is empty", m[1])
+			return
+		}
+	}
+	a.add("rm-recursive", RiskMedium, "rm -r deletes directories and their contents")
+}
+
+// assessFind assesses a find command that deletes the files it finds.
+func assessFind(a *Assessment, c simpleCommand) {
+	if !slices.Contains(c.args, "-delete") {
+		return
+	}
+	var paths []string
+	for _, arg := range c.args {
+		if arg == "-H" || arg == "-L" || arg == "-P" {
+			continue
+		}
+		if strings.HasPrefix(arg, "-") || arg == "(" || arg == "!" {
+			break
+		}
+		paths = append(paths, arg)
+	}
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	// Tests such as -name narrow down what is deleted.
+	for _, arg := range c.args {
+		if strings.HasPrefix(arg, "-") && !findEverything[arg] {
+			a.add("rm-recursive", RiskMedium, "find -delete deletes the files it finds")
+			return
+		}
+	}
+	for _, path := range paths {
+		if broadPath.MatchString(path) {
+			a.add("rm-recursive", RiskHigh, "find -delete deletes everything under %s", path)
+			return
+		}
+	}
+	a.add("rm-recursive", RiskMedium, "find -delete deletes directories and their contents")
+}
+
+// assessPush assesses a git command for force pushes.
+func assessPush(a *Assessment, c simpleCommand) {
+	operands := c.operands()
+	if len(operands) == 0 || operands[0] != "push" {
+		return
+	}
+	switch {
+	case c.flagged("f", "--force", "--mirror"):
+		a.add("force-push", RiskHigh, "git push --force overwrites the remote history")
+	case c.flagged("", "--force-with-lease", "--force-if-includes"):
+		a.add("force-push", RiskMedium, "git push --force-with-lease overwrites the remote history if nobody else pushed")
+	default:
+		for _, refspec := range operands[1:] {
+			if strings.HasPrefix(refspec, "+") {
+				a.add("force-push", RiskHigh, "git push %s overwrites the remote history", refspec)
+				return
+			}
+		}
+	}
+}
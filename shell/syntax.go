@@ -0,0 +1,31 @@
+package shell
+
+import (
+	"path/filepath"
+	"strings"
+
+	"mvdan.cc/sh/v3/syntax"
+)
+
+// variants maps the shells whose syntax can be checked to their dialect.
+var variants = map[string]syntax.LangVariant{
+	"bash": syntax.LangBash,
+	"sh":   syntax.LangPOSIX,
+	"dash": syntax.LangPOSIX,
+	"ash":  syntax.LangPOSIX,
+	"ksh":  syntax.LangMirBSDKorn,
+	"mksh": syntax.LangMirBSDKorn,
+}
+
+// Validate parses command with a real shell parser and returns the syntax
+// error, such as an unterminated quote or a pipe without a command, that the
+// shell at path would fail with. Commands for shells the parser does not
+// understand, such as zsh and fish, are not checked.
+func Validate(path, command string) error {
+	variant, ok := variants[filepath.Base(path)]
+	if !ok {
+		return nil
+	}
+	_, err := syntax.NewParser(syntax.Variant(variant)).Parse(strings.NewReader(command), "")
+	return err
+}
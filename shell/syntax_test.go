@@ -0,0 +1,35 @@
+package shell_test
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/shell"
+)
+
+var _ = Describe("Validate", func() {
+	DescribeTable("accepts valid commands",
+		func(path, command string) {
+			Expect(shell.Validate(path, command)).To(Succeed())
+		},
+		Entry("a pipeline", "/bin/bash", "ls -l | grep go"),
+		Entry("a heredoc", "/bin/bash", "cat <<EOF\nhello\nEOF"),
+		Entry("bash arrays", "/usr/bin/bash", "files=(*.go); echo ${files[@]}"),
+		Entry("a POSIX command", "/bin/sh", "for f in *.go; do echo \"$f\"; done"),
+		Entry("a korn shell command", "/bin/mksh", "print -r -- hello"),
+		Entry("a zsh command", "/bin/zsh", "ls **/*(.)"),
+		Entry("a fish command", "/usr/bin/fish", "for f in *.go; echo $f; end"),
+	)
+
+	DescribeTable("rejects invalid commands",
+		func(path, command, message string) {
+			Expect(shell.Validate(path, command)).To(MatchError(message))
+		},
+		Entry("an unterminated quote", "/bin/bash", `echo "hello`, `1:6: reached EOF without closing quote "`),
+		Entry("an unterminated single quote", "/bin/bash", `grep 'TODO *.go`, "1:6: reached EOF without closing quote '"),
+		Entry("a pipe without a command", "/bin/bash", "ls -l |", "1:7: | must be followed by a statement"),
+		Entry("a broken heredoc", "/bin/bash", "cat <<", "1:5: << must be followed by a word"),
+		Entry("an unclosed loop", "/bin/bash", "for f in *; do echo $f", `1:1: for statement must end with "done"`),
+		Entry("bash arrays in sh", "/bin/sh", "files=(*.go)", "1:7: arrays are a bash/mksh feature; tried parsing as posix"),
+	)
+})
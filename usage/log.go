@@ -0,0 +1,56 @@
+// Package usage records the tokens and latency of every provider request
+// and reports them with an estimated cost.
+package usage
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/zombor/gen/jsonl"
+	"github.com/zombor/gen/llm"
+)
+
+// Log is an llm.UsageRecorder that appends records to a JSON lines file.
+type Log struct {
+	Path string
+}
+
+// NewLog returns a Log that writes to path.
+func NewLog(path string) *Log {
+	return &Log{Path: path}
+}
+
+// RecordUsage implements llm.UsageRecorder.
+func (l *Log) RecordUsage(record llm.UsageRecord) error {
+	return jsonl.Append(l.Path, record)
+}
+
+// Read returns the records made at or after from and before to. A missing
+// log has no records, and lines that cannot be decoded are skipped.
+func (l *Log) Read(from, to time.Time) ([]llm.UsageRecord, error) {
+	f, err := os.Open(l.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var records []llm.UsageRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record llm.UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue
+		}
+		if record.Time.Before(from) || !record.Time.Before(to) {
+			continue
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
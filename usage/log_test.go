@@ -0,0 +1,77 @@
+package usage_test
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/llm"
+	"github.com/zombor/gen/usage"
+)
+
+var _ = Describe("Log", func() {
+	var (
+		log     *usage.Log
+		day     time.Time
+		records []llm.UsageRecord
+		err     error
+	)
+
+	record := func(t time.Time) llm.UsageRecord {
+		return llm.UsageRecord{
+			Time:     t,
+			Provider: "openai",
+			Model:    "gpt-4o",
+			Usage:    llm.Usage{InputTokens: 120, OutputTokens: 30},
+			Latency:  800 * time.Millisecond,
+		}
+	}
+
+	BeforeEach(func() {
+		log = usage.NewLog(filepath.Join(GinkgoT().TempDir(), "gen", "usage.jsonl"))
+		day = time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC)
+	})
+
+	Describe("Read", func() {
+		BeforeEach(func() {
+			for _, t := range []time.Time{day.Add(-time.Second), day, day.Add(23 * time.Hour), day.AddDate(0, 0, 1)} {
+				Expect(log.RecordUsage(record(t))).To(Succeed())
+			}
+		})
+
+		JustBeforeEach(func() {
+			records, err = log.Read(day, day.AddDate(0, 0, 1))
+		})
+
+		It("returns the records in the range", func() {
+			Expect(records, err).To(Equal([]llm.UsageRecord{record(day), record(day.Add(23 * time.Hour))}))
+		})
+
+		When("a line is corrupt", func() {
+			BeforeEach(func() {
+				f, err := os.OpenFile(log.Path, os.O_APPEND|os.O_WRONLY, 0)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = f.WriteString("{not json\n")
+				Expect(err).ToNot(HaveOccurred())
+				Expect(f.Close()).To(Succeed())
+			})
+
+			It("skips it", func() {
+				Expect(records, err).To(HaveLen(2))
+			})
+		})
+
+		When("nothing was recorded", func() {
+			BeforeEach(func() {
+				log.Path = filepath.Join(GinkgoT().TempDir(), "missing.jsonl")
+			})
+
+			It("returns no records", func() {
+				Expect(records, err).To(BeEmpty())
+			})
+		})
+	})
+})
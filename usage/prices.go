@@ -0,0 +1,113 @@
+package usage
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/zombor/gen/llm"
+)
+
+//go:embed prices.txt
+var builtinPrices []byte
+
+// Price is the cost of a model in USD per million tokens.
+type Price struct {
+	Input  float64
+	Output float64
+}
+
+// Cost returns the cost of usage in USD.
+func (p Price) Cost(usage llm.Usage) float64 {
+	return (float64(usage.InputTokens)*p.Input + float64(usage.OutputTokens)*p.Output) / 1e6
+}
+
+// Prices maps a provider and model to its price. See prices.txt for the
+// format of a price table.
+type Prices map[string]Price
+
+// bedrockGeoPrefixes are prepended to Bedrock model IDs by cross-region
+// inference profiles. They are priced the same as the model itself.
+var bedrockGeoPrefixes = []string{"us.", "eu.", "apac.", "us-gov.", "global."}
+
+// Lookup returns the price of model on provider. A model of "*" in the
+// table matches every model of the provider.
+func (p Prices) Lookup(provider, model string) (Price, bool) {
+	if price, ok := p[provider+" "+model]; ok {
+		return price, true
+	}
+	if provider == "bedrock" {
+		for _, prefix := range bedrockGeoPrefixes {
+			if base, ok := strings.CutPrefix(model, prefix); ok {
+				if price, ok := p[provider+" "+base]; ok {
+					return price, true
+				}
+			}
+		}
+	}
+	price, ok := p[provider+" *"]
+	return price, ok
+}
+
+// DefaultPrices returns the built-in price table.
+func DefaultPrices() Prices {
+	prices, err := ParsePrices(builtinPrices)
+	if err != nil {
+		panic(err)
+	}
+	return prices
+}
+
+// LoadPrices returns the built-in price table with the prices in the file
+// at path added to it. A missing file is not an error.
+func LoadPrices(path string) (Prices, error) {
+	prices := DefaultPrices()
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return prices, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	overrides, err := ParsePrices(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read prices from %s: %w", path, err)
+	}
+	for key, price := range overrides {
+		prices[key] = price
+	}
+	return prices, nil
+}
+
+// ParsePrices parses a price table: one "provider model input output" line
+// per model, with prices in USD per million tokens. Blank lines and lines
+// starting with # are ignored.
+func ParsePrices(data []byte) (Prices, error) {
+	prices := Prices{}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 4 {
+			return nil, fmt.Errorf("line %d: expected provider, model, input and output price, got %q", i+1, line)
+		}
+		input, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid input price %q", i+1, fields[2])
+		}
+		output, err := strconv.ParseFloat(fields[3], 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid output price %q", i+1, fields[3])
+		}
+		prices[fields[0]+" "+fields[1]] = Price{Input: input, Output: output}
+	}
+	return prices, nil
+}
@@ -0,0 +1,104 @@
+package usage_test
+
+import (
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/llm"
+	"github.com/zombor/gen/usage"
+)
+
+var _ = Describe("Prices", func() {
+	Describe("Lookup", func() {
+		var prices usage.Prices
+
+		BeforeEach(func() {
+			prices = usage.Prices{
+				"bedrock anthropic.claude-sonnet-4-20250514-v1:0": {Input: 3, Output: 15},
+				"ollama *": {},
+			}
+		})
+
+		It("finds a model by provider and name", func() {
+			price, _ := prices.Lookup("bedrock", "anthropic.claude-sonnet-4-20250514-v1:0")
+			Expect(price).To(Equal(usage.Price{Input: 3, Output: 15}))
+		})
+
+		It("prices a Bedrock cross-region inference profile as its model", func() {
+			price, _ := prices.Lookup("bedrock", "us.anthropic.claude-sonnet-4-20250514-v1:0")
+			Expect(price).To(Equal(usage.Price{Input: 3, Output: 15}))
+		})
+
+		It("matches any model of a provider priced with *", func() {
+			_, ok := prices.Lookup("ollama", "llama3")
+			Expect(ok).To(BeTrue())
+		})
+
+		It("reports models without a price", func() {
+			_, ok := prices.Lookup("openai", "gpt-4o")
+			Expect(ok).To(BeFalse())
+		})
+	})
+
+	Describe("Cost", func() {
+		It("charges per million tokens", func() {
+			price := usage.Price{Input: 2.5, Output: 10}
+			Expect(price.Cost(llm.Usage{InputTokens: 2000, OutputTokens: 500})).To(BeNumerically("~", 0.01))
+		})
+	})
+
+	Describe("LoadPrices", func() {
+		var (
+			path   string
+			prices usage.Prices
+			err    error
+		)
+
+		BeforeEach(func() {
+			path = filepath.Join(GinkgoT().TempDir(), "prices")
+		})
+
+		JustBeforeEach(func() {
+			prices, err = usage.LoadPrices(path)
+		})
+
+		When("the file does not exist", func() {
+			It("returns the built-in prices", func() {
+				Expect(prices, err).To(Equal(usage.DefaultPrices()))
+			})
+		})
+
+		When("the file overrides a price", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(path, []byte("# negotiated\nopenai gpt-4o 1.25 5\n"), 0o600)).To(Succeed())
+			})
+
+			It("uses the file's price", func() {
+				Expect(prices, err).To(HaveKeyWithValue("openai gpt-4o", usage.Price{Input: 1.25, Output: 5}))
+			})
+		})
+
+		When("a line is malformed", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(path, []byte("openai gpt-4o cheap 5\n"), 0o600)).To(Succeed())
+			})
+
+			It("returns an error naming the line", func() {
+				Expect(prices, err).Error().To(MatchError(ContainSubstring(`line 1: invalid input price "cheap"`)))
+			})
+		})
+
+		When("a line has the wrong number of fields", func() {
+			BeforeEach(func() {
+				Expect(os.WriteFile(path, []byte("openai gpt-4o 1\n"), 0o600)).To(Succeed())
+			})
+
+			It("returns an error naming the line", func() {
+				Expect(prices, err).Error().To(MatchError(ContainSubstring("line 1: expected provider, model, input and output price")))
+			})
+		})
+	})
+})
@@ -0,0 +1,121 @@
+package usage
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/zombor/gen/llm"
+)
+
+// Row summarizes the requests made to one model of one provider.
+type Row struct {
+	Provider string
+	Model    string
+	Requests int
+	Errors   int
+	llm.Usage
+	// Cost is the estimated cost in USD. It is only meaningful when Priced
+	// is set.
+	Cost   float64
+	Priced bool
+	// P50 and P95 are latency percentiles of the successful requests.
+	P50 time.Duration
+	P95 time.Duration
+}
+
+// Summarize groups records by provider and model, ordered by provider and
+// then model.
+func Summarize(records []llm.UsageRecord, prices Prices) []Row {
+	index := map[string]int{}
+	latencies := map[string][]time.Duration{}
+	var rows []Row
+	for _, record := range records {
+		key := record.Provider + " " + record.Model
+		i, ok := index[key]
+		if !ok {
+			i = len(rows)
+			index[key] = i
+			rows = append(rows, Row{Provider: record.Provider, Model: record.Model})
+		}
+
+		rows[i].Requests++
+		rows[i].Usage = rows[i].Usage.Add(record.Usage)
+		if record.Error != "" {
+			rows[i].Errors++
+		} else {
+			latencies[key] = append(latencies[key], record.Latency)
+		}
+	}
+
+	for i, row := range rows {
+		key := row.Provider + " " + row.Model
+		price, priced := prices.Lookup(row.Provider, row.Model)
+		rows[i].Cost = price.Cost(row.Usage)
+		rows[i].Priced = priced
+		rows[i].P50 = percentile(latencies[key], 50)
+		rows[i].P95 = percentile(latencies[key], 95)
+	}
+
+	slices.SortFunc(rows, func(a, b Row) int {
+		if c := strings.Compare(a.Provider, b.Provider); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Model, b.Model)
+	})
+	return rows
+}
+
+// percentile returns the nearest-rank pth percentile of latencies, or zero
+// when there are none.
+func percentile(latencies []time.Duration, p int) time.Duration {
+	if len(latencies) == 0 {
+		return 0
+	}
+	sorted := slices.Clone(latencies)
+	slices.Sort(sorted)
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
+
+// WriteReport writes rows as a table followed by a total. Costs of models
+// without a price are shown as "-" and left out of the total.
+func WriteReport(w io.Writer, rows []Row) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PROVIDER\tMODEL\tREQUESTS\tERRORS\tINPUT\tOUTPUT\tCOST\tP50\tP95")
+
+	var total Row
+	for _, row := range rows {
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\t%s\n",
+			row.Provider, row.Model, row.Requests, row.Errors, row.InputTokens, row.OutputTokens,
+			formatCost(row.Cost, row.Priced), formatLatency(row.P50), formatLatency(row.P95))
+
+		total.Requests += row.Requests
+		total.Errors += row.Errors
+		total.Usage = total.Usage.Add(row.Usage)
+		if row.Priced {
+			total.Cost += row.Cost
+		}
+	}
+	fmt.Fprintf(tw, "TOTAL\t\t%d\t%d\t%d\t%d\t%s\n",
+		total.Requests, total.Errors, total.InputTokens, total.OutputTokens, formatCost(total.Cost, true))
+
+	return tw.Flush()
+}
+
+func formatCost(cost float64, priced bool) string {
+	if !priced {
+		return "-"
+	}
+	return fmt.Sprintf("$%.4f", cost)
+}
+
+func formatLatency(latency time.Duration) string {
+	if latency == 0 {
+		return "-"
+	}
+	return latency.Round(time.Millisecond).String()
+}
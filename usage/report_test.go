@@ -0,0 +1,70 @@
+package usage_test
+
+import (
+	"bytes"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	"github.com/zombor/gen/llm"
+	"github.com/zombor/gen/usage"
+)
+
+var _ = Describe("Report", func() {
+	var (
+		records []llm.UsageRecord
+		prices  usage.Prices
+		rows    []usage.Row
+	)
+
+	BeforeEach(func() {
+		prices = usage.Prices{"openai gpt-4o": {Input: 2.5, Output: 10}}
+		records = []llm.UsageRecord{
+			{Provider: "openai", Model: "gpt-4o", Usage: llm.Usage{InputTokens: 1000, OutputTokens: 100}, Latency: 300 * time.Millisecond},
+			{Provider: "bedrock", Model: "amazon.nova-lite-v1:0", Usage: llm.Usage{InputTokens: 900, OutputTokens: 80}, Latency: 2 * time.Second},
+			{Provider: "openai", Model: "gpt-4o", Usage: llm.Usage{InputTokens: 1000, OutputTokens: 100}, Latency: 100 * time.Millisecond},
+			{Provider: "openai", Model: "gpt-4o", Latency: 30 * time.Second, Error: "timed out"},
+			{Provider: "openai", Model: "gpt-4o", Usage: llm.Usage{InputTokens: 1000, OutputTokens: 100}, Latency: 200 * time.Millisecond},
+		}
+	})
+
+	Describe("Summarize", func() {
+		JustBeforeEach(func() {
+			rows = usage.Summarize(records, prices)
+		})
+
+		It("groups the records by provider and model", func() {
+			Expect(rows).To(Equal([]usage.Row{
+				{
+					Provider: "bedrock", Model: "amazon.nova-lite-v1:0", Requests: 1,
+					Usage: llm.Usage{InputTokens: 900, OutputTokens: 80},
+					P50:   2 * time.Second, P95: 2 * time.Second,
+				},
+				{
+					Provider: "openai", Model: "gpt-4o", Requests: 4, Errors: 1,
+					Usage: llm.Usage{InputTokens: 3000, OutputTokens: 300},
+					Cost:  0.0105, Priced: true,
+					P50: 200 * time.Millisecond, P95: 300 * time.Millisecond,
+				},
+			}))
+		})
+	})
+
+	Describe("WriteReport", func() {
+		var out bytes.Buffer
+
+		JustBeforeEach(func() {
+			out.Reset()
+			Expect(usage.WriteReport(&out, usage.Summarize(records, prices))).To(Succeed())
+		})
+
+		It("prints a row per model and a total", func() {
+			Expect(out.String()).To(Equal(
+				"PROVIDER  MODEL                  REQUESTS  ERRORS  INPUT  OUTPUT  COST     P50    P95\n" +
+					"bedrock   amazon.nova-lite-v1:0  1         0       900    80      -        2s     2s\n" +
+					"openai    gpt-4o                 4         1       3000   300     $0.0105  200ms  300ms\n" +
+					"TOTAL                            5         1       3900   380     $0.0105\n"))
+		})
+	})
+})